@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type accountView struct {
+	ID         int64  `json:"id"`
+	Site       string `json:"site"`
+	Username   string `json:"username"`
+	Status     string `json:"status"`
+	LastError  string `json:"last_error"`
+	RetryAt    string `json:"retry_at"`
+	LastUsedAt string `json:"last_used_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func cmdAccounts(args []string) {
+	fs := flag.NewFlagSet("accounts", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	site := fs.String("site", "", "with list: only this site")
+	user := fs.String("user", "", "with add: account username")
+	password := fs.String("password", "", "with add: account password (read from stdin when omitted)")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 || positional[0] == "list" {
+		listAccounts(*api, *site)
+		return
+	}
+	switch positional[0] {
+	case "add":
+		if len(positional) > 1 {
+			*site = positional[1]
+		}
+		if *user == "" && len(positional) > 2 {
+			*user = positional[2]
+		}
+		if *site == "" || *user == "" {
+			fmt.Println("usage: dlq accounts add <site> --user <username> [--password <password>]")
+			return
+		}
+		if *password == "" {
+			fmt.Fprint(os.Stderr, "password: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Println("error: no password given")
+				return
+			}
+			*password = strings.TrimRight(line, "\r\n")
+		}
+		var acct accountView
+		if err := postJSON(*api+"/api/accounts", map[string]string{"site": *site, "username": *user, "password": *password}, &acct); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("account %d added (%s %s)\n", acct.ID, acct.Site, acct.Username)
+	case "remove", "reset":
+		if len(positional) < 2 {
+			fmt.Printf("usage: dlq accounts %s <account_id>\n", positional[0])
+			return
+		}
+		var err error
+		if positional[0] == "remove" {
+			err = deleteJSON(fmt.Sprintf("%s/api/accounts/%s", *api, positional[1]), nil)
+		} else {
+			err = postJSON(fmt.Sprintf("%s/api/accounts/%s/reset", *api, positional[1]), map[string]string{}, nil)
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("ok")
+	default:
+		fmt.Println("usage: dlq accounts [list] [--site <site>] | add <site> --user <username> [--password <password>] | remove <account_id> | reset <account_id>")
+	}
+}
+
+func listAccounts(api, site string) {
+	path := api + "/api/accounts"
+	if site != "" {
+		path += "?site=" + url.QueryEscape(site)
+	}
+	var list []accountView
+	if err := getJSON(path, &list); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Println("No accounts.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSITE\tUSERNAME\tSTATUS\tRETRY AT\tLAST USED\tLAST ERROR")
+	for _, a := range list {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.Site, a.Username, a.Status, orDash(a.RetryAt), orDash(a.LastUsedAt), orDash(a.LastError))
+	}
+	_ = tw.Flush()
+}
@@ -14,44 +14,109 @@ func cmdAdd(args []string) {
 			return
 		}
 	}
-	urls, outDir, name, site, archivePassword, api, err := parseAddArgs(args)
+	opts, err := parseAddArgs(args)
 	if err != nil {
 		fmt.Println("error:", err)
-		fmt.Println("usage: dlq add <url> [<url2> ...] --out /data/downloads [--name optional] [--archive-password batch-pass]")
+		fmt.Println(addUsage)
 		return
 	}
-	if len(urls) == 0 || outDir == "" {
-		fmt.Println("usage: dlq add <url> [<url2> ...] --out /data/downloads [--name optional] [--archive-password batch-pass]")
+	// Without --out, dlqd's output rules pick the location.
+	if len(opts.urls) == 0 {
+		fmt.Println(addUsage)
 		return
 	}
-	if len(urls) > 1 && name != "" {
+	if len(opts.urls) > 1 && opts.name != "" {
 		fmt.Println("error: --name can only be used with a single URL")
 		return
 	}
-	hadErr := false
-	for _, urlStr := range urls {
+	if len(opts.urls) > 1 && opts.checksum != "" {
+		fmt.Println("error: --checksum can only be used with a single URL")
+		return
+	}
+	var packageID any
+	hadErr, added := false, 0
+	for _, urlStr := range opts.urls {
 		payload := map[string]any{
 			"url":              urlStr,
-			"out_dir":          outDir,
-			"name":             name,
-			"site":             site,
-			"archive_password": archivePassword,
+			"out_dir":          opts.outDir,
+			"name":             opts.name,
+			"site":             opts.site,
+			"archive_password": opts.archivePassword,
+			"engine":           opts.engine,
+			"checksum":         opts.checksum,
+		}
+		if isLocalTorrent(urlStr) {
+			data, err := os.ReadFile(urlStr)
+			if err != nil {
+				fmt.Printf("error for %s: %v\n", urlStr, err)
+				hadErr = true
+				continue
+			}
+			// Sent base64-encoded; dlqd keeps the file in its state dir.
+			payload["url"] = ""
+			payload["torrent"] = data
+		}
+		if opts.packageName != "" && packageID == nil {
+			// Created on first use and removed again below when no job
+			// was added, so a failed batch leaves no empty package.
+			var resp map[string]any
+			if err := postJSON(opts.api+"/packages", map[string]any{"name": opts.packageName}, &resp); err != nil {
+				fmt.Println("error creating package:", err)
+				os.Exit(1)
+			}
+			packageID = resp["id"]
+			fmt.Printf("created package id %v (%s)\n", packageID, opts.packageName)
+		}
+		if packageID != nil {
+			payload["package_id"] = packageID
 		}
 		var resp map[string]any
-		if err := postJSON(api+"/jobs", payload, &resp); err != nil {
+		if err := postJSON(opts.api+"/jobs", payload, &resp); err != nil {
 			fmt.Printf("error for %s: %v\n", urlStr, err)
 			hadErr = true
 			continue
 		}
+		added++
 		fmt.Printf("queued job id %v (%s)\n", resp["id"], urlStr)
 	}
+	if packageID != nil && added == 0 {
+		if err := postJSON(fmt.Sprintf("%s/packages/%v/remove", opts.api, packageID), nil, nil); err != nil {
+			fmt.Printf("error removing empty package %v: %v\n", packageID, err)
+		} else {
+			fmt.Printf("removed empty package id %v\n", packageID)
+		}
+	}
 	if hadErr {
 		os.Exit(1)
 	}
 }
 
-func parseAddArgs(args []string) (urls []string, outDir, name, site, archivePassword string, api string, err error) {
-	api = apiBase()
+// isLocalTorrent reports whether arg names a .torrent file on this machine
+// rather than a URL.
+func isLocalTorrent(arg string) bool {
+	if !strings.HasSuffix(strings.ToLower(arg), ".torrent") || strings.Contains(arg, "://") {
+		return false
+	}
+	info, err := os.Stat(arg)
+	return err == nil && info.Mode().IsRegular()
+}
+
+const addUsage = "usage: dlq add <url|magnet|file.torrent> [<url2> ...] [--out /data/downloads] [--name optional] [--archive-password batch-pass] [--package name] [--engine aria2|native] [--checksum sha256:<hex>]"
+
+type addOptions struct {
+	urls            []string
+	outDir          string
+	name            string
+	site            string
+	archivePassword string
+	packageName     string
+	engine          string
+	checksum        string
+	api             string
+}
+
+func parseAddArgs(args []string) (addOptions, error) {
+	opts := addOptions{api: apiBase()}
 	var files []string
 	useStdin := false
 	for i := 0; i < len(args); i++ {
@@ -70,50 +135,56 @@ func parseAddArgs(args []string) (urls []string, outDir, name, site, archivePass
 				val = args[i+1]
 				i++
 			} else {
-				return nil, "", "", "", "", "", fmt.Errorf("missing value for %s", key)
+				return addOptions{}, fmt.Errorf("missing value for %s", key)
 			}
 			switch key {
 			case "--out":
-				outDir = val
+				opts.outDir = val
 			case "--name":
-				name = val
+				opts.name = val
 			case "--site":
-				site = val
+				opts.site = val
 			case "--archive-password":
-				archivePassword = val
+				opts.archivePassword = val
 			case "--password":
-				archivePassword = val
+				opts.archivePassword = val
+			case "--package":
+				opts.packageName = strings.TrimSpace(val)
+			case "--engine":
+				opts.engine = strings.TrimSpace(val)
+			case "--checksum":
+				opts.checksum = strings.TrimSpace(val)
 			case "--api":
-				api = val
+				opts.api = val
 			case "--file":
 				files = append(files, val)
 			default:
-				return nil, "", "", "", "", "", fmt.Errorf("unknown flag %s", key)
+				return addOptions{}, fmt.Errorf("unknown flag %s", key)
 			}
 			continue
 		}
-		urls = append(urls, arg)
+		opts.urls = append(opts.urls, arg)
 	}
 	if useStdin {
 		stdinURLs, err := readURLs(os.Stdin)
 		if err != nil {
-			return nil, "", "", "", "", "", err
+			return addOptions{}, err
 		}
-		urls = append(urls, stdinURLs...)
+		opts.urls = append(opts.urls, stdinURLs...)
 	}
 	for _, path := range files {
 		f, err := os.Open(path)
 		if err != nil {
-			return nil, "", "", "", "", "", err
+			return addOptions{}, err
 		}
 		fileURLs, err := readURLs(f)
 		_ = f.Close()
 		if err != nil {
-			return nil, "", "", "", "", "", err
+			return addOptions{}, err
 		}
-		urls = append(urls, fileURLs...)
+		opts.urls = append(opts.urls, fileURLs...)
 	}
-	return urls, outDir, name, site, archivePassword, api, nil
+	return opts, nil
 }
 
 func readURLs(r *os.File) ([]string, error) {
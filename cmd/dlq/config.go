@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cliConfig is the optional config file, $DLQ_CONFIG or
+// <user config dir>/dlq/config.json.
+type cliConfig struct {
+	API   string `json:"api"`
+	Token string `json:"token"`
+}
+
+func configPath() string {
+	if v := os.Getenv("DLQ_CONFIG"); v != "" {
+		return v
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dlq", "config.json")
+}
+
+func loadConfig() cliConfig {
+	var cfg cliConfig
+	path := configPath()
+	if path == "" {
+		return cfg
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg
+	}
+	_ = json.Unmarshal(data, &cfg)
+	return cfg
+}
+
+// apiToken returns the bearer token for API requests and where it came from:
+// $DLQ_TOKEN, then the config file, then the admin token dlqd writes to
+// $DLQ_STATE_DIR/cli.token on first start (readable inside the container).
+func apiToken() (token, source string) {
+	if v := strings.TrimSpace(os.Getenv("DLQ_TOKEN")); v != "" {
+		return v, "env DLQ_TOKEN"
+	}
+	if cfg := loadConfig(); cfg.Token != "" {
+		return cfg.Token, configPath()
+	}
+	stateDir := os.Getenv("DLQ_STATE_DIR")
+	if stateDir == "" {
+		stateDir = "/state"
+	}
+	path := filepath.Join(stateDir, "cli.token")
+	if data, err := os.ReadFile(path); err == nil {
+		if v := strings.TrimSpace(string(data)); v != "" {
+			return v, path
+		}
+	}
+	return "", ""
+}
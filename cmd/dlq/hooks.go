@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type webhookView struct {
+	ID        int64    `json:"id"`
+	URL       string   `json:"url"`
+	HasSecret bool     `json:"has_secret"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type webhookDeliveryView struct {
+	ID           int64  `json:"id"`
+	Event        string `json:"event"`
+	JobID        int64  `json:"job_id"`
+	PackageID    int64  `json:"package_id"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	ResponseCode int64  `json:"response_code"`
+	Error        string `json:"error"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func cmdHooks(args []string) {
+	fs := flag.NewFlagSet("hooks", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	secret := fs.String("secret", "", "with add: HMAC secret used to sign payloads")
+	events := fs.String("events", "", "with add: comma-separated events (completed,failed,decrypt_failed,package_completed); empty = all")
+	limit := fs.Int("limit", 20, "with log: number of deliveries to show")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 || positional[0] == "list" {
+		listHooks(*api)
+		return
+	}
+	switch positional[0] {
+	case "add":
+		if len(positional) < 2 {
+			fmt.Println("usage: dlq hooks add <url> [--secret s] [--events completed,failed]")
+			return
+		}
+		req := map[string]any{"url": positional[1]}
+		if *secret != "" {
+			req["secret"] = *secret
+		}
+		if *events != "" {
+			req["events"] = strings.Split(*events, ",")
+		}
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		if err := postJSON(*api+"/api/webhooks", req, &resp); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("webhook %d added\n", resp.ID)
+	case "remove", "test":
+		if len(positional) < 2 {
+			fmt.Printf("usage: dlq hooks %s <hook_id>\n", positional[0])
+			return
+		}
+		var err error
+		if positional[0] == "remove" {
+			err = deleteJSON(fmt.Sprintf("%s/api/webhooks/%s", *api, positional[1]), nil)
+		} else {
+			err = postJSON(fmt.Sprintf("%s/api/webhooks/%s/test", *api, positional[1]), map[string]any{}, nil)
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("ok")
+	case "log":
+		if len(positional) < 2 {
+			fmt.Println("usage: dlq hooks log <hook_id> [--limit 20]")
+			return
+		}
+		var deliveries []webhookDeliveryView
+		if err := getJSON(fmt.Sprintf("%s/api/webhooks/%s/deliveries?limit=%d", *api, positional[1], *limit), &deliveries); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			fmt.Println("No deliveries.")
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEVENT\tTARGET\tSTATUS\tATTEMPTS\tCODE\tCREATED\tERROR")
+		for _, d := range deliveries {
+			target := "-"
+			if d.JobID > 0 {
+				target = fmt.Sprintf("job %d", d.JobID)
+			} else if d.PackageID > 0 {
+				target = fmt.Sprintf("package %d", d.PackageID)
+			}
+			code := "-"
+			if d.ResponseCode > 0 {
+				code = fmt.Sprintf("%d", d.ResponseCode)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", d.ID, d.Event, target, d.Status, d.Attempts, code, d.CreatedAt, d.Error)
+		}
+		_ = tw.Flush()
+	default:
+		fmt.Println("usage: dlq hooks [list|add|remove|test|log]")
+	}
+}
+
+func listHooks(api string) {
+	var hooks []webhookView
+	if err := getJSON(api+"/api/webhooks", &hooks); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(hooks) == 0 {
+		fmt.Println("No webhooks.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEVENTS\tSIGNED\tURL")
+	for _, h := range hooks {
+		events := "all"
+		if len(h.Events) > 0 {
+			events = strings.Join(h.Events, ",")
+		}
+		signed := "no"
+		if h.HasSecret {
+			signed = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", h.ID, events, signed, h.URL)
+	}
+	_ = tw.Flush()
+}
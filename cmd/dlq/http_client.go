@@ -4,27 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 )
 
-func getJSON(url string, out interface{}) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
+func newAPIRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return readHTTPError(resp)
+	if token, _ := apiToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	return req, nil
 }
 
-func postJSON(url string, payload interface{}, out interface{}) error {
+func doJSON(req *http.Request, out interface{}) error {
 	client := &http.Client{Timeout: 10 * time.Second}
-	body, _ := json.Marshal(payload)
-	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -38,6 +36,24 @@ func postJSON(url string, payload interface{}, out interface{}) error {
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
+func getJSON(url string, out interface{}) error {
+	req, err := newAPIRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, out)
+}
+
+func postJSON(url string, payload interface{}, out interface{}) error {
+	body, _ := json.Marshal(payload)
+	req, err := newAPIRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(req, out)
+}
+
 func readHTTPError(resp *http.Response) error {
 	var body map[string]string
 	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
@@ -47,3 +63,11 @@ func readHTTPError(resp *http.Response) error {
 	}
 	return fmt.Errorf("http %d", resp.StatusCode)
 }
+
+func deleteJSON(url string, out interface{}) error {
+	req, err := newAPIRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, out)
+}
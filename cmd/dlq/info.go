@@ -25,6 +25,11 @@ func cmdInfo(args []string) {
 	} else {
 		fmt.Println("  env.DLQ_API: (unset)")
 	}
+	if _, source := apiToken(); source != "" {
+		fmt.Printf("  token: %s\n", source)
+	} else {
+		fmt.Println("  token: (none)")
+	}
 
 	var meta metaView
 	if err := getJSON(*api+"/meta", &meta); err != nil {
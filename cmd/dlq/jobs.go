@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 )
@@ -13,42 +14,43 @@ func cmdStatus(args []string) {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 	status := fs.String("status", "", "filter by status")
 	api := fs.String("api", apiBase(), "api base URL")
-	watch := fs.Bool("watch", false, "refresh every second")
-	interval := fs.Int("interval", 1, "refresh interval in seconds")
+	watch := fs.Bool("watch", false, "follow live updates until no job is active")
+	interval := fs.Int("interval", 1, "minimum seconds between redraws")
 	fs.Parse(args)
 	if *interval <= 0 {
 		*interval = 1
 	}
-	for {
-		if *watch {
-			fmt.Print("\033[H\033[2J")
-		}
-		var jobs []jobView
-		url := *api + "/jobs"
-		if *status != "" {
-			url += "?status=" + *status
-			if *status == "deleted" {
-				url += "&include_deleted=1"
-			}
-		}
-		if err := getJSON(url, &jobs); err != nil {
+	if *watch && *status != "deleted" {
+		if err := watchStatus(*api, *status, time.Duration(*interval)*time.Second); err != nil {
 			fmt.Println("error:", err)
-			return
 		}
-		counts := map[string]int{}
-		for _, j := range jobs {
-			counts[j.Status]++
-		}
-		active := counts["queued"] + counts["resolving"] + counts["downloading"] + counts["paused"] + counts["decrypting"]
-		done := counts["completed"] + counts["failed"] + counts["decrypt_failed"]
-		fmt.Printf("Jobs: %d total | active: %d (queued %d, resolving %d, downloading %d, paused/stopped %d, decrypting %d) | done: %d (completed %d, failed %d, decrypt_failed %d)\n",
-			len(jobs), active, counts["queued"], counts["resolving"], counts["downloading"], counts["paused"], counts["decrypting"], done, counts["completed"], counts["failed"], counts["decrypt_failed"])
-		printJobs(jobs)
-		if !*watch || !hasActiveJobs(jobs) {
-			return
+		return
+	}
+	var jobs []jobView
+	url := *api + "/jobs"
+	if *status != "" {
+		url += "?status=" + *status
+		if *status == "deleted" {
+			url += "&include_deleted=1"
 		}
-		time.Sleep(time.Duration(*interval) * time.Second)
 	}
+	if err := getJSON(url, &jobs); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	printStatus(jobs)
+}
+
+func printStatus(jobs []jobView) {
+	counts := map[string]int{}
+	for _, j := range jobs {
+		counts[j.Status]++
+	}
+	active := counts["queued"] + counts["resolving"] + counts["downloading"] + counts["paused"] + counts["verifying"] + counts["decrypting"]
+	done := counts["completed"] + counts["failed"] + counts["decrypt_failed"]
+	fmt.Printf("Jobs: %d total | active: %d (queued %d, resolving %d, downloading %d, paused/stopped %d, verifying %d, decrypting %d) | done: %d (completed %d, failed %d, decrypt_failed %d)\n",
+		len(jobs), active, counts["queued"], counts["resolving"], counts["downloading"], counts["paused"], counts["verifying"], counts["decrypting"], done, counts["completed"], counts["failed"], counts["decrypt_failed"])
+	printJobs(jobs)
 }
 
 func cmdFiles(args []string) {
@@ -76,13 +78,20 @@ func cmdFiles(args []string) {
 func cmdLogs(args []string) {
 	fs := flag.NewFlagSet("logs", flag.ExitOnError)
 	limit := fs.Int("tail", 50, "number of log lines")
+	follow := fs.Bool("f", false, "keep printing new log lines")
 	api := fs.String("api", apiBase(), "api base URL")
-	fs.Parse(args)
-	if fs.NArg() < 1 {
-		fmt.Println("usage: dlq logs <job_id>")
+	rest := parseJobArgs(fs, args)
+	if len(rest) < 1 {
+		fmt.Println("usage: dlq logs <job_id> [--tail 50] [-f]")
+		return
+	}
+	id := rest[0]
+	if *follow {
+		if err := followLogs(*api, id, *limit); err != nil {
+			fmt.Println("error:", err)
+		}
 		return
 	}
-	id := fs.Arg(0)
 	var lines []string
 	if err := getJSON(fmt.Sprintf("%s/jobs/%s/events?limit=%d", *api, id, *limit), &lines); err != nil {
 		fmt.Println("error:", err)
@@ -125,6 +134,151 @@ func runJobAction(args []string, action string) {
 	fmt.Println("ok")
 }
 
+func cmdMove(args []string) {
+	fs := flag.NewFlagSet("move", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	top := fs.Bool("top", false, "move to the top of the queue")
+	bottom := fs.Bool("bottom", false, "move to the bottom of the queue")
+	before := fs.Int64("before", 0, "move directly before this job id")
+	positional := parseJobArgs(fs, args)
+	if len(positional) < 1 {
+		fmt.Println("usage: dlq move <job_id> --top|--bottom|--before <job_id>")
+		return
+	}
+	id := positional[0]
+	payload := map[string]any{}
+	switch {
+	case *top && !*bottom && *before == 0:
+		payload["to"] = "top"
+	case *bottom && !*top && *before == 0:
+		payload["to"] = "bottom"
+	case *before > 0 && !*top && !*bottom:
+		payload["to"] = "before"
+		payload["before"] = *before
+	default:
+		fmt.Println("error: specify exactly one of --top, --bottom or --before <job_id>")
+		return
+	}
+	if err := postJSON(fmt.Sprintf("%s/jobs/%s/move", *api, id), payload, nil); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("ok")
+}
+
+func cmdPriority(args []string) {
+	fs := flag.NewFlagSet("priority", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	positional := parseJobArgs(fs, args)
+	if len(positional) < 2 {
+		fmt.Println("usage: dlq priority <job_id> <n>")
+		return
+	}
+	id := positional[0]
+	priority, err := strconv.Atoi(positional[1])
+	if err != nil {
+		fmt.Println("error: priority must be an integer")
+		return
+	}
+	if err := postJSON(fmt.Sprintf("%s/jobs/%s/priority", *api, id), map[string]any{"priority": priority}, nil); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("ok")
+}
+
+func cmdLimit(args []string) {
+	fs := flag.NewFlagSet("limit", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	positional := parseJobArgs(fs, args)
+	if len(positional) < 2 {
+		fmt.Println("usage: dlq limit <job_id> <rate|0>  (e.g. 500K, 2M; 0 removes the limit)")
+		return
+	}
+	id := positional[0]
+	if err := postJSON(fmt.Sprintf("%s/jobs/%s/limit", *api, id), map[string]any{"limit": positional[1]}, nil); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("ok")
+}
+
+// parseJobArgs parses flags that may follow leading positional arguments
+// (e.g. "dlq move 12 --top" or "dlq priority 12 -5") and returns all positional arguments.
+func parseJobArgs(fs *flag.FlagSet, args []string) []string {
+	var lead []string
+	for len(args) > 0 {
+		if strings.HasPrefix(args[0], "-") {
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				break
+			}
+		}
+		lead = append(lead, args[0])
+		args = args[1:]
+	}
+	fs.Parse(args)
+	return append(lead, fs.Args()...)
+}
+
+// parseSpeedSchedule turns "[days@]HH:MM-HH:MM=rate" values into API windows.
+func parseSpeedSchedule(values []string) ([]map[string]any, error) {
+	windows := []map[string]any{}
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), "none") {
+			continue
+		}
+		span, rate, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid speed window %q (want [days@]HH:MM-HH:MM=rate)", v)
+		}
+		window, err := parseTimeWindow(span)
+		if err != nil {
+			return nil, fmt.Errorf("invalid speed window %q (want [days@]HH:MM-HH:MM=rate)", v)
+		}
+		window["limit"] = strings.TrimSpace(rate)
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseTimeWindow turns "[days@]HH:MM-HH:MM" into an API window.
+func parseTimeWindow(v string) (map[string]any, error) {
+	window := map[string]any{}
+	spec := strings.TrimSpace(v)
+	if days, rest, ok := strings.Cut(spec, "@"); ok {
+		window["days"] = strings.Split(days, ",")
+		spec = rest
+	}
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q (want [days@]HH:MM-HH:MM)", v)
+	}
+	window["start"] = strings.TrimSpace(start)
+	window["end"] = strings.TrimSpace(end)
+	return window, nil
+}
+
+// mergeConcurrencyPairs applies "name=N" values on top of the current caps.
+func mergeConcurrencyPairs(current map[string]int, pairs []string) (map[string]int, error) {
+	limits := map[string]int{}
+	for k, v := range current {
+		limits[k] = v
+	}
+	for _, pair := range pairs {
+		name, val, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("must be name=N")
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit %q", val)
+		}
+		limits[name] = n
+	}
+	return limits, nil
+}
+
 func cmdClear(args []string) {
 	fs := flag.NewFlagSet("clear", flag.ExitOnError)
 	api := fs.String("api", apiBase(), "api base URL")
@@ -152,10 +306,42 @@ func cmdSettings(args []string) {
 	api := fs.String("api", apiBase(), "api base URL")
 	concurrency := fs.Int("concurrency", 0, "set concurrency (1-10)")
 	autoDecrypt := fs.String("auto-decrypt", "", "set archive auto decrypt (true|false)")
+	archiveCleanup := fs.String("archive-cleanup", "", "after extraction keep, delete or trash the archive volumes (keep|delete|trash)")
+	var siteEngines []string
+	fs.Func("site-engine", "set engine for a site as site=engine (aria2|native, empty for auto); repeatable", func(v string) error {
+		siteEngines = append(siteEngines, v)
+		return nil
+	})
+	speedLimit := fs.String("speed-limit", "", "set global download speed limit (e.g. 1M, 500K, 0 for unlimited)")
+	var speedSchedule []string
+	fs.Func("speed-schedule", "add a speed window as [days@]HH:MM-HH:MM=rate (e.g. mon,tue@18:00-23:00=1M); \"none\" clears; repeatable", func(v string) error {
+		speedSchedule = append(speedSchedule, v)
+		return nil
+	})
+	var siteConcurrency, hostConcurrency []string
+	fs.Func("site-concurrency", "cap active downloads for a site as site=N (0 removes the cap); repeatable", func(v string) error {
+		siteConcurrency = append(siteConcurrency, v)
+		return nil
+	})
+	fs.Func("host-concurrency", "cap active downloads for a host and its subdomains as host=N (0 removes the cap); repeatable", func(v string) error {
+		hostConcurrency = append(hostConcurrency, v)
+		return nil
+	})
+	var minFree []string
+	fs.Func("min-free", "keep free space on a DATA root as root=size (e.g. /data=20G, 0 removes); repeatable", func(v string) error {
+		minFree = append(minFree, v)
+		return nil
+	})
+	seedRatio := fs.Float64("seed-ratio", -1, "seed finished torrents up to this share ratio (0 = no ratio limit)")
+	seedTime := fs.Int("seed-time", -1, "seed finished torrents for up to this many minutes (0 = no time limit; both 0 = no seeding)")
+	hookTimeout := fs.Int("hook-timeout", -1, "time limit per hook script in seconds (0 = default of 300)")
+	hookFailJob := fs.String("hook-fail-job", "", "move jobs whose hook scripts fail to decrypt_failed (true|false)")
 	fs.Parse(args)
 
 	// If no flags set, just show current settings.
-	if *concurrency == 0 && *autoDecrypt == "" {
+	if *concurrency == 0 && *autoDecrypt == "" && *archiveCleanup == "" && len(siteEngines) == 0 && *speedLimit == "" && len(speedSchedule) == 0 &&
+		len(siteConcurrency) == 0 && len(hostConcurrency) == 0 && len(minFree) == 0 && *seedRatio < 0 && *seedTime < 0 &&
+		*hookTimeout < 0 && *hookFailJob == "" {
 		var settings map[string]interface{}
 		if err := getJSON(*api+"/api/settings", &settings); err != nil {
 			fmt.Println("error:", err)
@@ -180,6 +366,107 @@ func cmdSettings(args []string) {
 		}
 		updates["auto_decrypt"] = parsed
 	}
+	if *archiveCleanup != "" {
+		updates["archive_cleanup"] = *archiveCleanup
+	}
+	if len(siteEngines) > 0 {
+		var current struct {
+			SiteEngines map[string]string `json:"site_engines"`
+		}
+		if err := getJSON(*api+"/api/settings", &current); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		engines := map[string]string{}
+		for k, v := range current.SiteEngines {
+			engines[k] = v
+		}
+		for _, pair := range siteEngines {
+			site, engine, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(site) == "" {
+				fmt.Println("error: --site-engine must be site=engine")
+				return
+			}
+			engines[strings.TrimSpace(site)] = strings.TrimSpace(engine)
+		}
+		updates["site_engines"] = engines
+	}
+	if *speedLimit != "" {
+		updates["speed_limit"] = *speedLimit
+	}
+	if *seedRatio >= 0 {
+		updates["seed_ratio"] = *seedRatio
+	}
+	if *seedTime >= 0 {
+		updates["seed_time"] = *seedTime
+	}
+	if *hookTimeout >= 0 {
+		updates["hook_timeout"] = *hookTimeout
+	}
+	if *hookFailJob != "" {
+		parsed, err := strconv.ParseBool(*hookFailJob)
+		if err != nil {
+			fmt.Println("error: --hook-fail-job must be true or false")
+			return
+		}
+		updates["hook_fail_job"] = parsed
+	}
+	if len(speedSchedule) > 0 {
+		windows, err := parseSpeedSchedule(speedSchedule)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		updates["speed_schedule"] = windows
+	}
+	if len(siteConcurrency) > 0 || len(hostConcurrency) > 0 {
+		var current struct {
+			SiteConcurrency map[string]int `json:"site_concurrency"`
+			HostConcurrency map[string]int `json:"host_concurrency"`
+		}
+		if err := getJSON(*api+"/api/settings", &current); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		if len(siteConcurrency) > 0 {
+			limits, err := mergeConcurrencyPairs(current.SiteConcurrency, siteConcurrency)
+			if err != nil {
+				fmt.Println("error: --site-concurrency", err)
+				return
+			}
+			updates["site_concurrency"] = limits
+		}
+		if len(hostConcurrency) > 0 {
+			limits, err := mergeConcurrencyPairs(current.HostConcurrency, hostConcurrency)
+			if err != nil {
+				fmt.Println("error: --host-concurrency", err)
+				return
+			}
+			updates["host_concurrency"] = limits
+		}
+	}
+	if len(minFree) > 0 {
+		var current struct {
+			MinFreeSpace map[string]int64 `json:"min_free_space"`
+		}
+		if err := getJSON(*api+"/api/settings", &current); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		limits := map[string]any{}
+		for k, v := range current.MinFreeSpace {
+			limits[k] = v
+		}
+		for _, pair := range minFree {
+			root, size, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(root) == "" {
+				fmt.Println("error: --min-free must be root=size")
+				return
+			}
+			limits[strings.TrimSpace(root)] = strings.TrimSpace(size)
+		}
+		updates["min_free_space"] = limits
+	}
 	if len(updates) == 0 {
 		fmt.Println("error: no updates provided")
 		return
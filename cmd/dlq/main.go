@@ -38,6 +38,26 @@ func main() {
 		cmdPause(os.Args[2:])
 	case "resume":
 		cmdResume(os.Args[2:])
+	case "packages":
+		cmdPackages(os.Args[2:])
+	case "move":
+		cmdMove(os.Args[2:])
+	case "priority":
+		cmdPriority(os.Args[2:])
+	case "limit":
+		cmdLimit(os.Args[2:])
+	case "hooks":
+		cmdHooks(os.Args[2:])
+	case "rules":
+		cmdRules(os.Args[2:])
+	case "token":
+		cmdToken(os.Args[2:])
+	case "resolvers":
+		cmdResolvers(os.Args[2:])
+	case "accounts":
+		cmdAccounts(os.Args[2:])
+	case "queue":
+		cmdQueue(os.Args[2:])
 	case "settings":
 		cmdSettings(os.Args[2:])
 	case "help":
@@ -61,13 +81,14 @@ func usage() {
 	fmt.Println("  dlq <command> [options]")
 	fmt.Println("")
 	fmt.Println("Core:")
-	fmt.Println("  dlq add <url> [<url2> ...] --out /data/downloads [--name optional] [--site mega|webshare|http|https] [--archive-password batch-pass]")
+	fmt.Println("  dlq add <url|magnet|file.torrent> [<url2> ...] [--out /data/downloads] [--name optional] [--site mega|webshare|torrent|metalink|http|https|<plugin>] [--archive-password batch-pass] [--package name] [--engine aria2|native] [--checksum sha256:<hex>]")
 	fmt.Println("  dlq add --file urls.txt --out /data/downloads")
 	fmt.Println("  dlq add --stdin --out /data/downloads")
-	fmt.Println("  dlq status [--watch] [--interval 1] [--status queued|resolving|downloading|paused|decrypting|completed|failed|decrypt_failed|deleted]  (paused shows as stopped for webshare)")
+	fmt.Println("  dlq status [--watch] [--interval 1] [--status queued|resolving|downloading|paused|waiting_space|verifying|decrypting|completed|failed|decrypt_failed|deleted]  (paused shows as stopped for webshare)")
 	fmt.Println("  dlq files")
-	fmt.Println("  dlq logs <job_id> [--tail 50]")
+	fmt.Println("  dlq logs <job_id> [--tail 50] [-f]")
 	fmt.Println("  dlq info [--api http://127.0.0.1:8099]")
+	fmt.Println("  dlq resolvers  (built-in sites and resolver plugins usable with --site)")
 	fmt.Println("  dlq help")
 	fmt.Println("  dlq version | dlq --version")
 	fmt.Println("")
@@ -76,18 +97,59 @@ func usage() {
 	fmt.Println("  dlq resume <job_id>")
 	fmt.Println("  dlq retry <job_id>")
 	fmt.Println("  dlq remove <job_id>")
+	fmt.Println("  dlq packages [<package_id>]")
+	fmt.Println("  dlq packages pause|resume|retry|remove <package_id>")
+	fmt.Println("  dlq move <job_id> --top|--bottom|--before <job_id>")
+	fmt.Println("  dlq priority <job_id> <n>  (higher runs first, default 0)")
+	fmt.Println("  dlq limit <job_id> <rate>  (e.g. 500K, 2M; 0 removes the limit)")
+	fmt.Println("")
+	fmt.Println("Queue:")
+	fmt.Println("  dlq queue [status]")
+	fmt.Println("  dlq queue pause|resume  (stop/start the whole queue; pause also pauses running downloads)")
+	fmt.Println("  dlq queue schedule [--pause-active true|false] [days@]HH:MM-HH:MM ... | none")
+	fmt.Println("")
+	fmt.Println("Webhooks:")
+	fmt.Println("  dlq hooks [list]")
+	fmt.Println("  dlq hooks add <url> [--secret s] [--events completed,failed,decrypt_failed,package_completed]")
+	fmt.Println("  dlq hooks remove|test <hook_id>")
+	fmt.Println("  dlq hooks log <hook_id> [--limit 20]")
+	fmt.Println("")
+	fmt.Println("Output Rules:  (used by jobs added without --out; first match wins)")
+	fmt.Println("  dlq rules [list]")
+	fmt.Println("  dlq rules add --template {root}/{site}/{package}/{filename} [--site s] [--host h] [--pattern regex]")
+	fmt.Println("                [--ext mkv,mp4] [--min-size 700M] [--max-size 4G] [--root /data/x] [--name label]")
+	fmt.Println("  dlq rules test <url> [--filename f] [--size 1G] [--site s] [--package p]  (dry run)")
+	fmt.Println("  dlq rules remove <rule_id>")
+	fmt.Println("")
+	fmt.Println("Accounts:")
+	fmt.Println("  dlq accounts [list] [--site webshare]")
+	fmt.Println("  dlq accounts add <site> --user <username> [--password <password>]  (password read from stdin when omitted)")
+	fmt.Println("  dlq accounts remove|reset <account_id>  (reset makes an exhausted or failed account usable again)")
+	fmt.Println("")
+	fmt.Println("Access:")
+	fmt.Println("  dlq token [list]")
+	fmt.Println("  dlq token create --name <name> [--scope read|add|control|admin]  (prints the token once)")
+	fmt.Println("  dlq token revoke <token_id>")
+	fmt.Println("  (the CLI sends $DLQ_TOKEN, the \"token\" from $DLQ_CONFIG or ~/.config/dlq/config.json, or $DLQ_STATE_DIR/cli.token)")
 	fmt.Println("")
 	fmt.Println("Maintenance:")
 	fmt.Println("  dlq clear      (clear completed jobs)")
 	fmt.Println("  dlq purge      (delete all jobs and events)")
 	fmt.Println("")
 	fmt.Println("Configuration:")
-	fmt.Println("  dlq settings [--concurrency <1-10>] [--auto-decrypt <true|false>]")
+	fmt.Println("  dlq settings [--concurrency <1-10>] [--auto-decrypt <true|false>] [--archive-cleanup keep|delete|trash]")
+	fmt.Println("               [--site-engine site=aria2|native]")
+	fmt.Println("               [--speed-limit <rate>] [--speed-schedule [days@]HH:MM-HH:MM=rate|none]")
+	fmt.Println("               [--site-concurrency site=N] [--host-concurrency host=N] [--min-free root=size]")
+	fmt.Println("               [--seed-ratio <ratio>] [--seed-time <minutes>] [--hook-timeout <seconds>] [--hook-fail-job <true|false>]")
 }
 
 func apiBase() string {
 	if v := os.Getenv("DLQ_API"); v != "" {
 		return v
 	}
+	if cfg := loadConfig(); cfg.API != "" {
+		return cfg.API
+	}
 	return defaultAPI
 }
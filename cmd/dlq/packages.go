@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type packageView struct {
+	ID            int64          `json:"id"`
+	Name          string         `json:"name"`
+	Status        string         `json:"status"`
+	JobCount      int            `json:"job_count"`
+	StatusCounts  map[string]int `json:"status_counts"`
+	SizeBytes     int64          `json:"size_bytes"`
+	BytesDone     int64          `json:"bytes_done"`
+	DownloadSpeed int64          `json:"download_speed"`
+	EtaSeconds    int64          `json:"eta_seconds"`
+	CreatedAt     string         `json:"created_at"`
+	Jobs          []jobView      `json:"jobs"`
+}
+
+func cmdPackages(args []string) {
+	fs := flag.NewFlagSet("packages", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 {
+		listPackages(*api)
+		return
+	}
+	switch positional[0] {
+	case "pause", "resume", "retry", "remove":
+		if len(positional) < 2 {
+			fmt.Printf("usage: dlq packages %s <package_id>\n", positional[0])
+			return
+		}
+		if err := postJSON(fmt.Sprintf("%s/packages/%s/%s", *api, positional[1], positional[0]), map[string]any{}, nil); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("ok")
+	default:
+		showPackage(*api, positional[0])
+	}
+}
+
+func listPackages(api string) {
+	var pkgs []packageView
+	if err := getJSON(api+"/packages", &pkgs); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(pkgs) == 0 {
+		fmt.Println("No packages.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tJOBS\tPROGRESS\tSPEED\tETA\tNAME")
+	for _, p := range pkgs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Status, packageJobsSummary(p), formatProgress(p.BytesDone, p.SizeBytes),
+			formatPackageSpeed(p), formatPackageETA(p), p.Name)
+	}
+	_ = tw.Flush()
+}
+
+func showPackage(api, id string) {
+	var pkg packageView
+	if err := getJSON(api+"/packages/"+id, &pkg); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Printf("Package %d: %s\n", pkg.ID, pkg.Name)
+	fmt.Printf("  status: %s | jobs: %s | progress: %s | speed: %s | eta: %s\n",
+		pkg.Status, packageJobsSummary(pkg), formatProgress(pkg.BytesDone, pkg.SizeBytes), formatPackageSpeed(pkg), formatPackageETA(pkg))
+	printJobs(pkg.Jobs)
+}
+
+func packageJobsSummary(p packageView) string {
+	return fmt.Sprintf("%d/%d done", p.StatusCounts["completed"], p.JobCount)
+}
+
+func formatPackageSpeed(p packageView) string {
+	if p.DownloadSpeed <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/s", humanBytes(p.DownloadSpeed))
+}
+
+func formatPackageETA(p packageView) string {
+	if p.EtaSeconds <= 0 {
+		return "-"
+	}
+	return humanDuration(time.Duration(p.EtaSeconds) * time.Second)
+}
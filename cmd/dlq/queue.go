@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type timeWindowView struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+type queueStatusView struct {
+	Open                bool             `json:"open"`
+	Reason              string           `json:"reason"`
+	Paused              bool             `json:"paused"`
+	DownloadWindows     []timeWindowView `json:"download_windows"`
+	PauseOutsideWindows bool             `json:"pause_outside_windows"`
+}
+
+func cmdQueue(args []string) {
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	pauseActive := fs.String("pause-active", "", "with schedule: pause running downloads when the window closes (true|false)")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 || positional[0] == "status" {
+		showQueueStatus(*api)
+		return
+	}
+	switch positional[0] {
+	case "pause", "resume":
+		var status queueStatusView
+		if err := postJSON(*api+"/queue/"+positional[0], map[string]any{}, &status); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		printQueueStatus(status)
+	case "schedule":
+		updates := map[string]any{}
+		if *pauseActive != "" {
+			parsed, err := strconv.ParseBool(*pauseActive)
+			if err != nil {
+				fmt.Println("error: --pause-active must be true or false")
+				return
+			}
+			updates["pause_outside_windows"] = parsed
+		}
+		if len(positional) > 1 {
+			windows := []map[string]any{}
+			for _, v := range positional[1:] {
+				if strings.EqualFold(strings.TrimSpace(v), "none") {
+					continue
+				}
+				window, err := parseTimeWindow(v)
+				if err != nil {
+					fmt.Println("error:", err)
+					return
+				}
+				windows = append(windows, window)
+			}
+			updates["download_windows"] = windows
+		}
+		if len(updates) > 0 {
+			if err := postJSON(*api+"/api/settings", updates, nil); err != nil {
+				fmt.Println("error:", err)
+				return
+			}
+		}
+		showQueueStatus(*api)
+	default:
+		fmt.Println("usage: dlq queue [status|pause|resume|schedule]")
+	}
+}
+
+func showQueueStatus(api string) {
+	var status queueStatusView
+	if err := getJSON(api+"/queue", &status); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	printQueueStatus(status)
+}
+
+func printQueueStatus(status queueStatusView) {
+	state := "open"
+	if !status.Open {
+		state = "closed (" + status.Reason + ")"
+	}
+	fmt.Printf("Queue: %s\n", state)
+	if len(status.DownloadWindows) == 0 {
+		fmt.Println("Schedule: always")
+		return
+	}
+	parts := make([]string, 0, len(status.DownloadWindows))
+	for _, w := range status.DownloadWindows {
+		span := w.Start + "-" + w.End
+		if len(w.Days) > 0 {
+			span = strings.Join(w.Days, ",") + "@" + span
+		}
+		parts = append(parts, span)
+	}
+	fmt.Printf("Schedule: %s\n", strings.Join(parts, " "))
+	if status.PauseOutsideWindows {
+		fmt.Println("Running downloads are paused outside the schedule.")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type siteInfoView struct {
+	Site  string   `json:"site"`
+	Type  string   `json:"type"`
+	Path  string   `json:"path"`
+	Hosts []string `json:"hosts"`
+}
+
+func cmdResolvers(args []string) {
+	fs := flag.NewFlagSet("resolvers", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	fs.Parse(args)
+	var sites []siteInfoView
+	if err := getJSON(*api+"/api/resolvers", &sites); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(sites) == 0 {
+		fmt.Println("No resolvers.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SITE\tTYPE\tHOSTS\tPATH")
+	for _, s := range sites {
+		hosts := strings.Join(s.Hosts, ",")
+		if hosts == "" {
+			hosts = "-"
+		}
+		path := s.Path
+		if path == "" {
+			path = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Site, s.Type, hosts, path)
+	}
+	_ = tw.Flush()
+}
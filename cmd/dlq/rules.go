@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type ruleView struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Site     string   `json:"site"`
+	Host     string   `json:"host"`
+	Pattern  string   `json:"pattern"`
+	Ext      []string `json:"ext"`
+	MinSize  int64    `json:"min_size"`
+	MaxSize  int64    `json:"max_size"`
+	Root     string   `json:"root"`
+	Template string   `json:"template"`
+}
+
+type rulePreview struct {
+	Matched  bool   `json:"matched"`
+	RuleID   int64  `json:"rule_id"`
+	RuleName string `json:"rule_name"`
+	OutDir   string `json:"out_dir"`
+	Name     string `json:"name"`
+	Error    string `json:"error"`
+}
+
+func cmdRules(args []string) {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	name := fs.String("name", "", "with add: label shown in the list")
+	site := fs.String("site", "", "with add: match the job site; with test: site of the job")
+	host := fs.String("host", "", "with add: match the URL host and its subdomains")
+	pattern := fs.String("pattern", "", "with add: regexp matched against the filename")
+	ext := fs.String("ext", "", "with add: comma-separated extensions (mkv,mp4)")
+	minSize := fs.String("min-size", "", "with add: smallest matching size (e.g. 700M)")
+	maxSize := fs.String("max-size", "", "with add: largest matching size (e.g. 4G)")
+	root := fs.String("root", "", "with add: value of {root} (default: first DATA_* volume)")
+	template := fs.String("template", "", "with add: target path, e.g. {root}/{site}/{package}/{filename}")
+	filename := fs.String("filename", "", "with test: filename (default: last segment of the URL)")
+	size := fs.String("size", "", "with test: file size (e.g. 1.5G)")
+	pkg := fs.String("package", "", "with test: package name")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 || positional[0] == "list" {
+		listRules(*api)
+		return
+	}
+	switch positional[0] {
+	case "add":
+		if *template == "" {
+			fmt.Println("usage: dlq rules add --template {root}/{site}/{filename} [--site s] [--host h] [--pattern re] [--ext mkv,mp4] [--min-size 700M] [--max-size 4G] [--root /data/x] [--name n]")
+			return
+		}
+		req := map[string]any{
+			"name":     *name,
+			"site":     *site,
+			"host":     *host,
+			"pattern":  *pattern,
+			"root":     *root,
+			"template": *template,
+		}
+		if *ext != "" {
+			req["ext"] = strings.Split(*ext, ",")
+		}
+		if *minSize != "" {
+			req["min_size"] = *minSize
+		}
+		if *maxSize != "" {
+			req["max_size"] = *maxSize
+		}
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		if err := postJSON(*api+"/api/rules", req, &resp); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("rule %d added\n", resp.ID)
+	case "remove":
+		if len(positional) < 2 {
+			fmt.Println("usage: dlq rules remove <rule_id>")
+			return
+		}
+		if err := deleteJSON(fmt.Sprintf("%s/api/rules/%s", *api, positional[1]), nil); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("ok")
+	case "test":
+		if len(positional) < 2 {
+			fmt.Println("usage: dlq rules test <url> [--filename f] [--size 1G] [--site s] [--package p]")
+			return
+		}
+		req := map[string]any{
+			"url":      positional[1],
+			"site":     *site,
+			"filename": *filename,
+			"package":  *pkg,
+		}
+		if *size != "" {
+			req["size"] = *size
+		}
+		var preview rulePreview
+		if err := postJSON(*api+"/api/rules/preview", req, &preview); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		if !preview.Matched {
+			fmt.Println("no rule matched")
+			return
+		}
+		label := fmt.Sprintf("rule %d", preview.RuleID)
+		if preview.RuleName != "" {
+			label += " (" + preview.RuleName + ")"
+		}
+		if preview.Error != "" {
+			fmt.Printf("%s matched, but: %s\n", label, preview.Error)
+			return
+		}
+		fmt.Printf("%s: %s/%s\n", label, strings.TrimSuffix(preview.OutDir, "/"), preview.Name)
+	default:
+		fmt.Println("usage: dlq rules [list|add|remove|test]")
+	}
+}
+
+func listRules(api string) {
+	var rules []ruleView
+	if err := getJSON(api+"/api/rules", &rules); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(rules) == 0 {
+		fmt.Println("No rules.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tMATCH\tTEMPLATE")
+	for _, r := range rules {
+		var match []string
+		if r.Site != "" {
+			match = append(match, "site="+r.Site)
+		}
+		if r.Host != "" {
+			match = append(match, "host="+r.Host)
+		}
+		if r.Pattern != "" {
+			match = append(match, "pattern="+r.Pattern)
+		}
+		if len(r.Ext) > 0 {
+			match = append(match, "ext="+strings.Join(r.Ext, ","))
+		}
+		if r.MinSize > 0 {
+			match = append(match, "size>="+humanBytes(r.MinSize))
+		}
+		if r.MaxSize > 0 {
+			match = append(match, "size<="+humanBytes(r.MaxSize))
+		}
+		if len(match) == 0 {
+			match = append(match, "any")
+		}
+		template := r.Template
+		if r.Root != "" {
+			template += " (root=" + r.Root + ")"
+		}
+		label := r.Name
+		if label == "" {
+			label = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.ID, label, strings.Join(match, " "), template)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// streamEvents follows GET /events/stream, calling handle for every event
+// until it returns false. A dropped connection is reopened with
+// Last-Event-ID so no log rows are lost; handle sees a synthetic "open" event
+// each time a connection is (re)established, before the server's snapshot.
+func streamEvents(url string, handle func(sseEvent) bool) error {
+	client := &http.Client{}
+	lastID := ""
+	failures := 0
+	for {
+		req, err := newAPIRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			failures++
+			if failures >= 5 {
+				return err
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			err := readHTTPError(resp)
+			resp.Body.Close()
+			return err
+		}
+		failures = 0
+		if !handle(sseEvent{Event: "open"}) {
+			resp.Body.Close()
+			return nil
+		}
+		done := readSSE(resp, func(ev sseEvent) bool {
+			if ev.ID != "" {
+				lastID = ev.ID
+			}
+			return handle(ev)
+		})
+		resp.Body.Close()
+		if done {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// readSSE dispatches events from one response body. It reports whether
+// handle asked to stop.
+func readSSE(resp *http.Response, handle func(sseEvent) bool) bool {
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var ev sseEvent
+	var data []string
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if ev.Event != "" || len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if !handle(ev) {
+					return true
+				}
+			}
+			ev, data = sseEvent{}, nil
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return false
+}
+
+// watchStatus redraws the status table from the event stream, at most once
+// per interval, until no job is active any more.
+func watchStatus(api, status string, interval time.Duration) error {
+	events := make(chan sseEvent)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- streamEvents(api+"/events/stream?types=job", func(ev sseEvent) bool {
+			events <- ev
+			return true
+		})
+	}()
+	jobs := map[int64]jobView{}
+	ready, dirty := false, false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case ev := <-events:
+			switch ev.Event {
+			case "open":
+				jobs, ready = map[int64]jobView{}, false
+			case "job":
+				var j jobView
+				if err := json.Unmarshal([]byte(ev.Data), &j); err == nil {
+					jobs[j.ID] = j
+					dirty = true
+				}
+			case "job_removed":
+				var removed struct {
+					ID int64 `json:"id"`
+				}
+				if err := json.Unmarshal([]byte(ev.Data), &removed); err == nil {
+					delete(jobs, removed.ID)
+					dirty = true
+				}
+			case "ready":
+				if !ready {
+					ready, dirty = true, false
+					if !drawStatus(jobs, status) {
+						return nil
+					}
+				}
+			}
+		case <-ticker.C:
+			if ready && dirty {
+				dirty = false
+				if !drawStatus(jobs, status) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// drawStatus prints the status view for jobs (newest first) and reports
+// whether any of them is still active.
+func drawStatus(all map[int64]jobView, status string) bool {
+	jobs := make([]jobView, 0, len(all))
+	for _, j := range all {
+		if status == "" || j.Status == status {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID > jobs[b].ID })
+	fmt.Print("\033[H\033[2J")
+	printStatus(jobs)
+	return hasActiveJobs(jobs)
+}
+
+// followLogs prints a job's newest log lines and then new ones as they are
+// written, until interrupted.
+func followLogs(api, id string, tail int) error {
+	url := fmt.Sprintf("%s/events/stream?types=log&job=%s&tail=%d", api, id, tail)
+	return streamEvents(url, func(ev sseEvent) bool {
+		if ev.Event != "log" {
+			return true
+		}
+		var row struct {
+			Level     string `json:"level"`
+			Message   string `json:"message"`
+			CreatedAt string `json:"created_at"`
+		}
+		if err := json.Unmarshal([]byte(ev.Data), &row); err == nil {
+			fmt.Println(row.CreatedAt + " " + row.Level + " " + row.Message)
+		}
+		return true
+	})
+}
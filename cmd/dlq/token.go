@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+type tokenView struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	Prefix    string `json:"prefix"`
+	CreatedAt string `json:"created_at"`
+	Token     string `json:"token"`
+}
+
+func cmdToken(args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	api := fs.String("api", apiBase(), "api base URL")
+	name := fs.String("name", "", "with create: token name")
+	scope := fs.String("scope", "read", "with create: read|add|control|admin")
+	positional := parseJobArgs(fs, args)
+	if len(positional) == 0 || positional[0] == "list" {
+		listTokens(*api)
+		return
+	}
+	switch positional[0] {
+	case "create":
+		if *name == "" && len(positional) > 1 {
+			*name = positional[1]
+		}
+		if *name == "" {
+			fmt.Println("usage: dlq token create --name <name> [--scope read|add|control|admin]")
+			return
+		}
+		var tok tokenView
+		if err := postJSON(*api+"/api/tokens", map[string]string{"name": *name, "scope": *scope}, &tok); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("token %d (%s, scope %s) created; it is shown only once:\n%s\n", tok.ID, tok.Name, tok.Scope, tok.Token)
+	case "revoke":
+		if len(positional) < 2 {
+			fmt.Println("usage: dlq token revoke <token_id>")
+			return
+		}
+		if err := deleteJSON(fmt.Sprintf("%s/api/tokens/%s", *api, positional[1]), nil); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("ok")
+	default:
+		fmt.Println("usage: dlq token [list] | create --name <name> [--scope read|add|control|admin] | revoke <token_id>")
+	}
+}
+
+func listTokens(api string) {
+	var tokens []tokenView
+	if err := getJSON(api+"/api/tokens", &tokens); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No tokens.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tPREFIX\tCREATED")
+	for _, t := range tokens {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s…\t%s\n", t.ID, t.Name, t.Scope, t.Prefix, t.CreatedAt)
+	}
+	_ = tw.Flush()
+}
@@ -6,13 +6,16 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/Witriol/dlq-download-queue/internal/accounts"
 	"github.com/Witriol/dlq-download-queue/internal/api"
 	"github.com/Witriol/dlq-download-queue/internal/db"
 	"github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/metrics"
 	"github.com/Witriol/dlq-download-queue/internal/queue"
 	"github.com/Witriol/dlq-download-queue/internal/resolver"
 )
@@ -24,6 +27,7 @@ func main() {
 	listen := httpListenAddr()
 	aria2RPC := getenv("ARIA2_RPC", "http://127.0.0.1:6800/jsonrpc")
 	aria2Secret := getenv("ARIA2_SECRET", "")
+	aria2WS := getenv("ARIA2_WS", downloader.WebSocketURL(aria2RPC))
 	outDirPresets := outDirPresetsFromEnv()
 
 	dbConn, err := db.Open(dbPath)
@@ -31,20 +35,67 @@ func main() {
 		log.Fatalf("db open: %v", err)
 	}
 	store := queue.NewStore(dbConn)
+	metrics.Default.OnCollect(store.CollectMetrics)
 	service := queue.NewService(store, downloader.NewAria2Client(aria2RPC, aria2Secret), outDirPresets)
+	httpEngine := downloader.NewHTTPEngine()
+	service.RegisterEngine(queue.EngineNative, httpEngine)
 
-	webshareResolver := resolver.NewWebshareResolver()
+	accountStore, err := accounts.Open(stateDir, os.Getenv("DLQ_ACCOUNTS_KEY"))
+	if err != nil {
+		log.Fatalf("accounts init: %v", err)
+	}
+	webshareResolver := resolver.NewWebshareResolver(os.Getenv("WEBSHARE_USERNAME"), os.Getenv("WEBSHARE_PASSWORD"), accountStore)
 	megaResolver := resolver.NewMegaResolver()
 	httpResolver := resolver.NewHTTPResolver()
-	resRegistry := resolver.NewRegistry(
-		webshareResolver,
-		megaResolver,
-		httpResolver,
-	)
-	resRegistry.RegisterSite("webshare", webshareResolver)
-	resRegistry.RegisterSite("mega", megaResolver)
-	resRegistry.RegisterSite("http", httpResolver)
-	resRegistry.RegisterSite("https", httpResolver)
+	torrentDir := filepath.Join(stateDir, "torrents")
+	service.SetTorrentDir(torrentDir)
+	torrentResolver := resolver.NewTorrentResolver(torrentDir)
+	metalinkResolver := resolver.NewMetalinkResolver()
+	builtinSites := map[string]resolver.Resolver{
+		"webshare": webshareResolver,
+		"mega":     megaResolver,
+		"torrent":  torrentResolver,
+		"metalink": metalinkResolver,
+		"http":     httpResolver,
+		"https":    httpResolver,
+	}
+	// Plugins are tried after the hoster resolvers and before the generic
+	// HTTP resolver, which accepts any http(s) URL.
+	resolvers := []resolver.Resolver{webshareResolver, megaResolver, torrentResolver, metalinkResolver}
+	pluginDir := getenv("DLQ_RESOLVERS_DIR", filepath.Join(stateDir, "resolvers"))
+	plugins, err := resolver.LoadPlugins(pluginDir)
+	if err != nil {
+		log.Printf("resolver plugins: %v", err)
+	}
+	var pluginSites []*resolver.PluginResolver
+	for _, p := range plugins {
+		if _, taken := builtinSites[p.Name]; taken {
+			log.Printf("resolver plugin %s: site %q is built in; skipped", p.Path, p.Name)
+			continue
+		}
+		log.Printf("resolver plugin %s loaded from %s", p.Name, p.Path)
+		p.Accounts = accountStore
+		resolvers = append(resolvers, p)
+		pluginSites = append(pluginSites, p)
+	}
+	resolvers = append(resolvers, httpResolver)
+	resRegistry := resolver.NewRegistry(resolvers...)
+	for name, res := range builtinSites {
+		resRegistry.RegisterSite(name, res)
+	}
+	for _, p := range pluginSites {
+		resRegistry.RegisterSite(p.Name, p)
+	}
+	service.SetSites(resRegistry)
+
+	scriptDir := getenv("DLQ_SCRIPTS_DIR", filepath.Join(stateDir, "scripts"))
+	hookScripts, err := queue.LoadHookScripts(scriptDir)
+	if err != nil {
+		log.Printf("hook scripts: %v", err)
+	}
+	for _, script := range hookScripts {
+		log.Printf("hook script %s loaded", script)
+	}
 
 	settings, err := api.NewSettings(stateDir)
 	if err != nil {
@@ -52,24 +103,64 @@ func main() {
 	}
 
 	runner := &queue.Runner{
-		Store:            store,
-		Resolvers:        resRegistry,
-		Downloader:       downloader.NewAria2Client(aria2RPC, aria2Secret),
-		MegaDecryptor:    queue.NewMegaDecryptor(),
-		ArchiveDecryptor: queue.NewArchiveDecryptor(),
-		GetConcurrency:   settings.GetConcurrency,
-		GetAutoDecrypt:   settings.GetAutoDecrypt,
-		PollEvery:        2 * time.Second,
+		Store:              store,
+		Resolvers:          resRegistry,
+		Downloader:         downloader.NewAria2Client(aria2RPC, aria2Secret),
+		Engines:            map[string]queue.Downloader{queue.EngineNative: httpEngine},
+		GetSiteEngine:      settings.GetSiteEngine,
+		GetSpeedLimit:      settings.GetSpeedLimit,
+		GetQueueState:      settings.GetQueueState,
+		GetMinFreeSpace:    settings.GetMinFreeSpace,
+		MegaDecryptor:      queue.NewMegaDecryptor(),
+		ArchiveDecryptor:   queue.NewArchiveDecryptor(),
+		Par2Repairer:       queue.NewPar2Repairer(),
+		GetConcurrency:     settings.GetConcurrency,
+		GetSiteConcurrency: settings.GetSiteConcurrency,
+		GetHostConcurrency: settings.GetHostConcurrency,
+		GetAutoDecrypt:     settings.GetAutoDecrypt,
+		GetArchiveCleanup:  settings.GetArchiveCleanup,
+		AllowedRoots:       outDirPresets,
+		GetSeedLimits:      settings.GetSeedLimits,
+		HookScripts:        hookScripts,
+		GetHookPolicy:      settings.GetHookPolicy,
+		PollEvery:          2 * time.Second,
+	}
+	if aria2WS != "" && aria2WS != "off" {
+		runner.Notifier = downloader.NewAria2Notifier(aria2WS)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go runner.Start(ctx)
+	webhooks := &queue.WebhookSender{Store: store, Client: &http.Client{Timeout: 20 * time.Second}}
+	go webhooks.Start(ctx)
+
+	tokens, err := api.NewTokens(stateDir)
+	if err != nil {
+		log.Fatalf("tokens init: %v", err)
+	}
+	if err := api.EnsureCLIToken(tokens, stateDir); err != nil {
+		log.Fatalf("tokens init: %v", err)
+	}
+	anonymousScope := strings.ToLower(strings.TrimSpace(os.Getenv("DLQ_ANONYMOUS_SCOPE")))
+	if anonymousScope == "none" {
+		anonymousScope = ""
+	}
+	if anonymousScope != "" {
+		if !api.ValidScope(anonymousScope) {
+			log.Fatalf("DLQ_ANONYMOUS_SCOPE must be read, add, control, admin or none, got %q", anonymousScope)
+		}
+		log.Printf("unauthenticated requests are allowed with scope %s", anonymousScope)
+	}
 
 	server := &api.Server{
-		Queue:    service,
-		Meta:     &api.Meta{OutDirPresets: outDirPresets, Version: versionString()},
-		Settings: settings,
+		Queue:          service,
+		Meta:           &api.Meta{OutDirPresets: outDirPresets, Version: versionString()},
+		Settings:       settings,
+		Tokens:         tokens,
+		AnonymousScope: anonymousScope,
+		Resolvers:      resRegistry,
+		Accounts:       accountStore,
 	}
 	ln, err := net.Listen("tcp", listen)
 	if err != nil {
@@ -2,7 +2,10 @@ module github.com/Witriol/dlq-download-queue
 
 go 1.22
 
-require modernc.org/sqlite v1.29.5
+require (
+	golang.org/x/crypto v0.18.0
+	modernc.org/sqlite v1.29.5
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -108,6 +108,7 @@ func TestPauseResumeHappyPathWithLiveAria2(t *testing.T) {
 		"-v", stateDir+":/state",
 		"-e", "DLQ_HTTP_ADDR=0.0.0.0:8099",
 		"-e", "DLQ_HTTP_PORT=8099",
+		"-e", "DLQ_ANONYMOUS_SCOPE=admin",
 		"-e", "DATA_DOWNLOADS=/tmp:/data",
 		"-e", "ARIA2_MAX_CONNECTION_PER_SERVER=1",
 		"-p", fmt.Sprintf("%d:8099", hostPort),
@@ -0,0 +1,393 @@
+// Package accounts keeps hoster credentials, encrypted at rest in the state
+// dir, and tracks which accounts are currently usable.
+package accounts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Account health states.
+const (
+	StatusOK          = "ok"
+	StatusExhausted   = "exhausted"    // quota used up; usable again after RetryAt
+	StatusLoginFailed = "login_failed" // credentials rejected; usable again after RetryAt
+)
+
+// How long an account is skipped after a quota or login error. They match
+// the retry delays the runner uses for jobs failing with the same codes.
+const (
+	exhaustedFor   = 2 * time.Hour
+	loginFailedFor = 6 * time.Hour
+)
+
+// sealedMagic starts accounts.enc when its key is derived from
+// DLQ_ACCOUNTS_KEY; the scrypt salt follows it, then nonce and ciphertext.
+const sealedMagic = "dlqacct1"
+
+const saltSize = 16
+
+var ErrNotFound = errors.New("account not found")
+
+// Account is a stored hoster login with its health.
+type Account struct {
+	ID         int64  `json:"id"`
+	Site       string `json:"site"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Status     string `json:"status"`
+	LastError  string `json:"last_error,omitempty"`
+	RetryAt    string `json:"retry_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// View is the API shape of an account (never includes the password).
+type View struct {
+	ID         int64  `json:"id"`
+	Site       string `json:"site"`
+	Username   string `json:"username"`
+	Status     string `json:"status"`
+	LastError  string `json:"last_error,omitempty"`
+	RetryAt    string `json:"retry_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type storeData struct {
+	NextID   int64     `json:"next_id"`
+	Accounts []Account `json:"accounts"`
+}
+
+// Store is the account store, persisted as accounts.enc in the state dir and
+// sealed with AES-256-GCM.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	aead cipher.AEAD
+	salt []byte // scrypt salt when the key comes from a secret
+	data storeData
+	now  func() time.Time
+}
+
+// Open loads the store from stateDir. With a secret (DLQ_ACCOUNTS_KEY) the
+// encryption key is derived from it with scrypt and a salt kept in
+// accounts.enc; otherwise a random key is created once in
+// <stateDir>/accounts.key.
+func Open(stateDir, secret string) (*Store, error) {
+	s := &Store{path: filepath.Join(stateDir, "accounts.enc"), data: storeData{NextID: 1}, now: time.Now}
+	sealed, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load accounts: %w", err)
+	}
+	exists := err == nil
+	salted := bytes.HasPrefix(sealed, []byte(sealedMagic))
+	var key []byte
+	switch {
+	case secret == "":
+		if salted {
+			return nil, errors.New("load accounts: sealed with DLQ_ACCOUNTS_KEY, which is not set")
+		}
+		key, err = loadKey(stateDir)
+	case salted:
+		sealed = sealed[len(sealedMagic):]
+		if len(sealed) < saltSize {
+			return nil, errors.New("load accounts: file is truncated")
+		}
+		s.salt, sealed = sealed[:saltSize], sealed[saltSize:]
+		key, err = deriveKey(secret, s.salt)
+	case exists:
+		return nil, errors.New("load accounts: sealed with accounts.key, but DLQ_ACCOUNTS_KEY is set")
+	default:
+		s.salt, key, err = newSaltedKey(secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return s, nil
+	}
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("load accounts: file is truncated")
+	}
+	plain, err := s.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, errors.New("load accounts: cannot decrypt (wrong key?)")
+	}
+	if err := json.Unmarshal(plain, &s.data); err != nil {
+		return nil, fmt.Errorf("load accounts: %w", err)
+	}
+	return s, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey stretches the accounts secret into an AES-256 key.
+func deriveKey(secret string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(secret), salt, 1<<15, 8, 1, 32)
+}
+
+func newSaltedKey(secret string) (salt, key []byte, err error) {
+	salt = make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	key, err = deriveKey(secret, salt)
+	return salt, key, err
+}
+
+func loadKey(stateDir string) ([]byte, error) {
+	path := filepath.Join(stateDir, "accounts.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid accounts key in %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load accounts key: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, fmt.Errorf("create accounts dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("write accounts key: %w", err)
+	}
+	return key, nil
+}
+
+// save encrypts and writes the store; callers hold s.mu.
+func (s *Store) save() error {
+	plain, err := json.Marshal(s.data)
+	if err != nil {
+		return fmt.Errorf("marshal accounts: %w", err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	var sealed []byte
+	if s.salt != nil {
+		sealed = append([]byte(sealedMagic), s.salt...)
+	}
+	sealed = append(sealed, nonce...)
+	sealed = s.aead.Seal(sealed, nonce, plain, nil)
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("write accounts: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write accounts: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) timestamp() string {
+	return s.now().UTC().Format(time.RFC3339)
+}
+
+// Add stores an account for site.
+func (s *Store) Add(site, username, password string) (View, error) {
+	site = strings.ToLower(strings.TrimSpace(site))
+	username = strings.TrimSpace(username)
+	if site == "" {
+		return View{}, errors.New("site is required")
+	}
+	if username == "" {
+		return View{}, errors.New("username is required")
+	}
+	if password == "" {
+		return View{}, errors.New("password is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.data.Accounts {
+		if a.Site == site && a.Username == username {
+			return View{}, fmt.Errorf("account %s already exists for %s", username, site)
+		}
+	}
+	acct := Account{
+		ID:        s.data.NextID,
+		Site:      site,
+		Username:  username,
+		Password:  password,
+		Status:    StatusOK,
+		CreatedAt: s.timestamp(),
+	}
+	prev := s.data
+	s.data.Accounts = append(append([]Account{}, s.data.Accounts...), acct)
+	s.data.NextID++
+	if err := s.save(); err != nil {
+		s.data = prev
+		return View{}, err
+	}
+	return acct.view(), nil
+}
+
+// List returns the accounts of site, or of every site when site is empty,
+// sorted by site and then in rotation order.
+func (s *Store) List(site string) []View {
+	site = strings.ToLower(strings.TrimSpace(site))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []View{}
+	for _, a := range s.data.Accounts {
+		if site == "" || a.Site == site {
+			out = append(out, a.view())
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Site < out[j].Site })
+	return out
+}
+
+func (s *Store) Remove(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, a := range s.data.Accounts {
+		if a.ID != id {
+			continue
+		}
+		prev := s.data.Accounts
+		s.data.Accounts = append(append([]Account{}, prev[:i]...), prev[i+1:]...)
+		if err := s.save(); err != nil {
+			s.data.Accounts = prev
+			return err
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Reset marks an account usable again, e.g. after its password was fixed on
+// the hoster or a new quota was bought.
+func (s *Store) Reset(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data.Accounts {
+		a := &s.data.Accounts[i]
+		if a.ID != id {
+			continue
+		}
+		prev := *a
+		a.setHealth(StatusOK, "", time.Time{})
+		if err := s.save(); err != nil {
+			*a = prev
+			return err
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (a *Account) setHealth(status, lastError string, retryAt time.Time) {
+	a.Status, a.LastError, a.RetryAt = status, lastError, ""
+	if !retryAt.IsZero() {
+		a.RetryAt = retryAt.UTC().Format(time.RFC3339)
+	}
+}
+
+// Next implements resolver.AccountSource: the first account of site not in
+// skip that is healthy or whose RetryAt has passed.
+func (s *Store) Next(site string, skip []int64) (resolver.Account, bool) {
+	site = strings.ToLower(strings.TrimSpace(site))
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.data.Accounts {
+		if a.Site != site || containsID(skip, a.ID) {
+			continue
+		}
+		if a.Status != StatusOK {
+			retryAt, err := time.Parse(time.RFC3339, a.RetryAt)
+			if err == nil && now.Before(retryAt) {
+				continue
+			}
+		}
+		return resolver.Account{ID: a.ID, Username: a.Username, Password: a.Password}, true
+	}
+	return resolver.Account{}, false
+}
+
+// Report implements resolver.AccountSource. Quota errors mark the account
+// exhausted and login errors mark it failed, each for a while; success makes
+// it healthy again. Other errors say nothing about the account.
+func (s *Store) Report(id int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data.Accounts {
+		a := &s.data.Accounts[i]
+		if a.ID != id {
+			continue
+		}
+		prev := *a
+		a.LastUsedAt = s.timestamp()
+		switch {
+		case err == nil:
+			a.setHealth(StatusOK, "", time.Time{})
+		case errors.Is(err, resolver.ErrQuotaExceeded):
+			a.setHealth(StatusExhausted, err.Error(), s.now().Add(exhaustedFor))
+		case errors.Is(err, resolver.ErrLoginRequired):
+			a.setHealth(StatusLoginFailed, err.Error(), s.now().Add(loginFailedFor))
+		}
+		if saveErr := s.save(); saveErr != nil {
+			*a = prev
+			log.Printf("accounts: save health of account %d: %v", id, saveErr)
+		}
+		return
+	}
+}
+
+func (a Account) view() View {
+	return View{
+		ID:         a.ID,
+		Site:       a.Site,
+		Username:   a.Username,
+		Status:     a.Status,
+		LastError:  a.LastError,
+		RetryAt:    a.RetryAt,
+		LastUsedAt: a.LastUsedAt,
+		CreatedAt:  a.CreatedAt,
+	}
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package accounts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+func TestStoreEncryptsAndReloads(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, "")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := s.Add("Webshare", "alice", "hunter2"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := s.Add("webshare", "alice", "other"); err == nil {
+		t.Fatalf("expected duplicate account error")
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "accounts.enc"))
+	if err != nil {
+		t.Fatalf("read store: %v", err)
+	}
+	if bytes.Contains(raw, []byte("hunter2")) || bytes.Contains(raw, []byte("alice")) {
+		t.Fatalf("store is not encrypted: %q", raw)
+	}
+
+	reopened, err := Open(dir, "")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	acct, ok := reopened.Next("webshare", nil)
+	if !ok || acct.Username != "alice" || acct.Password != "hunter2" {
+		t.Fatalf("next = %+v, %v", acct, ok)
+	}
+	if _, err := Open(dir, "some passphrase"); err == nil {
+		t.Fatalf("expected error opening with a different key")
+	}
+}
+
+func TestStoreRotatesExhaustedAccounts(t *testing.T) {
+	s, err := Open(t.TempDir(), "passphrase")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	a, _ := s.Add("webshare", "a", "pa")
+	b, _ := s.Add("webshare", "b", "pb")
+	_, _ = s.Add("mega", "m", "pm")
+
+	s.Report(a.ID, fmt.Errorf("%w: daily limit", resolver.ErrQuotaExceeded))
+	next, ok := s.Next("webshare", nil)
+	if !ok || next.ID != b.ID {
+		t.Fatalf("next after exhausting a = %+v, %v; want b", next, ok)
+	}
+	s.Report(b.ID, resolver.ErrLoginRequired)
+	if _, ok := s.Next("webshare", nil); ok {
+		t.Fatalf("expected no usable webshare account")
+	}
+	views := s.List("webshare")
+	if views[0].Status != StatusExhausted || views[1].Status != StatusLoginFailed || views[0].RetryAt == "" {
+		t.Fatalf("views = %+v", views)
+	}
+
+	now = now.Add(3 * time.Hour)
+	next, ok = s.Next("webshare", nil)
+	if !ok || next.ID != a.ID {
+		t.Fatalf("next after cooldown = %+v, %v; want a", next, ok)
+	}
+	if _, ok := s.Next("webshare", []int64{a.ID}); ok {
+		t.Fatalf("b should still be in its login cooldown")
+	}
+	s.Report(a.ID, nil)
+	if v := s.List("webshare")[0]; v.Status != StatusOK || v.RetryAt != "" || v.LastUsedAt == "" {
+		t.Fatalf("a after success = %+v", v)
+	}
+	if err := s.Reset(b.ID); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if next, ok := s.Next("webshare", []int64{a.ID}); !ok || next.ID != b.ID {
+		t.Fatalf("next after reset = %+v, %v", next, ok)
+	}
+	if err := s.Remove(99); err != ErrNotFound {
+		t.Fatalf("remove unknown = %v", err)
+	}
+}
+
+func TestStoreSealsWithSecret(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := s.Add("webshare", "alice", "hunter2"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "accounts.enc"))
+	if err != nil || !bytes.HasPrefix(raw, []byte(sealedMagic)) {
+		t.Fatalf("store not sealed with a salted key: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "accounts.key")); !os.IsNotExist(err) {
+		t.Fatalf("accounts.key written although a secret is set: %v", err)
+	}
+	acct, ok := s.Next("webshare", nil)
+	if !ok || acct.Password != "hunter2" {
+		t.Fatalf("next = %+v, %v", acct, ok)
+	}
+	s.Report(acct.ID, nil)
+
+	reopened, err := Open(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if v := reopened.List("webshare")[0]; v.LastUsedAt == "" {
+		t.Fatalf("last use of a healthy account not saved: %+v", v)
+	}
+	if _, err := Open(dir, "other"); err == nil {
+		t.Fatalf("expected error opening with a different secret")
+	}
+	if _, err := Open(dir, ""); err == nil {
+		t.Fatalf("expected error opening a secret-sealed store without the secret")
+	}
+}
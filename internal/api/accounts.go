@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Witriol/dlq-download-queue/internal/accounts"
+)
+
+type addAccountRequest struct {
+	Site     string `json:"site"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if s.Accounts == nil {
+		writeErr(w, http.StatusNotFound, errors.New("account store is not configured"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Accounts.List(r.URL.Query().Get("site")))
+	case http.MethodPost:
+		var req addAccountRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		view, err := s.Accounts.Add(req.Site, req.Username, req.Password)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("action=account_add id=%d site=%s username=%q", view.ID, view.Site, view.Username)
+		writeJSON(w, http.StatusOK, view)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if s.Accounts == nil {
+		writeErr(w, http.StatusNotFound, errors.New("account store is not configured"))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/accounts/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	var action string
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		action = "remove"
+		err = s.Accounts.Remove(id)
+	case len(parts) == 2 && parts[1] == "reset" && r.Method == http.MethodPost:
+		action = "reset"
+		err = s.Accounts.Reset(id)
+	case len(parts) <= 2:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	default:
+		writeErr(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, err)
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("action=account_%s id=%d", action, id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Witriol/dlq-download-queue/internal/accounts"
+)
+
+func TestAccountsAPIHidesPasswords(t *testing.T) {
+	store, err := accounts.Open(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("open accounts: %v", err)
+	}
+	h := (&Server{Queue: &stubQueue{}, Accounts: store}).Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/accounts", strings.NewReader(`{"site":"webshare","username":"alice","password":"hunter2"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/accounts?site=webshare", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "hunter2") {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body.String())
+	}
+	var list []accounts.View
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Username != "alice" {
+		t.Fatalf("list = %+v, err = %v", list, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/accounts/1/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/accounts/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/accounts/1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("remove again: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes, from least to most privileged. Each scope includes the ones
+// before it.
+const (
+	ScopeRead    = "read"    // GET endpoints and the event stream
+	ScopeAdd     = "add"     // read + adding jobs and packages, previewing rules
+	ScopeControl = "control" // add + job, package and queue control: what the web UI needs to run the queue
+	ScopeAdmin   = "admin"   // everything, including settings, mkdir, tokens, accounts, webhooks and rules
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeAdd: 2, ScopeControl: 3, ScopeAdmin: 4}
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// ValidScope reports whether s is a known token scope.
+func ValidScope(s string) bool {
+	_, ok := scopeRank[s]
+	return ok
+}
+
+func scopeAllows(have, need string) bool {
+	return scopeRank[have] > 0 && scopeRank[have] >= scopeRank[need]
+}
+
+// Token is an API token as stored on disk. Only the SHA-256 of the secret is
+// kept; the secret itself is shown once when the token is created.
+type Token struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	Prefix    string `json:"prefix"`
+	Hash      string `json:"hash"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TokenView is the API shape of a token (never includes the hash).
+type TokenView struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	Prefix    string `json:"prefix"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Tokens is the token store, persisted as tokens.json in the state dir.
+type Tokens struct {
+	mu     sync.RWMutex
+	path   string
+	NextID int64   `json:"next_id"`
+	List   []Token `json:"tokens"`
+}
+
+func NewTokens(stateDir string) (*Tokens, error) {
+	t := &Tokens{path: filepath.Join(stateDir, "tokens.json"), NextID: 1}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("load tokens: %w", err)
+	}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("load tokens: %w", err)
+	}
+	return t, nil
+}
+
+// save writes the store; callers hold t.mu.
+func (t *Tokens) save() error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal tokens: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("create tokens dir: %w", err)
+	}
+	if err := os.WriteFile(t.path, data, 0600); err != nil {
+		return fmt.Errorf("write tokens: %w", err)
+	}
+	return nil
+}
+
+// Create adds a token and returns it with its secret.
+func (t *Tokens) Create(name, scope string) (TokenView, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return TokenView{}, "", errors.New("name is required")
+	}
+	if !ValidScope(scope) {
+		return TokenView{}, "", fmt.Errorf("scope must be one of %s, %s, %s, %s", ScopeRead, ScopeAdd, ScopeControl, ScopeAdmin)
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return TokenView{}, "", err
+	}
+	secret := "dlq_" + base64.RawURLEncoding.EncodeToString(buf)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tok := Token{
+		ID:        t.NextID,
+		Name:      name,
+		Scope:     scope,
+		Prefix:    secret[:12],
+		Hash:      hashToken(secret),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	t.List = append(t.List, tok)
+	t.NextID++
+	if err := t.save(); err != nil {
+		t.List = t.List[:len(t.List)-1]
+		t.NextID--
+		return TokenView{}, "", err
+	}
+	return tok.view(), secret, nil
+}
+
+func (t *Tokens) Views() []TokenView {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]TokenView, 0, len(t.List))
+	for _, tok := range t.List {
+		out = append(out, tok.view())
+	}
+	return out
+}
+
+func (t *Tokens) Revoke(id int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, tok := range t.List {
+		if tok.ID != id {
+			continue
+		}
+		list := append(append([]Token{}, t.List[:i]...), t.List[i+1:]...)
+		prev := t.List
+		t.List = list
+		if err := t.save(); err != nil {
+			t.List = prev
+			return err
+		}
+		return nil
+	}
+	return ErrTokenNotFound
+}
+
+// Lookup returns the token whose secret is raw.
+func (t *Tokens) Lookup(raw string) (Token, bool) {
+	hash := hashToken(raw)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, tok := range t.List {
+		if subtle.ConstantTimeCompare([]byte(tok.Hash), []byte(hash)) == 1 {
+			return tok, true
+		}
+	}
+	return Token{}, false
+}
+
+func (t *Tokens) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.List)
+}
+
+func (tok Token) view() TokenView {
+	return TokenView{ID: tok.ID, Name: tok.Name, Scope: tok.Scope, Prefix: tok.Prefix, CreatedAt: tok.CreatedAt}
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureCLIToken creates an admin token on first start, when the store is
+// empty, and writes its secret to <stateDir>/cli.token so the CLI inside the
+// container can authenticate without further setup.
+func EnsureCLIToken(tokens *Tokens, stateDir string) error {
+	if tokens.Len() > 0 {
+		return nil
+	}
+	view, secret, err := tokens.Create("cli", ScopeAdmin)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir, "cli.token")
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("write cli token: %w", err)
+	}
+	log.Printf("created admin token id=%d for the CLI in %s", view.ID, path)
+	return nil
+}
+
+// requiredScope maps a request to the least scope allowed to make it.
+// Tokens and accounts hold secrets, webhooks, rules and mkdir decide where
+// data goes and settings change how the whole daemon runs, so they stay with
+// admin; running the queue needs at most control.
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case path == "/api/tokens" || strings.HasPrefix(path, "/api/tokens/"),
+		path == "/api/accounts" || strings.HasPrefix(path, "/api/accounts/"):
+		return ScopeAdmin
+	case read:
+		return ScopeRead
+	case r.Method == http.MethodPost && (path == "/jobs" || path == "/packages" || path == "/api/rules/preview"):
+		return ScopeAdd
+	case path == "/api/webhooks" || strings.HasPrefix(path, "/api/webhooks/"),
+		path == "/api/rules" || strings.HasPrefix(path, "/api/rules/"),
+		path == "/api/settings", path == "/api/browse/mkdir":
+		return ScopeAdmin
+	default:
+		return ScopeControl
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// withAuth checks the bearer token against the scope the request needs.
+// Requests without a token get AnonymousScope, which is empty (no access)
+// unless explicitly configured.
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	if s.Tokens == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := requiredScope(r)
+		scope := s.AnonymousScope
+		if raw := bearerToken(r); raw != "" {
+			tok, ok := s.Tokens.Lookup(raw)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="dlq", error="invalid_token"`)
+				writeErr(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+			scope = tok.Scope
+		}
+		if scope == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dlq"`)
+			writeErr(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+			return
+		}
+		if !scopeAllows(scope, need) {
+			writeErr(w, http.StatusForbidden, fmt.Errorf("scope %q does not allow this request (needs %q)", scope, need))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type createTokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if s.Tokens == nil {
+		writeErr(w, http.StatusNotFound, errors.New("authentication is disabled"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Tokens.Views())
+	case http.MethodPost:
+		var req createTokenRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = ScopeRead
+		}
+		view, secret, err := s.Tokens.Create(req.Name, req.Scope)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("action=token_create id=%d name=%q scope=%s", view.ID, view.Name, view.Scope)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id":         view.ID,
+			"name":       view.Name,
+			"scope":      view.Scope,
+			"prefix":     view.Prefix,
+			"created_at": view.CreatedAt,
+			"token":      secret,
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if s.Tokens == nil {
+		writeErr(w, http.StatusNotFound, errors.New("authentication is disabled"))
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/tokens/"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.Tokens.Revoke(id); err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			writeErr(w, http.StatusNotFound, err)
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("action=token_revoke id=%d", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthEnforcesTokenScopes(t *testing.T) {
+	dir := t.TempDir()
+	tokens, err := NewTokens(dir)
+	if err != nil {
+		t.Fatalf("new tokens: %v", err)
+	}
+	_, readTok, err := tokens.Create("dash", ScopeRead)
+	if err != nil {
+		t.Fatalf("create read token: %v", err)
+	}
+	_, addTok, err := tokens.Create("ext", ScopeAdd)
+	if err != nil {
+		t.Fatalf("create add token: %v", err)
+	}
+	_, controlTok, err := tokens.Create("webui", ScopeControl)
+	if err != nil {
+		t.Fatalf("create control token: %v", err)
+	}
+	srv := &Server{Queue: &stubQueue{}, Tokens: tokens}
+	h := srv.Handler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/jobs", want: http.StatusUnauthorized},
+		{name: "bad token", method: http.MethodGet, path: "/jobs", token: "dlq_nope", want: http.StatusUnauthorized},
+		{name: "read lists", method: http.MethodGet, path: "/jobs", token: readTok, want: http.StatusOK},
+		{name: "read cannot add", method: http.MethodPost, path: "/packages", token: readTok, want: http.StatusForbidden},
+		{name: "add creates package", method: http.MethodPost, path: "/packages", token: addTok, want: http.StatusOK},
+		{name: "add cannot pause", method: http.MethodPost, path: "/jobs/1/pause", token: addTok, want: http.StatusForbidden},
+		{name: "add previews rules", method: http.MethodPost, path: "/api/rules/preview", token: addTok, want: http.StatusOK},
+		{name: "add cannot create rules", method: http.MethodPost, path: "/api/rules", token: addTok, want: http.StatusForbidden},
+		{name: "add cannot mkdir", method: http.MethodPost, path: "/api/browse/mkdir", token: addTok, want: http.StatusForbidden},
+		{name: "control pauses", method: http.MethodPost, path: "/jobs/1/pause", token: controlTok, want: http.StatusOK},
+		{name: "control cannot change settings", method: http.MethodPost, path: "/api/settings", token: controlTok, want: http.StatusForbidden},
+		{name: "control cannot mkdir", method: http.MethodPost, path: "/api/browse/mkdir", token: controlTok, want: http.StatusForbidden},
+		{name: "control cannot create webhooks", method: http.MethodPost, path: "/api/webhooks", token: controlTok, want: http.StatusForbidden},
+		{name: "control cannot create rules", method: http.MethodPost, path: "/api/rules", token: controlTok, want: http.StatusForbidden},
+		{name: "control cannot create tokens", method: http.MethodPost, path: "/api/tokens", token: controlTok, want: http.StatusForbidden},
+		{name: "control cannot list accounts", method: http.MethodGet, path: "/api/accounts", token: controlTok, want: http.StatusForbidden},
+		{name: "read cannot list tokens", method: http.MethodGet, path: "/api/tokens", token: readTok, want: http.StatusForbidden},
+		{name: "read cannot list accounts", method: http.MethodGet, path: "/api/accounts", token: readTok, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"name":"x"}`))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tt.name, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	srv.AnonymousScope = ScopeRead
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("anonymous read: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestTokensPersistHashedAndRevoke(t *testing.T) {
+	dir := t.TempDir()
+	tokens, err := NewTokens(dir)
+	if err != nil {
+		t.Fatalf("new tokens: %v", err)
+	}
+	if err := EnsureCLIToken(tokens, dir); err != nil {
+		t.Fatalf("ensure cli token: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "cli.token"))
+	if err != nil {
+		t.Fatalf("read cli token: %v", err)
+	}
+	secret := strings.TrimSpace(string(raw))
+	stored, err := os.ReadFile(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatalf("read tokens file: %v", err)
+	}
+	if strings.Contains(string(stored), secret) {
+		t.Fatalf("tokens.json must not contain the secret")
+	}
+
+	reloaded, err := NewTokens(dir)
+	if err != nil {
+		t.Fatalf("reload tokens: %v", err)
+	}
+	tok, ok := reloaded.Lookup(secret)
+	if !ok || tok.Scope != ScopeAdmin {
+		t.Fatalf("expected reloaded admin token, got %+v ok=%v", tok, ok)
+	}
+	if err := EnsureCLIToken(reloaded, dir); err != nil || reloaded.Len() != 1 {
+		t.Fatalf("cli token should only be created once, len=%d err=%v", reloaded.Len(), err)
+	}
+	if err := reloaded.Revoke(tok.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, ok := reloaded.Lookup(secret); ok {
+		t.Fatalf("revoked token still accepted")
+	}
+	if err := reloaded.Revoke(tok.ID); err != ErrTokenNotFound {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+}
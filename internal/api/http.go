@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,14 +14,21 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Witriol/dlq-download-queue/internal/accounts"
 	"github.com/Witriol/dlq-download-queue/internal/queue"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
 )
 
 const maxRequestBodyBytes = 1 << 20
 
+// maxJobBodyBytes is the body limit of POST /jobs, which may carry a .torrent
+// of up to resolver.MaxTorrentSize as base64.
+const maxJobBodyBytes = maxRequestBodyBytes + (resolver.MaxTorrentSize+2)/3*4
+
 type Queue interface {
-	CreateJob(ctx context.Context, url, outDir, name, site, archivePassword string, maxAttempts int) (int64, error)
+	CreateJob(ctx context.Context, req queue.JobRequest) (int64, error)
 	ListJobs(ctx context.Context, status string, includeDeleted bool) ([]JobView, error)
 	GetJob(ctx context.Context, id int64) (*JobView, error)
 	ListEvents(ctx context.Context, id int64, limit int) ([]string, error)
@@ -30,27 +38,77 @@ type Queue interface {
 	Purge(ctx context.Context) error
 	Pause(ctx context.Context, id int64) error
 	Resume(ctx context.Context, id int64) error
+	Move(ctx context.Context, id int64, to string, beforeID int64) error
+	SetPriority(ctx context.Context, id int64, priority int) error
+	SetDownloadLimit(ctx context.Context, id int64, limit int64) error
+	CreatePackage(ctx context.Context, name string) (int64, error)
+	ListPackages(ctx context.Context) ([]PackageView, error)
+	GetPackage(ctx context.Context, id int64) (*PackageView, error)
+	PausePackage(ctx context.Context, id int64) error
+	ResumePackage(ctx context.Context, id int64) error
+	RetryPackage(ctx context.Context, id int64) error
+	RemovePackage(ctx context.Context, id int64) error
+	CreateWebhook(ctx context.Context, req queue.WebhookRequest) (int64, error)
+	ListWebhooks(ctx context.Context) ([]queue.WebhookView, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	ListWebhookDeliveries(ctx context.Context, id int64, limit int) ([]queue.WebhookDeliveryView, error)
+	TestWebhook(ctx context.Context, id int64) error
+	CreateRule(ctx context.Context, req queue.RuleRequest) (int64, error)
+	ListRules(ctx context.Context) ([]queue.RuleView, error)
+	DeleteRule(ctx context.Context, id int64) error
+	PreviewRules(ctx context.Context, req queue.RulePreviewRequest) (queue.RulePreview, error)
+	ListEventsAfter(ctx context.Context, f queue.EventFilter) ([]queue.EventView, error)
+	LastEventID(ctx context.Context) (int64, error)
+	SubscribeChanges(ctx context.Context) *queue.Changes
 }
 
 type JobView = queue.JobView
+type PackageView = queue.PackageView
 
 type Server struct {
 	Queue    Queue
 	Meta     *Meta
 	Settings *Settings
+	// StreamPoll is how often /events/stream re-reads every job in case a
+	// change was not pushed (default 30s).
+	StreamPoll time.Duration
+	// Tokens enables bearer-token authentication; nil leaves the API open.
+	Tokens *Tokens
+	// AnonymousScope is granted to requests without a token ("" = none).
+	AnonymousScope string
+	Resolvers      interface{ Sites() []resolver.SiteInfo }
+	// Accounts is the hoster account store; nil disables /api/accounts.
+	Accounts *accounts.Store
 }
 
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/meta", s.handleMeta)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 	mux.HandleFunc("/jobs", s.handleJobs)
 	mux.HandleFunc("/jobs/clear", s.handleJobsClear)
 	mux.HandleFunc("/jobs/purge", s.handleJobsPurge)
 	mux.HandleFunc("/jobs/", s.handleJob)
+	mux.HandleFunc("/packages", s.handlePackages)
+	mux.HandleFunc("/packages/", s.handlePackage)
+	mux.HandleFunc("/events/stream", s.handleEventStream)
+	mux.HandleFunc("/queue", s.handleQueue)
+	mux.HandleFunc("/queue/pause", s.handleQueuePause)
+	mux.HandleFunc("/queue/resume", s.handleQueueResume)
 	mux.HandleFunc("/api/settings", s.handleSettings)
+	mux.HandleFunc("/api/webhooks", s.handleWebhooks)
+	mux.HandleFunc("/api/webhooks/", s.handleWebhook)
+	mux.HandleFunc("/api/rules", s.handleRules)
+	mux.HandleFunc("/api/rules/preview", s.handleRulesPreview)
+	mux.HandleFunc("/api/rules/", s.handleRule)
+	mux.HandleFunc("/api/resolvers", s.handleResolvers)
+	mux.HandleFunc("/api/accounts", s.handleAccounts)
+	mux.HandleFunc("/api/accounts/", s.handleAccount)
+	mux.HandleFunc("/api/tokens", s.handleTokens)
+	mux.HandleFunc("/api/tokens/", s.handleToken)
 	mux.HandleFunc("/api/browse/mkdir", s.handleBrowseMkdir)
 	mux.HandleFunc("/api/browse", s.handleBrowse)
-	return withRequestLimit(mux, maxRequestBodyBytes)
+	return withRequestLimit(s.withAuth(mux))
 }
 
 type Meta struct {
@@ -70,6 +128,18 @@ func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, meta)
 }
 
+func (s *Server) handleResolvers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sites := []resolver.SiteInfo{}
+	if s.Resolvers != nil {
+		sites = s.Resolvers.Sites()
+	}
+	writeJSON(w, http.StatusOK, sites)
+}
+
 type addJobRequest struct {
 	URL             string `json:"url"`
 	OutDir          string `json:"out_dir"`
@@ -77,6 +147,15 @@ type addJobRequest struct {
 	Site            string `json:"site"`
 	ArchivePassword string `json:"archive_password"`
 	MaxAttempts     int    `json:"max_attempts"`
+	Priority        int    `json:"priority"`
+	PackageID       int64  `json:"package_id"`
+	Engine          string `json:"engine"`
+	// MaxDownloadLimit is bytes per second as a number or a rate like "1M".
+	MaxDownloadLimit interface{} `json:"max_download_limit"`
+	// Torrent is a base64-encoded .torrent file, sent instead of url.
+	Torrent []byte `json:"torrent"`
+	// Checksum is the expected hash of the file, e.g. "sha256:...".
+	Checksum string `json:"checksum"`
 }
 
 func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
@@ -101,8 +180,18 @@ func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
-		if req.URL == "" || req.OutDir == "" {
-			writeErr(w, http.StatusBadRequest, errors.New("missing url or out_dir"))
+		// out_dir may be left to the output rules; the queue checks it.
+		if req.URL == "" && len(req.Torrent) == 0 {
+			writeErr(w, http.StatusBadRequest, errors.New("missing url"))
+			return
+		}
+		if req.URL != "" && len(req.Torrent) > 0 {
+			writeErr(w, http.StatusBadRequest, errors.New("url and torrent are mutually exclusive"))
+			return
+		}
+		maxDownloadLimit, err := parseRateValue(req.MaxDownloadLimit)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("max_download_limit: %w", err))
 			return
 		}
 		maxAttempts := req.MaxAttempts
@@ -111,13 +200,30 @@ func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
 				maxAttempts = v
 			}
 		}
-		id, err := s.Queue.CreateJob(r.Context(), req.URL, req.OutDir, req.Name, req.Site, req.ArchivePassword, maxAttempts)
+		id, err := s.Queue.CreateJob(r.Context(), queue.JobRequest{
+			URL:              req.URL,
+			OutDir:           req.OutDir,
+			Name:             req.Name,
+			Site:             req.Site,
+			ArchivePassword:  req.ArchivePassword,
+			MaxAttempts:      maxAttempts,
+			Priority:         req.Priority,
+			PackageID:        req.PackageID,
+			Engine:           req.Engine,
+			MaxDownloadLimit: maxDownloadLimit,
+			Torrent:          req.Torrent,
+			Checksum:         req.Checksum,
+		})
 		if err != nil {
 			writeQueueErr(w, err)
 			return
 		}
-		log.Printf("action=add id=%d url=%q out=%q name=%q site=%q archive_password_set=%t max_attempts=%d",
-			id, redactURLForLog(req.URL), req.OutDir, req.Name, req.Site, strings.TrimSpace(req.ArchivePassword) != "", maxAttempts)
+		logURL := redactURLForLog(req.URL)
+		if len(req.Torrent) > 0 {
+			logURL = "(torrent upload)"
+		}
+		log.Printf("action=add id=%d url=%q out=%q name=%q site=%q archive_password_set=%t max_attempts=%d package_id=%d engine=%q",
+			id, logURL, req.OutDir, req.Name, req.Site, strings.TrimSpace(req.ArchivePassword) != "", maxAttempts, req.PackageID, req.Engine)
 		writeJSON(w, http.StatusOK, map[string]any{"id": id})
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -136,6 +242,20 @@ func redactURLForLog(raw string) string {
 	return u.String()
 }
 
+type moveJobRequest struct {
+	To     string `json:"to"`
+	Before int64  `json:"before"`
+}
+
+type priorityRequest struct {
+	Priority *int `json:"priority"`
+}
+
+type limitRequest struct {
+	// Limit is bytes per second as a number or a rate like "1M"; 0 removes it.
+	Limit interface{} `json:"limit"`
+}
+
 func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/jobs/")
 	parts := strings.Split(path, "/")
@@ -227,11 +347,150 @@ func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Printf("action=resume id=%d", id)
 		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "move":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req moveJobRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		to := req.To
+		if to == "" && req.Before > 0 {
+			to = queue.MoveBefore
+		}
+		if err := s.Queue.Move(r.Context(), id, to, req.Before); err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=move id=%d to=%s before=%d", id, to, req.Before)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "priority":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req priorityRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if req.Priority == nil {
+			writeErr(w, http.StatusBadRequest, errors.New("missing priority"))
+			return
+		}
+		if err := s.Queue.SetPriority(r.Context(), id, *req.Priority); err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=priority id=%d priority=%d", id, *req.Priority)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "limit":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req limitRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if req.Limit == nil {
+			writeErr(w, http.StatusBadRequest, errors.New("missing limit"))
+			return
+		}
+		limit, err := parseRateValue(req.Limit)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.Queue.SetDownloadLimit(r.Context(), id, limit); err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=limit id=%d limit=%d", id, limit)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
+type createPackageRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) handlePackages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		pkgs, err := s.Queue.ListPackages(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pkgs)
+	case http.MethodPost:
+		var req createPackageRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		id, err := s.Queue.CreatePackage(r.Context(), req.Name)
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=package_add id=%d name=%q", id, req.Name)
+		writeJSON(w, http.StatusOK, map[string]any{"id": id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/packages/")
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		pkg, err := s.Queue.GetPackage(r.Context(), id)
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pkg)
+		return
+	}
+	var action func(context.Context, int64) error
+	switch parts[1] {
+	case "pause":
+		action = s.Queue.PausePackage
+	case "resume":
+		action = s.Queue.ResumePackage
+	case "retry":
+		action = s.Queue.RetryPackage
+	case "remove":
+		action = s.Queue.RemovePackage
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := action(r.Context(), id); err != nil {
+		writeQueueErr(w, err)
+		return
+	}
+	log.Printf("action=package_%s id=%d", parts[1], id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
 func (s *Server) handleJobsClear(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -293,6 +552,67 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type queueStatusResponse struct {
+	queue.QueueState
+	Paused              bool         `json:"paused"`
+	DownloadWindows     []TimeWindow `json:"download_windows"`
+	PauseOutsideWindows bool         `json:"pause_outside_windows"`
+}
+
+func (s *Server) queueStatus() queueStatusResponse {
+	s.Settings.mu.RLock()
+	resp := queueStatusResponse{
+		Paused:              s.Settings.QueuePaused,
+		DownloadWindows:     append([]TimeWindow{}, s.Settings.DownloadWindows...),
+		PauseOutsideWindows: s.Settings.PauseOutsideWindows,
+	}
+	s.Settings.mu.RUnlock()
+	resp.QueueState = s.Settings.GetQueueState(time.Now())
+	return resp
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Settings == nil {
+		writeErr(w, http.StatusInternalServerError, errors.New("settings not initialized"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.queueStatus())
+}
+
+func (s *Server) handleQueuePause(w http.ResponseWriter, r *http.Request) {
+	s.setQueuePaused(w, r, true)
+}
+
+func (s *Server) handleQueueResume(w http.ResponseWriter, r *http.Request) {
+	s.setQueuePaused(w, r, false)
+}
+
+func (s *Server) setQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Settings == nil {
+		writeErr(w, http.StatusInternalServerError, errors.New("settings not initialized"))
+		return
+	}
+	s.Settings.SetQueuePaused(paused)
+	if err := s.Settings.Save(); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if paused {
+		log.Printf("action=queue_pause")
+	} else {
+		log.Printf("action=queue_resume")
+	}
+	writeJSON(w, http.StatusOK, s.queueStatus())
+}
+
 type browseResponse struct {
 	Path   string   `json:"path"`
 	Parent string   `json:"parent"`
@@ -457,6 +777,21 @@ func (s *Server) isRootPreset(path string) bool {
 	return false
 }
 
+// decodeJSONBody decodes the request body into v and writes an error response
+// when that fails.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeErr(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+			return false
+		}
+		writeErr(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -487,22 +822,54 @@ func statusForQueueErr(err error) int {
 	if errors.Is(err, queue.ErrDownloaderNotConfigured) {
 		return http.StatusServiceUnavailable
 	}
+	if errors.Is(err, queue.ErrInvalidMove) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrPackageNotFound) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrUnknownEngine) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrInvalidLimit) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrInvalidTorrent) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrInvalidChecksum) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrUnknownSite) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrInvalidWebhook) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, queue.ErrInvalidRule) {
+		return http.StatusBadRequest
+	}
 	switch err.Error() {
 	case "missing out_dir",
 		"no DATA_* volumes configured",
 		"out_dir must be absolute",
 		"out_dir is not within an allowed DATA_* volume",
 		"name must not contain path separators",
-		"invalid name":
+		"invalid name",
+		"missing package name":
 		return http.StatusBadRequest
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
-func withRequestLimit(next http.Handler, limit int64) http.Handler {
+func withRequestLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
+			limit := int64(maxRequestBodyBytes)
+			if r.URL.Path == "/jobs" {
+				limit = maxJobBodyBytes
+			}
 			r.Body = http.MaxBytesReader(w, r.Body, limit)
 		}
 		next.ServeHTTP(w, r)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/Witriol/dlq-download-queue/internal/queue"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
 )
 
 func TestStatusForQueueErr(t *testing.T) {
@@ -25,6 +27,9 @@ func TestStatusForQueueErr(t *testing.T) {
 		{name: "missing engine gid", err: queue.ErrMissingEngineGID, want: http.StatusConflict},
 		{name: "action not allowed", err: fmt.Errorf("%w: detail", queue.ErrActionNotAllowed), want: http.StatusConflict},
 		{name: "downloader missing", err: queue.ErrDownloaderNotConfigured, want: http.StatusServiceUnavailable},
+		{name: "invalid move", err: queue.ErrInvalidMove, want: http.StatusBadRequest},
+		{name: "invalid webhook", err: fmt.Errorf("%w: bad url", queue.ErrInvalidWebhook), want: http.StatusBadRequest},
+		{name: "invalid rule", err: fmt.Errorf("%w: bad template", queue.ErrInvalidRule), want: http.StatusBadRequest},
 		{name: "unknown", err: errors.New("boom"), want: http.StatusInternalServerError},
 	}
 	for _, tt := range tests {
@@ -35,15 +40,33 @@ func TestStatusForQueueErr(t *testing.T) {
 }
 
 type stubQueue struct {
-	pauseErr error
+	pauseErr   error
+	moveErr    error
+	moveTo     string
+	moveBefore int64
+	priority   int
+	limit      int64
+
+	packageAction string
+
+	webhookReq queue.WebhookRequest
+	webhookErr error
+
+	ruleReq    queue.RuleRequest
+	previewReq queue.RulePreviewRequest
+
+	jobReq queue.JobRequest
+	jobs   []JobView
+	events []queue.EventView
 }
 
-func (q *stubQueue) CreateJob(ctx context.Context, url, outDir, name, site, archivePassword string, maxAttempts int) (int64, error) {
+func (q *stubQueue) CreateJob(ctx context.Context, req queue.JobRequest) (int64, error) {
+	q.jobReq = req
 	return 0, nil
 }
 
 func (q *stubQueue) ListJobs(ctx context.Context, status string, includeDeleted bool) ([]JobView, error) {
-	return nil, nil
+	return q.jobs, nil
 }
 
 func (q *stubQueue) GetJob(ctx context.Context, id int64) (*JobView, error) {
@@ -78,6 +101,114 @@ func (q *stubQueue) Resume(ctx context.Context, id int64) error {
 	return nil
 }
 
+func (q *stubQueue) Move(ctx context.Context, id int64, to string, beforeID int64) error {
+	q.moveTo = to
+	q.moveBefore = beforeID
+	return q.moveErr
+}
+
+func (q *stubQueue) SetPriority(ctx context.Context, id int64, priority int) error {
+	q.priority = priority
+	return nil
+}
+
+func (q *stubQueue) SetDownloadLimit(ctx context.Context, id int64, limit int64) error {
+	q.limit = limit
+	return nil
+}
+
+func (q *stubQueue) CreatePackage(ctx context.Context, name string) (int64, error) {
+	return 1, nil
+}
+
+func (q *stubQueue) ListPackages(ctx context.Context) ([]PackageView, error) {
+	return nil, nil
+}
+
+func (q *stubQueue) GetPackage(ctx context.Context, id int64) (*PackageView, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (q *stubQueue) PausePackage(ctx context.Context, id int64) error {
+	q.packageAction = "pause"
+	return nil
+}
+
+func (q *stubQueue) ResumePackage(ctx context.Context, id int64) error {
+	q.packageAction = "resume"
+	return nil
+}
+
+func (q *stubQueue) RetryPackage(ctx context.Context, id int64) error {
+	q.packageAction = "retry"
+	return nil
+}
+
+func (q *stubQueue) RemovePackage(ctx context.Context, id int64) error {
+	q.packageAction = "remove"
+	return nil
+}
+
+func (q *stubQueue) CreateWebhook(ctx context.Context, req queue.WebhookRequest) (int64, error) {
+	q.webhookReq = req
+	return 1, q.webhookErr
+}
+
+func (q *stubQueue) ListWebhooks(ctx context.Context) ([]queue.WebhookView, error) {
+	return nil, nil
+}
+
+func (q *stubQueue) DeleteWebhook(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (q *stubQueue) ListWebhookDeliveries(ctx context.Context, id int64, limit int) ([]queue.WebhookDeliveryView, error) {
+	return nil, nil
+}
+
+func (q *stubQueue) TestWebhook(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (q *stubQueue) CreateRule(ctx context.Context, req queue.RuleRequest) (int64, error) {
+	q.ruleReq = req
+	return 1, nil
+}
+
+func (q *stubQueue) ListRules(ctx context.Context) ([]queue.RuleView, error) {
+	return nil, nil
+}
+
+func (q *stubQueue) DeleteRule(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (q *stubQueue) PreviewRules(ctx context.Context, req queue.RulePreviewRequest) (queue.RulePreview, error) {
+	q.previewReq = req
+	return queue.RulePreview{Matched: true, RuleID: 1, OutDir: "/data/http", Name: req.Filename}, nil
+}
+
+func (q *stubQueue) ListEventsAfter(ctx context.Context, f queue.EventFilter) ([]queue.EventView, error) {
+	var out []queue.EventView
+	for _, ev := range q.events {
+		if ev.ID > f.AfterID && (f.JobID == 0 || ev.JobID == f.JobID) {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (q *stubQueue) LastEventID(ctx context.Context) (int64, error) {
+	if len(q.events) == 0 {
+		return 0, nil
+	}
+	return q.events[len(q.events)-1].ID, nil
+}
+
+func (q *stubQueue) SubscribeChanges(ctx context.Context) *queue.Changes {
+	return &queue.Changes{}
+}
+
 func TestHandlePauseMapsActionNotAllowedToConflict(t *testing.T) {
 	srv := &Server{
 		Queue: &stubQueue{pauseErr: fmt.Errorf("%w: cannot be paused now", queue.ErrActionNotAllowed)},
@@ -113,6 +244,87 @@ func TestHandlePauseMapsNotFoundTo404(t *testing.T) {
 	}
 }
 
+func TestHandleMoveDefaultsToBeforeWhenTargetGiven(t *testing.T) {
+	q := &stubQueue{}
+	srv := &Server{Queue: q}
+	req := httptest.NewRequest(http.MethodPost, "/jobs/5/move", strings.NewReader(`{"before": 3}`))
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if q.moveTo != queue.MoveBefore || q.moveBefore != 3 {
+		t.Fatalf("expected move before 3, got to=%q before=%d", q.moveTo, q.moveBefore)
+	}
+}
+
+func TestHandlePriorityRequiresValue(t *testing.T) {
+	srv := &Server{Queue: &stubQueue{}}
+	req := httptest.NewRequest(http.MethodPost, "/jobs/5/priority", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleLimitParsesRate(t *testing.T) {
+	q := &stubQueue{}
+	srv := &Server{Queue: q}
+	req := httptest.NewRequest(http.MethodPost, "/jobs/5/limit", strings.NewReader(`{"limit": "1.5M"}`))
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if q.limit != 1572864 {
+		t.Fatalf("expected limit 1572864, got %d", q.limit)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/jobs/5/limit", strings.NewReader(`{"limit": "fast"}`))
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid rate, got %d", rec.Code)
+	}
+}
+
+func TestHandlePackageActions(t *testing.T) {
+	for _, action := range []string{"pause", "resume", "retry", "remove"} {
+		q := &stubQueue{}
+		srv := &Server{Queue: q}
+		req := httptest.NewRequest(http.MethodPost, "/packages/7/"+action, strings.NewReader("{}"))
+		rec := httptest.NewRecorder()
+
+		srv.Handler().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", action, rec.Code)
+		}
+		if q.packageAction != action {
+			t.Fatalf("%s: expected package action to be dispatched, got %q", action, q.packageAction)
+		}
+	}
+}
+
+func TestHandleGetPackageMapsNotFoundTo404(t *testing.T) {
+	srv := &Server{Queue: &stubQueue{}}
+	req := httptest.NewRequest(http.MethodGet, "/packages/7", nil)
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
 func TestRedactURLForLog(t *testing.T) {
 	got := redactURLForLog("https://mega.nz/file/AbCdEf12#super-secret-key")
 	if got != "https://mega.nz/file/AbCdEf12#***" {
@@ -123,3 +335,73 @@ func TestRedactURLForLog(t *testing.T) {
 		t.Fatalf("url without fragment should be unchanged, got %q", got)
 	}
 }
+
+func TestHandleCreateJobAcceptsLargeTorrent(t *testing.T) {
+	q := &stubQueue{}
+	srv := &Server{Queue: q}
+	post := func(size int) int {
+		body, _ := json.Marshal(map[string]any{"torrent": bytes.Repeat([]byte("d"), size), "out_dir": "/data"})
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(body)))
+		return rec.Code
+	}
+	if code := post(3 << 20); code != http.StatusOK || len(q.jobReq.Torrent) != 3<<20 {
+		t.Fatalf("3 MiB torrent: status %d, torrent %d bytes", code, len(q.jobReq.Torrent))
+	}
+	if code := post(resolver.MaxTorrentSize + maxRequestBodyBytes); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized torrent: status %d, want 413", code)
+	}
+	// Other endpoints keep the small limit.
+	rec := httptest.NewRecorder()
+	big := `{"url":"https://example.com/hook","secret":"` + strings.Repeat("s", 2<<20) + `"}`
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(big)))
+	if rec.Code == http.StatusOK {
+		t.Fatalf("2 MiB webhook body accepted")
+	}
+}
+
+func TestHandleWebhooksCreate(t *testing.T) {
+	q := &stubQueue{}
+	srv := &Server{Queue: q}
+	body := `{"url":"https://example.com/hook","secret":"s3cret","events":["completed","failed"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if q.webhookReq.URL != "https://example.com/hook" || q.webhookReq.Secret != "s3cret" || len(q.webhookReq.Events) != 2 {
+		t.Fatalf("unexpected webhook request: %+v", q.webhookReq)
+	}
+
+	q.webhookErr = fmt.Errorf("%w: unknown event", queue.ErrInvalidWebhook)
+	req = httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid webhook, got %d", rec.Code)
+	}
+}
+
+func TestHandleRulesParsesSizes(t *testing.T) {
+	q := &stubQueue{}
+	srv := &Server{Queue: q}
+	body := `{"site":"http","ext":["mkv"],"min_size":"700M","template":"{root}/{site}/{filename}"}`
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/rules", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if q.ruleReq.MinSize != 700<<20 || q.ruleReq.Template != "{root}/{site}/{filename}" || len(q.ruleReq.Ext) != 1 {
+		t.Fatalf("unexpected rule request: %+v", q.ruleReq)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/rules/preview", strings.NewReader(`{"url":"https://example.com/a.mkv","filename":"a.mkv","size":"1G"}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"out_dir":"/data/http"`) {
+		t.Fatalf("preview: %d %s", rec.Code, rec.Body.String())
+	}
+	if q.previewReq.Size != 1<<30 || q.previewReq.URL != "https://example.com/a.mkv" {
+		t.Fatalf("unexpected preview request: %+v", q.previewReq)
+	}
+}
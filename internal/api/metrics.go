@@ -0,0 +1,25 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/Witriol/dlq-download-queue/internal/metrics"
+)
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	if err := metrics.Default.WriteText(r.Context(), &buf); err != nil {
+		log.Printf("metrics error: %v", err)
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
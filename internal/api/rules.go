@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Witriol/dlq-download-queue/internal/queue"
+)
+
+type addRuleRequest struct {
+	Name    string   `json:"name"`
+	Site    string   `json:"site"`
+	Host    string   `json:"host"`
+	Pattern string   `json:"pattern"`
+	Ext     []string `json:"ext"`
+	// MinSize and MaxSize are bytes as a number or a size like "700M".
+	MinSize  interface{} `json:"min_size"`
+	MaxSize  interface{} `json:"max_size"`
+	Root     string      `json:"root"`
+	Template string      `json:"template"`
+}
+
+type previewRuleRequest struct {
+	URL      string      `json:"url"`
+	Site     string      `json:"site"`
+	Filename string      `json:"filename"`
+	Size     interface{} `json:"size"`
+	Package  string      `json:"package"`
+}
+
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.Queue.ListRules(r.Context())
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		var req addRuleRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		minSize, err := parseSizeValue(req.MinSize)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("min_size: %w", err))
+			return
+		}
+		maxSize, err := parseSizeValue(req.MaxSize)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("max_size: %w", err))
+			return
+		}
+		id, err := s.Queue.CreateRule(r.Context(), queue.RuleRequest{
+			Name:     req.Name,
+			Site:     req.Site,
+			Host:     req.Host,
+			Pattern:  req.Pattern,
+			Ext:      req.Ext,
+			MinSize:  minSize,
+			MaxSize:  maxSize,
+			Root:     req.Root,
+			Template: req.Template,
+		})
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=rule_create id=%d template=%q", id, req.Template)
+		writeJSON(w, http.StatusOK, map[string]int64{"id": id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/rules/"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Queue.DeleteRule(r.Context(), id); err != nil {
+		writeQueueErr(w, err)
+		return
+	}
+	log.Printf("action=rule_delete id=%d", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleRulesPreview runs a job description through the rules without
+// adding or resolving anything.
+func (s *Server) handleRulesPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req previewRuleRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	size, err := parseSizeValue(req.Size)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("size: %w", err))
+		return
+	}
+	preview, err := s.Queue.PreviewRules(r.Context(), queue.RulePreviewRequest{
+		URL:      req.URL,
+		Site:     req.Site,
+		Filename: req.Filename,
+		Size:     size,
+		Package:  req.Package,
+	})
+	if err != nil {
+		writeQueueErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
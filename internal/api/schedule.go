@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeWindow is a daily time range in dlqd's local time. End before start
+// wraps past midnight; equal start and end cover the whole day. Days limits
+// the window to the given weekdays (mon..sun), keyed by the day it starts.
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// SpeedWindow applies a speed limit while its window is open.
+type SpeedWindow struct {
+	TimeWindow
+	Limit int64 `json:"limit"` // bytes per second, 0 = unlimited
+}
+
+// Contains reports whether t falls inside the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	switch {
+	case start == end:
+		return w.onDay(today)
+	case start < end:
+		return now >= start && now < end && w.onDay(today)
+	default:
+		return (now >= start && w.onDay(today)) || (now < end && w.onDay(yesterday))
+	}
+}
+
+func (w TimeWindow) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdayNames[name] == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *TimeWindow) validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return err
+	}
+	for i, d := range w.Days {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if len(d) > 3 {
+			d = d[:3]
+		}
+		if _, ok := weekdayNames[d]; !ok {
+			return fmt.Errorf("invalid day %q", w.Days[i])
+		}
+		w.Days[i] = d
+	}
+	return nil
+}
+
+// parseClock returns minutes since midnight for "HH:MM".
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseRateValue accepts a JSON number of bytes per second or a rate string
+// such as "1M" or "500K".
+func parseRateValue(v interface{}) (int64, error) {
+	return parseBytesValue(v, downloader.ParseRate)
+}
+
+// parseSizeValue accepts a JSON number of bytes or a size string such as "20G".
+func parseSizeValue(v interface{}) (int64, error) {
+	return parseBytesValue(v, downloader.ParseSize)
+}
+
+func parseBytesValue(v interface{}, parse func(string) (int64, error)) (int64, error) {
+	switch n := v.(type) {
+	case float64:
+		if n < 0 || n != math.Trunc(n) {
+			return 0, fmt.Errorf("invalid value %v", n)
+		}
+		return int64(n), nil
+	case string:
+		return parse(n)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("invalid value %v", v)
+	}
+}
+
+func parseTimeWindows(key string, v interface{}) ([]TimeWindow, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var windows []TimeWindow
+	if err := json.Unmarshal(data, &windows); err != nil {
+		return nil, fmt.Errorf("%s must be a list of {days, start, end}", key)
+	}
+	for i := range windows {
+		if err := windows[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return windows, nil
+}
+
+func parseSpeedSchedule(v interface{}) ([]SpeedWindow, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		TimeWindow
+		Limit interface{} `json:"limit"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("speed_schedule must be a list of {days, start, end, limit}")
+	}
+	out := make([]SpeedWindow, 0, len(raw))
+	for _, r := range raw {
+		if err := r.TimeWindow.validate(); err != nil {
+			return nil, fmt.Errorf("speed_schedule: %w", err)
+		}
+		limit, err := parseRateValue(r.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("speed_schedule: %w", err)
+		}
+		out = append(out, SpeedWindow{TimeWindow: r.TimeWindow, Limit: limit})
+	}
+	return out, nil
+}
@@ -6,18 +6,54 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/queue"
 )
 
 const defaultConcurrency = 2
 const defaultMaxAttempts = 5
 const defaultAutoDecrypt = true
+const defaultArchiveCleanup = queue.ArchiveCleanupKeep
 
 // Settings represents runtime application settings
 type Settings struct {
 	Concurrency int  `json:"concurrency"`
 	MaxAttempts int  `json:"max_attempts"`
 	AutoDecrypt bool `json:"auto_decrypt"`
+	// ArchiveCleanup decides what happens to archive volumes after a
+	// successful extraction: keep, delete or trash (moved to .dlq-trash).
+	ArchiveCleanup string `json:"archive_cleanup"`
+	// SiteEngines maps a site (webshare, mega, http) to the download engine
+	// its jobs use unless a job asks for one itself.
+	SiteEngines map[string]string `json:"site_engines,omitempty"`
+	// SpeedLimit is the global download cap in bytes per second (0 = unlimited);
+	// the first matching SpeedSchedule window overrides it.
+	SpeedLimit    int64         `json:"speed_limit"`
+	SpeedSchedule []SpeedWindow `json:"speed_schedule,omitempty"`
+	// SiteConcurrency and HostConcurrency cap active downloads per site and
+	// per host on top of Concurrency. A host key also covers its subdomains.
+	SiteConcurrency map[string]int `json:"site_concurrency,omitempty"`
+	HostConcurrency map[string]int `json:"host_concurrency,omitempty"`
+	// QueuePaused stops new downloads and pauses running ones. When
+	// DownloadWindows is set, downloads only start inside one of the windows;
+	// PauseOutsideWindows also pauses running transfers when they close.
+	QueuePaused         bool         `json:"queue_paused"`
+	DownloadWindows     []TimeWindow `json:"download_windows,omitempty"`
+	PauseOutsideWindows bool         `json:"pause_outside_windows"`
+	// MinFreeSpace maps a DATA root to the bytes to keep free on it; jobs that
+	// would go below it wait in waiting_space.
+	MinFreeSpace map[string]int64 `json:"min_free_space,omitempty"`
+	// SeedRatio and SeedTime (minutes) bound how long finished torrents keep
+	// seeding; seeding ends at whichever is reached first. Both 0 = no seeding.
+	SeedRatio float64 `json:"seed_ratio"`
+	SeedTime  int     `json:"seed_time"`
+	// HookTimeout bounds each hook script in seconds (0 = default of 5m);
+	// with HookFailJob a failing script moves the job to decrypt_failed.
+	HookTimeout int  `json:"hook_timeout"`
+	HookFailJob bool `json:"hook_fail_job"`
 	mu          sync.RWMutex
 	path        string
 }
@@ -26,10 +62,11 @@ type Settings struct {
 func NewSettings(stateDir string) (*Settings, error) {
 	path := filepath.Join(stateDir, "settings.json")
 	s := &Settings{
-		Concurrency: defaultConcurrency,
-		MaxAttempts: defaultMaxAttempts,
-		AutoDecrypt: defaultAutoDecrypt,
-		path:        path,
+		Concurrency:    defaultConcurrency,
+		MaxAttempts:    defaultMaxAttempts,
+		AutoDecrypt:    defaultAutoDecrypt,
+		ArchiveCleanup: defaultArchiveCleanup,
+		path:           path,
 	}
 
 	// Try to load from file, fall back to defaults if it doesn't exist
@@ -84,12 +121,50 @@ func (s *Settings) Get() map[string]interface{} {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return map[string]interface{}{
-		"concurrency":  s.Concurrency,
-		"max_attempts": s.MaxAttempts,
-		"auto_decrypt": s.AutoDecrypt,
+		"concurrency":           s.Concurrency,
+		"max_attempts":          s.MaxAttempts,
+		"auto_decrypt":          s.AutoDecrypt,
+		"archive_cleanup":       s.ArchiveCleanup,
+		"site_engines":          copyStringMap(s.SiteEngines),
+		"speed_limit":           s.SpeedLimit,
+		"speed_schedule":        append([]SpeedWindow{}, s.SpeedSchedule...),
+		"site_concurrency":      copyIntMap(s.SiteConcurrency),
+		"host_concurrency":      copyIntMap(s.HostConcurrency),
+		"queue_paused":          s.QueuePaused,
+		"download_windows":      append([]TimeWindow{}, s.DownloadWindows...),
+		"pause_outside_windows": s.PauseOutsideWindows,
+		"min_free_space":        copyInt64Map(s.MinFreeSpace),
+		"seed_ratio":            s.SeedRatio,
+		"seed_time":             s.SeedTime,
+		"hook_timeout":          s.HookTimeout,
+		"hook_fail_job":         s.HookFailJob,
 	}
 }
 
+func copyInt64Map(m map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func copyIntMap(m map[string]int) map[string]int {
+	out := make(map[string]int, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
 // GetConcurrency returns the current concurrency setting (thread-safe)
 func (s *Settings) GetConcurrency() int {
 	s.mu.RLock()
@@ -111,6 +186,116 @@ func (s *Settings) GetAutoDecrypt() bool {
 	return s.AutoDecrypt
 }
 
+// GetArchiveCleanup returns the archive cleanup policy, "" meaning keep.
+func (s *Settings) GetArchiveCleanup() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ArchiveCleanup
+}
+
+// GetSiteEngine returns the engine configured for a site, or "" for automatic.
+func (s *Settings) GetSiteEngine(site string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.SiteEngines[strings.ToLower(site)]
+}
+
+// GetSpeedLimit returns the global download cap in effect at now.
+func (s *Settings) GetSpeedLimit(now time.Time) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, w := range s.SpeedSchedule {
+		if w.Contains(now) {
+			return w.Limit
+		}
+	}
+	return s.SpeedLimit
+}
+
+// GetSiteConcurrency returns the active download cap for a site, 0 if none.
+func (s *Settings) GetSiteConcurrency(site string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.SiteConcurrency[strings.ToLower(site)]
+}
+
+// GetHostConcurrency returns the most specific host rule covering host and
+// its cap, or ("", 0) if no rule applies.
+func (s *Settings) GetHostConcurrency(host string) (string, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for host != "" {
+		if limit, ok := s.HostConcurrency[host]; ok {
+			return host, limit
+		}
+		_, rest, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = rest
+	}
+	return "", 0
+}
+
+// GetQueueState reports whether downloads may start at now.
+func (s *Settings) GetQueueState(now time.Time) queue.QueueState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.QueuePaused {
+		return queue.QueueState{Open: false, PauseActive: true, Reason: "queue paused"}
+	}
+	if len(s.DownloadWindows) == 0 {
+		return queue.QueueState{Open: true}
+	}
+	for _, w := range s.DownloadWindows {
+		if w.Contains(now) {
+			return queue.QueueState{Open: true}
+		}
+	}
+	return queue.QueueState{Open: false, PauseActive: s.PauseOutsideWindows, Reason: "outside download window"}
+}
+
+// GetMinFreeSpace returns the free space to keep under the DATA root that
+// contains outDir, using the longest matching root.
+func (s *Settings) GetMinFreeSpace(outDir string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	outDir = filepath.Clean(outDir)
+	best, limit := -1, int64(0)
+	for root, v := range s.MinFreeSpace {
+		if outDir != root && !strings.HasPrefix(outDir, strings.TrimSuffix(root, "/")+"/") {
+			continue
+		}
+		if len(root) > best {
+			best, limit = len(root), v
+		}
+	}
+	return limit
+}
+
+// GetSeedLimits returns the seeding limits for finished torrents.
+func (s *Settings) GetSeedLimits() (float64, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.SeedRatio, time.Duration(s.SeedTime) * time.Minute
+}
+
+// GetHookPolicy returns the hook script timeout and whether a failing script
+// fails the job.
+func (s *Settings) GetHookPolicy() (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Duration(s.HookTimeout) * time.Second, s.HookFailJob
+}
+
+// SetQueuePaused flips the global pause switch.
+func (s *Settings) SetQueuePaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.QueuePaused = paused
+}
+
 // Update updates settings with the provided values and saves to disk
 func (s *Settings) Update(updates map[string]interface{}) error {
 	s.mu.Lock()
@@ -153,5 +338,189 @@ func (s *Settings) Update(updates map[string]interface{}) error {
 		s.AutoDecrypt = autoDecrypt
 	}
 
+	if v, ok := updates["archive_cleanup"]; ok {
+		policy, ok := v.(string)
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if !ok || !queue.ValidArchiveCleanup(policy) {
+			return fmt.Errorf("archive_cleanup must be keep, delete or trash")
+		}
+		if policy == "" {
+			policy = queue.ArchiveCleanupKeep
+		}
+		s.ArchiveCleanup = policy
+	}
+
+	if v, ok := updates["site_engines"]; ok {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("site_engines must be an object")
+		}
+		engines := make(map[string]string, len(raw))
+		for site, val := range raw {
+			name, ok := val.(string)
+			if !ok {
+				return fmt.Errorf("site_engines values must be strings")
+			}
+			site = strings.ToLower(strings.TrimSpace(site))
+			name = strings.ToLower(strings.TrimSpace(name))
+			if site == "" {
+				return fmt.Errorf("site_engines keys must not be empty")
+			}
+			if !queue.ValidEngine(name) {
+				return fmt.Errorf("unknown engine %q for site %s", name, site)
+			}
+			if name != "" {
+				engines[site] = name
+			}
+		}
+		s.SiteEngines = engines
+	}
+
+	if v, ok := updates["speed_limit"]; ok {
+		limit, err := parseRateValue(v)
+		if err != nil {
+			return fmt.Errorf("speed_limit: %w", err)
+		}
+		s.SpeedLimit = limit
+	}
+
+	if v, ok := updates["speed_schedule"]; ok {
+		schedule, err := parseSpeedSchedule(v)
+		if err != nil {
+			return err
+		}
+		s.SpeedSchedule = schedule
+	}
+
+	if v, ok := updates["site_concurrency"]; ok {
+		limits, err := parseConcurrencyMap("site_concurrency", v)
+		if err != nil {
+			return err
+		}
+		s.SiteConcurrency = limits
+	}
+
+	if v, ok := updates["host_concurrency"]; ok {
+		limits, err := parseConcurrencyMap("host_concurrency", v)
+		if err != nil {
+			return err
+		}
+		s.HostConcurrency = limits
+	}
+
+	if v, ok := updates["queue_paused"]; ok {
+		paused, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("queue_paused must be a boolean")
+		}
+		s.QueuePaused = paused
+	}
+
+	if v, ok := updates["download_windows"]; ok {
+		windows, err := parseTimeWindows("download_windows", v)
+		if err != nil {
+			return err
+		}
+		s.DownloadWindows = windows
+	}
+
+	if v, ok := updates["pause_outside_windows"]; ok {
+		pause, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("pause_outside_windows must be a boolean")
+		}
+		s.PauseOutsideWindows = pause
+	}
+
+	if v, ok := updates["min_free_space"]; ok {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("min_free_space must be an object")
+		}
+		limits := make(map[string]int64, len(raw))
+		for root, val := range raw {
+			root = strings.TrimSpace(root)
+			if !filepath.IsAbs(root) {
+				return fmt.Errorf("min_free_space keys must be absolute paths")
+			}
+			n, err := parseSizeValue(val)
+			if err != nil {
+				return fmt.Errorf("min_free_space: %w", err)
+			}
+			if n > 0 {
+				limits[filepath.Clean(root)] = n
+			}
+		}
+		s.MinFreeSpace = limits
+	}
+
+	if v, ok := updates["seed_ratio"]; ok {
+		ratio, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("seed_ratio must be a number")
+		}
+		if ratio < 0 || ratio > 100 {
+			return fmt.Errorf("seed_ratio must be between 0 and 100")
+		}
+		s.SeedRatio = ratio
+	}
+
+	if v, ok := updates["seed_time"]; ok {
+		minutes, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("seed_time must be a number")
+		}
+		if minutes != math.Trunc(minutes) || minutes < 0 {
+			return fmt.Errorf("seed_time must be a whole number of minutes")
+		}
+		s.SeedTime = int(minutes)
+	}
+
+	if v, ok := updates["hook_timeout"]; ok {
+		seconds, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("hook_timeout must be a number")
+		}
+		if seconds != math.Trunc(seconds) || seconds < 0 || seconds > 86400 {
+			return fmt.Errorf("hook_timeout must be a whole number of seconds up to 86400")
+		}
+		s.HookTimeout = int(seconds)
+	}
+
+	if v, ok := updates["hook_fail_job"]; ok {
+		failJob, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("hook_fail_job must be a boolean")
+		}
+		s.HookFailJob = failJob
+	}
+
 	return nil
 }
+
+// parseConcurrencyMap validates a {name: limit} object. A limit of 0 drops
+// the entry.
+func parseConcurrencyMap(key string, v interface{}) (map[string]int, error) {
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", key)
+	}
+	limits := make(map[string]int, len(raw))
+	for name, val := range raw {
+		n, ok := val.(float64)
+		if !ok || n != math.Trunc(n) {
+			return nil, fmt.Errorf("%s values must be integers", key)
+		}
+		if n < 0 || n > 10 {
+			return nil, fmt.Errorf("%s values must be between 0 and 10", key)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return nil, fmt.Errorf("%s keys must not be empty", key)
+		}
+		if n > 0 {
+			limits[name] = int(n)
+		}
+	}
+	return limits, nil
+}
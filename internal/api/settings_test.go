@@ -3,6 +3,7 @@ package api
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestSettingsUpdateRejectsNonIntegerConcurrency(t *testing.T) {
@@ -75,3 +76,196 @@ func TestNewSettingsDefaultsEnableAutoDecrypt(t *testing.T) {
 		t.Fatalf("expected settings.json to be written")
 	}
 }
+
+func TestSettingsUpdateSiteEngines(t *testing.T) {
+	s := &Settings{Concurrency: 2, MaxAttempts: 5}
+	if err := s.Update(map[string]interface{}{
+		"site_engines": map[string]interface{}{"HTTP": "native", "mega": ""},
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := s.GetSiteEngine("http"); got != "native" {
+		t.Fatalf("expected http engine native, got %q", got)
+	}
+	if got := s.GetSiteEngine("mega"); got != "" {
+		t.Fatalf("expected mega engine unset, got %q", got)
+	}
+	err := s.Update(map[string]interface{}{"site_engines": map[string]interface{}{"http": "curl"}})
+	if err == nil {
+		t.Fatalf("expected error for unknown engine")
+	}
+}
+
+func TestSettingsSpeedScheduleOverridesGlobalLimit(t *testing.T) {
+	s := &Settings{Concurrency: 2, MaxAttempts: 5}
+	if err := s.Update(map[string]interface{}{
+		"speed_limit": "0",
+		"speed_schedule": []interface{}{
+			map[string]interface{}{"start": "18:00", "end": "23:00", "limit": "1M"},
+			map[string]interface{}{"days": []interface{}{"Sat"}, "start": "23:00", "end": "02:00", "limit": float64(1000)},
+		},
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	at := func(day, clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", day+" "+clock, time.Local)
+		if err != nil {
+			t.Fatalf("parse time: %v", err)
+		}
+		return tm
+	}
+	// 2026-10-17 is a Saturday.
+	tests := []struct {
+		at   time.Time
+		want int64
+	}{
+		{at("2026-10-16", "17:59"), 0},
+		{at("2026-10-16", "18:00"), 1 << 20},
+		{at("2026-10-16", "23:30"), 0},
+		{at("2026-10-17", "23:30"), 1000},
+		{at("2026-10-18", "01:00"), 1000},
+		{at("2026-10-18", "02:00"), 0},
+	}
+	for _, tt := range tests {
+		if got := s.GetSpeedLimit(tt.at); got != tt.want {
+			t.Fatalf("GetSpeedLimit(%s)=%d, want %d", tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+	if err := s.Update(map[string]interface{}{
+		"speed_schedule": []interface{}{map[string]interface{}{"start": "25:00", "end": "01:00"}},
+	}); err == nil {
+		t.Fatalf("expected error for invalid time")
+	}
+}
+
+func TestSettingsHostConcurrencyMatchesSubdomains(t *testing.T) {
+	s := &Settings{Concurrency: 2, MaxAttempts: 5}
+	if err := s.Update(map[string]interface{}{
+		"site_concurrency": map[string]interface{}{"Webshare": float64(1)},
+		"host_concurrency": map[string]interface{}{"example.com": float64(3), "dl.example.com": float64(1), "other.org": float64(0)},
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := s.GetSiteConcurrency("webshare"); got != 1 {
+		t.Fatalf("expected webshare cap 1, got %d", got)
+	}
+	if rule, limit := s.GetHostConcurrency("cdn.example.com"); rule != "example.com" || limit != 3 {
+		t.Fatalf("expected example.com rule with cap 3, got %q %d", rule, limit)
+	}
+	if rule, limit := s.GetHostConcurrency("dl.example.com"); rule != "dl.example.com" || limit != 1 {
+		t.Fatalf("expected most specific rule, got %q %d", rule, limit)
+	}
+	if _, limit := s.GetHostConcurrency("other.org"); limit != 0 {
+		t.Fatalf("expected zero cap to be dropped, got %d", limit)
+	}
+	if err := s.Update(map[string]interface{}{"site_concurrency": map[string]interface{}{"mega": 1.5}}); err == nil {
+		t.Fatalf("expected error for non-integer cap")
+	}
+}
+
+func TestSettingsQueueStateFollowsDownloadWindows(t *testing.T) {
+	s := &Settings{Concurrency: 2, MaxAttempts: 5}
+	if err := s.Update(map[string]interface{}{
+		"download_windows": []interface{}{
+			map[string]interface{}{"start": "01:00", "end": "07:00"},
+		},
+		"pause_outside_windows": true,
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	night := time.Date(2024, 5, 6, 3, 0, 0, 0, time.Local)
+	day := time.Date(2024, 5, 6, 12, 0, 0, 0, time.Local)
+	if st := s.GetQueueState(night); !st.Open {
+		t.Fatalf("expected queue open inside window, got %+v", st)
+	}
+	if st := s.GetQueueState(day); st.Open || !st.PauseActive {
+		t.Fatalf("expected queue closed with pause outside window, got %+v", st)
+	}
+	s.SetQueuePaused(true)
+	if st := s.GetQueueState(night); st.Open || st.Reason != "queue paused" {
+		t.Fatalf("expected global pause to close the queue, got %+v", st)
+	}
+	if err := s.Update(map[string]interface{}{"download_windows": []interface{}{map[string]interface{}{"start": "25:00", "end": "07:00"}}}); err == nil {
+		t.Fatalf("expected error for invalid window")
+	}
+}
+
+func TestSettingsMinFreeSpaceUsesLongestRoot(t *testing.T) {
+	s := &Settings{Concurrency: 2, MaxAttempts: 5}
+	if err := s.Update(map[string]interface{}{
+		"min_free_space": map[string]interface{}{"/data": "10G", "/data/media": float64(1024)},
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := s.GetMinFreeSpace("/data/downloads"); got != 10<<30 {
+		t.Fatalf("expected 10G for /data/downloads, got %d", got)
+	}
+	if got := s.GetMinFreeSpace("/data/media/tv"); got != 1024 {
+		t.Fatalf("expected most specific root, got %d", got)
+	}
+	if got := s.GetMinFreeSpace("/database"); got != 0 {
+		t.Fatalf("expected no match for sibling path, got %d", got)
+	}
+	if err := s.Update(map[string]interface{}{"min_free_space": map[string]interface{}{"data": "1G"}}); err == nil {
+		t.Fatalf("expected error for relative root")
+	}
+}
+
+func TestSettingsSeedLimits(t *testing.T) {
+	s := &Settings{}
+	if err := s.Update(map[string]interface{}{"seed_ratio": 1.5, "seed_time": float64(90)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	ratio, seedTime := s.GetSeedLimits()
+	if ratio != 1.5 || seedTime != 90*time.Minute {
+		t.Fatalf("seed limits = %v/%v", ratio, seedTime)
+	}
+	for _, bad := range []map[string]interface{}{
+		{"seed_ratio": -1.0},
+		{"seed_ratio": "2"},
+		{"seed_time": 1.5},
+		{"seed_time": -5.0},
+	} {
+		if err := s.Update(bad); err == nil {
+			t.Fatalf("expected error for %v", bad)
+		}
+	}
+}
+
+func TestSettingsArchiveCleanup(t *testing.T) {
+	s := &Settings{}
+	if got := s.GetArchiveCleanup(); got != "" {
+		t.Fatalf("default = %q", got)
+	}
+	if err := s.Update(map[string]interface{}{"archive_cleanup": " Trash "}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := s.GetArchiveCleanup(); got != "trash" {
+		t.Fatalf("archive_cleanup = %q", got)
+	}
+	for _, bad := range []interface{}{"shred", true} {
+		if err := s.Update(map[string]interface{}{"archive_cleanup": bad}); err == nil {
+			t.Fatalf("expected error for %v", bad)
+		}
+	}
+}
+
+func TestSettingsHookPolicy(t *testing.T) {
+	s := &Settings{}
+	if err := s.Update(map[string]interface{}{"hook_timeout": float64(90), "hook_fail_job": true}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	timeout, failJob := s.GetHookPolicy()
+	if timeout != 90*time.Second || !failJob {
+		t.Fatalf("hook policy = %v/%v", timeout, failJob)
+	}
+	for _, bad := range []map[string]interface{}{
+		{"hook_timeout": 1.5},
+		{"hook_timeout": -1.0},
+		{"hook_fail_job": "yes"},
+	} {
+		if err := s.Update(bad); err == nil {
+			t.Fatalf("expected error for %v", bad)
+		}
+	}
+}
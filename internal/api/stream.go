@@ -0,0 +1,343 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/queue"
+)
+
+const (
+	defaultStreamPoll = 30 * time.Second
+	streamKeepAlive   = 15 * time.Second
+	streamBatch       = 500
+)
+
+// eventStream is one client of GET /events/stream.
+type eventStream struct {
+	ctx    context.Context
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	filter queue.EventFilter
+	jobs   bool
+	logs   bool
+	sent   map[int64]JobView
+}
+
+// handleEventStream serves Server-Sent Events:
+//
+//   - "job" carries a JobView whenever a job's state or progress changes
+//     (every matching job is sent once when the stream opens),
+//   - "job_removed" carries {"id": N} when a job is deleted,
+//   - "log" carries a job_events row; its SSE id is the row id, so clients
+//     resume with Last-Event-ID (or ?last_event_id=) without gaps,
+//   - "ready" marks the end of the initial snapshot.
+//
+// Job events carry the log position as their id, so Last-Event-ID always
+// points at the last log row seen; a resumed stream sends the job snapshot
+// again, so no job change is lost either.
+//
+// Changes are pushed by the store as they are written; the stream only
+// re-reads every job every StreamPoll as a fallback.
+//
+// Query parameters: job, package (filters), types (job,log) and tail (replay
+// the last N log rows when not resuming).
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	jobID, err := parseOptionalID(q.Get("job"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, errors.New("invalid job"))
+		return
+	}
+	pkgID, err := parseOptionalID(q.Get("package"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, errors.New("invalid package"))
+		return
+	}
+	st := &eventStream{
+		ctx:    r.Context(),
+		w:      w,
+		rc:     http.NewResponseController(w),
+		filter: queue.EventFilter{JobID: jobID, PackageID: pkgID, Limit: streamBatch},
+		jobs:   true,
+		logs:   true,
+		sent:   map[int64]JobView{},
+	}
+	if types := strings.TrimSpace(q.Get("types")); types != "" {
+		st.jobs, st.logs = false, false
+		for _, t := range strings.Split(types, ",") {
+			switch strings.TrimSpace(t) {
+			case "job":
+				st.jobs = true
+			case "log":
+				st.logs = true
+			default:
+				writeErr(w, http.StatusBadRequest, fmt.Errorf("unknown event type %q", t))
+				return
+			}
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+	resume := lastID != ""
+	if resume {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || id < 0 {
+			writeErr(w, http.StatusBadRequest, errors.New("invalid Last-Event-ID"))
+			return
+		}
+		st.filter.AfterID = id
+	}
+	tail := 0
+	if v := q.Get("tail"); v != "" {
+		tail, err = strconv.Atoi(v)
+		if err != nil || tail < 0 {
+			writeErr(w, http.StatusBadRequest, errors.New("invalid tail"))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	// Subscribe before the snapshot so nothing written in between is missed.
+	changes := s.Queue.SubscribeChanges(ctx)
+	// The server-wide write timeout would cut the stream off.
+	_ = st.rc.SetWriteDeadline(time.Time{})
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if !resume {
+		last, err := s.Queue.LastEventID(ctx)
+		if err != nil {
+			return
+		}
+		st.filter.AfterID = last
+		if tail > 0 && st.logs {
+			f := st.filter
+			f.AfterID, f.Limit, f.Tail = 0, tail, true
+			events, err := s.Queue.ListEventsAfter(ctx, f)
+			if err != nil {
+				return
+			}
+			if err := st.sendLogs(events); err != nil {
+				return
+			}
+		}
+	}
+	if err := s.pollStream(st); err != nil {
+		return
+	}
+	if err := st.send(0, "ready", map[string]any{}); err != nil || st.rc.Flush() != nil {
+		return
+	}
+
+	interval := s.StreamPoll
+	if interval <= 0 {
+		interval = defaultStreamPoll
+	}
+	poll := time.NewTicker(interval)
+	defer poll.Stop()
+	ping := time.NewTicker(streamKeepAlive)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes.Ready():
+			jobs, all, events := changes.Take()
+			if all {
+				err = s.pollStream(st)
+			} else {
+				err = s.pushChanges(st, jobs, events)
+			}
+		case <-poll.C:
+			err = s.pollStream(st)
+		case <-ping.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err != nil || st.rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// pushChanges sends the jobs the store reported as written and, when events
+// were added, the new log rows.
+func (s *Server) pushChanges(st *eventStream, jobs []int64, events bool) error {
+	if events {
+		if err := s.sendNewLogs(st); err != nil {
+			return err
+		}
+	}
+	if !st.jobs {
+		return nil
+	}
+	for _, id := range jobs {
+		if !st.wants(id, -1) {
+			continue
+		}
+		j, err := s.Queue.GetJob(st.ctx, id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && (j == nil || j.Status == queue.StatusDeleted)) {
+			if err := st.removed(id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !st.wants(j.ID, j.PackageID) {
+			continue
+		}
+		if err := st.sendJob(*j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pollStream re-reads every job and sends what changed since the last send;
+// it covers the snapshot, writes touching many jobs and the fallback poll.
+func (s *Server) pollStream(st *eventStream) error {
+	if err := s.sendNewLogs(st); err != nil {
+		return err
+	}
+	if !st.jobs {
+		return nil
+	}
+	jobs, err := s.Queue.ListJobs(st.ctx, "", false)
+	if err != nil {
+		return err
+	}
+	seen := make(map[int64]bool, len(jobs))
+	for _, j := range jobs {
+		if !st.wants(j.ID, j.PackageID) {
+			continue
+		}
+		seen[j.ID] = true
+		if err := st.sendJob(j); err != nil {
+			return err
+		}
+	}
+	for id := range st.sent {
+		if seen[id] {
+			continue
+		}
+		if err := st.removed(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendNewLogs sends the log rows added since the last one sent.
+func (s *Server) sendNewLogs(st *eventStream) error {
+	if !st.logs {
+		if last, err := s.Queue.LastEventID(st.ctx); err == nil {
+			st.filter.AfterID = last
+		}
+		return nil
+	}
+	for {
+		events, err := s.Queue.ListEventsAfter(st.ctx, st.filter)
+		if err != nil {
+			return err
+		}
+		if err := st.sendLogs(events); err != nil {
+			return err
+		}
+		if len(events) < streamBatch {
+			break
+		}
+	}
+	// `dlq clear` resets the id sequence; start over from the beginning.
+	if last, err := s.Queue.LastEventID(st.ctx); err == nil && last < st.filter.AfterID {
+		st.filter.AfterID = 0
+	}
+	return nil
+}
+
+// wants reports whether a job passes the stream filters; a negative
+// packageID skips the package check.
+func (st *eventStream) wants(id, packageID int64) bool {
+	if st.filter.JobID > 0 && id != st.filter.JobID {
+		return false
+	}
+	return packageID < 0 || st.filter.PackageID == 0 || packageID == st.filter.PackageID
+}
+
+// sendJob sends a job unless the client already has this exact view.
+func (st *eventStream) sendJob(j JobView) error {
+	if prev, ok := st.sent[j.ID]; ok && prev == j {
+		return nil
+	}
+	if err := st.send(st.filter.AfterID, "job", j); err != nil {
+		return err
+	}
+	st.sent[j.ID] = j
+	return nil
+}
+
+// removed tells the client a job it has is gone.
+func (st *eventStream) removed(id int64) error {
+	if _, ok := st.sent[id]; !ok {
+		return nil
+	}
+	delete(st.sent, id)
+	return st.send(st.filter.AfterID, "job_removed", map[string]int64{"id": id})
+}
+
+func (st *eventStream) sendLogs(events []queue.EventView) error {
+	for _, ev := range events {
+		if err := st.send(ev.ID, "log", ev); err != nil {
+			return err
+		}
+		if ev.ID > st.filter.AfterID {
+			st.filter.AfterID = ev.ID
+		}
+	}
+	return nil
+}
+
+func (st *eventStream) send(id int64, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(st.w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(st.w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+func parseOptionalID(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid id")
+	}
+	return id, nil
+}
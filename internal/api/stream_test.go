@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/db"
+	"github.com/Witriol/dlq-download-queue/internal/queue"
+)
+
+func TestEventStreamResumesFromLastEventID(t *testing.T) {
+	q := &stubQueue{
+		jobs: []JobView{{ID: 2, Status: "queued"}, {ID: 1, Status: "downloading", BytesDone: 10}},
+		events: []queue.EventView{
+			{ID: 1, JobID: 1, Level: "info", Message: "resolved"},
+			{ID: 2, JobID: 2, Level: "info", Message: "other job"},
+			{ID: 3, JobID: 1, Level: "info", Message: "started"},
+		},
+	}
+	srv := httptest.NewServer((&Server{Queue: q, StreamPoll: 10 * time.Millisecond}).Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream?job=1", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	var got []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "retry:") {
+			continue
+		}
+		got = append(got, line)
+		if line == "event: ready" {
+			break
+		}
+	}
+	stream := strings.Join(got, "\n")
+	if !strings.Contains(stream, "id: 3\nevent: log\ndata: {\"id\":3,\"job_id\":1,") {
+		t.Fatalf("expected resumed log event 3, got:\n%s", stream)
+	}
+	if strings.Contains(stream, "resolved") || strings.Contains(stream, "other job") {
+		t.Fatalf("stream replayed events it should have skipped:\n%s", stream)
+	}
+	if !strings.Contains(stream, "event: job\ndata: {\"id\":1,") || strings.Contains(stream, "{\"id\":2,\"url\"") {
+		t.Fatalf("expected only job 1 snapshot, got:\n%s", stream)
+	}
+}
+
+func TestEventStreamRejectsUnknownType(t *testing.T) {
+	srv := &Server{Queue: &stubQueue{}}
+	req := httptest.NewRequest(http.MethodGet, "/events/stream?types=job,bogus", nil)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestEventStreamPushesStoreChanges(t *testing.T) {
+	conn, err := db.Open(filepath.Join(t.TempDir(), "dlq.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	defer conn.Close()
+	store := queue.NewStore(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := store.CreateJob(ctx, &queue.Job{URL: "https://example.com/a", OutDir: "/data"})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	// No fallback poll within the test: everything after ready is pushed.
+	srv := httptest.NewServer((&Server{Queue: queue.NewService(store, nil, []string{"/data"}), StreamPoll: time.Hour}).Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	readUntil := func(want string) string {
+		t.Helper()
+		var got []string
+		for sc.Scan() {
+			got = append(got, sc.Text())
+			if strings.Contains(sc.Text(), want) {
+				return strings.Join(got, "\n")
+			}
+		}
+		t.Fatalf("stream ended before %q:\n%s", want, strings.Join(got, "\n"))
+		return ""
+	}
+	readUntil("event: ready")
+
+	if err := store.AddEvent(ctx, id, "info", "download started"); err != nil {
+		t.Fatalf("add event: %v", err)
+	}
+	if got := readUntil(`"message":"download started"`); !strings.Contains(got, "id: 1\nevent: log") {
+		t.Fatalf("expected log event with id 1, got:\n%s", got)
+	}
+	if err := store.UpdateProgress(ctx, id, 42, queue.StatusDownloading, 7, 3); err != nil {
+		t.Fatalf("update progress: %v", err)
+	}
+	if got := readUntil(`"bytes_done":42`); !strings.Contains(got, "id: 1\nevent: job") {
+		t.Fatalf("expected job event carrying the log position, got:\n%s", got)
+	}
+	if err := store.Remove(ctx, id); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	readUntil("event: job_removed")
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Witriol/dlq-download-queue/internal/queue"
+)
+
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := s.Queue.ListWebhooks(r.Context())
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	case http.MethodPost:
+		var req queue.WebhookRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		id, err := s.Queue.CreateWebhook(r.Context(), req)
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=webhook_create id=%d", id)
+		writeJSON(w, http.StatusOK, map[string]int64{"id": id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/webhooks/")
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.Queue.DeleteWebhook(r.Context(), id); err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=webhook_delete id=%d", id)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	switch parts[1] {
+	case "deliveries":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+				limit = parsed
+			}
+		}
+		deliveries, err := s.Queue.ListWebhookDeliveries(r.Context(), id, limit)
+		if err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	case "test":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.Queue.TestWebhook(r.Context(), id); err != nil {
+			writeQueueErr(w, err)
+			return
+		}
+		log.Printf("action=webhook_test id=%d", id)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
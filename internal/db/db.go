@@ -30,10 +30,21 @@ CREATE TABLE IF NOT EXISTS jobs (
   error TEXT,
   error_code TEXT,
   engine TEXT DEFAULT 'aria2',
+  requested_engine TEXT,
   engine_gid TEXT,
   attempts INTEGER DEFAULT 0,
   max_attempts INTEGER DEFAULT 5,
   next_retry_at TEXT,
+  priority INTEGER DEFAULT 0,
+  position INTEGER,
+  max_download_limit INTEGER DEFAULT 0,
+  queue_held INTEGER DEFAULT 0,
+  resume_status TEXT,
+  checksum TEXT,
+  hooks_pending INTEGER DEFAULT 0,
+  output_paths TEXT,
+  etag TEXT,
+  last_modified TEXT,
   created_at TEXT NOT NULL,
   updated_at TEXT NOT NULL,
   started_at TEXT,
@@ -54,6 +65,90 @@ CREATE TABLE IF NOT EXISTS job_events (
 );
 
 CREATE INDEX IF NOT EXISTS idx_job_events_job_id ON job_events(job_id);
+
+CREATE TABLE IF NOT EXISTS packages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  deleted_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  url TEXT NOT NULL,
+  secret TEXT,
+  events TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook_id INTEGER NOT NULL,
+  event TEXT NOT NULL,
+  job_id INTEGER,
+  package_id INTEGER,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL,
+  attempts INTEGER DEFAULT 0,
+  next_attempt_at TEXT,
+  response_code INTEGER,
+  error TEXT,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  delivered_at TEXT,
+  FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+
+CREATE TABLE IF NOT EXISTS rules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL DEFAULT '',
+  site TEXT NOT NULL DEFAULT '',
+  host TEXT NOT NULL DEFAULT '',
+  pattern TEXT NOT NULL DEFAULT '',
+  exts TEXT NOT NULL DEFAULT '',
+  min_size INTEGER NOT NULL DEFAULT 0,
+  max_size INTEGER NOT NULL DEFAULT 0,
+  root TEXT NOT NULL DEFAULT '',
+  template TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+`
+
+// jobColumnMigrations lists columns added to jobs after the initial schema.
+var jobColumnMigrations = []struct {
+	name    string
+	colType string
+}{
+	{"deleted_at", "TEXT"},
+	{"download_speed", "INTEGER DEFAULT 0"},
+	{"eta_seconds", "INTEGER"},
+	{"archive_password", "TEXT"},
+	{"priority", "INTEGER DEFAULT 0"},
+	{"position", "INTEGER"},
+	{"package_id", "INTEGER REFERENCES packages(id)"},
+	{"requested_engine", "TEXT"},
+	{"max_download_limit", "INTEGER DEFAULT 0"},
+	{"queue_held", "INTEGER DEFAULT 0"},
+	{"resume_status", "TEXT"},
+	{"checksum", "TEXT"},
+	{"hooks_pending", "INTEGER DEFAULT 0"},
+	{"output_paths", "TEXT"},
+	{"etag", "TEXT"},
+	{"last_modified", "TEXT"},
+}
+
+// postMigrations runs after column migrations, so it may reference new columns.
+const postMigrations = `
+UPDATE jobs SET position = id WHERE position IS NULL;
+CREATE INDEX IF NOT EXISTS idx_jobs_queue_order ON jobs(status, priority, position);
+CREATE INDEX IF NOT EXISTS idx_jobs_package_id ON jobs(package_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_engine_gid ON jobs(engine_gid);
 `
 
 // Open opens the SQLite database and ensures schema exists.
@@ -69,19 +164,13 @@ func Open(path string) (*sql.DB, error) {
 		_ = db.Close()
 		return nil, err
 	}
-	if err := ensureColumn(ctx, db, "deleted_at", "TEXT"); err != nil {
-		_ = db.Close()
-		return nil, err
-	}
-	if err := ensureColumn(ctx, db, "download_speed", "INTEGER DEFAULT 0"); err != nil {
-		_ = db.Close()
-		return nil, err
-	}
-	if err := ensureColumn(ctx, db, "eta_seconds", "INTEGER"); err != nil {
-		_ = db.Close()
-		return nil, err
+	for _, col := range jobColumnMigrations {
+		if err := ensureColumn(ctx, db, col.name, col.colType); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
 	}
-	if err := ensureColumn(ctx, db, "archive_password", "TEXT"); err != nil {
+	if _, err := db.ExecContext(ctx, postMigrations); err != nil {
 		_ = db.Close()
 		return nil, err
 	}
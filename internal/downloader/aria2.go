@@ -3,12 +3,15 @@ package downloader
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/metrics"
 )
 
 var ErrGIDNotFound = errors.New("aria2_gid_not_found")
@@ -45,7 +48,24 @@ type rpcError struct {
 	Message string `json:"message"`
 }
 
+var (
+	aria2RPCDuration = metrics.NewHistogramVec("dlq_aria2_rpc_duration_seconds",
+		"Latency of aria2 JSON-RPC calls.", metrics.DurationBuckets, "method")
+	aria2RPCErrors = metrics.NewCounterVec("dlq_aria2_rpc_errors_total",
+		"Failed aria2 JSON-RPC calls (transport or RPC errors).", "method")
+)
+
 func (a *Aria2Client) call(ctx context.Context, method string, params []interface{}, out interface{}) error {
+	start := time.Now()
+	err := a.doCall(ctx, method, params, out)
+	aria2RPCDuration.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		aria2RPCErrors.Inc(method)
+	}
+	return err
+}
+
+func (a *Aria2Client) doCall(ctx context.Context, method string, params []interface{}, out interface{}) error {
 	p := params
 	if a.Secret != "" {
 		p = append([]interface{}{"token:" + a.Secret}, params...)
@@ -85,7 +105,12 @@ func (a *Aria2Client) call(ctx context.Context, method string, params []interfac
 }
 
 func (a *Aria2Client) AddURI(ctx context.Context, uri string, options map[string]string) (string, error) {
-	params := []interface{}{[]string{uri}, options}
+	return a.AddURIs(ctx, []string{uri}, options)
+}
+
+// AddURIs starts one download from several mirrors of the same file.
+func (a *Aria2Client) AddURIs(ctx context.Context, uris []string, options map[string]string) (string, error) {
+	params := []interface{}{uris, options}
 	var gid string
 	if err := a.call(ctx, "aria2.addUri", params, &gid); err != nil {
 		return "", err
@@ -93,6 +118,17 @@ func (a *Aria2Client) AddURI(ctx context.Context, uri string, options map[string
 	return gid, nil
 }
 
+// AddTorrent starts a BitTorrent download from the contents of a .torrent
+// file.
+func (a *Aria2Client) AddTorrent(ctx context.Context, torrent []byte, options map[string]string) (string, error) {
+	params := []interface{}{base64.StdEncoding.EncodeToString(torrent), []string{}, options}
+	var gid string
+	if err := a.call(ctx, "aria2.addTorrent", params, &gid); err != nil {
+		return "", err
+	}
+	return gid, nil
+}
+
 type Status struct {
 	GID           string `json:"gid"`
 	Status        string `json:"status"`
@@ -101,14 +137,25 @@ type Status struct {
 	DownloadSpeed string `json:"downloadSpeed"`
 	ErrorCode     string `json:"errorCode"`
 	ErrorMessage  string `json:"errorMessage"`
-	Files         []struct {
-		Path string `json:"path"`
-	} `json:"files"`
+	// FollowedBy lists the downloads aria2 started from this one, e.g. the
+	// real torrent download after a magnet link's metadata arrived.
+	FollowedBy []string `json:"followedBy"`
+	// Seeder is "true" once a torrent is complete and only seeding.
+	Seeder string       `json:"seeder"`
+	Files  []StatusFile `json:"files"`
+}
+
+// StatusFile is one file of a transfer; torrents may have many.
+type StatusFile struct {
+	Path            string `json:"path"`
+	Length          string `json:"length"`
+	CompletedLength string `json:"completedLength"`
+	Selected        string `json:"selected"`
 }
 
 func (a *Aria2Client) TellStatus(ctx context.Context, gid string) (*Status, error) {
 	var st Status
-	params := []interface{}{gid, []string{"gid", "status", "totalLength", "completedLength", "downloadSpeed", "errorCode", "errorMessage", "files"}}
+	params := []interface{}{gid, []string{"gid", "status", "totalLength", "completedLength", "downloadSpeed", "errorCode", "errorMessage", "followedBy", "seeder", "files"}}
 	if err := a.call(ctx, "aria2.tellStatus", params, &st); err != nil {
 		return nil, err
 	}
@@ -120,7 +167,7 @@ func (a *Aria2Client) TellStatus(ctx context.Context, gid string) (*Status, erro
 
 func (a *Aria2Client) TellActive(ctx context.Context) ([]Status, error) {
 	var out []Status
-	params := []interface{}{[]string{"gid", "status", "totalLength", "completedLength", "errorCode", "errorMessage"}}
+	params := []interface{}{[]string{"gid", "status", "totalLength", "completedLength", "downloadSpeed", "errorCode", "errorMessage"}}
 	if err := a.call(ctx, "aria2.tellActive", params, &out); err != nil {
 		return nil, err
 	}
@@ -129,7 +176,7 @@ func (a *Aria2Client) TellActive(ctx context.Context) ([]Status, error) {
 
 func (a *Aria2Client) TellWaiting(ctx context.Context, offset, num int) ([]Status, error) {
 	var out []Status
-	params := []interface{}{offset, num, []string{"gid", "status", "totalLength", "completedLength", "errorCode", "errorMessage"}}
+	params := []interface{}{offset, num, []string{"gid", "status", "totalLength", "completedLength", "errorCode", "errorMessage", "files"}}
 	if err := a.call(ctx, "aria2.tellWaiting", params, &out); err != nil {
 		return nil, err
 	}
@@ -138,13 +185,23 @@ func (a *Aria2Client) TellWaiting(ctx context.Context, offset, num int) ([]Statu
 
 func (a *Aria2Client) TellStopped(ctx context.Context, offset, num int) ([]Status, error) {
 	var out []Status
-	params := []interface{}{offset, num, []string{"gid", "status", "totalLength", "completedLength", "errorCode", "errorMessage"}}
+	params := []interface{}{offset, num, []string{"gid", "status", "totalLength", "completedLength", "errorCode", "errorMessage", "files"}}
 	if err := a.call(ctx, "aria2.tellStopped", params, &out); err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+// ChangeOption changes options of a single download, e.g. max-download-limit.
+func (a *Aria2Client) ChangeOption(ctx context.Context, gid string, options map[string]string) error {
+	return a.call(ctx, "aria2.changeOption", []interface{}{gid, options}, nil)
+}
+
+// ChangeGlobalOption changes aria2-wide options, e.g. max-overall-download-limit.
+func (a *Aria2Client) ChangeGlobalOption(ctx context.Context, options map[string]string) error {
+	return a.call(ctx, "aria2.changeGlobalOption", []interface{}{options}, nil)
+}
+
 func (a *Aria2Client) Pause(ctx context.Context, gid string) error {
 	return a.call(ctx, "aria2.pause", []interface{}{gid}, nil)
 }
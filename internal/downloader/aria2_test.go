@@ -92,3 +92,70 @@ func TestAria2RemoveFallsBackAndCleansResult(t *testing.T) {
 		t.Fatalf("unexpected method order: %v", methods)
 	}
 }
+
+func TestAria2AddTorrentSendsBase64(t *testing.T) {
+	var params []any
+	client := NewAria2Client("http://aria2.local/jsonrpc", "")
+	client.Client = &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			defer r.Body.Close()
+			var req map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decode request: %v", err)
+			}
+			if req["method"] != "aria2.addTorrent" {
+				t.Fatalf("method = %v", req["method"])
+			}
+			params, _ = req["params"].([]any)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"jsonrpc":"2.0","id":"dlq","result":"gid-bt"}`)),
+			}, nil
+		}),
+	}
+
+	gid, err := client.AddTorrent(context.Background(), []byte("d4:infodee"), map[string]string{"dir": "/data"})
+	if err != nil {
+		t.Fatalf("add torrent: %v", err)
+	}
+	if gid != "gid-bt" {
+		t.Fatalf("gid = %q", gid)
+	}
+	if len(params) != 3 || params[0] != "ZDQ6aW5mb2RlZQ==" {
+		t.Fatalf("params = %v", params)
+	}
+	if opts, _ := params[2].(map[string]any); opts["dir"] != "/data" {
+		t.Fatalf("options = %v", params[2])
+	}
+}
+
+func TestAria2AddURIsSendsAllMirrors(t *testing.T) {
+	var params []any
+	client := NewAria2Client("http://aria2.local/jsonrpc", "")
+	client.Client = &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			defer r.Body.Close()
+			var req map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decode request: %v", err)
+			}
+			if req["method"] != "aria2.addUri" {
+				t.Fatalf("method = %v", req["method"])
+			}
+			params, _ = req["params"].([]any)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"jsonrpc":"2.0","id":"dlq","result":"gid-1"}`)),
+			}, nil
+		}),
+	}
+
+	if _, err := client.AddURIs(context.Background(), []string{"https://a/x.iso", "https://b/x.iso"}, map[string]string{"checksum": "sha-256=ab"}); err != nil {
+		t.Fatalf("add uris: %v", err)
+	}
+	if uris, _ := params[0].([]any); len(uris) != 2 || uris[1] != "https://b/x.iso" {
+		t.Fatalf("uris = %v", params[0])
+	}
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrChecksumMismatch = errors.New("checksum_mismatch")
+
+// ParseChecksum splits an aria2 checksum option, TYPE=DIGEST with types such
+// as sha-256 or md5, and checks that the type is supported. crc32, used by
+// SFV files, is accepted as well.
+func ParseChecksum(v string) (typ, digest string, err error) {
+	typ, digest, ok := strings.Cut(strings.TrimSpace(v), "=")
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	digest = strings.ToLower(strings.TrimSpace(digest))
+	if !ok || newChecksumHash(typ) == nil {
+		return "", "", fmt.Errorf("unsupported checksum: %s", v)
+	}
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*newChecksumHash(typ).Size() {
+		return "", "", fmt.Errorf("invalid %s digest: %s", typ, digest)
+	}
+	return typ, digest, nil
+}
+
+func newChecksumHash(typ string) hash.Hash {
+	switch typ {
+	case "crc32":
+		return crc32.NewIEEE()
+	case "md5":
+		return md5.New()
+	case "sha-1":
+		return sha1.New()
+	case "sha-224":
+		return sha256.New224()
+	case "sha-256":
+		return sha256.New()
+	case "sha-384":
+		return sha512.New384()
+	case "sha-512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// VerifyChecksum hashes the file at path and compares it with an aria2
+// checksum option. A different digest returns an error wrapping
+// ErrChecksumMismatch.
+func VerifyChecksum(path, checksum string) error {
+	typ, want, err := ParseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := newChecksumHash(typ)
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w: %s got %s, want %s", ErrChecksumMismatch, typ, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,697 @@
+package downloader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPStateSuffix is appended to the output path for the native engine's
+// resume state, the counterpart of aria2's .aria2 control file.
+const HTTPStateSuffix = ".dlq-http"
+
+const (
+	defaultHTTPSegments       = 4
+	defaultHTTPMinSegmentSize = 4 << 20
+	httpStateSaveEvery        = time.Second
+	defaultHTTPMaxStopped     = 1000 // aria2's max-download-result default
+	// httpStoppedGrace keeps a finished transfer around for a while after its
+	// final status was read, so a caller retrying a failed store write still
+	// finds it.
+	httpStoppedGrace = time.Minute
+)
+
+// HTTPEngine is a built-in downloader for plain HTTP(S) transfers. It mirrors
+// the subset of the aria2 RPC surface used by the queue: ranged, resumable and
+// split into parallel segments when the server supports byte ranges.
+type HTTPEngine struct {
+	Client         *http.Client
+	Segments       int   // default parallel segments per transfer
+	MinSegmentSize int64 // transfers smaller than two segments use one stream
+	MaxStopped     int   // finished and failed transfers kept for status queries
+
+	mu        sync.Mutex
+	transfers map[string]*httpTransfer
+	order     []string
+	global    rateLimiter
+}
+
+func NewHTTPEngine() *HTTPEngine {
+	return &HTTPEngine{
+		Client:         &http.Client{},
+		Segments:       defaultHTTPSegments,
+		MinSegmentSize: defaultHTTPMinSegmentSize,
+		MaxStopped:     defaultHTTPMaxStopped,
+	}
+}
+
+type httpTransfer struct {
+	gid      string
+	uri      string
+	path     string
+	header   http.Header
+	segments int
+	resume   bool
+	limit    rateLimiter
+
+	// Guarded by HTTPEngine.mu.
+	status    string
+	errMsg    string
+	cancel    context.CancelFunc
+	done      chan struct{}
+	lastBytes int64
+	lastAt    time.Time
+	speed     int64
+	readAt    time.Time // when the final status was first reported
+
+	total     atomic.Int64
+	completed atomic.Int64
+}
+
+// httpState is persisted next to the output so an interrupted transfer can
+// continue its segments.
+type httpState struct {
+	URL      string         `json:"url"`
+	Total    int64          `json:"total"`
+	Segments []httpSegState `json:"segments"`
+}
+
+type httpSegState struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive
+	Done  int64 `json:"done"`
+}
+
+// AddURI starts a transfer. Supported options follow aria2 naming: dir, out,
+// header (newline separated), continue, split, max-download-limit and pause.
+// Checksums are verified by the queue once the download is complete.
+func (e *HTTPEngine) AddURI(ctx context.Context, uri string, options map[string]string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("http_engine_unsupported_uri: %s", uri)
+	}
+	dir := strings.TrimSpace(options["dir"])
+	if dir == "" {
+		return "", errors.New("http_engine_missing_dir")
+	}
+	name := strings.TrimSpace(options["out"])
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "download"
+	}
+	gid, err := newHTTPGID()
+	if err != nil {
+		return "", err
+	}
+	t := &httpTransfer{
+		gid:      gid,
+		uri:      u.String(),
+		path:     filepath.Join(dir, filepath.Base(name)),
+		header:   parseHeaderOption(options["header"]),
+		segments: e.Segments,
+		resume:   !strings.EqualFold(strings.TrimSpace(options["continue"]), "false"),
+		status:   "paused",
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(options["split"])); err == nil && n > 0 {
+		t.segments = n
+	}
+	if t.segments <= 0 {
+		t.segments = defaultHTTPSegments
+	}
+	if v := options["max-download-limit"]; v != "" {
+		rate, err := ParseRate(v)
+		if err != nil {
+			return "", err
+		}
+		t.limit.setRate(rate)
+	}
+	e.mu.Lock()
+	e.pruneLocked(time.Now())
+	if e.transfers == nil {
+		e.transfers = map[string]*httpTransfer{}
+	}
+	e.transfers[gid] = t
+	e.order = append(e.order, gid)
+	if !strings.EqualFold(strings.TrimSpace(options["pause"]), "true") {
+		e.startLocked(t)
+	}
+	e.mu.Unlock()
+	return gid, nil
+}
+
+func (e *HTTPEngine) TellStatus(ctx context.Context, gid string) (*Status, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	e.pruneLocked(now)
+	t, ok := e.transfers[gid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGIDNotFound, gid)
+	}
+	st := e.statusLocked(t)
+	if stoppedStatus(t.status) && t.readAt.IsZero() {
+		t.readAt = now
+	}
+	return &st, nil
+}
+
+func (e *HTTPEngine) TellActive(ctx context.Context) ([]Status, error) {
+	return e.list(func(status string) bool { return status == "active" }, 0, -1), nil
+}
+
+func (e *HTTPEngine) TellWaiting(ctx context.Context, offset, num int) ([]Status, error) {
+	return e.list(func(status string) bool { return status == "paused" }, offset, num), nil
+}
+
+func (e *HTTPEngine) TellStopped(ctx context.Context, offset, num int) ([]Status, error) {
+	return e.list(stoppedStatus, offset, num), nil
+}
+
+func stoppedStatus(status string) bool {
+	return status == "complete" || status == "error"
+}
+
+func (e *HTTPEngine) Pause(ctx context.Context, gid string) error {
+	e.mu.Lock()
+	t, ok := e.transfers[gid]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrGIDNotFound, gid)
+	}
+	if t.status != "active" {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s cannot be paused now", ErrActionNotAllowed, gid)
+	}
+	t.status = "paused"
+	cancel, done := t.cancel, t.done
+	e.mu.Unlock()
+	cancel()
+	<-done
+	return nil
+}
+
+func (e *HTTPEngine) Unpause(ctx context.Context, gid string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.transfers[gid]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrGIDNotFound, gid)
+	}
+	if t.status != "paused" {
+		return fmt.Errorf("%w: %s cannot be unpaused now", ErrActionNotAllowed, gid)
+	}
+	t.resume = true
+	e.startLocked(t)
+	return nil
+}
+
+// Remove stops a transfer and forgets it. Partial data stays on disk.
+func (e *HTTPEngine) Remove(ctx context.Context, gid string) error {
+	e.mu.Lock()
+	t, ok := e.transfers[gid]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrGIDNotFound, gid)
+	}
+	e.forgetLocked(gid)
+	wasActive := t.status == "active"
+	t.status = "removed"
+	cancel, done := t.cancel, t.done
+	e.mu.Unlock()
+	if wasActive {
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// ChangeOption updates options of a running transfer; only
+// max-download-limit is supported.
+func (e *HTTPEngine) ChangeOption(ctx context.Context, gid string, options map[string]string) error {
+	e.mu.Lock()
+	t, ok := e.transfers[gid]
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrGIDNotFound, gid)
+	}
+	if v, ok := options["max-download-limit"]; ok {
+		rate, err := ParseRate(v)
+		if err != nil {
+			return err
+		}
+		t.limit.setRate(rate)
+	}
+	return nil
+}
+
+// ChangeGlobalOption updates engine-wide options; only
+// max-overall-download-limit is supported.
+func (e *HTTPEngine) ChangeGlobalOption(ctx context.Context, options map[string]string) error {
+	if v, ok := options["max-overall-download-limit"]; ok {
+		rate, err := ParseRate(v)
+		if err != nil {
+			return err
+		}
+		e.global.setRate(rate)
+	}
+	return nil
+}
+
+func (e *HTTPEngine) throttle(ctx context.Context, t *httpTransfer, r io.Reader) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, limiters: []*rateLimiter{&t.limit, &e.global}}
+}
+
+func (e *HTTPEngine) list(match func(string) bool, offset, num int) []Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pruneLocked(time.Now())
+	var out []Status
+	for _, gid := range e.order {
+		t := e.transfers[gid]
+		if t == nil || !match(t.status) {
+			continue
+		}
+		out = append(out, e.statusLocked(t))
+	}
+	if offset >= len(out) {
+		return nil
+	}
+	out = out[offset:]
+	if num >= 0 && num < len(out) {
+		out = out[:num]
+	}
+	return out
+}
+
+func (e *HTTPEngine) forgetLocked(gid string) {
+	delete(e.transfers, gid)
+	for i, id := range e.order {
+		if id == gid {
+			e.order = append(e.order[:i], e.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// pruneLocked forgets finished and failed transfers whose final status was
+// read over httpStoppedGrace ago, and the oldest ones beyond MaxStopped, so a
+// long-running engine does not keep every transfer it ever ran.
+func (e *HTTPEngine) pruneLocked(now time.Time) {
+	maxStopped := e.MaxStopped
+	if maxStopped <= 0 {
+		maxStopped = defaultHTTPMaxStopped
+	}
+	excess := -maxStopped
+	for _, t := range e.transfers {
+		if stoppedStatus(t.status) {
+			excess++
+		}
+	}
+	kept := e.order[:0]
+	for _, gid := range e.order {
+		t := e.transfers[gid]
+		if t != nil && stoppedStatus(t.status) {
+			expired := !t.readAt.IsZero() && now.Sub(t.readAt) >= httpStoppedGrace
+			if excess > 0 || expired {
+				// e.order runs oldest first.
+				excess--
+				delete(e.transfers, gid)
+				continue
+			}
+		}
+		kept = append(kept, gid)
+	}
+	clear(e.order[len(kept):])
+	e.order = kept
+}
+
+func (e *HTTPEngine) statusLocked(t *httpTransfer) Status {
+	completed := t.completed.Load()
+	now := time.Now()
+	if t.status != "active" {
+		t.speed = 0
+	} else if t.lastAt.IsZero() {
+		t.lastAt, t.lastBytes = now, completed
+	} else if elapsed := now.Sub(t.lastAt); elapsed >= time.Second {
+		t.speed = int64(float64(completed-t.lastBytes) / elapsed.Seconds())
+		t.lastAt, t.lastBytes = now, completed
+	}
+	st := Status{
+		GID:           t.gid,
+		Status:        t.status,
+		TotalLength:   strconv.FormatInt(t.total.Load(), 10),
+		CompletedLen:  strconv.FormatInt(completed, 10),
+		DownloadSpeed: strconv.FormatInt(t.speed, 10),
+		ErrorMessage:  t.errMsg,
+	}
+	if t.status == "error" {
+		st.ErrorCode = "1"
+	}
+	st.Files = append(st.Files, StatusFile{Path: t.path})
+	return st
+}
+
+func (e *HTTPEngine) startLocked(t *httpTransfer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.status = "active"
+	t.errMsg = ""
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	t.lastAt = time.Time{}
+	done := t.done
+	go func() {
+		defer close(done)
+		defer cancel()
+		err := e.run(ctx, t)
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if t.status != "active" {
+			// Paused or removed while running.
+			return
+		}
+		if err != nil {
+			t.status = "error"
+			t.errMsg = err.Error()
+			return
+		}
+		t.status = "complete"
+	}()
+}
+
+func (e *HTTPEngine) client() *http.Client {
+	if e.Client != nil {
+		return e.Client
+	}
+	return http.DefaultClient
+}
+
+func (e *HTTPEngine) minSegmentSize() int64 {
+	if e.MinSegmentSize > 0 {
+		return e.MinSegmentSize
+	}
+	return defaultHTTPMinSegmentSize
+}
+
+func (e *HTTPEngine) run(ctx context.Context, t *httpTransfer) error {
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	// Probe range support with a one-byte request; servers without range
+	// support answer 200 and the body is used as a single stream.
+	resp, err := e.get(ctx, t, 0, 0)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		total := contentRangeTotal(resp.Header.Get("Content-Range"))
+		resp.Body.Close()
+		if total < 0 {
+			return errors.New("http_engine_unknown_length")
+		}
+		return e.runRanged(ctx, t, total)
+	case http.StatusOK:
+		defer resp.Body.Close()
+		return e.runStream(ctx, t, resp)
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		// Zero-length resources cannot satisfy bytes=0-0.
+		t.total.Store(0)
+		t.completed.Store(0)
+		return writeEmpty(t.path)
+	default:
+		resp.Body.Close()
+		return httpStatusError(resp.StatusCode)
+	}
+}
+
+func (e *HTTPEngine) runStream(ctx context.Context, t *httpTransfer, resp *http.Response) error {
+	os.Remove(t.path + HTTPStateSuffix)
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	t.total.Store(resp.ContentLength)
+	if resp.ContentLength < 0 {
+		t.total.Store(0)
+	}
+	t.completed.Store(0)
+	n, err := io.Copy(f, &countingReader{r: e.throttle(ctx, t, resp.Body), n: &t.completed})
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength < 0 {
+		t.total.Store(n)
+	} else if n != resp.ContentLength {
+		return fmt.Errorf("http_engine_short_body: got %d of %d bytes", n, resp.ContentLength)
+	}
+	return f.Sync()
+}
+
+func (e *HTTPEngine) runRanged(ctx context.Context, t *httpTransfer, total int64) error {
+	statePath := t.path + HTTPStateSuffix
+	state := e.loadOrPlan(t, statePath, total)
+	t.total.Store(total)
+	var done int64
+	for _, seg := range state.Segments {
+		done += seg.Done
+	}
+	t.completed.Store(done)
+
+	flags := os.O_CREATE | os.O_RDWR
+	f, err := os.OpenFile(t.path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(total); err != nil {
+		return err
+	}
+
+	var stateMu sync.Mutex
+	save := func() error {
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(statePath, data, 0o644)
+	}
+	if err := save(); err != nil {
+		return err
+	}
+
+	segCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(state.Segments))
+	var wg sync.WaitGroup
+	for i := range state.Segments {
+		seg := &state.Segments[i]
+		if seg.Start+seg.Done > seg.End {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.fetchSegment(segCtx, t, f, seg, &stateMu); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(httpStateSaveEvery)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-finished:
+			running = false
+		case <-ticker.C:
+			_ = save()
+		}
+	}
+	close(errs)
+	var firstErr error
+	for err := range errs {
+		if firstErr == nil || errors.Is(firstErr, context.Canceled) {
+			firstErr = err
+		}
+	}
+	if ctx.Err() != nil {
+		_ = save()
+		return ctx.Err()
+	}
+	if firstErr != nil {
+		_ = save()
+		return firstErr
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return os.Remove(statePath)
+}
+
+// loadOrPlan returns saved segment state when resuming the same resource,
+// otherwise a fresh plan. The size of an existing file without state says
+// nothing about its contents: runRanged, and aria2 alike, preallocate the
+// full length, so such a file is downloaded again from the start.
+func (e *HTTPEngine) loadOrPlan(t *httpTransfer, statePath string, total int64) *httpState {
+	if t.resume {
+		if data, err := os.ReadFile(statePath); err == nil {
+			var st httpState
+			if json.Unmarshal(data, &st) == nil && st.Total == total && len(st.Segments) > 0 {
+				return &st
+			}
+		}
+	}
+	n := int64(t.segments)
+	if max := total / e.minSegmentSize(); n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	st := &httpState{URL: t.uri, Total: total}
+	size := total / n
+	for i := int64(0); i < n; i++ {
+		start := i * size
+		end := start + size - 1
+		if i == n-1 {
+			end = total - 1
+		}
+		st.Segments = append(st.Segments, httpSegState{Start: start, End: end})
+	}
+	return st
+}
+
+func (e *HTTPEngine) fetchSegment(ctx context.Context, t *httpTransfer, f *os.File, seg *httpSegState, stateMu *sync.Mutex) error {
+	stateMu.Lock()
+	offset := seg.Start + seg.Done
+	stateMu.Unlock()
+	resp, err := e.get(ctx, t, offset, seg.End)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return httpStatusError(resp.StatusCode)
+	}
+	body := e.throttle(ctx, t, resp.Body)
+	buf := make([]byte, 64<<10)
+	for offset <= seg.End {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if remaining := seg.End - offset + 1; int64(n) > remaining {
+				n = int(remaining)
+			}
+			if _, err := f.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			stateMu.Lock()
+			seg.Done = offset - seg.Start
+			stateMu.Unlock()
+			t.completed.Add(int64(n))
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if offset <= seg.End {
+		return fmt.Errorf("http_engine_short_body: segment ended at %d, want %d", offset, seg.End+1)
+	}
+	return nil
+}
+
+func (e *HTTPEngine) get(ctx context.Context, t *httpTransfer, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vals := range t.header {
+		for _, v := range vals {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	return e.client().Do(req)
+}
+
+// httpStatusError formats failures the same way aria2 reports them so the
+// queue's error mapping applies to both engines.
+func httpStatusError(code int) error {
+	return fmt.Errorf("http_engine_error: status=%d %s", code, http.StatusText(code))
+}
+
+func contentRangeTotal(v string) int64 {
+	idx := strings.LastIndex(v, "/")
+	if idx < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(v[idx+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+func parseHeaderOption(v string) http.Header {
+	h := http.Header{}
+	for _, line := range strings.Split(v, "\n") {
+		k, val, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		h.Add(strings.TrimSpace(k), strings.TrimSpace(val))
+	}
+	return h
+}
+
+func writeEmpty(p string) error {
+	os.Remove(p + HTTPStateSuffix)
+	return os.WriteFile(p, nil, 0o644)
+}
+
+func newHTTPGID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
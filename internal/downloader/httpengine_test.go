@@ -0,0 +1,311 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testPayload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func waitHTTPStatus(t *testing.T, e *HTTPEngine, gid, want string) *Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := e.TellStatus(context.Background(), gid)
+		if err != nil {
+			t.Fatalf("tell status: %v", err)
+		}
+		if st.Status == want {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected status %s, got %s (%s)", want, st.Status, st.ErrorMessage)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPEngineDownloadsInSegments(t *testing.T) {
+	payload := testPayload(100 << 10)
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	e := NewHTTPEngine()
+	e.MinSegmentSize = 16 << 10
+	gid, err := e.AddURI(context.Background(), srv.URL+"/file.bin", map[string]string{
+		"dir":    dir,
+		"out":    "out.bin",
+		"header": "X-Token: abc",
+		"split":  "4",
+	})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	st := waitHTTPStatus(t, e, gid, "complete")
+	if st.CompletedLen != st.TotalLength || st.TotalLength != "102400" {
+		t.Fatalf("unexpected progress %s/%s", st.CompletedLen, st.TotalLength)
+	}
+	if len(st.Files) != 1 || st.Files[0].Path != filepath.Join(dir, "out.bin") {
+		t.Fatalf("unexpected files: %+v", st.Files)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "out.bin"))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("output does not match payload")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.bin"+HTTPStateSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected state file to be removed, err: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// One probe plus four segments.
+	if len(ranges) != 5 {
+		t.Fatalf("expected 5 ranged requests, got %v", ranges)
+	}
+}
+
+func TestHTTPEngineResumesFromState(t *testing.T) {
+	payload := testPayload(64 << 10)
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.bin")
+	partial := make([]byte, len(payload))
+	copy(partial, payload[:1000])
+	if err := os.WriteFile(out, partial, 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	state, _ := json.Marshal(httpState{
+		Total:    int64(len(payload)),
+		Segments: []httpSegState{{Start: 0, End: int64(len(payload)) - 1, Done: 1000}},
+	})
+	if err := os.WriteFile(out+HTTPStateSuffix, state, 0o644); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+
+	e := NewHTTPEngine()
+	gid, err := e.AddURI(context.Background(), srv.URL+"/file.bin", map[string]string{"dir": dir, "out": "out.bin", "continue": "true"})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	waitHTTPStatus(t, e, gid, "complete")
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("output does not match payload")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[1] != "bytes=1000-65535" {
+		t.Fatalf("expected resume from byte 1000, got %v", ranges)
+	}
+}
+
+func TestHTTPEngineRestartsPreallocatedFileWithoutState(t *testing.T) {
+	payload := testPayload(64 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.bin")
+	// A preallocated file of the full length, as left by a run without its
+	// state file; the zeros must not count as downloaded.
+	if err := os.WriteFile(out, make([]byte, len(payload)), 0o644); err != nil {
+		t.Fatalf("write preallocated: %v", err)
+	}
+	e := NewHTTPEngine()
+	gid, err := e.AddURI(context.Background(), srv.URL+"/file.bin", map[string]string{"dir": dir, "out": "out.bin", "continue": "true"})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	waitHTTPStatus(t, e, gid, "complete")
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("output does not match payload")
+	}
+}
+
+func TestHTTPEngineStreamsWithoutRangeSupport(t *testing.T) {
+	payload := testPayload(10 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(payload)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	e := NewHTTPEngine()
+	gid, err := e.AddURI(context.Background(), srv.URL+"/plain.bin", map[string]string{"dir": dir})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	waitHTTPStatus(t, e, gid, "complete")
+	got, err := os.ReadFile(filepath.Join(dir, "plain.bin"))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("output does not match payload")
+	}
+}
+
+func TestHTTPEngineReportsStatusErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(509)
+	}))
+	defer srv.Close()
+
+	e := NewHTTPEngine()
+	gid, err := e.AddURI(context.Background(), srv.URL+"/file.bin", map[string]string{"dir": t.TempDir()})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	st := waitHTTPStatus(t, e, gid, "error")
+	if !strings.Contains(st.ErrorMessage, "status=509") {
+		t.Fatalf("expected aria2-style status in error, got %q", st.ErrorMessage)
+	}
+	stopped, _ := e.TellStopped(context.Background(), 0, 10)
+	if len(stopped) != 1 || stopped[0].GID != gid {
+		t.Fatalf("expected errored transfer in stopped list, got %+v", stopped)
+	}
+	if err := e.Remove(context.Background(), gid); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := e.TellStatus(context.Background(), gid); err == nil {
+		t.Fatalf("expected removed transfer to be forgotten")
+	}
+}
+
+func TestHTTPEngineForgetsReadAndExcessStoppedTransfers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	e := NewHTTPEngine()
+	e.MaxStopped = 2
+	var gids []string
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		gid, err := e.AddURI(ctx, srv.URL+"/"+name, map[string]string{"dir": dir})
+		if err != nil {
+			t.Fatalf("add uri: %v", err)
+		}
+		waitHTTPStatus(t, e, gid, "complete")
+		gids = append(gids, gid)
+	}
+	stopped, _ := e.TellStopped(ctx, 0, 10)
+	if len(stopped) != 2 || stopped[0].GID != gids[1] || stopped[1].GID != gids[2] {
+		t.Fatalf("expected the two newest stopped transfers, got %+v", stopped)
+	}
+	if _, err := e.TellStatus(ctx, gids[0]); !errors.Is(err, ErrGIDNotFound) {
+		t.Fatalf("expected oldest transfer beyond the cap to be forgotten, got %v", err)
+	}
+
+	// Still within the grace period after the final status was read.
+	if _, err := e.TellStatus(ctx, gids[1]); err != nil {
+		t.Fatalf("tell status: %v", err)
+	}
+	e.mu.Lock()
+	e.transfers[gids[1]].readAt = time.Now().Add(-httpStoppedGrace)
+	e.mu.Unlock()
+	if _, err := e.TellStatus(ctx, gids[1]); !errors.Is(err, ErrGIDNotFound) {
+		t.Fatalf("expected read transfer to be forgotten after the grace period, got %v", err)
+	}
+	stopped, _ = e.TellStopped(ctx, 0, 10)
+	if len(stopped) != 1 || stopped[0].GID != gids[2] {
+		t.Fatalf("expected only the last transfer left, got %+v", stopped)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"1024", 1024},
+		{"500K", 500 << 10},
+		{"1M", 1 << 20},
+		{"1.5MiB/s", 3 << 19},
+		{"2g", 2 << 30},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Fatalf("ParseRate(%q)=%d,%v want %d", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseRate("fast"); err == nil {
+		t.Fatalf("expected error for invalid rate")
+	}
+}
+
+func TestHTTPEngineAppliesDownloadLimit(t *testing.T) {
+	payload := testPayload(40 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	e := NewHTTPEngine()
+	start := time.Now()
+	gid, err := e.AddURI(context.Background(), srv.URL+"/file.bin", map[string]string{
+		"dir":                t.TempDir(),
+		"max-download-limit": "100K",
+	})
+	if err != nil {
+		t.Fatalf("add uri: %v", err)
+	}
+	waitHTTPStatus(t, e, gid, "complete")
+	// 40K at 100K/s with no initial burst takes ~400ms.
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("expected throttled download, finished in %s", elapsed)
+	}
+}
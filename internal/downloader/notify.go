@@ -0,0 +1,369 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notification event types. Connected/Disconnected describe the WebSocket
+// session itself; the rest mirror aria2's on* notifications.
+const (
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventStart        = "start"
+	EventPause        = "pause"
+	EventStop         = "stop"
+	EventComplete     = "complete"
+	EventError        = "error"
+	EventBtComplete   = "bt_complete"
+)
+
+// Event is a single download lifecycle notification pushed by aria2.
+type Event struct {
+	Type string
+	GID  string
+}
+
+var aria2NotificationTypes = map[string]string{
+	"aria2.onDownloadStart":      EventStart,
+	"aria2.onDownloadPause":      EventPause,
+	"aria2.onDownloadStop":       EventStop,
+	"aria2.onDownloadComplete":   EventComplete,
+	"aria2.onDownloadError":      EventError,
+	"aria2.onBtDownloadComplete": EventBtComplete,
+}
+
+// Aria2Notifier listens for aria2 notifications over its WebSocket RPC endpoint.
+type Aria2Notifier struct {
+	Endpoint   string
+	RetryEvery time.Duration
+}
+
+func NewAria2Notifier(endpoint string) *Aria2Notifier {
+	return &Aria2Notifier{
+		Endpoint:   endpoint,
+		RetryEvery: 5 * time.Second,
+	}
+}
+
+// WebSocketURL derives the aria2 WebSocket endpoint from its JSON-RPC HTTP endpoint.
+func WebSocketURL(rpcEndpoint string) string {
+	u, err := url.Parse(strings.TrimSpace(rpcEndpoint))
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return ""
+	}
+	return u.String()
+}
+
+// Subscribe streams notifications until ctx is done, reconnecting after
+// connection loss. The channel is closed when ctx is done.
+func (n *Aria2Notifier) Subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event, 64)
+	go func() {
+		defer close(ch)
+		retry := n.RetryEvery
+		if retry <= 0 {
+			retry = 5 * time.Second
+		}
+		for {
+			connected, err := n.listen(ctx, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			if connected {
+				log.Printf("aria2 notifications disconnected: %v", err)
+				if !sendEvent(ctx, ch, Event{Type: EventDisconnected}) {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+	}()
+	return ch
+}
+
+func (n *Aria2Notifier) listen(ctx context.Context, ch chan<- Event) (bool, error) {
+	conn, br, err := dialWebSocket(ctx, n.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+			_ = conn.Close()
+		}
+	}()
+	if !sendEvent(ctx, ch, Event{Type: EventConnected}) {
+		return true, ctx.Err()
+	}
+	fr := &frameReader{br: br}
+	for {
+		opcode, payload, err := fr.next()
+		if err != nil {
+			return true, err
+		}
+		switch opcode {
+		case wsOpText:
+			ev, ok := parseNotification(payload)
+			if !ok {
+				continue
+			}
+			if !sendEvent(ctx, ch, ev) {
+				return true, ctx.Err()
+			}
+		case wsOpPing:
+			if err := writeFrame(conn, wsOpPong, payload); err != nil {
+				return true, err
+			}
+		case wsOpClose:
+			_ = writeFrame(conn, wsOpClose, nil)
+			return true, errors.New("aria2_ws_closed")
+		}
+	}
+}
+
+func sendEvent(ctx context.Context, ch chan<- Event, ev Event) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case ch <- ev:
+		return true
+	}
+}
+
+func parseNotification(payload []byte) (Event, bool) {
+	var msg struct {
+		Method string `json:"method"`
+		Params []struct {
+			GID string `json:"gid"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return Event{}, false
+	}
+	typ, ok := aria2NotificationTypes[msg.Method]
+	if !ok || len(msg.Params) == 0 || msg.Params[0].GID == "" {
+		return Event{}, false
+	}
+	return Event{Type: typ, GID: msg.Params[0].GID}, true
+}
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsAcceptGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxMessageBytes = 1 << 20
+)
+
+func dialWebSocket(ctx context.Context, rawURL string) (net.Conn, *bufio.Reader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		default:
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("aria2_ws_handshake_status:%d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		_ = conn.Close()
+		return nil, nil, errors.New("aria2_ws_handshake_invalid_accept")
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, br, nil
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// frameReader reads WebSocket messages, joining fragmented frames. Control
+// frames interleaved with fragments are returned as they arrive without
+// dropping the partial message.
+type frameReader struct {
+	br        *bufio.Reader
+	message   []byte
+	messageOp byte
+}
+
+func (fr *frameReader) next() (byte, []byte, error) {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(fr.br, header[:]); err != nil {
+			return 0, nil, err
+		}
+		fin := header[0]&0x80 != 0
+		opcode := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(fr.br, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(fr.br, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if length > wsMaxMessageBytes || uint64(len(fr.message))+length > wsMaxMessageBytes {
+			return 0, nil, errors.New("aria2_ws_message_too_large")
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(fr.br, mask[:]); err != nil {
+				return 0, nil, err
+			}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(fr.br, payload); err != nil {
+			return 0, nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		if opcode >= wsOpClose {
+			return opcode, payload, nil
+		}
+		if opcode != wsOpContinuation {
+			fr.messageOp = opcode
+		}
+		fr.message = append(fr.message, payload...)
+		if fin {
+			op, msg := fr.messageOp, fr.message
+			fr.message, fr.messageOp = nil, 0
+			return op, msg, nil
+		}
+	}
+}
+
+// writeFrame writes a single masked client frame.
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, 0x80|127)
+		header = append(header, ext[:]...)
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	_, err := w.Write(append(header, masked...))
+	return err
+}
@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "http://127.0.0.1:6800/jsonrpc", want: "ws://127.0.0.1:6800/jsonrpc"},
+		{in: "https://aria2.local/jsonrpc", want: "wss://aria2.local/jsonrpc"},
+		{in: "ws://aria2.local:6800/jsonrpc", want: "ws://aria2.local:6800/jsonrpc"},
+		{in: "ftp://aria2.local", want: ""},
+	}
+	for _, tt := range tests {
+		if got := WebSocketURL(tt.in); got != tt.want {
+			t.Fatalf("WebSocketURL(%q)=%q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	ev, ok := parseNotification([]byte(`{"jsonrpc":"2.0","method":"aria2.onDownloadComplete","params":[{"gid":"2089b05ecca3d829"}]}`))
+	if !ok || ev.Type != EventComplete || ev.GID != "2089b05ecca3d829" {
+		t.Fatalf("unexpected event %+v ok=%v", ev, ok)
+	}
+	if _, ok := parseNotification([]byte(`{"jsonrpc":"2.0","id":"dlq","result":"OK"}`)); ok {
+		t.Fatalf("expected rpc responses to be ignored")
+	}
+}
+
+func TestAria2NotifierDeliversEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Errorf("hijack not supported")
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		accept := websocketAccept(r.Header.Get("Sec-WebSocket-Key"))
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n")
+		msg := `{"jsonrpc":"2.0","method":"aria2.onDownloadError","params":[{"gid":"gid-7"}]}`
+		// Unmasked server text frame.
+		_, _ = rw.Write(append([]byte{0x81, byte(len(msg))}, msg...))
+		_ = rw.Flush()
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n := NewAria2Notifier(WebSocketURL(srv.URL + "/jsonrpc"))
+	ch := n.Subscribe(ctx)
+
+	var got []Event
+	for len(got) < 2 {
+		select {
+		case ev := <-ch:
+			got = append(got, ev)
+		case <-ctx.Done():
+			t.Fatalf("timed out, got %+v", got)
+		}
+	}
+	if got[0].Type != EventConnected {
+		t.Fatalf("expected connected event first, got %+v", got[0])
+	}
+	if got[1].Type != EventError || got[1].GID != "gid-7" {
+		t.Fatalf("expected error event for gid-7, got %+v", got[1])
+	}
+}
+
+func TestFrameReaderKeepsFragmentsAcrossControlFrames(t *testing.T) {
+	var b strings.Builder
+	b.Write([]byte{0x01, 3})
+	b.WriteString("abc")
+	b.Write([]byte{0x89, 0}) // interleaved ping
+	b.Write([]byte{0x80, 3})
+	b.WriteString("def")
+	fr := &frameReader{br: bufio.NewReader(strings.NewReader(b.String()))}
+
+	op, _, err := fr.next()
+	if err != nil || op != wsOpPing {
+		t.Fatalf("expected ping first, got op=%d err=%v", op, err)
+	}
+	op, payload, err := fr.next()
+	if err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if op != wsOpText || string(payload) != "abcdef" {
+		t.Fatalf("unexpected frame op=%d payload=%q", op, payload)
+	}
+}
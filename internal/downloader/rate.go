@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseRate parses a speed in bytes per second. It accepts plain numbers and
+// aria2-style K/M/G suffixes (binary multiples), with an optional trailing
+// "B" or "/s". "0", "" and "unlimited" mean no limit.
+func ParseRate(s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(v), "/s") {
+		v = v[:len(v)-2]
+	}
+	n, err := ParseSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// ParseSize parses a byte count such as "512", "500K", "1.5GiB" or "2T".
+// Suffixes are binary multiples. "" and "unlimited" parse as 0.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+	if v == "" || v == "UNLIMITED" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch v[len(v)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	case 'T':
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		v = strings.TrimSpace(v[:len(v)-1])
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// rateLimiter throttles reads to a rate in bytes per second. Callers take
+// what they read and sleep off any debt, so bursts are capped at one second.
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  int64
+	avail float64
+	last  time.Time
+}
+
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.avail = 0
+	l.last = time.Time{}
+}
+
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.avail += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.avail > float64(l.rate) {
+			l.avail = float64(l.rate)
+		}
+	}
+	l.last = now
+	l.avail -= float64(n)
+	var delay time.Duration
+	if l.avail < 0 {
+		delay = time.Duration(-l.avail / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			if werr := l.wait(t.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
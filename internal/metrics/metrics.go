@@ -0,0 +1,272 @@
+// Package metrics is a minimal Prometheus instrumentation library: counters,
+// gauges and histograms with labels, rendered in the text exposition format.
+//
+// dlqd exports a dozen series and needs nothing beyond the text format, so
+// this stays on the standard library rather than pulling in client_golang
+// and its protobuf and procfs dependencies.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets suit request-sized latencies, in seconds.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and the collectors that refresh gauges
+// before each scrape.
+type Registry struct {
+	mu         sync.Mutex
+	families   []family
+	collectors []func(context.Context)
+}
+
+// Default is the process-wide registry served on /metrics.
+var Default = &Registry{}
+
+type family interface {
+	writeTo(b *strings.Builder)
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// OnCollect runs fn before every scrape, e.g. to set gauges from the database.
+func (r *Registry) OnCollect(fn func(context.Context)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// WriteText runs the collectors and writes every family in the Prometheus
+// text format (version 0.0.4).
+func (r *Registry) WriteText(ctx context.Context, w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]func(context.Context){}, r.collectors...)
+	families := append([]family{}, r.families...)
+	r.mu.Unlock()
+	for _, fn := range collectors {
+		fn(ctx)
+	}
+	var b strings.Builder
+	for _, f := range families {
+		f.writeTo(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) header(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", d.name, helpEscaper.Replace(d.help), d.name, d.kind)
+}
+
+// series renders name{labels} for one set of label values; a non-empty le
+// adds the histogram bucket bound.
+func (d desc) series(name string, values []string, le string) string {
+	pairs := make([]string, 0, len(d.labels)+1)
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+type sample struct {
+	values []string
+	value  float64
+}
+
+// valueVec is the shared storage of counters and gauges.
+type valueVec struct {
+	desc
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+func (v *valueVec) get(values []string) *sample {
+	k := v.key(values)
+	s, ok := v.samples[k]
+	if !ok {
+		s = &sample{values: append([]string{}, values...)}
+		v.samples[k] = s
+	}
+	return s
+}
+
+func (v *valueVec) Value(values ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.samples[v.key(values)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *valueVec) writeTo(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(b)
+	for _, k := range sortedKeys(v.samples) {
+		s := v.samples[k]
+		fmt.Fprintf(b, "%s %s\n", v.series(v.name, s.values, ""), formatFloat(s.value))
+	}
+}
+
+func newValueVec(kind, name, help string, labels []string) valueVec {
+	return valueVec{desc: desc{name: name, help: help, kind: kind, labels: labels}, samples: map[string]*sample{}}
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct{ valueVec }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newValueVec("counter", name, help, labels)}
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += delta
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// GaugeVec is a value per label set that can go up and down.
+type GaugeVec struct{ valueVec }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newValueVec("gauge", name, help, labels)}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(values).value = value
+}
+
+// Reset drops every label set, so values that disappeared are not reported.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.samples = map[string]*sample{}
+}
+
+// HistogramVec counts observations into cumulative buckets per label set.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	hists   map[string]*histSample
+}
+
+type histSample struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{desc: desc{name: name, help: help, kind: "histogram", labels: labels}, buckets: b, hists: map[string]*histSample{}}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(values)
+	s, ok := h.hists[k]
+	if !ok {
+		s = &histSample{values: append([]string{}, values...), counts: make([]uint64, len(h.buckets))}
+		h.hists[k] = s
+	}
+	for i, ub := range h.buckets {
+		if v <= ub {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) writeTo(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(b)
+	for _, k := range sortedKeys(h.hists) {
+		s := h.hists[k]
+		for i, ub := range h.buckets {
+			fmt.Fprintf(b, "%s %d\n", h.series(h.name+"_bucket", s.values, formatFloat(ub)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s %d\n", h.series(h.name+"_bucket", s.values, "+Inf"), s.count)
+		fmt.Fprintf(b, "%s %s\n", h.series(h.name+"_sum", s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s %d\n", h.series(h.name+"_count", s.values, ""), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
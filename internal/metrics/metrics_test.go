@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestWriteTextFormatsFamilies(t *testing.T) {
+	reg := &Registry{}
+	c := &CounterVec{newValueVec("counter", "test_requests_total", "Requests.", []string{"code"})}
+	h := &HistogramVec{desc: desc{name: "test_duration_seconds", help: "Durations.", kind: "histogram"}, buckets: []float64{0.1, 1}, hists: map[string]*histSample{}}
+	reg.register(c)
+	reg.register(h)
+	collected := false
+	reg.OnCollect(func(context.Context) { collected = true })
+
+	c.Inc(`quo"ta`)
+	c.Add(2, "ok")
+	c.Add(-5, "ok")
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(context.Background(), &buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !collected {
+		t.Fatalf("collector did not run")
+	}
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{code="ok"} 2
+test_requests_total{code="quo\"ta"} 1
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 2
+test_duration_seconds_sum 0.55
+test_duration_seconds_count 2
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if c.Value("ok") != 2 {
+		t.Fatalf("counter value = %v", c.Value("ok"))
+	}
+}
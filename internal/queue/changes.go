@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// Changes collects what store writes touched since the reader last took
+// them. Writes coalesce, so a slow reader neither blocks the store nor misses
+// a change: it just sees several at once.
+type Changes struct {
+	ready chan struct{}
+
+	mu     sync.Mutex
+	all    bool
+	jobs   map[int64]struct{}
+	events bool
+}
+
+// Ready receives when changes are pending.
+func (c *Changes) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Take returns and clears the pending changes: the ids of written jobs, all
+// when a write touched many jobs at once (reload everything), and events
+// when job events were added.
+func (c *Changes) Take() (jobs []int64, all, events bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.jobs {
+		jobs = append(jobs, id)
+	}
+	all, events = c.all, c.events
+	c.jobs, c.all, c.events = nil, false, false
+	return jobs, all, events
+}
+
+func (c *Changes) add(jobID int64, event bool) {
+	c.mu.Lock()
+	switch {
+	case event:
+		c.events = true
+	case jobID == 0:
+		c.all = true
+	default:
+		if c.jobs == nil {
+			c.jobs = map[int64]struct{}{}
+		}
+		c.jobs[jobID] = struct{}{}
+	}
+	c.mu.Unlock()
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// changeHub fans store writes out to the subscribed Changes.
+type changeHub struct {
+	mu   sync.Mutex
+	subs map[*Changes]struct{}
+}
+
+// SubscribeChanges returns a Changes fed by every job and event write until
+// ctx is done.
+func (s *Store) SubscribeChanges(ctx context.Context) *Changes {
+	c := &Changes{ready: make(chan struct{}, 1)}
+	h := &s.changes
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = map[*Changes]struct{}{}
+	}
+	h.subs[c] = struct{}{}
+	h.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, c)
+		h.mu.Unlock()
+	}()
+	return c
+}
+
+// jobChanged tells subscribers a job was written; id 0 means many jobs.
+func (s *Store) jobChanged(id int64) {
+	s.changes.publish(id, false)
+}
+
+// eventAdded tells subscribers a job event was added.
+func (s *Store) eventAdded() {
+	s.changes.publish(0, true)
+}
+
+func (h *changeHub) publish(jobID int64, event bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.subs {
+		c.add(jobID, event)
+	}
+}
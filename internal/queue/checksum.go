@@ -0,0 +1,279 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+// maxSidecarSize caps checksum sidecar files read from disk.
+const maxSidecarSize = 1 << 20
+
+// sidecarTypes maps checksum sidecar extensions to the hash type they hold,
+// strongest first.
+var sidecarTypes = []struct{ ext, typ string }{
+	{".sha256", "sha256"},
+	{".sha1", "sha1"},
+	{".md5", "md5"},
+	{".sfv", "crc32"},
+}
+
+// aria2Checksum converts a job checksum, type:hex such as sha256:..., to
+// the TYPE=DIGEST form of aria2's checksum option.
+func aria2Checksum(checksum string) string {
+	typ, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return ""
+	}
+	typ = strings.ToLower(typ)
+	if strings.HasPrefix(typ, "sha") && !strings.HasPrefix(typ, "sha-") {
+		typ = "sha-" + typ[len("sha"):]
+	}
+	return typ + "=" + strings.ToLower(digest)
+}
+
+// normalizeChecksum validates a user supplied checksum such as sha256:...,
+// md5:... or sha1:... and returns it lowercased.
+func normalizeChecksum(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return "", nil
+	}
+	typ, digest, ok := strings.Cut(v, ":")
+	if !ok || strings.Contains(typ, "-") {
+		return "", fmt.Errorf("%w: want type:hex, e.g. sha256:...", ErrInvalidChecksum)
+	}
+	if _, _, err := downloadclient.ParseChecksum(aria2Checksum(v)); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidChecksum, err)
+	}
+	return typ + ":" + digest, nil
+}
+
+func isSidecarName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, st := range sidecarTypes {
+		if strings.HasSuffix(lower, st.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// findSidecarChecksum looks in dir for .sha256, .sha1, .md5 and .sfv files
+// listing filename and returns the strongest checksum found with the sidecar
+// it came from. A sidecar named after the file may hold a bare digest.
+func findSidecarChecksum(dir, filename string) (checksum, source string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || filename == "" {
+		return "", ""
+	}
+	for _, st := range sidecarTypes {
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), st.ext) {
+				continue
+			}
+			if info, err := e.Info(); err != nil || info.Size() > maxSidecarSize {
+				continue
+			}
+			own := strings.EqualFold(e.Name(), filename+st.ext)
+			if digest := sidecarDigest(filepath.Join(dir, e.Name()), st.typ, filename, own); digest != "" {
+				return st.typ + ":" + digest, e.Name()
+			}
+		}
+	}
+	return "", ""
+}
+
+// sidecarDigest reads the digest for filename from a sidecar. Hash files use
+// the "<digest>  <name>" lines of sha256sum and md5sum, SFV files
+// "<name> <crc32>" lines; own allows a line without a name.
+func sidecarDigest(sidecar, typ, filename string, own bool) string {
+	f, err := os.Open(sidecar)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var digest, name string
+		if typ == "crc32" {
+			idx := strings.LastIndexAny(line, " \t")
+			if idx < 0 {
+				continue
+			}
+			name, digest = strings.TrimSpace(line[:idx]), line[idx+1:]
+		} else {
+			fields := strings.Fields(line)
+			digest = fields[0]
+			if len(fields) > 1 {
+				name = strings.TrimPrefix(strings.TrimSpace(line[len(digest):]), "*")
+			}
+		}
+		if name == "" && !own {
+			continue
+		}
+		if name != "" && path.Base(filepath.ToSlash(name)) != filename {
+			continue
+		}
+		digest = strings.ToLower(digest)
+		if _, _, err := downloadclient.ParseChecksum(aria2Checksum(typ + ":" + digest)); err == nil {
+			return digest
+		}
+	}
+	return ""
+}
+
+// sidecarPending reports whether a checksum sidecar that may cover the job is
+// still downloading: one named after the file (<file>.sha256, ...), or any
+// sidecar in the same package and out_dir, such as a release-wide .sfv.
+func (r *Runner) sidecarPending(ctx context.Context, job Job) bool {
+	jobs, err := r.Store.ListJobs(ctx, "", false)
+	if err != nil {
+		log.Printf("runner sidecar scan error for job %d: %v", job.ID, err)
+		return false
+	}
+	name := strings.ToLower(outputNameForJob(job))
+	for _, other := range jobs {
+		if other.ID == job.ID || other.OutDir != job.OutDir || !isMultipartSiblingPending(other.Status) {
+			continue
+		}
+		sidecar := strings.ToLower(outputNameForJob(other))
+		if !isSidecarName(sidecar) {
+			continue
+		}
+		if job.PackageID.Valid && other.PackageID == job.PackageID {
+			return true
+		}
+		for _, st := range sidecarTypes {
+			if name != "" && sidecar == name+st.ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shouldVerify reports whether a finished download goes through the
+// verifying step: it has a checksum, or a sidecar may provide one. Torrents
+// are checked by aria2 piece by piece and MEGA files by their MAC.
+func (r *Runner) shouldVerify(ctx context.Context, job Job) bool {
+	if IsTorrentJob(job.Site, job.URL) || IsMegaJob(job.Site, job.URL) {
+		return false
+	}
+	name := outputNameForJob(job)
+	if name == "" || isSidecarName(name) {
+		return nullString(job.Checksum) != ""
+	}
+	if nullString(job.Checksum) != "" {
+		return true
+	}
+	if checksum, _ := findSidecarChecksum(job.OutDir, name); checksum != "" {
+		return true
+	}
+	return r.sidecarPending(ctx, job)
+}
+
+// dispatchVerify schedules jobs left in verifying, e.g. by a restart or
+// while a sidecar was still downloading.
+func (r *Runner) dispatchVerify(ctx context.Context) error {
+	jobs, err := r.Store.ListJobs(ctx, StatusVerifying, false)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		r.scheduleVerify(ctx, job.ID)
+	}
+	return nil
+}
+
+func (r *Runner) scheduleVerify(ctx context.Context, jobID int64) {
+	r.decryptMu.Lock()
+	if r.verifyPending == nil {
+		r.verifyPending = make(map[int64]struct{})
+	}
+	if _, exists := r.verifyPending[jobID]; exists {
+		r.decryptMu.Unlock()
+		return
+	}
+	r.verifyPending[jobID] = struct{}{}
+	r.decryptMu.Unlock()
+	go func() {
+		defer func() {
+			r.decryptMu.Lock()
+			delete(r.verifyPending, jobID)
+			r.decryptMu.Unlock()
+		}()
+		r.runVerify(ctx, jobID)
+	}()
+}
+
+// runVerify hashes a finished download and compares it with the job's
+// checksum, or one from a sidecar. A mismatch deletes the file and fails the
+// job with checksum_mismatch, so it is downloaded again while attempts remain.
+func (r *Runner) runVerify(ctx context.Context, jobID int64) {
+	if !r.acquireDecryptWorker(ctx) {
+		return
+	}
+	defer r.releaseDecryptWorker()
+	job, err := r.Store.GetJob(ctx, jobID)
+	if err != nil || job.Status != StatusVerifying || job.DeletedAt.Valid {
+		return
+	}
+	checksum := nullString(job.Checksum)
+	filePath := archivePathForJob(*job)
+	if checksum == "" {
+		if r.sidecarPending(ctx, *job) {
+			return
+		}
+		var source string
+		checksum, source = findSidecarChecksum(job.OutDir, filepath.Base(filePath))
+		if checksum == "" {
+			_ = r.Store.AddEvent(ctx, jobID, "info", "no checksum found; verification skipped")
+			r.finishDownload(ctx, *job, nil, job.BytesDone)
+			return
+		}
+		if err := r.Store.SetChecksum(ctx, jobID, checksum); err != nil {
+			log.Printf("runner set checksum error for job %d: %v", jobID, err)
+		}
+		_ = r.Store.AddEvent(ctx, jobID, "info", "checksum from "+source+": "+checksum)
+	}
+	_ = r.Store.AddEvent(ctx, jobID, "info", "verifying "+filepath.Base(filePath))
+	err = downloadclient.VerifyChecksum(filePath, aria2Checksum(checksum))
+	if errors.Is(err, downloadclient.ErrChecksumMismatch) {
+		msg := "checksum mismatch: " + err.Error()
+		_ = r.Store.AddEvent(ctx, jobID, "error", msg)
+		// A corrupt file must not be resumed by the next attempt.
+		for _, p := range []string{filePath, filePath + ".aria2", filePath + downloadclient.HTTPStateSuffix} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("runner remove corrupt download error for job %d: %v", jobID, err)
+			}
+		}
+		if err := r.Store.MarkFailed(ctx, jobID, "checksum_mismatch", msg, time.Now().UTC().Add(time.Minute)); err != nil {
+			log.Printf("runner mark checksum mismatch error for job %d: %v", jobID, err)
+		}
+		return
+	}
+	if err != nil {
+		msg := "verify failed: " + err.Error()
+		_ = r.Store.AddEvent(ctx, jobID, "error", msg)
+		if err := r.Store.MarkFailed(ctx, jobID, "verify_failed", msg, time.Now().UTC().Add(10*time.Minute)); err != nil {
+			log.Printf("runner mark verify failed error for job %d: %v", jobID, err)
+		}
+		return
+	}
+	_ = r.Store.AddEvent(ctx, jobID, "info", "checksum verified: "+checksum)
+	r.finishDownload(ctx, *job, nil, job.BytesDone)
+}
@@ -0,0 +1,316 @@
+package queue
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+// fakeMirrorDownloader records the mirrors and options of the last start.
+type fakeMirrorDownloader struct {
+	fakeDownloader
+	uris    []string
+	options map[string]string
+}
+
+func (d *fakeMirrorDownloader) AddURIs(ctx context.Context, uris []string, options map[string]string) (string, error) {
+	d.uris, d.options = uris, options
+	return "gid-m", nil
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAria2Checksum(t *testing.T) {
+	cases := map[string]string{
+		"sha256:ABCD": "sha-256=abcd",
+		"sha1:ab":     "sha-1=ab",
+		"md5:ab":      "md5=ab",
+		"sha-512:ab":  "sha-512=ab",
+		"crc32:ab":    "crc32=ab",
+		"bogus":       "",
+	}
+	for in, want := range cases {
+		if got := aria2Checksum(in); got != want {
+			t.Fatalf("aria2Checksum(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeChecksum(t *testing.T) {
+	digest := sha256Hex("x")
+	got, err := normalizeChecksum(" SHA256:" + strings.ToUpper(digest))
+	if err != nil || got != "sha256:"+digest {
+		t.Fatalf("normalizeChecksum = %q, %v", got, err)
+	}
+	if got, err := normalizeChecksum(""); err != nil || got != "" {
+		t.Fatalf("empty checksum = %q, %v", got, err)
+	}
+	for _, bad := range []string{digest, "sha256:abc", "sha-256:" + digest, "whirlpool:" + digest, "md5:" + digest} {
+		if _, err := normalizeChecksum(bad); !errors.Is(err, ErrInvalidChecksum) {
+			t.Fatalf("normalizeChecksum(%q) err = %v", bad, err)
+		}
+	}
+}
+
+func TestFindSidecarChecksum(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md5Sum := md5.Sum([]byte("x"))
+	write("distro.iso.md5", hex.EncodeToString(md5Sum[:])+"\n")
+	write("release.sfv", "; generated\nother.bin 01234567\ndistro.iso DEADBEEF\n")
+	if got, src := findSidecarChecksum(dir, "distro.iso"); got != "md5:"+hex.EncodeToString(md5Sum[:]) || src != "distro.iso.md5" {
+		t.Fatalf("md5 sidecar = %q from %q", got, src)
+	}
+	write("SHA256SUMS.sha256", sha256Hex("other")+"  other.bin\n"+sha256Hex("x")+" *sub/distro.iso\n")
+	if got, src := findSidecarChecksum(dir, "distro.iso"); got != "sha256:"+sha256Hex("x") || src != "SHA256SUMS.sha256" {
+		t.Fatalf("sha256 sidecar = %q from %q", got, src)
+	}
+	if got, _ := findSidecarChecksum(dir, "other.bin"); got != "sha256:"+sha256Hex("other") {
+		t.Fatalf("other.bin = %q", got)
+	}
+	if got, _ := findSidecarChecksum(dir, "third.bin"); got != "" {
+		t.Fatalf("third.bin = %q, want none", got)
+	}
+	os.Remove(filepath.Join(dir, "SHA256SUMS.sha256"))
+	os.Remove(filepath.Join(dir, "distro.iso.md5"))
+	if got, _ := findSidecarChecksum(dir, "distro.iso"); got != "crc32:deadbeef" {
+		t.Fatalf("sfv = %q", got)
+	}
+}
+
+func TestRunnerStartsMetalinkWithMirrorsAndChecksum(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/distro.meta4", OutDir: t.TempDir(), MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	checksum := "sha256:" + strings.Repeat("ab", 32)
+	dl := &fakeMirrorDownloader{}
+	reg := resolver.NewRegistry(&torrentTargetResolver{target: resolver.ResolvedTarget{
+		Kind: "aria2", URL: "https://a.example/distro.iso", Filename: "distro.iso", Size: 10,
+		Mirrors:  []string{"https://a.example/distro.iso", "https://b.example/distro.iso"},
+		Checksum: checksum,
+	}})
+	runner := &Runner{Store: store, Resolvers: reg, Downloader: dl}
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	if len(dl.uris) != 2 || dl.uris[1] != "https://b.example/distro.iso" {
+		t.Fatalf("uris = %v", dl.uris)
+	}
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Checksum.String != checksum || job.EngineGID.String != "gid-m" {
+		t.Fatalf("checksum/gid = %q/%q", job.Checksum.String, job.EngineGID.String)
+	}
+	events, err := store.ListEvents(ctx, id, 20)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if !eventsContain(events, "mirrors=2") || !eventsContain(events, "expected checksum "+checksum) {
+		t.Fatalf("events = %v", events)
+	}
+
+	// Engines without mirror support download from the first URL, and a
+	// checksum given when adding the job is kept.
+	native := &fakeTorrentDownloader{}
+	runner.Engines = map[string]Downloader{EngineNative: native}
+	job.RequestedEngine = sqlNullString(EngineNative)
+	userChecksum := "md5:" + strings.Repeat("cd", 16)
+	if err := store.SetChecksum(ctx, id, userChecksum); err != nil {
+		t.Fatalf("set checksum: %v", err)
+	}
+	job.Checksum = sqlNullString(userChecksum)
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob native: %v", err)
+	}
+	if native.uri != "https://a.example/distro.iso" {
+		t.Fatalf("native uri = %q", native.uri)
+	}
+	if job, err = store.GetJob(ctx, id); err != nil || job.Checksum.String != userChecksum {
+		t.Fatalf("checksum = %q (%v), want the user's", job.Checksum.String, err)
+	}
+}
+
+func TestRunnerVerifiesChecksumAndRedownloadsOnMismatch(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	good := &Job{URL: "https://example.com/good.bin", OutDir: outDir, Name: "good.bin", MaxAttempts: 3, Checksum: sqlNullString("sha256:" + sha256Hex("payload"))}
+	bad := &Job{URL: "https://example.com/bad.bin", OutDir: outDir, Name: "bad.bin", MaxAttempts: 3, Checksum: sqlNullString("sha256:" + sha256Hex("other"))}
+	for _, j := range []*Job{good, bad} {
+		id, err := store.CreateJob(ctx, j)
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		j.ID = id
+		if err := os.WriteFile(filepath.Join(outDir, j.Name), []byte("payload"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.MarkDownloading(ctx, id, EngineAria2, "gid-1"); err != nil {
+			t.Fatalf("mark downloading: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "bad.bin.aria2"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dl := &fakeDownloader{status: &downloader.Status{GID: "gid-1", Status: "complete", TotalLength: "7", CompletedLen: "7"}}
+	runner := &Runner{Store: store, Resolvers: resolver.NewRegistry(&fakeResolver{}), Downloader: dl}
+	if err := runner.updateActive(ctx); err != nil {
+		t.Fatalf("updateActive: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		g, _ := store.GetJob(ctx, good.ID)
+		b, _ := store.GetJob(ctx, bad.ID)
+		return g.Status == StatusCompleted && b.Status == StatusFailed
+	})
+	events, _ := store.ListEvents(ctx, good.ID, 20)
+	if !eventsContain(events, "checksum verified: sha256:") {
+		t.Fatalf("good events = %v", events)
+	}
+	job, err := store.GetJob(ctx, bad.ID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.ErrorCode.String != "checksum_mismatch" || job.Attempts != 1 || !job.NextRetryAt.Valid {
+		t.Fatalf("code/attempts/retry = %s/%d/%v", job.ErrorCode.String, job.Attempts, job.NextRetryAt.Valid)
+	}
+	for _, name := range []string{"bad.bin", "bad.bin.aria2"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed for the re-download: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "good.bin")); err != nil {
+		t.Fatalf("verified file removed: %v", err)
+	}
+}
+
+func TestRunnerVerifyWaitsForSidecarInBatch(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/distro.iso", OutDir: outDir, Name: "distro.iso", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	sidecarID, err := store.CreateJob(ctx, &Job{URL: "https://example.com/distro.iso.sha256", OutDir: outDir, Name: "distro.iso.sha256", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create sidecar job: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "distro.iso"), []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkDownloading(ctx, id, EngineAria2, "gid-1"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	runner := &Runner{Store: store, Resolvers: resolver.NewRegistry(&fakeResolver{})}
+	if !runner.shouldVerify(ctx, *job) {
+		t.Fatalf("expected verification while the sidecar is queued")
+	}
+	if err := store.MarkVerifying(ctx, id, 7); err != nil {
+		t.Fatalf("mark verifying: %v", err)
+	}
+	runner.runVerify(ctx, id)
+	if job, _ = store.GetJob(ctx, id); job.Status != StatusVerifying {
+		t.Fatalf("status = %s, want verifying until the sidecar is done", job.Status)
+	}
+
+	if err := os.WriteFile(filepath.Join(outDir, "distro.iso.sha256"), []byte(sha256Hex("payload")+"  distro.iso\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkCompleted(ctx, sidecarID); err != nil {
+		t.Fatalf("complete sidecar: %v", err)
+	}
+	runner.runVerify(ctx, id)
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusCompleted || job.Checksum.String != "sha256:"+sha256Hex("payload") {
+		t.Fatalf("status/checksum = %s/%q", job.Status, job.Checksum.String)
+	}
+	events, _ := store.ListEvents(ctx, id, 20)
+	if !eventsContain(events, "checksum from distro.iso.sha256") {
+		t.Fatalf("events = %v", events)
+	}
+}
+
+func TestSidecarPendingMatchesOwnSidecarOrPackage(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	pkgID, err := store.CreatePackage(ctx, "Release")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	inPkg := sql.NullInt64{Int64: pkgID, Valid: true}
+	create := func(name string, pkg sql.NullInt64) Job {
+		t.Helper()
+		id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/" + name, OutDir: "/data", Name: name, MaxAttempts: 1, PackageID: pkg})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		job, _ := store.GetJob(ctx, id)
+		return *job
+	}
+	runner := &Runner{Store: store}
+	iso := create("distro.iso", sql.NullInt64{})
+	create("other.iso.sha256", sql.NullInt64{})
+	if runner.sidecarPending(ctx, iso) {
+		t.Fatalf("another file's sidecar counted as pending")
+	}
+	create("DISTRO.ISO.md5", sql.NullInt64{})
+	if !runner.sidecarPending(ctx, iso) {
+		t.Fatalf("own sidecar not counted as pending")
+	}
+	part := create("part1.bin", inPkg)
+	if runner.sidecarPending(ctx, part) {
+		t.Fatalf("sidecar outside the package counted as pending")
+	}
+	create("release.sfv", inPkg)
+	if !runner.sidecarPending(ctx, part) {
+		t.Fatalf("package sidecar not counted as pending")
+	}
+}
+
+func TestServiceRejectsChecksumForMegaAndTorrents(t *testing.T) {
+	store := newRunnerStore(t)
+	svc := NewService(store, &fakeDownloader{}, []string{"/data"})
+	for _, req := range []JobRequest{
+		{URL: "https://mega.nz/file/abc#key", OutDir: "/data", Checksum: "sha256:" + sha256Hex("payload")},
+		{URL: "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567", OutDir: "/data", Checksum: "sha256:" + sha256Hex("payload")},
+	} {
+		if _, err := svc.CreateJob(context.Background(), req); !errors.Is(err, ErrInvalidChecksum) {
+			t.Fatalf("CreateJob(%s) = %v, want ErrInvalidChecksum", req.URL, err)
+		}
+	}
+}
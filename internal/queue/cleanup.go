@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+// Archive cleanup policies applied after a successful extraction.
+const (
+	ArchiveCleanupKeep   = "keep"
+	ArchiveCleanupDelete = "delete"
+	ArchiveCleanupTrash  = "trash"
+)
+
+// TrashDirName is the folder under out_dir that the trash policy moves
+// extracted archives to.
+const TrashDirName = ".dlq-trash"
+
+// ValidArchiveCleanup reports whether name is a known cleanup policy; ""
+// means keep.
+func ValidArchiveCleanup(name string) bool {
+	switch name {
+	case "", ArchiveCleanupKeep, ArchiveCleanupDelete, ArchiveCleanupTrash:
+		return true
+	}
+	return false
+}
+
+func (r *Runner) archiveCleanup() string {
+	if r.GetArchiveCleanup != nil {
+		if policy := r.GetArchiveCleanup(); policy != "" {
+			return policy
+		}
+	}
+	return ArchiveCleanupKeep
+}
+
+// archiveVolumes returns the files in the archive's directory that belong
+// to its multipart group, or just the archive when it has none.
+func archiveVolumes(archivePath string) []string {
+	key, _ := multipartArchiveGroupKey(archivePath)
+	if key == "" {
+		return []string{archivePath}
+	}
+	dir := filepath.Dir(archivePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return []string{archivePath}
+	}
+	var volumes []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		if other, _ := multipartArchiveGroupKey(p); other == key {
+			volumes = append(volumes, p)
+		}
+	}
+	return volumes
+}
+
+// downloadLeftovers returns the engine control and temp files left next to
+// a download: aria2 .aria2 files, native engine state and MEGA decrypt temps.
+func downloadLeftovers(path string) []string {
+	out := []string{path + ".aria2", path + downloadclient.HTTPStateSuffix}
+	megaTemps, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "."+escapeGlob(filepath.Base(path))+".dlq-mega-*"))
+	return append(out, megaTemps...)
+}
+
+// isDownloadLeftover reports whether name is one of the files
+// downloadLeftovers lists.
+func isDownloadLeftover(name string) bool {
+	return strings.HasSuffix(name, ".aria2") || strings.HasSuffix(name, downloadclient.HTTPStateSuffix) ||
+		(strings.HasPrefix(name, ".") && strings.Contains(name, ".dlq-mega-"))
+}
+
+// dirSnapshot returns the modification time of each entry in dir.
+func dirSnapshot(dir string) map[string]time.Time {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	snap := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			snap[e.Name()] = info.ModTime()
+		}
+	}
+	return snap
+}
+
+// extractedPaths returns the top-level entries of dir that are new or
+// changed since the before snapshot: what an extraction into dir produced.
+// The archive's volumes, TrashDirName and downloads still in progress are
+// left out.
+func extractedPaths(dir string, before map[string]time.Time, archivePath string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	skip := map[string]bool{TrashDirName: true}
+	for _, v := range archiveVolumes(archivePath) {
+		skip[filepath.Base(v)] = true
+	}
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if skip[name] || isDownloadLeftover(name) || names[name+".aria2"] || names[name+downloadclient.HTTPStateSuffix] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if t, ok := before[name]; ok && t.Equal(info.ModTime()) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return paths
+}
+
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(s)
+}
+
+// archiveGroupExtracting reports whether another job of the archive's group
+// still has to extract it; cleanup is left to the last one.
+func (r *Runner) archiveGroupExtracting(ctx context.Context, jobID int64, outDir, archivePath string) bool {
+	key, _ := multipartArchiveGroupKey(archivePath)
+	if key == "" {
+		return false
+	}
+	jobs, err := r.Store.ListJobs(ctx, StatusDecrypting, false)
+	if err != nil {
+		log.Printf("runner cleanup scan error for job %d: %v", jobID, err)
+		return true
+	}
+	for _, other := range jobs {
+		if other.ID == jobID || other.OutDir != outDir || other.HooksPending {
+			continue
+		}
+		if otherKey, _ := multipartArchiveGroupKey(archivePathForJob(other)); otherKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupArchive runs after a successful extraction: engine leftovers of
+// the volumes are always deleted, then the cleanup policy keeps the volumes,
+// deletes them or moves them to TrashDirName in out_dir. Each removed path
+// is logged as a job event.
+func (r *Runner) cleanupArchive(ctx context.Context, task decryptTask) {
+	volumes := archiveVolumes(task.archivePath)
+	for _, p := range volumes {
+		for _, leftover := range downloadLeftovers(p) {
+			r.removeCleanupPath(ctx, task.jobID, leftover)
+		}
+	}
+	policy := r.archiveCleanup()
+	if policy == ArchiveCleanupKeep || r.archiveGroupExtracting(ctx, task.jobID, task.outDir, task.archivePath) {
+		return
+	}
+	if policy == ArchiveCleanupDelete {
+		for _, p := range volumes {
+			r.removeCleanupPath(ctx, task.jobID, p)
+		}
+		return
+	}
+	trash := filepath.Join(task.outDir, TrashDirName)
+	if err := os.MkdirAll(trash, 0o755); err != nil {
+		_ = r.Store.AddEvent(ctx, task.jobID, "error", "cleanup failed: "+err.Error())
+		return
+	}
+	for _, p := range volumes {
+		dst := filepath.Join(trash, filepath.Base(p))
+		if err := os.Rename(p, dst); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				_ = r.Store.AddEvent(ctx, task.jobID, "error", "cleanup failed: "+err.Error())
+			}
+			continue
+		}
+		_ = r.Store.AddEvent(ctx, task.jobID, "info", "cleanup: moved "+p+" to "+trash)
+	}
+}
+
+func (r *Runner) removeCleanupPath(ctx context.Context, jobID int64, p string) {
+	if err := os.Remove(p); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			_ = r.Store.AddEvent(ctx, jobID, "error", "cleanup failed: "+err.Error())
+		}
+		return
+	}
+	_ = r.Store.AddEvent(ctx, jobID, "info", "cleanup: deleted "+p)
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveVolumes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"show.part1.rar", "show.part2.rar", "show.part2.rar.aria2", "other.part1.rar", "show.par2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := archiveVolumes(filepath.Join(dir, "show.part1.rar"))
+	if len(got) != 2 || filepath.Base(got[0]) != "show.part1.rar" || filepath.Base(got[1]) != "show.part2.rar" {
+		t.Fatalf("volumes = %v", got)
+	}
+	if got := archiveVolumes(filepath.Join(dir, "movie.zip")); len(got) != 1 || filepath.Base(got[0]) != "movie.zip" {
+		t.Fatalf("single archive volumes = %v", got)
+	}
+}
+
+func TestRunnerCleansUpMultipartArchiveAfterLastExtraction(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	files := []string{"show.part1.rar", "show.part2.rar", "show.part2.rar.aria2", ".show.part1.rar.dlq-mega-123", "notes.txt"}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(outDir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []int64
+	for _, name := range []string{"show.part1.rar", "show.part2.rar"} {
+		id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/" + name, OutDir: outDir, Name: name, MaxAttempts: 1})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		if err := store.MarkDecrypting(ctx, id, 1); err != nil {
+			t.Fatalf("mark decrypting: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	runner := &Runner{
+		Store:             store,
+		ArchiveDecryptor:  &fakeArchiveDecryptor{attempted: true},
+		GetAutoDecrypt:    func() bool { return true },
+		GetArchiveCleanup: func() string { return ArchiveCleanupDelete },
+	}
+	run := func(id int64) {
+		t.Helper()
+		job, err := store.GetJob(ctx, id)
+		if err != nil {
+			t.Fatalf("get job: %v", err)
+		}
+		task, ok, waitMsg, _ := runner.buildDecryptTask(ctx, *job, nil)
+		if !ok || waitMsg != "" {
+			t.Fatalf("buildDecryptTask = %v/%q", ok, waitMsg)
+		}
+		runner.runDecrypt(ctx, task)
+	}
+
+	run(ids[0])
+	if _, err := os.Stat(filepath.Join(outDir, "show.part2.rar")); err != nil {
+		t.Fatalf("volumes removed while part2 still extracts: %v", err)
+	}
+	run(ids[1])
+	for _, name := range files[:4] {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "notes.txt")); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+	// Leftovers go with the first extraction, the volumes with the last.
+	first, _ := store.ListEvents(ctx, ids[0], 20)
+	last, _ := store.ListEvents(ctx, ids[1], 20)
+	for i, name := range files[:4] {
+		events := last
+		if i >= 2 {
+			events = first
+		}
+		if !eventsContain(events, "cleanup: deleted "+filepath.Join(outDir, name)) {
+			t.Fatalf("no event for %s: %v", name, events)
+		}
+	}
+}
+
+func TestRunnerMovesArchiveToTrash(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	for _, name := range []string{"movie.zip", "movie.zip.dlq-http"} {
+		if err := os.WriteFile(filepath.Join(outDir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/movie.zip", OutDir: outDir, Name: "movie.zip", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	policy := ArchiveCleanupKeep
+	runner := &Runner{
+		Store:             store,
+		ArchiveDecryptor:  &fakeArchiveDecryptor{attempted: true},
+		GetAutoDecrypt:    func() bool { return true },
+		GetArchiveCleanup: func() string { return policy },
+	}
+	task := decryptTask{jobID: id, archivePath: filepath.Join(outDir, "movie.zip"), outDir: outDir, decryptArch: true}
+	runner.runDecrypt(ctx, task)
+	if _, err := os.Stat(filepath.Join(outDir, "movie.zip")); err != nil {
+		t.Fatalf("keep policy removed the archive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "movie.zip.dlq-http")); !os.IsNotExist(err) {
+		t.Fatalf("keep policy left the engine leftover: %v", err)
+	}
+
+	policy = ArchiveCleanupTrash
+	runner.runDecrypt(ctx, task)
+	if _, err := os.Stat(filepath.Join(outDir, TrashDirName, "movie.zip")); err != nil {
+		t.Fatalf("archive not in trash: %v", err)
+	}
+	for _, name := range []string{"movie.zip", "movie.zip.dlq-http"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s still in out_dir: %v", name, err)
+		}
+	}
+	events, _ := store.ListEvents(ctx, id, 20)
+	if !eventsContain(events, "cleanup: moved "+filepath.Join(outDir, "movie.zip")) || !eventsContain(events, "cleanup: deleted "+filepath.Join(outDir, "movie.zip.dlq-http")) {
+		t.Fatalf("events = %v", events)
+	}
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users on the
+// filesystem holding path. Missing directories are resolved to their nearest
+// existing parent, since the engine creates out_dir on demand.
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(nearestExistingDir(path), &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// fsDevice identifies the filesystem holding path.
+func fsDevice(path string) (uint64, bool) {
+	info, err := os.Stat(nearestExistingDir(path))
+	if err != nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+func nearestExistingDir(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func (r *Runner) freeSpace(path string) (int64, error) {
+	if r.FreeSpace != nil {
+		return r.FreeSpace(path)
+	}
+	return diskFree(path)
+}
+
+func (r *Runner) minFreeSpace(outDir string) int64 {
+	if r.GetMinFreeSpace != nil {
+		return r.GetMinFreeSpace(outDir)
+	}
+	return 0
+}
+
+// downloadSpaceNeeded estimates what a job still has to write: the rest of the
+// download plus, when it will be decrypted or extracted afterwards, one more
+// copy of the file for the output.
+func (r *Runner) downloadSpaceNeeded(job Job, outName string, size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	path := filepath.Join(job.OutDir, outName)
+	need := size
+	if info, err := os.Stat(path); err == nil {
+		need -= info.Size()
+	}
+	if need < 0 {
+		need = 0
+	}
+	if r.shouldDecryptMega(job, path) || r.shouldDecryptArchive(job, path, nullString(job.ArchivePassword)) {
+		need += size
+	}
+	return need
+}
+
+// decryptSpaceNeeded estimates the output size of a post-download task as the
+// size of its input.
+func decryptSpaceNeeded(task decryptTask) int64 {
+	var need int64
+	if task.decryptMega {
+		if info, err := os.Stat(task.megaPath); err == nil {
+			need += info.Size()
+		}
+	}
+	if task.decryptArch {
+		if info, err := os.Stat(task.archivePath); err == nil {
+			need += info.Size()
+		}
+	}
+	return need
+}
+
+// spaceShortfall checks that writing need more bytes to outDir keeps at least
+// the configured minimum free, after setting aside what other running
+// downloads on the same filesystem still have to write. It returns a
+// user-facing reason when there is not enough room, or "" when there is.
+func (r *Runner) spaceShortfall(ctx context.Context, jobID int64, outDir string, need int64) string {
+	minFree := r.minFreeSpace(outDir)
+	if need <= 0 && minFree <= 0 {
+		return ""
+	}
+	free, err := r.freeSpace(outDir)
+	if err != nil {
+		log.Printf("job %d free space check error: %v", jobID, err)
+		return ""
+	}
+	reserved := r.reservedSpace(ctx, jobID, outDir)
+	if free-reserved-need >= minFree {
+		return ""
+	}
+	msg := fmt.Sprintf("waiting for disk space: need %s, %s free on %s", formatBytes(need), formatBytes(free), nearestExistingDir(outDir))
+	if reserved > 0 {
+		msg += fmt.Sprintf(" (%s reserved by running downloads)", formatBytes(reserved))
+	}
+	if minFree > 0 {
+		msg += fmt.Sprintf(", keeping %s free", formatBytes(minFree))
+	}
+	return msg
+}
+
+// reservedSpace sums the bytes other downloading jobs on the same filesystem
+// as outDir still have to write.
+func (r *Runner) reservedSpace(ctx context.Context, jobID int64, outDir string) int64 {
+	dev, ok := fsDevice(outDir)
+	if !ok {
+		return 0
+	}
+	jobs, err := r.Store.ListJobs(ctx, StatusDownloading, false)
+	if err != nil {
+		return 0
+	}
+	var reserved int64
+	for _, j := range jobs {
+		if j.ID == jobID || !j.SizeBytes.Valid || j.SizeBytes.Int64 <= j.BytesDone {
+			continue
+		}
+		if d, ok := fsDevice(j.OutDir); !ok || d != dev {
+			continue
+		}
+		reserved += j.SizeBytes.Int64 - j.BytesDone
+	}
+	return reserved
+}
+
+// recheckWaitingSpace releases jobs parked in waiting_space, in queue order,
+// as long as there is room for them.
+func (r *Runner) recheckWaitingSpace(ctx context.Context) error {
+	jobs, err := r.Store.ListWaitingSpace(ctx)
+	if err != nil {
+		return err
+	}
+	// Space promised to jobs released earlier in this pass, per filesystem.
+	promised := map[uint64]int64{}
+	for _, job := range jobs {
+		var need int64
+		if nullString(job.ResumeStatus) == StatusDecrypting {
+			job.Status = StatusDecrypting
+			task, shouldProcess, _, _ := r.buildDecryptTask(ctx, job, nil)
+			if shouldProcess {
+				need = decryptSpaceNeeded(task)
+			}
+		} else {
+			need = r.downloadSpaceNeeded(job, outputNameForJob(job), job.SizeBytes.Int64)
+		}
+		dev, _ := fsDevice(job.OutDir)
+		if r.spaceShortfall(ctx, job.ID, job.OutDir, need+promised[dev]) != "" {
+			continue
+		}
+		if err := r.Store.ResumeFromWaitingSpace(ctx, job.ID); err != nil {
+			return err
+		}
+		promised[dev] += need
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "disk space available; resuming")
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+func TestRunnerWaitsForDiskSpaceAndResumes(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/file.bin", OutDir: outDir, MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	free := int64(5)
+	runner := &Runner{
+		Store:           store,
+		Resolvers:       resolver.NewRegistry(&fakeResolver{}),
+		Downloader:      &fakeDownloader{},
+		Concurrency:     1,
+		FreeSpace:       func(string) (int64, error) { return free, nil },
+		GetMinFreeSpace: func(string) int64 { return 2 },
+	}
+	runner.tick(ctx)
+
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusWaitingSpace {
+		t.Fatalf("expected waiting_space, got %s", job.Status)
+	}
+	events, err := store.ListEvents(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events) == 0 || !strings.Contains(events[0], "waiting for disk space") {
+		t.Fatalf("expected disk space event, got %v", events)
+	}
+
+	// 10 bytes needed plus 2 kept free.
+	free = 11
+	runner.tick(ctx)
+	if job, _ = store.GetJob(ctx, id); job.Status != StatusWaitingSpace {
+		t.Fatalf("expected job to keep waiting below the minimum, got %s", job.Status)
+	}
+	free = 12
+	runner.tick(ctx)
+	if job, _ = store.GetJob(ctx, id); job.Status != StatusDownloading {
+		t.Fatalf("expected job to start once space frees up, got %s", job.Status)
+	}
+	if job.Attempts != 0 {
+		t.Fatalf("expected waiting for space not to use an attempt, got %d", job.Attempts)
+	}
+}
@@ -6,6 +6,21 @@ import (
 	"github.com/Witriol/dlq-download-queue/internal/downloader"
 )
 
+// Download engines a job can run on.
+const (
+	EngineAria2  = "aria2"
+	EngineNative = "native"
+)
+
+// ValidEngine reports whether name is a known engine. Empty means automatic.
+func ValidEngine(name string) bool {
+	switch name {
+	case "", EngineAria2, EngineNative:
+		return true
+	}
+	return false
+}
+
 // Downloader defines the minimal aria2 client surface used by the queue.
 type Downloader interface {
 	AddURI(ctx context.Context, uri string, options map[string]string) (string, error)
@@ -15,4 +30,49 @@ type Downloader interface {
 	Remove(ctx context.Context, gid string) error
 }
 
+// ActiveLister is implemented by engines that can report every active
+// transfer in a single call.
+type ActiveLister interface {
+	TellActive(ctx context.Context) ([]downloader.Status, error)
+}
+
+// TransferLister is implemented by engines that can enumerate every transfer
+// they know about, including queued and finished ones.
+type TransferLister interface {
+	ActiveLister
+	TellWaiting(ctx context.Context, offset, num int) ([]downloader.Status, error)
+	TellStopped(ctx context.Context, offset, num int) ([]downloader.Status, error)
+}
+
+// OptionChanger is implemented by engines that accept option changes at
+// runtime, such as speed limits.
+type OptionChanger interface {
+	ChangeOption(ctx context.Context, gid string, options map[string]string) error
+	ChangeGlobalOption(ctx context.Context, options map[string]string) error
+}
+
+// TorrentAdder is implemented by engines that can download BitTorrent.
+type TorrentAdder interface {
+	AddTorrent(ctx context.Context, torrent []byte, options map[string]string) (string, error)
+}
+
+// MirrorAdder is implemented by engines that can download one file from
+// several mirrors at once.
+type MirrorAdder interface {
+	AddURIs(ctx context.Context, uris []string, options map[string]string) (string, error)
+}
+
+// Notifier streams download lifecycle events pushed by the engine.
+type Notifier interface {
+	Subscribe(ctx context.Context) <-chan downloader.Event
+}
+
 var _ Downloader = (*downloader.Aria2Client)(nil)
+var _ TransferLister = (*downloader.Aria2Client)(nil)
+var _ TransferLister = (*downloader.HTTPEngine)(nil)
+var _ Downloader = (*downloader.HTTPEngine)(nil)
+var _ OptionChanger = (*downloader.Aria2Client)(nil)
+var _ OptionChanger = (*downloader.HTTPEngine)(nil)
+var _ TorrentAdder = (*downloader.Aria2Client)(nil)
+var _ MirrorAdder = (*downloader.Aria2Client)(nil)
+var _ Notifier = (*downloader.Aria2Notifier)(nil)
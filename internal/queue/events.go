@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"strings"
+)
+
+// EventFilter selects job_events rows for the live event stream.
+type EventFilter struct {
+	AfterID   int64 // only rows with a larger id
+	JobID     int64 // 0 = any job
+	PackageID int64 // 0 = any package
+	Limit     int
+	// Tail returns the newest Limit rows (still in ascending order) instead of
+	// the oldest ones after AfterID.
+	Tail bool
+}
+
+// EventView is one job_events row.
+type EventView struct {
+	ID        int64  `json:"id"`
+	JobID     int64  `json:"job_id"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (s *Store) ListEventsAfter(ctx context.Context, f EventFilter) ([]EventView, error) {
+	query := `SELECT e.id, e.job_id, e.level, e.message, e.created_at FROM job_events e`
+	where := []string{"e.id > ?"}
+	args := []any{f.AfterID}
+	if f.PackageID > 0 {
+		query += ` JOIN jobs j ON j.id = e.job_id`
+		where = append(where, "j.package_id = ?")
+		args = append(args, f.PackageID)
+	}
+	if f.JobID > 0 {
+		where = append(where, "e.job_id = ?")
+		args = append(args, f.JobID)
+	}
+	query += " WHERE " + strings.Join(where, " AND ")
+	if f.Tail {
+		query += " ORDER BY e.id DESC"
+	} else {
+		query += " ORDER BY e.id ASC"
+	}
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []EventView
+	for rows.Next() {
+		var ev EventView
+		if err := rows.Scan(&ev.ID, &ev.JobID, &ev.Level, &ev.Message, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if f.Tail {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out, nil
+}
+
+// LastEventID returns the id of the newest job_events row, or 0 when there
+// are none.
+func (s *Store) LastEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM job_events`).Scan(&id)
+	return id, err
+}
+
+func (s *Service) ListEventsAfter(ctx context.Context, f EventFilter) ([]EventView, error) {
+	return s.store.ListEventsAfter(ctx, f)
+}
+
+func (s *Service) LastEventID(ctx context.Context) (int64, error) {
+	return s.store.LastEventID(ctx)
+}
+
+// SubscribeChanges reports job and event writes until ctx is done.
+func (s *Service) SubscribeChanges(ctx context.Context) *Changes {
+	return s.store.SubscribeChanges(ctx)
+}
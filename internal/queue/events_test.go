@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStoreListEventsAfterFiltersAndTails(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, nil, []string{"/data"})
+
+	pkgID, err := svc.CreatePackage(ctx, "release")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	inPkg, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a", OutDir: "/data", PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	other, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/b", OutDir: "/data"})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	start, err := store.LastEventID(ctx)
+	if err != nil {
+		t.Fatalf("last event id: %v", err)
+	}
+	for _, e := range []struct {
+		id  int64
+		msg string
+	}{{inPkg, "one"}, {other, "two"}, {inPkg, "three"}, {inPkg, "four"}} {
+		if err := store.AddEvent(ctx, e.id, "info", e.msg); err != nil {
+			t.Fatalf("add event: %v", err)
+		}
+	}
+
+	events, err := store.ListEventsAfter(ctx, EventFilter{AfterID: start, PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events) != 3 || events[0].Message != "one" || events[2].Message != "four" {
+		t.Fatalf("unexpected package events: %+v", events)
+	}
+
+	events, err = store.ListEventsAfter(ctx, EventFilter{AfterID: events[0].ID, JobID: other})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events) != 1 || events[0].Message != "two" {
+		t.Fatalf("unexpected job events: %+v", events)
+	}
+
+	events, err = store.ListEventsAfter(ctx, EventFilter{JobID: inPkg, Limit: 2, Tail: true})
+	if err != nil {
+		t.Fatalf("tail events: %v", err)
+	}
+	if len(events) != 2 || events[0].Message != "three" || events[1].Message != "four" {
+		t.Fatalf("tail should return the newest rows in order, got %+v", events)
+	}
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+// expandFolder replaces a job whose URL resolved to a folder with one queued
+// job per file. The children keep the folder's subdirectories under the
+// job's out_dir and are grouped in the job's package, or in a new package
+// named after the folder. The folder job itself is removed.
+func (r *Runner) expandFolder(ctx context.Context, job *Job, res *resolver.ResolvedTarget) error {
+	var children []*Job
+	for _, entry := range res.Entries {
+		outDir, err := cleanOutDir(filepath.Join(job.OutDir, filepath.FromSlash(entry.Path)), []string{job.OutDir})
+		if err != nil {
+			_ = r.Store.AddEvent(ctx, job.ID, "error", "skipped "+entry.Path+"/"+entry.Filename+": "+err.Error())
+			continue
+		}
+		children = append(children, &Job{
+			URL:              entry.URL,
+			Site:             job.Site,
+			OutDir:           outDir,
+			Name:             entry.Filename,
+			ArchivePassword:  job.ArchivePassword,
+			MaxAttempts:      job.MaxAttempts,
+			Priority:         job.Priority,
+			PackageID:        job.PackageID,
+			RequestedEngine:  job.RequestedEngine,
+			MaxDownloadLimit: job.MaxDownloadLimit,
+		})
+	}
+	if len(children) == 0 {
+		msg := "folder has no files"
+		_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
+		return r.Store.MarkFailed(ctx, job.ID, "folder_empty", msg, time.Now().UTC().Add(30*time.Minute))
+	}
+	pkgName := ""
+	if !job.PackageID.Valid {
+		pkgName = strings.TrimSpace(job.Name)
+		if pkgName == "" {
+			pkgName = strings.TrimSpace(res.Filename)
+		}
+		if pkgName == "" {
+			pkgName = fmt.Sprintf("folder %d", job.ID)
+		}
+	}
+	packageID, ids, err := r.Store.ExpandFolder(ctx, job.ID, job.PackageID, pkgName, children)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		_ = r.Store.AddEvent(ctx, id, "info", fmt.Sprintf("added from folder job %d out=%s", job.ID, children[i].OutDir))
+	}
+	_ = r.Store.AddEvent(ctx, job.ID, "info", fmt.Sprintf("expanded folder %q into %d jobs in package %d", res.Filename, len(children), packageID))
+	return nil
+}
+
+// ExpandFolder replaces the folder job with children in one transaction:
+// unless packageID is set, a package named pkgName is created for them; the
+// children are queued in it and the folder job is removed. It returns the
+// package id and the children's ids.
+func (s *Store) ExpandFolder(ctx context.Context, folderID int64, packageID sql.NullInt64, pkgName string, children []*Job) (int64, []int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	if !packageID.Valid {
+		id, err := insertPackage(ctx, tx, pkgName)
+		if err != nil {
+			return 0, nil, err
+		}
+		packageID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	ids := make([]int64, 0, len(children))
+	for _, child := range children {
+		child.PackageID = packageID
+		id, err := insertJob(ctx, tx, child)
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := tx.ExecContext(ctx, `
+UPDATE jobs SET status = ?, deleted_at = ?, updated_at = ?
+WHERE id = ?
+`, StatusDeleted, now, now, folderID); err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	s.jobChanged(0)
+	return packageID.Int64, ids, nil
+}
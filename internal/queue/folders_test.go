@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+type folderResolver struct{}
+
+func (r *folderResolver) CanHandle(rawURL string) bool { return true }
+func (r *folderResolver) Resolve(ctx context.Context, rawURL string) (*resolver.ResolvedTarget, error) {
+	return &resolver.ResolvedTarget{
+		Kind:     resolver.KindFolder,
+		Filename: "Release",
+		Size:     15,
+		Entries: []resolver.FolderEntry{
+			{URL: "https://mega.nz/#N!AAAA!k1###n=FOLD", Filename: "a.rar", Size: 10},
+			{URL: "https://mega.nz/#N!BBBB!k2###n=FOLD", Path: "Extras/Subs", Filename: "b.srt", Size: 5},
+		},
+	}, nil
+}
+
+func TestRunnerExpandsFolderIntoPackage(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://mega.nz/folder/FOLD#key", Site: "mega", OutDir: "/data/dl", MaxAttempts: 3, Priority: 2})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	reg := resolver.NewRegistry(&folderResolver{})
+	reg.RegisterSite("mega", &folderResolver{})
+	runner := &Runner{Store: store, Resolvers: reg, Downloader: &fakeDownloader{}}
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+
+	parent, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get parent: %v", err)
+	}
+	if parent.Status != StatusDeleted {
+		t.Fatalf("parent status = %s, want deleted", parent.Status)
+	}
+	pkgs, err := store.ListPackages(ctx)
+	if err != nil || len(pkgs) != 1 || pkgs[0].Name != "Release" {
+		t.Fatalf("packages = %+v, err = %v", pkgs, err)
+	}
+	children, err := store.ListPackageJobs(ctx, pkgs[0].ID)
+	if err != nil {
+		t.Fatalf("list package jobs: %v", err)
+	}
+	if len(children) != 2 {
+		t.Fatalf("children = %d, want 2", len(children))
+	}
+	wantDirs := map[string]string{
+		"https://mega.nz/#N!AAAA!k1###n=FOLD": "/data/dl",
+		"https://mega.nz/#N!BBBB!k2###n=FOLD": filepath.Join("/data/dl", "Extras", "Subs"),
+	}
+	for _, c := range children {
+		if c.OutDir != wantDirs[c.URL] {
+			t.Fatalf("child %s out_dir = %q, want %q", c.URL, c.OutDir, wantDirs[c.URL])
+		}
+		if c.Status != StatusQueued || c.Site != "mega" || c.MaxAttempts != 3 || c.Priority != 2 || c.Name == "" {
+			t.Fatalf("child = %+v", c)
+		}
+	}
+}
+
+func TestRunnerExpandFolderRejectsEscapingPaths(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://mega.nz/folder/FOLD#key", OutDir: "/data/dl", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, _ := store.GetJob(ctx, id)
+	runner := &Runner{Store: store}
+	res := &resolver.ResolvedTarget{Kind: resolver.KindFolder, Filename: "x", Entries: []resolver.FolderEntry{
+		{URL: "https://mega.nz/#N!AAAA!k1###n=FOLD", Path: "../../etc", Filename: "passwd"},
+	}}
+	if err := runner.expandFolder(ctx, job, res); err != nil {
+		t.Fatalf("expandFolder: %v", err)
+	}
+	jobs, err := store.ListJobs(ctx, "", false)
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != id || jobs[0].Status != StatusFailed {
+		t.Fatalf("expected only the failed folder job, got %+v", jobs)
+	}
+	if pkgs, _ := store.ListPackages(ctx); len(pkgs) != 0 {
+		t.Fatalf("expected no package, got %+v", pkgs)
+	}
+}
+
+func TestStoreExpandFolderIsAtomic(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://mega.nz/folder/FOLD#key", Site: "mega", OutDir: "/data/dl", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	children := []*Job{{URL: "https://mega.nz/#N!AAAA!k1###n=FOLD", OutDir: "/data/dl", Name: "a.rar", MaxAttempts: 3}}
+	// A package that does not exist fails the insert of the children.
+	if _, _, err := store.ExpandFolder(ctx, id, sql.NullInt64{Int64: 999, Valid: true}, "", children); err == nil {
+		t.Fatalf("expected error for a missing package")
+	}
+	jobs, err := store.ListJobs(ctx, "", true)
+	if err != nil || len(jobs) != 1 || jobs[0].Status != StatusQueued {
+		t.Fatalf("jobs after failed expand = %+v, %v", jobs, err)
+	}
+	pkgID, ids, err := store.ExpandFolder(ctx, id, sql.NullInt64{}, "Release", children)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expand = %v, %v", ids, err)
+	}
+	if child, _ := store.GetJob(ctx, ids[0]); child.PackageID.Int64 != pkgID {
+		t.Fatalf("child package = %d, want %d", child.PackageID.Int64, pkgID)
+	}
+	if parent, _ := store.GetJob(ctx, id); parent.Status != StatusDeleted {
+		t.Fatalf("parent status = %s", parent.Status)
+	}
+}
@@ -291,8 +291,10 @@ func parseMegaFileLink(raw string) (string, string, error) {
 			fileID = strings.TrimSpace(parts[1])
 		}
 		fileKey = strings.TrimSpace(fragment)
-	} else if strings.HasPrefix(fragment, "!") {
-		parts := strings.Split(strings.TrimPrefix(fragment, "!"), "!")
+	} else if strings.HasPrefix(fragment, "!") || strings.HasPrefix(fragment, "N!") {
+		// "#N!<handle>!<key>###n=<folder>" is a file inside a folder link.
+		node, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(fragment, "N"), "!"), "###")
+		parts := strings.Split(node, "!")
 		if len(parts) >= 2 {
 			fileID = strings.TrimSpace(parts[0])
 			fileKey = strings.TrimSpace(parts[1])
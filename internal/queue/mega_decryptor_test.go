@@ -115,3 +115,13 @@ func encryptMegaCiphertext32ForTest(t *testing.T, plain []byte) ([]byte, string)
 	token := base64.RawURLEncoding.EncodeToString(rawKey)
 	return out, token
 }
+
+func TestParseMegaFileLinkAcceptsFolderNodeLinks(t *testing.T) {
+	id, key, err := parseMegaFileLink("https://mega.nz/#N!AbCd1234!QwErTy123_-###n=FoLdEr12")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if id != "AbCd1234" || key != "QwErTy123_-" {
+		t.Fatalf("got (%q,%q)", id, key)
+	}
+}
@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"log"
+
+	"github.com/Witriol/dlq-download-queue/internal/metrics"
+)
+
+var (
+	jobsByStatus = metrics.NewGaugeVec("dlq_jobs",
+		"Jobs by status (deleted jobs excluded).", "status")
+	downloadSpeed = metrics.NewGaugeVec("dlq_download_speed_bytes",
+		"Current aggregate download speed in bytes per second.")
+	downloadedBytes = metrics.NewCounterVec("dlq_downloaded_bytes_total",
+		"Bytes downloaded, by site.", "site")
+	decryptDuration = metrics.NewHistogramVec("dlq_decrypt_duration_seconds",
+		"Time spent decrypting or extracting downloads.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}, "kind")
+	decryptFailures = metrics.NewCounterVec("dlq_decrypt_failures_total",
+		"Failed decrypt/extract runs.", "kind")
+	tickDuration = metrics.NewHistogramVec("dlq_runner_tick_duration_seconds",
+		"Duration of runner ticks.", metrics.DurationBuckets)
+)
+
+// CollectMetrics refreshes the job gauges from the database; dlqd runs it
+// before every /metrics scrape.
+func (s *Store) CollectMetrics(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM jobs WHERE deleted_at IS NULL GROUP BY status`)
+	if err != nil {
+		log.Printf("metrics collect error: %v", err)
+		return
+	}
+	defer rows.Close()
+	jobsByStatus.Reset()
+	for _, st := range liveStatuses {
+		jobsByStatus.Set(0, st)
+	}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			log.Printf("metrics collect error: %v", err)
+			return
+		}
+		jobsByStatus.Set(float64(n), status)
+	}
+	var speed int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(download_speed), 0) FROM jobs WHERE status = ? AND deleted_at IS NULL`, StatusDownloading).Scan(&speed); err == nil {
+		downloadSpeed.Set(float64(speed))
+	}
+}
+
+// countDownloaded adds the progress since the job was last stored to the
+// per-site byte counter.
+func countDownloaded(job Job, bytesDone int64) {
+	if delta := bytesDone - job.BytesDone; delta > 0 {
+		downloadedBytes.Add(float64(delta), JobSite(job.Site, job.URL))
+	}
+}
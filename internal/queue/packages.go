@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Aggregate package states; job states are reused where they apply.
+const (
+	PackageStatusEmpty = "empty"
+)
+
+// Package groups jobs that were added together (e.g. all parts of one release).
+type Package struct {
+	ID        int64
+	Name      string
+	CreatedAt string
+	UpdatedAt string
+	DeletedAt sql.NullString
+}
+
+func (s *Store) CreatePackage(ctx context.Context, name string) (int64, error) {
+	return insertPackage(ctx, s.db, name)
+}
+
+func insertPackage(ctx context.Context, ex execer, name string) (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := ex.ExecContext(ctx, `
+INSERT INTO packages (name, created_at, updated_at) VALUES (?, ?, ?)
+`, name, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetPackage(ctx context.Context, id int64) (*Package, error) {
+	var p Package
+	err := s.db.QueryRowContext(ctx, `
+SELECT id, name, created_at, updated_at, deleted_at FROM packages WHERE id = ?
+`, id).Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) ListPackages(ctx context.Context) ([]Package, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, name, created_at, updated_at, deleted_at FROM packages WHERE deleted_at IS NULL ORDER BY id DESC
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Package
+	for rows.Next() {
+		var p Package
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// ListPackageJobs returns the non-deleted jobs of a package in queue order.
+func (s *Store) ListPackageJobs(ctx context.Context, packageID int64) ([]Job, error) {
+	return s.queryJobs(ctx, `SELECT `+jobColumns+`
+FROM jobs
+WHERE package_id = ? AND deleted_at IS NULL
+ORDER BY `+queueOrder, packageID)
+}
+
+func (s *Store) RemovePackage(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE packages SET deleted_at = ?, updated_at = ? WHERE id = ?
+`, now, now, id)
+	return err
+}
+
+// PackageView is the aggregate view of a package for API/CLI.
+type PackageView struct {
+	ID            int64          `json:"id"`
+	Name          string         `json:"name"`
+	Status        string         `json:"status"`
+	JobCount      int            `json:"job_count"`
+	StatusCounts  map[string]int `json:"status_counts"`
+	SizeBytes     int64          `json:"size_bytes"`
+	BytesDone     int64          `json:"bytes_done"`
+	DownloadSpeed int64          `json:"download_speed"`
+	EtaSeconds    int64          `json:"eta_seconds"`
+	CreatedAt     string         `json:"created_at"`
+	UpdatedAt     string         `json:"updated_at"`
+	Jobs          []JobView      `json:"jobs,omitempty"`
+}
+
+func toPackageView(p Package, jobs []Job) PackageView {
+	v := PackageView{
+		ID:           p.ID,
+		Name:         p.Name,
+		JobCount:     len(jobs),
+		StatusCounts: map[string]int{},
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+	var remaining int64
+	for _, j := range jobs {
+		v.StatusCounts[j.Status]++
+		done := j.BytesDone
+		if j.SizeBytes.Valid {
+			v.SizeBytes += j.SizeBytes.Int64
+			if j.Status == StatusCompleted && done == 0 {
+				done = j.SizeBytes.Int64
+			}
+			if done < j.SizeBytes.Int64 {
+				remaining += j.SizeBytes.Int64 - done
+			}
+		}
+		v.BytesDone += done
+		if j.Status == StatusDownloading && j.DownloadSpeed.Valid {
+			v.DownloadSpeed += j.DownloadSpeed.Int64
+		}
+	}
+	if v.DownloadSpeed > 0 && remaining > 0 {
+		v.EtaSeconds = remaining / v.DownloadSpeed
+	}
+	v.Status = packageStatus(v.StatusCounts, len(jobs))
+	return v
+}
+
+// packageStatus derives one state for a package from its job states. Active
+// states win over finished ones, so a package is only completed once every job is.
+func packageStatus(counts map[string]int, total int) string {
+	switch {
+	case total == 0:
+		return PackageStatusEmpty
+	case counts[StatusResolving] > 0 || counts[StatusDownloading] > 0:
+		return StatusDownloading
+	case counts[StatusVerifying] > 0:
+		return StatusVerifying
+	case counts[StatusDecrypting] > 0:
+		return StatusDecrypting
+	case counts[StatusQueued] > 0:
+		return StatusQueued
+	case counts[StatusWaitingSpace] > 0:
+		return StatusWaitingSpace
+	case counts[StatusPaused] > 0:
+		return StatusPaused
+	case counts[StatusFailed] > 0 || counts[StatusDecryptFail] > 0:
+		return StatusFailed
+	case counts[StatusCompleted] == total:
+		return StatusCompleted
+	default:
+		return StatusQueued
+	}
+}
+
+func (s *Service) CreatePackage(ctx context.Context, name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("missing package name")
+	}
+	return s.store.CreatePackage(ctx, name)
+}
+
+func (s *Service) ListPackages(ctx context.Context) ([]PackageView, error) {
+	pkgs, err := s.store.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PackageView, 0, len(pkgs))
+	for _, p := range pkgs {
+		jobs, err := s.store.ListPackageJobs(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, toPackageView(p, jobs))
+	}
+	return out, nil
+}
+
+// GetPackage returns the aggregate view of a package including its jobs.
+func (s *Service) GetPackage(ctx context.Context, id int64) (*PackageView, error) {
+	p, jobs, err := s.packageWithJobs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := toPackageView(*p, jobs)
+	v.Jobs = make([]JobView, 0, len(jobs))
+	for _, j := range jobs {
+		v.Jobs = append(v.Jobs, toView(j))
+	}
+	return &v, nil
+}
+
+func (s *Service) PausePackage(ctx context.Context, id int64) error {
+	return s.applyToPackage(ctx, id, func(j Job) bool {
+		switch j.Status {
+		case StatusQueued, StatusResolving, StatusDownloading:
+			return true
+		}
+		return false
+	}, s.Pause)
+}
+
+func (s *Service) ResumePackage(ctx context.Context, id int64) error {
+	return s.applyToPackage(ctx, id, func(j Job) bool {
+		return j.Status == StatusPaused
+	}, s.Resume)
+}
+
+func (s *Service) RetryPackage(ctx context.Context, id int64) error {
+	return s.applyToPackage(ctx, id, func(j Job) bool {
+		return j.Status == StatusFailed || j.Status == StatusDecryptFail
+	}, s.Retry)
+}
+
+// RemovePackage soft-deletes every job of the package and then the package itself.
+func (s *Service) RemovePackage(ctx context.Context, id int64) error {
+	err := s.applyToPackage(ctx, id, func(Job) bool { return true }, s.Remove)
+	if err != nil {
+		return err
+	}
+	return s.store.RemovePackage(ctx, id)
+}
+
+func (s *Service) packageWithJobs(ctx context.Context, id int64) (*Package, []Job, error) {
+	p, err := s.store.GetPackage(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.DeletedAt.Valid {
+		return nil, nil, sql.ErrNoRows
+	}
+	jobs, err := s.store.ListPackageJobs(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, jobs, nil
+}
+
+// applyToPackage runs action for each package job matched by filter. It keeps
+// going after a failed job so one stuck transfer does not block the rest.
+func (s *Service) applyToPackage(ctx context.Context, id int64, filter func(Job) bool, action func(context.Context, int64) error) error {
+	_, jobs, err := s.packageWithJobs(ctx, id)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, j := range jobs {
+		if !filter(j) {
+			continue
+		}
+		if err := action(ctx, j.ID); err != nil {
+			errs = append(errs, fmt.Errorf("job %d: %w", j.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestServicePackageAggregatesJobs(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, nil, []string{"/data"})
+
+	pkgID, err := svc.CreatePackage(ctx, "release")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	doneID, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a.part1.rar", OutDir: "/data", PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	activeID, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a.part2.rar", OutDir: "/data", PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if _, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/other", OutDir: "/data"}); err != nil {
+		t.Fatalf("create loose job: %v", err)
+	}
+	if err := store.UpdateResolving(ctx, doneID, "https://resolved/a", "a.part1.rar", 100); err != nil {
+		t.Fatalf("update resolving: %v", err)
+	}
+	if err := store.MarkCompleted(ctx, doneID); err != nil {
+		t.Fatalf("mark completed: %v", err)
+	}
+	if err := store.UpdateResolving(ctx, activeID, "https://resolved/b", "a.part2.rar", 100); err != nil {
+		t.Fatalf("update resolving: %v", err)
+	}
+	if err := store.UpdateProgress(ctx, activeID, 40, StatusDownloading, 10, 6); err != nil {
+		t.Fatalf("update progress: %v", err)
+	}
+
+	pkg, err := svc.GetPackage(ctx, pkgID)
+	if err != nil {
+		t.Fatalf("get package: %v", err)
+	}
+	if pkg.JobCount != 2 || len(pkg.Jobs) != 2 {
+		t.Fatalf("expected 2 package jobs, got count=%d jobs=%d", pkg.JobCount, len(pkg.Jobs))
+	}
+	if pkg.Status != StatusDownloading {
+		t.Fatalf("expected downloading, got %s", pkg.Status)
+	}
+	if pkg.SizeBytes != 200 || pkg.BytesDone != 140 {
+		t.Fatalf("expected 140/200 bytes, got %d/%d", pkg.BytesDone, pkg.SizeBytes)
+	}
+	if pkg.DownloadSpeed != 10 || pkg.EtaSeconds != 6 {
+		t.Fatalf("expected speed 10 eta 6, got speed %d eta %d", pkg.DownloadSpeed, pkg.EtaSeconds)
+	}
+}
+
+func TestServiceCreateJobRejectsUnknownPackage(t *testing.T) {
+	store := newTestStore(t)
+	svc := NewService(store, nil, []string{"/data"})
+	_, err := svc.CreateJob(context.Background(), JobRequest{URL: "https://example.com/a", OutDir: "/data", PackageID: 99})
+	if err != ErrPackageNotFound {
+		t.Fatalf("expected ErrPackageNotFound, got %v", err)
+	}
+}
+
+func TestServiceRemovePackageRemovesJobs(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, nil, []string{"/data"})
+	pkgID, err := svc.CreatePackage(ctx, "release")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	jobID, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a", OutDir: "/data", PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := svc.RemovePackage(ctx, pkgID); err != nil {
+		t.Fatalf("remove package: %v", err)
+	}
+	job, err := store.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDeleted {
+		t.Fatalf("expected job deleted, got %s", job.Status)
+	}
+	pkgs, err := svc.ListPackages(ctx)
+	if err != nil {
+		t.Fatalf("list packages: %v", err)
+	}
+	if len(pkgs) != 0 {
+		t.Fatalf("expected removed package to be hidden, got %+v", pkgs)
+	}
+}
@@ -0,0 +1,236 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrPar2Insufficient reports a par2 set without enough recovery blocks to
+// repair the damaged files.
+var ErrPar2Insufficient = errors.New("par2_insufficient_blocks")
+
+// par2cmdline exit codes.
+const (
+	par2ExitOK                   = 0
+	par2ExitRepairPossible       = 1
+	par2ExitRepairNotPossible    = 2
+	par2ExitInsufficientCritical = 4 // the par2 files themselves are too damaged
+	par2ExitRepairFailed         = 5 // repaired files still do not verify
+)
+
+// Par2Repairer verifies and repairs files against a par2 recovery set.
+type Par2Repairer interface {
+	// Verify reports whether all files of the set are intact.
+	Verify(ctx context.Context, par2Path string) (bool, error)
+	// Repair rebuilds damaged or missing files from the recovery blocks.
+	Repair(ctx context.Context, par2Path string) error
+}
+
+type commandPar2Repairer struct {
+	command string
+}
+
+func NewPar2Repairer() Par2Repairer {
+	return &commandPar2Repairer{
+		command: "par2",
+	}
+}
+
+func (p *commandPar2Repairer) Verify(ctx context.Context, par2Path string) (bool, error) {
+	out, code, err := p.run(ctx, "verify", par2Path)
+	switch code {
+	case par2ExitOK:
+		return true, nil
+	case par2ExitRepairPossible:
+		return false, nil
+	case par2ExitRepairNotPossible, par2ExitInsufficientCritical, par2ExitRepairFailed:
+		return false, fmt.Errorf("%w: %s", ErrPar2Insufficient, out)
+	}
+	return false, par2CommandError("verify", out, err)
+}
+
+func (p *commandPar2Repairer) Repair(ctx context.Context, par2Path string) error {
+	out, code, err := p.run(ctx, "repair", par2Path)
+	switch code {
+	case par2ExitOK:
+		return nil
+	case par2ExitRepairNotPossible, par2ExitInsufficientCritical, par2ExitRepairFailed:
+		return fmt.Errorf("%w: %s", ErrPar2Insufficient, out)
+	}
+	return par2CommandError("repair", out, err)
+}
+
+// run executes a par2 operation next to the par2 file and returns its output
+// and exit code; the code is -1 when the command did not run.
+func (p *commandPar2Repairer) run(ctx context.Context, op, par2Path string) (string, int, error) {
+	command := strings.TrimSpace(p.command)
+	if command == "" {
+		command = "par2"
+	}
+	cmd := exec.CommandContext(ctx, command, op, "-q", par2Path)
+	cmd.Dir = filepath.Dir(par2Path)
+	out, err := cmd.CombinedOutput()
+	code := 0
+	if err != nil {
+		code = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+	}
+	return par2Summary(string(out)), code, err
+}
+
+func par2CommandError(op, out string, err error) error {
+	if out == "" && err != nil {
+		out = err.Error()
+	}
+	return fmt.Errorf("par2 %s failed: %s", op, out)
+}
+
+// par2Summary keeps the last lines of par2 output, which hold the verdict;
+// the full output lists every block of every file.
+func par2Summary(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) > 3 {
+		lines = lines[len(lines)-3:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// par2SetName returns the set a par2 file belongs to, lowercased:
+// release.par2 and release.vol03+04.par2 both belong to "release". index
+// reports the index file of the set, the one without recovery blocks.
+func par2SetName(name string) (set string, index bool, ok bool) {
+	lower := strings.ToLower(filepath.Base(strings.TrimSpace(name)))
+	if !strings.HasSuffix(lower, ".par2") {
+		return "", false, false
+	}
+	set = strings.TrimSuffix(lower, ".par2")
+	if idx := strings.LastIndex(set, ".vol"); idx >= 0 {
+		first, last, found := strings.Cut(set[idx+len(".vol"):], "+")
+		if found && isAllDigits(first) && isAllDigits(last) {
+			return set[:idx], false, true
+		}
+	}
+	return set, true, set != ""
+}
+
+// archiveSetNames returns the par2 set names that may cover an archive: the
+// multipart set name (release for release.part01.rar or release.r00), the
+// name without extension and the full file name.
+func archiveSetNames(archivePath string) []string {
+	base := strings.ToLower(filepath.Base(strings.TrimSpace(archivePath)))
+	if base == "" || base == "." {
+		return nil
+	}
+	var names []string
+	if key, _ := multipartArchiveGroupKey(archivePath); key != "" {
+		names = append(names, key[strings.LastIndex(key, "|")+1:])
+	}
+	stem := base
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(stem, ext) {
+			stem = strings.TrimSuffix(stem, ext)
+			break
+		}
+	}
+	if stem == base {
+		stem = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return append(names, stem, base)
+}
+
+// par2ForArchive looks for a par2 set covering archivePath among the other
+// jobs in outDir. It returns the par2 file to run, preferring the index file,
+// and how many par2 jobs of the set are still downloading.
+func (r *Runner) par2ForArchive(ctx context.Context, jobID int64, outDir, archivePath string) (string, int) {
+	if r.Par2Repairer == nil || r.Store == nil {
+		return "", 0
+	}
+	names := archiveSetNames(archivePath)
+	if len(names) == 0 {
+		return "", 0
+	}
+	jobs, err := r.Store.ListJobs(ctx, "", false)
+	if err != nil {
+		log.Printf("runner par2 scan error for job %d: %v", jobID, err)
+		return "", 0
+	}
+	par2Path, pending := "", 0
+	for _, other := range jobs {
+		if other.ID == jobID || other.OutDir != outDir {
+			continue
+		}
+		set, index, ok := par2SetName(outputNameForJob(other))
+		if !ok || !containsString(names, set) {
+			continue
+		}
+		if isMultipartSiblingPending(other.Status) {
+			pending++
+			continue
+		}
+		p := archivePathForJob(other)
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if par2Path == "" || index {
+			par2Path = p
+		}
+	}
+	return par2Path, pending
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// repairPar2 checks the archive against the par2 set downloaded with it and
+// repairs damaged volumes before extraction. Only a set without enough
+// recovery blocks fails the job; other par2 errors leave the decision to the
+// archive tool. It returns false when the job was failed.
+func (r *Runner) repairPar2(ctx context.Context, task decryptTask) bool {
+	par2Path, _ := r.par2ForArchive(ctx, task.jobID, task.outDir, task.archivePath)
+	if par2Path == "" {
+		return true
+	}
+	name := filepath.Base(par2Path)
+	_ = r.Store.AddEvent(ctx, task.jobID, "info", "par2 verify started: "+name)
+	start := time.Now()
+	intact, err := r.Par2Repairer.Verify(ctx, par2Path)
+	if err == nil && !intact {
+		_ = r.Store.AddEvent(ctx, task.jobID, "info", "repairing: damaged files found, repairing with "+name)
+		if err = r.Par2Repairer.Repair(ctx, par2Path); err == nil {
+			_ = r.Store.AddEvent(ctx, task.jobID, "info", "par2 repaired: "+name)
+		}
+	} else if err == nil {
+		_ = r.Store.AddEvent(ctx, task.jobID, "info", "par2 verified: "+name)
+	}
+	decryptDuration.Observe(time.Since(start).Seconds(), "par2")
+	if errors.Is(err, ErrPar2Insufficient) {
+		decryptFailures.Inc("par2")
+		_ = r.Store.AddEvent(ctx, task.jobID, "error", "par2 repair failed: "+err.Error())
+		if markErr := r.Store.MarkPostprocessFailed(ctx, task.jobID, "par2 repair failed", "par2_repair_failed"); markErr != nil {
+			log.Printf("runner mark par2 repair failed error for job %d: %v", task.jobID, markErr)
+		}
+		_ = r.Store.ClearArchivePassword(ctx, task.jobID)
+		return false
+	}
+	if err != nil {
+		decryptFailures.Inc("par2")
+		_ = r.Store.AddEvent(ctx, task.jobID, "error", "par2 skipped: "+err.Error())
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakePar2Repairer struct {
+	mu        sync.Mutex
+	intact    bool
+	repairErr error
+	calls     []string
+}
+
+func (p *fakePar2Repairer) Verify(ctx context.Context, par2Path string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, "verify "+filepath.Base(par2Path))
+	return p.intact, nil
+}
+
+func (p *fakePar2Repairer) Repair(ctx context.Context, par2Path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, "repair "+filepath.Base(par2Path))
+	return p.repairErr
+}
+
+func TestPar2SetName(t *testing.T) {
+	cases := []struct {
+		name  string
+		set   string
+		index bool
+		ok    bool
+	}{
+		{"Release.par2", "release", true, true},
+		{"release.vol003+04.PAR2", "release", false, true},
+		{"release.part01.rar.vol0+1.par2", "release.part01.rar", false, true},
+		{"release.volume.par2", "release.volume", true, true},
+		{"release.rar", "", false, false},
+	}
+	for _, tc := range cases {
+		set, index, ok := par2SetName(tc.name)
+		if set != tc.set || index != tc.index || ok != tc.ok {
+			t.Fatalf("par2SetName(%q) = %q/%v/%v, want %q/%v/%v", tc.name, set, index, ok, tc.set, tc.index, tc.ok)
+		}
+	}
+	names := strings.Join(archiveSetNames("/data/Release.part02.rar"), " ")
+	if names != "release release.part02 release.part02.rar" {
+		t.Fatalf("archiveSetNames = %q", names)
+	}
+	if names := strings.Join(archiveSetNames("/data/backup.tar.gz"), " "); names != "backup backup.tar.gz" {
+		t.Fatalf("archiveSetNames tar = %q", names)
+	}
+}
+
+func TestCommandPar2RepairerExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "par2")
+	par2Path := filepath.Join(dir, "release.par2")
+	// Exit code per operation: verify 1 (repairable), repair 2 (not enough blocks).
+	body := "#!/bin/sh\necho \"$1 $2\"\nif [ \"$1\" = verify ]; then exit 1; fi\necho 'You need 3 more recovery blocks'\nexit 2\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := &commandPar2Repairer{command: script}
+	ctx := context.Background()
+	intact, err := p.Verify(ctx, par2Path)
+	if intact || err != nil {
+		t.Fatalf("verify = %v, %v; want damaged without error", intact, err)
+	}
+	err = p.Repair(ctx, par2Path)
+	if !errors.Is(err, ErrPar2Insufficient) || !strings.Contains(err.Error(), "3 more recovery blocks") {
+		t.Fatalf("repair err = %v", err)
+	}
+	for _, code := range []string{"4", "5"} {
+		if err := os.WriteFile(script, []byte("#!/bin/sh\nexit "+code+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Verify(ctx, par2Path); !errors.Is(err, ErrPar2Insufficient) {
+			t.Fatalf("verify exit %s err = %v", code, err)
+		}
+		if err := p.Repair(ctx, par2Path); !errors.Is(err, ErrPar2Insufficient) {
+			t.Fatalf("repair exit %s err = %v", code, err)
+		}
+	}
+	missing := &commandPar2Repairer{command: filepath.Join(t.TempDir(), "missing")}
+	if _, err := missing.Verify(ctx, par2Path); err == nil || errors.Is(err, ErrPar2Insufficient) {
+		t.Fatalf("missing command err = %v", err)
+	}
+}
+
+func TestRunnerRepairsWithPar2BeforeExtract(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		repairErr error
+		status    string
+		code      string
+		extracted bool
+	}{
+		{"repaired", nil, StatusCompleted, "", true},
+		{"insufficient", fmt.Errorf("%w: need 2 more blocks", ErrPar2Insufficient), StatusDecryptFail, "par2_repair_failed", false},
+		{"tool error", errors.New("par2 repair failed: exec: not found"), StatusCompleted, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newRunnerStore(t)
+			ctx := context.Background()
+			outDir := t.TempDir()
+			ids := map[string]int64{}
+			for _, name := range []string{"release.part1.rar", "release.part2.rar", "release.vol0+1.par2", "release.par2", "other.par2"} {
+				id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/" + name, OutDir: outDir, Name: name, MaxAttempts: 1})
+				if err != nil {
+					t.Fatalf("create job: %v", err)
+				}
+				ids[name] = id
+				if err := os.WriteFile(filepath.Join(outDir, name), []byte("x"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.MarkCompleted(ctx, id); err != nil {
+					t.Fatalf("mark completed: %v", err)
+				}
+			}
+			par2 := &fakePar2Repairer{repairErr: tc.repairErr}
+			dec := &fakeArchiveDecryptor{attempted: true}
+			runner := &Runner{Store: store, ArchiveDecryptor: dec, Par2Repairer: par2, GetAutoDecrypt: func() bool { return true }}
+			job, err := store.GetJob(ctx, ids["release.part2.rar"])
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			task, ok, waitMsg, failMsg := runner.buildDecryptTask(ctx, *job, nil)
+			if !ok || waitMsg != "" || failMsg != "" {
+				t.Fatalf("buildDecryptTask = %v/%q/%q", ok, waitMsg, failMsg)
+			}
+			if err := store.MarkDecrypting(ctx, job.ID, 1); err != nil {
+				t.Fatalf("mark decrypting: %v", err)
+			}
+			runner.runDecrypt(ctx, task)
+
+			if got := strings.Join(par2.calls, ","); got != "verify release.par2,repair release.par2" {
+				t.Fatalf("par2 calls = %s", got)
+			}
+			if called, _, _, _ := dec.snapshot(); called != tc.extracted {
+				t.Fatalf("extracted = %v, want %v", called, tc.extracted)
+			}
+			job, err = store.GetJob(ctx, job.ID)
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			if job.Status != tc.status || job.ErrorCode.String != tc.code {
+				t.Fatalf("status/code = %s/%s, want %s/%s", job.Status, job.ErrorCode.String, tc.status, tc.code)
+			}
+			events, _ := store.ListEvents(ctx, job.ID, 20)
+			if !eventsContain(events, "repairing: damaged files found") {
+				t.Fatalf("events = %v", events)
+			}
+		})
+	}
+}
+
+func TestRunnerArchiveWaitsForPar2Set(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/movie.7z", OutDir: outDir, Name: "movie.7z", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if _, err := store.CreateJob(ctx, &Job{URL: "https://example.com/movie.vol00+10.par2", OutDir: outDir, Name: "movie.vol00+10.par2", MaxAttempts: 1}); err != nil {
+		t.Fatalf("create par2 job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	runner := &Runner{Store: store, ArchiveDecryptor: &fakeArchiveDecryptor{}, GetAutoDecrypt: func() bool { return true }}
+	if _, _, waitMsg, _ := runner.buildDecryptTask(ctx, *job, nil); waitMsg != "" {
+		t.Fatalf("waitMsg without a par2 repairer = %q", waitMsg)
+	}
+	runner.Par2Repairer = &fakePar2Repairer{}
+	if _, _, waitMsg, _ := runner.buildDecryptTask(ctx, *job, nil); !strings.Contains(waitMsg, "par2 set still downloading (1 job(s))") {
+		t.Fatalf("waitMsg = %q", waitMsg)
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+// QueueState says whether the runner may start downloads right now.
+type QueueState struct {
+	Open bool `json:"open"`
+	// PauseActive asks the runner to pause running transfers while closed.
+	PauseActive bool `json:"pause_active"`
+	// Reason explains why the queue is closed, e.g. "queue paused".
+	Reason string `json:"reason,omitempty"`
+}
+
+// applyQueueState holds running transfers when the queue closes and releases
+// them when it opens again. It reports whether new jobs may be claimed.
+func (r *Runner) applyQueueState(ctx context.Context) bool {
+	if r.GetQueueState == nil {
+		return true
+	}
+	st := r.GetQueueState(time.Now())
+	if st.Open {
+		if r.queueClosed || !r.queueSeen {
+			if r.queueClosed {
+				log.Printf("runner: queue open")
+			}
+			r.releaseHeld(ctx)
+		}
+		r.queueClosed = false
+		r.queueHeld = false
+		r.queueSeen = true
+		return true
+	}
+	if !r.queueClosed {
+		log.Printf("runner: queue closed (%s)", st.Reason)
+	}
+	if st.PauseActive && !r.queueHeld {
+		r.holdActive(ctx, st.Reason)
+		r.queueHeld = true
+	}
+	r.queueClosed = true
+	r.queueSeen = true
+	return false
+}
+
+// holdActive pauses every downloading job. Webshare links expire, so those
+// jobs go back to the queue instead and are resolved again later.
+func (r *Runner) holdActive(ctx context.Context, reason string) {
+	jobs, err := r.Store.ListJobs(ctx, StatusDownloading, false)
+	if err != nil {
+		log.Printf("runner hold error: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		dl := r.engine(job.Engine)
+		if IsWebshareJob(job.Site, job.URL) {
+			if dl != nil && job.EngineGID.Valid {
+				if err := dl.Remove(ctx, job.EngineGID.String); err != nil && !errors.Is(err, downloadclient.ErrGIDNotFound) {
+					log.Printf("job %d hold remove error: %v", job.ID, err)
+					continue
+				}
+			}
+			_ = r.Store.Requeue(ctx, job.ID)
+			_ = r.Store.AddEvent(ctx, job.ID, "info", "requeued: "+reason)
+			continue
+		}
+		if dl == nil || !job.EngineGID.Valid {
+			continue
+		}
+		if err := dl.Pause(ctx, job.EngineGID.String); err != nil {
+			log.Printf("job %d hold pause error: %v", job.ID, err)
+			continue
+		}
+		_ = r.Store.MarkHeld(ctx, job.ID)
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "paused: "+reason)
+	}
+}
+
+// releaseHeld resumes jobs paused by holdActive.
+func (r *Runner) releaseHeld(ctx context.Context) {
+	jobs, err := r.Store.ListHeld(ctx)
+	if err != nil {
+		log.Printf("runner release error: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		dl := r.engine(job.Engine)
+		if dl == nil || !job.EngineGID.Valid {
+			_ = r.Store.Requeue(ctx, job.ID)
+			_ = r.Store.ClearHeld(ctx, job.ID)
+			_ = r.Store.AddEvent(ctx, job.ID, "info", "queue open; resume requeued")
+			continue
+		}
+		if err := dl.Unpause(ctx, job.EngineGID.String); err != nil {
+			if !errors.Is(err, downloadclient.ErrGIDNotFound) {
+				log.Printf("job %d release error: %v", job.ID, err)
+				continue
+			}
+			_ = r.Store.Requeue(ctx, job.ID)
+			_ = r.Store.ClearHeld(ctx, job.ID)
+			_ = r.Store.AddEvent(ctx, job.ID, "info", "queue open; resume requeued")
+			continue
+		}
+		_ = r.Store.MarkDownloadingStatus(ctx, job.ID)
+		_ = r.Store.ClearHeld(ctx, job.ID)
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "queue open; resumed")
+	}
+}
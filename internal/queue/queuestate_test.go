@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+type holdingDownloader struct {
+	fakeDownloader
+	paused   []string
+	unpaused []string
+}
+
+func (d *holdingDownloader) Pause(ctx context.Context, gid string) error {
+	d.paused = append(d.paused, gid)
+	return nil
+}
+
+func (d *holdingDownloader) Unpause(ctx context.Context, gid string) error {
+	d.unpaused = append(d.unpaused, gid)
+	return nil
+}
+
+func TestRunnerHoldsAndReleasesJobsWithQueueState(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	running, err := store.CreateJob(ctx, &Job{URL: "https://example.com/a", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, running, EngineAria2, "gid-1"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	userPaused, err := store.CreateJob(ctx, &Job{URL: "https://example.com/b", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, userPaused, EngineAria2, "gid-2"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	if err := store.MarkPaused(ctx, userPaused); err != nil {
+		t.Fatalf("mark paused: %v", err)
+	}
+	queued, err := store.CreateJob(ctx, &Job{URL: "https://example.com/c", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	dl := &holdingDownloader{fakeDownloader: fakeDownloader{status: &downloader.Status{GID: "gid-1", Status: "active", TotalLength: "10", CompletedLen: "1"}}}
+	state := QueueState{Open: false, PauseActive: true, Reason: "queue paused"}
+	runner := &Runner{
+		Store:         store,
+		Resolvers:     resolver.NewRegistry(&fakeResolver{}),
+		Downloader:    dl,
+		Concurrency:   3,
+		GetQueueState: func(time.Time) QueueState { return state },
+	}
+	runner.tick(ctx)
+
+	if len(dl.paused) != 1 || dl.paused[0] != "gid-1" {
+		t.Fatalf("expected running transfer to be paused, got %v", dl.paused)
+	}
+	job, err := store.GetJob(ctx, running)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusPaused {
+		t.Fatalf("expected running job paused, got %s", job.Status)
+	}
+	job, err = store.GetJob(ctx, queued)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected queued job to wait while queue is closed, got %s", job.Status)
+	}
+
+	state = QueueState{Open: true}
+	runner.tick(ctx)
+
+	if len(dl.unpaused) != 1 || dl.unpaused[0] != "gid-1" {
+		t.Fatalf("expected only the held transfer to resume, got %v", dl.unpaused)
+	}
+	job, err = store.GetJob(ctx, userPaused)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusPaused {
+		t.Fatalf("expected user-paused job to stay paused, got %s", job.Status)
+	}
+	job, err = store.GetJob(ctx, queued)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading {
+		t.Fatalf("expected queued job to start once the queue opens, got %s", job.Status)
+	}
+}
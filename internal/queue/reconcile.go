@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+// reconcilePageSize is the number of waiting/stopped transfers fetched per call.
+const reconcilePageSize = 1000
+
+// Reconcile matches jobs against the transfers the engines currently know
+// about, typically after dlqd or aria2 restarted. Running transfers are
+// re-attached, lost ones re-added with resume, finished ones adopted, and
+// transfers no job owns are logged. A restart never counts as a failed attempt.
+//
+// Each engine is reconciled on its own: one that cannot be reached yet, such
+// as aria2 still starting, is retried by the next tick without holding up the
+// others.
+func (r *Runner) Reconcile(ctx context.Context) error {
+	jobs, err := r.Store.ListJobs(ctx, "", true)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.DeletedAt.Valid || job.Status != StatusResolving {
+			continue
+		}
+		// Interrupted before the engine got the transfer.
+		if err := r.Store.Requeue(ctx, job.ID); err != nil {
+			log.Printf("reconcile job %d requeue error: %v", job.ID, err)
+			continue
+		}
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "requeued interrupted resolve after restart")
+	}
+	var errs []error
+	for _, name := range r.engineNames() {
+		if r.reconciled[name] {
+			continue
+		}
+		if lister, ok := r.engine(name).(TransferLister); ok {
+			transfers, order, err := listTransfers(ctx, lister)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			r.reconcileEngine(ctx, name, jobs, transfers, order)
+		}
+		if r.reconciled == nil {
+			r.reconciled = map[string]bool{}
+		}
+		r.reconciled[name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// reconcilePending reports whether an engine still has to be reconciled.
+func (r *Runner) reconcilePending() bool {
+	for _, name := range r.engineNames() {
+		if !r.reconciled[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// engineNames returns the names of the configured engines in a stable order.
+func (r *Runner) engineNames() []string {
+	var names []string
+	if r.Downloader != nil {
+		names = append(names, EngineAria2)
+	}
+	for name := range r.Engines {
+		if name != EngineAria2 || r.Downloader == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Runner) reconcileEngine(ctx context.Context, engine string, jobs []Job, transfers map[string]downloadclient.Status, order []string) {
+	owned := make(map[string]bool, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		if jobEngine(job) != engine {
+			continue
+		}
+		if job.EngineGID.Valid {
+			owned[job.EngineGID.String] = true
+		}
+		if !job.DeletedAt.Valid && job.Status == StatusDownloading {
+			r.reconcileDownloading(ctx, job, transfers)
+		}
+	}
+	for _, gid := range order {
+		if owned[gid] {
+			continue
+		}
+		st := transfers[gid]
+		log.Printf("reconcile: orphan %s download gid=%s status=%s progress=%s/%s path=%q", engine, gid, st.Status, st.CompletedLen, st.TotalLength, firstStatusPath(&st))
+	}
+}
+
+// jobEngine returns the engine a job's transfer runs on; empty means aria2.
+func jobEngine(job Job) string {
+	if job.Engine == "" {
+		return EngineAria2
+	}
+	return job.Engine
+}
+
+func (r *Runner) reconcileDownloading(ctx context.Context, job Job, transfers map[string]downloadclient.Status) {
+	engine := jobEngine(job)
+	var st downloadclient.Status
+	var ok bool
+	if job.EngineGID.Valid {
+		st, ok = transfers[job.EngineGID.String]
+	}
+	if !ok || st.Status == "removed" {
+		_ = r.Store.AddEvent(ctx, job.ID, "info", engine+" transfer lost; re-adding with resume")
+		if err := r.startJob(ctx, &job, true); err != nil {
+			log.Printf("reconcile job %d re-add error: %v", job.ID, err)
+		}
+		return
+	}
+	switch st.Status {
+	case "complete", "error":
+		if st.Status == "complete" {
+			_ = r.Store.AddEvent(ctx, job.ID, "info", "adopted finished "+engine+" transfer")
+		}
+		r.syncJob(ctx, job)
+	case "paused":
+		dl := r.engine(job.Engine)
+		if dl == nil {
+			return
+		}
+		if err := dl.Unpause(ctx, st.GID); err != nil {
+			_ = r.Store.AddEvent(ctx, job.ID, "error", "unpause after restart failed: "+err.Error())
+			return
+		}
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "re-attached to "+engine+" transfer "+st.GID)
+	default:
+		bytesDone, _, speed, eta := statusProgress(&st)
+		countDownloaded(job, bytesDone)
+		_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, StatusDownloading, speed, eta)
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "re-attached to "+engine+" transfer "+st.GID)
+	}
+}
+
+// listTransfers returns every active, waiting and stopped transfer by gid,
+// along with the gids in engine order.
+func listTransfers(ctx context.Context, lister TransferLister) (map[string]downloadclient.Status, []string, error) {
+	out := map[string]downloadclient.Status{}
+	var order []string
+	add := func(list []downloadclient.Status) {
+		for _, st := range list {
+			if st.GID == "" {
+				continue
+			}
+			if _, seen := out[st.GID]; !seen {
+				order = append(order, st.GID)
+			}
+			out[st.GID] = st
+		}
+	}
+	active, err := lister.TellActive(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	add(active)
+	for _, page := range []func(context.Context, int, int) ([]downloadclient.Status, error){lister.TellWaiting, lister.TellStopped} {
+		for offset := 0; ; offset += reconcilePageSize {
+			list, err := page(ctx, offset, reconcilePageSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			add(list)
+			if len(list) < reconcilePageSize {
+				break
+			}
+		}
+	}
+	return out, order, nil
+}
@@ -0,0 +1,252 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+type reconcileDownloader struct {
+	fakeDownloader
+	active   []downloader.Status
+	waiting  []downloader.Status
+	stopped  []downloader.Status
+	added    []map[string]string
+	unpaused []string
+	listErr  error
+	addErr   error
+}
+
+func (d *reconcileDownloader) AddURI(ctx context.Context, uri string, options map[string]string) (string, error) {
+	if d.addErr != nil {
+		return "", d.addErr
+	}
+	d.added = append(d.added, options)
+	return "gid-new", nil
+}
+func (d *reconcileDownloader) TellStatus(ctx context.Context, gid string) (*downloader.Status, error) {
+	for _, list := range [][]downloader.Status{d.active, d.waiting, d.stopped} {
+		for _, st := range list {
+			if st.GID == gid {
+				st := st
+				return &st, nil
+			}
+		}
+	}
+	return nil, downloader.ErrGIDNotFound
+}
+func (d *reconcileDownloader) Unpause(ctx context.Context, gid string) error {
+	d.unpaused = append(d.unpaused, gid)
+	return nil
+}
+func (d *reconcileDownloader) TellActive(ctx context.Context) ([]downloader.Status, error) {
+	return d.active, d.listErr
+}
+func (d *reconcileDownloader) TellWaiting(ctx context.Context, offset, num int) ([]downloader.Status, error) {
+	return page(d.waiting, offset, num), nil
+}
+func (d *reconcileDownloader) TellStopped(ctx context.Context, offset, num int) ([]downloader.Status, error) {
+	return page(d.stopped, offset, num), nil
+}
+
+type failingResolver struct{ fakeResolver }
+
+func (r *failingResolver) Resolve(ctx context.Context, rawURL string) (*resolver.ResolvedTarget, error) {
+	return nil, errors.New("host unreachable")
+}
+
+func page(list []downloader.Status, offset, num int) []downloader.Status {
+	if offset >= len(list) {
+		return nil
+	}
+	end := offset + num
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[offset:end]
+}
+
+func createDownloadingJob(t *testing.T, store *Store, gid string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/" + gid, OutDir: "/data", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, id, "aria2", gid); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	return id
+}
+
+func TestRunnerReconcileAfterRestart(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	running := createDownloadingJob(t, store, "gid-running")
+	paused := createDownloadingJob(t, store, "gid-paused")
+	lost := createDownloadingJob(t, store, "gid-lost")
+	finished := createDownloadingJob(t, store, "gid-finished")
+	resolving, err := store.CreateJob(ctx, &Job{URL: "https://example.com/resolving", OutDir: "/data", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if _, err := store.ClaimNextQueued(ctx); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	dl := &reconcileDownloader{
+		active:  []downloader.Status{{GID: "gid-running", Status: "active", TotalLength: "10", CompletedLen: "4"}},
+		waiting: []downloader.Status{{GID: "gid-paused", Status: "paused", TotalLength: "10", CompletedLen: "2"}},
+		stopped: []downloader.Status{
+			{GID: "gid-finished", Status: "complete", TotalLength: "10", CompletedLen: "10"},
+			{GID: "gid-orphan", Status: "complete", TotalLength: "5", CompletedLen: "5"},
+		},
+	}
+	runner := &Runner{
+		Store:      store,
+		Resolvers:  resolver.NewRegistry(&fakeResolver{}),
+		Downloader: dl,
+	}
+	if err := runner.Reconcile(ctx); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	expect := func(id int64, status, gid string) *Job {
+		t.Helper()
+		job, err := store.GetJob(ctx, id)
+		if err != nil {
+			t.Fatalf("get job %d: %v", id, err)
+		}
+		if job.Status != status {
+			t.Fatalf("job %d: expected %s, got %s", id, status, job.Status)
+		}
+		if gid != "" && job.EngineGID.String != gid {
+			t.Fatalf("job %d: expected gid %s, got %s", id, gid, job.EngineGID.String)
+		}
+		if job.Attempts != 0 {
+			t.Fatalf("job %d: expected restart not to count as attempt, got %d", id, job.Attempts)
+		}
+		return job
+	}
+	if job := expect(running, StatusDownloading, "gid-running"); job.BytesDone != 4 {
+		t.Fatalf("expected running progress 4, got %d", job.BytesDone)
+	}
+	expect(paused, StatusDownloading, "gid-paused")
+	if len(dl.unpaused) != 1 || dl.unpaused[0] != "gid-paused" {
+		t.Fatalf("expected gid-paused to be unpaused, got %v", dl.unpaused)
+	}
+	expect(lost, StatusDownloading, "gid-new")
+	if len(dl.added) != 1 || dl.added[0]["continue"] != "true" {
+		t.Fatalf("expected lost transfer re-added with resume, got %v", dl.added)
+	}
+	expect(finished, StatusCompleted, "gid-finished")
+	job := expect(resolving, StatusQueued, "")
+	if job.EngineGID != (sql.NullString{}) {
+		t.Fatalf("expected requeued job to have no gid")
+	}
+}
+
+func TestRunnerReconcileRequeuesFailedReResolveWithoutAttempt(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	lost := createDownloadingJob(t, store, "gid-lost")
+	dl := &reconcileDownloader{}
+	runner := &Runner{
+		Store:      store,
+		Resolvers:  resolver.NewRegistry(&failingResolver{}),
+		Downloader: dl,
+	}
+	if err := runner.Reconcile(ctx); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	job, err := store.GetJob(ctx, lost)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusQueued || job.Attempts != 0 || job.EngineGID.Valid {
+		t.Fatalf("status/attempts/gid = %s/%d/%v, want queued/0/none", job.Status, job.Attempts, job.EngineGID.Valid)
+	}
+	if len(dl.added) != 0 {
+		t.Fatalf("expected no re-add, got %v", dl.added)
+	}
+	events, _ := store.ListEvents(ctx, lost, 10)
+	if !eventsContain(events, "re-adding after restart failed; requeued") {
+		t.Fatalf("events = %v", events)
+	}
+}
+
+func TestRunnerReconcileRetriesUnreachableEngine(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	onAria2 := createDownloadingJob(t, store, "gid-aria2")
+	onNative, err := store.CreateJob(ctx, &Job{URL: "https://example.com/native", OutDir: "/data", MaxAttempts: 3, RequestedEngine: sql.NullString{String: EngineNative, Valid: true}})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, onNative, EngineNative, "gid-native"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	aria2 := &reconcileDownloader{listErr: errors.New("connection refused")}
+	native := &reconcileDownloader{}
+	runner := &Runner{
+		Store:      store,
+		Resolvers:  resolver.NewRegistry(&fakeResolver{}),
+		Downloader: aria2,
+		Engines:    map[string]Downloader{EngineNative: native},
+	}
+	if err := runner.Reconcile(ctx); err == nil {
+		t.Fatalf("expected reconcile error while aria2 is down")
+	}
+	if len(native.added) != 1 || len(aria2.added) != 0 || !runner.reconcilePending() {
+		t.Fatalf("native/aria2 re-adds = %d/%d, pending = %v", len(native.added), len(aria2.added), runner.reconcilePending())
+	}
+	if job, _ := store.GetJob(ctx, onAria2); job.Status != StatusDownloading || job.EngineGID.String != "gid-aria2" {
+		t.Fatalf("aria2 job = %s/%s, want untouched", job.Status, job.EngineGID.String)
+	}
+
+	aria2.listErr = nil
+	if err := runner.Reconcile(ctx); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(native.added) != 1 || len(aria2.added) != 1 || aria2.added[0]["continue"] != "true" || runner.reconcilePending() {
+		t.Fatalf("native/aria2 re-adds = %d/%v, pending = %v", len(native.added), aria2.added, runner.reconcilePending())
+	}
+	for _, id := range []int64{onAria2, onNative} {
+		if job, _ := store.GetJob(ctx, id); job.Status != StatusDownloading || job.Attempts != 0 {
+			t.Fatalf("job %d = %s with %d attempts", id, job.Status, job.Attempts)
+		}
+	}
+}
+
+func TestRunnerSyncReAddsForgottenTransfer(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id := createDownloadingJob(t, store, "gid-forgotten")
+	dl := &reconcileDownloader{}
+	runner := &Runner{
+		Store:      store,
+		Resolvers:  resolver.NewRegistry(&fakeResolver{}),
+		Downloader: dl,
+	}
+	job, _ := store.GetJob(ctx, id)
+	runner.syncJob(ctx, *job)
+	job, _ = store.GetJob(ctx, id)
+	if job.Status != StatusDownloading || job.EngineGID.String != "gid-new" || job.Attempts != 0 {
+		t.Fatalf("status/gid/attempts = %s/%s/%d", job.Status, job.EngineGID.String, job.Attempts)
+	}
+	if len(dl.added) != 1 || dl.added[0]["continue"] != "true" {
+		t.Fatalf("expected re-add with resume, got %v", dl.added)
+	}
+
+	// A failed re-add is not an attempt either.
+	dl.addErr = errors.New("aria2 busy")
+	runner.syncJob(ctx, Job{ID: id, URL: job.URL, OutDir: job.OutDir, Engine: EngineAria2, EngineGID: sql.NullString{String: "gid-gone", Valid: true}})
+	job, _ = store.GetJob(ctx, id)
+	if job.Status != StatusQueued || job.Attempts != 0 {
+		t.Fatalf("status/attempts = %s/%d, want queued/0", job.Status, job.Attempts)
+	}
+}
@@ -0,0 +1,452 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// ruleVars are the placeholders a rule template may use. {root} is the
+// rule's root, or the first DATA_* volume, and may only start the template.
+var ruleVars = map[string]bool{
+	"root": true, "site": true, "host": true, "package": true,
+	"filename": true, "stem": true, "ext": true,
+}
+
+var ruleVarPattern = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// Rule picks the output directory and filename of jobs added without an
+// out_dir. Empty conditions match anything; all set conditions must match.
+type Rule struct {
+	ID      int64
+	Name    string
+	Site    string
+	Host    string // matches the host and its subdomains
+	Pattern string // regexp on the filename
+	Exts    []string
+	MinSize int64 // bytes; a size bound never matches an unknown size
+	MaxSize int64
+	Root    string
+	// Template renders the target path, e.g. {root}/{site}/{package}/{filename}.
+	Template  string
+	CreatedAt string
+	UpdatedAt string
+}
+
+// ruleInput is what rules are matched against once a job is resolved.
+type ruleInput struct {
+	Site     string
+	Host     string
+	Filename string
+	Size     int64
+	Package  string
+}
+
+func (rule Rule) matches(in ruleInput) bool {
+	if rule.Site != "" && rule.Site != in.Site {
+		return false
+	}
+	if rule.Host != "" && in.Host != rule.Host && !strings.HasSuffix(in.Host, "."+rule.Host) {
+		return false
+	}
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil || !re.MatchString(in.Filename) {
+			return false
+		}
+	}
+	if len(rule.Exts) > 0 {
+		lower := strings.ToLower(in.Filename)
+		found := false
+		for _, ext := range rule.Exts {
+			if strings.HasSuffix(lower, "."+ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.MinSize > 0 && (in.Size <= 0 || in.Size < rule.MinSize) {
+		return false
+	}
+	if rule.MaxSize > 0 && (in.Size <= 0 || in.Size > rule.MaxSize) {
+		return false
+	}
+	return true
+}
+
+// render expands the template for in. The directory goes through
+// cleanOutDir like a hand-given out_dir; an empty filename part falls back
+// to the resolved filename.
+func (rule Rule) render(in ruleInput, allowedRoots []string) (string, string, error) {
+	root := rule.Root
+	if root == "" {
+		if len(allowedRoots) == 0 {
+			return "", "", errors.New("no DATA_* volumes configured")
+		}
+		root = allowedRoots[0]
+	}
+	ext := filepath.Ext(in.Filename)
+	vars := map[string]string{
+		"site":     in.Site,
+		"host":     in.Host,
+		"package":  in.Package,
+		"filename": in.Filename,
+		"stem":     strings.TrimSuffix(in.Filename, ext),
+		"ext":      strings.TrimPrefix(ext, "."),
+	}
+	for k, v := range vars {
+		vars[k] = templateValue(v)
+	}
+	vars["root"] = root
+	dirTmpl, fileTmpl := splitTemplate(rule.Template)
+	outDir, err := cleanOutDir(expandTemplate(dirTmpl, vars), allowedRoots)
+	if err != nil {
+		return "", "", err
+	}
+	name := sanitizeFilename(expandTemplate(fileTmpl, vars))
+	if name == "" {
+		name = sanitizeFilename(in.Filename)
+	}
+	return outDir, name, nil
+}
+
+func splitTemplate(tmpl string) (dir, file string) {
+	i := strings.LastIndex(tmpl, "/")
+	if i < 0 {
+		return "", tmpl
+	}
+	return tmpl[:i], tmpl[i+1:]
+}
+
+func expandTemplate(tmpl string, vars map[string]string) string {
+	return ruleVarPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		return vars[m[1:len(m)-1]]
+	})
+}
+
+// templateValue keeps a value within one path segment.
+func templateValue(v string) string {
+	v = strings.TrimSpace(v)
+	v = strings.NewReplacer("/", "_", "\\", "_").Replace(v)
+	if v == "." || v == ".." {
+		return ""
+	}
+	return v
+}
+
+func validateTemplate(tmpl string) error {
+	if tmpl == "" {
+		return fmt.Errorf("%w: missing template", ErrInvalidRule)
+	}
+	for _, m := range ruleVarPattern.FindAllStringSubmatchIndex(tmpl, -1) {
+		name := tmpl[m[2]:m[3]]
+		if !ruleVars[name] {
+			return fmt.Errorf("%w: unknown template variable {%s}", ErrInvalidRule, name)
+		}
+		if name == "root" && m[0] != 0 {
+			return fmt.Errorf("%w: {root} must start the template", ErrInvalidRule)
+		}
+	}
+	if !strings.HasPrefix(tmpl, "/") && !strings.HasPrefix(tmpl, "{root}") {
+		return fmt.Errorf("%w: template must start with {root} or an absolute path", ErrInvalidRule)
+	}
+	if dir, _ := splitTemplate(tmpl); dir == "" {
+		return fmt.Errorf("%w: template must name a directory", ErrInvalidRule)
+	}
+	return nil
+}
+
+// matchRules returns the first rule matching in with its rendered output,
+// or a nil rule when none matches.
+func matchRules(rules []Rule, in ruleInput, allowedRoots []string) (*Rule, string, string, error) {
+	for i := range rules {
+		if !rules[i].matches(in) {
+			continue
+		}
+		outDir, name, err := rules[i].render(in, allowedRoots)
+		return &rules[i], outDir, name, err
+	}
+	return nil, "", "", nil
+}
+
+// applyRules sets the output of a job added without out_dir from the first
+// matching rule. It reports false when the job was failed instead. A folder
+// only takes the directory; its files keep their own names.
+func (r *Runner) applyRules(ctx context.Context, job *Job, res *resolver.ResolvedTarget) (bool, error) {
+	filename := job.Name
+	if filename == "" {
+		filename = sanitizeFilename(res.Filename)
+	}
+	in := ruleInput{Site: JobSite(job.Site, job.URL), Host: JobHost(job.URL), Filename: filename, Size: res.Size}
+	if job.PackageID.Valid {
+		if pkg, err := r.Store.GetPackage(ctx, job.PackageID.Int64); err == nil {
+			in.Package = pkg.Name
+		}
+	}
+	rules, err := r.Store.ListRules(ctx)
+	if err != nil {
+		return false, err
+	}
+	rule, outDir, name, err := matchRules(rules, in, r.AllowedRoots)
+	if rule == nil || err != nil {
+		code, msg := "no_rule_matched", "no output rule matched "+filename
+		if err != nil {
+			code, msg = "rule_output_invalid", fmt.Sprintf("rule %d: %v", rule.ID, err)
+		}
+		_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
+		return false, r.Store.MarkFailed(ctx, job.ID, code, msg, time.Time{})
+	}
+	if res.Kind == resolver.KindFolder {
+		name = job.Name
+	}
+	if err := r.Store.SetOutput(ctx, job.ID, outDir, name); err != nil {
+		return false, err
+	}
+	job.OutDir, job.Name = outDir, name
+	_ = r.Store.AddEvent(ctx, job.ID, "info", fmt.Sprintf("rule %d matched: out=%s name=%s", rule.ID, outDir, name))
+	return true, nil
+}
+
+const ruleColumns = `id, name, site, host, pattern, exts, min_size, max_size, root, template, created_at, updated_at`
+
+func scanRule(row rowScanner) (Rule, error) {
+	var rule Rule
+	var exts string
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Site, &rule.Host, &rule.Pattern, &exts, &rule.MinSize, &rule.MaxSize,
+		&rule.Root, &rule.Template, &rule.CreatedAt, &rule.UpdatedAt)
+	if exts != "" {
+		rule.Exts = strings.Split(exts, ",")
+	}
+	return rule, err
+}
+
+func (s *Store) CreateRule(ctx context.Context, rule *Rule) (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO rules (name, site, host, pattern, exts, min_size, max_size, root, template, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, rule.Name, rule.Site, rule.Host, rule.Pattern, strings.Join(rule.Exts, ","), rule.MinSize, rule.MaxSize, rule.Root, rule.Template, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListRules returns the rules in evaluation order.
+func (s *Store) ListRules(ctx context.Context) ([]Rule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+ruleColumns+` FROM rules ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rule)
+	}
+	return out, rows.Err()
+}
+
+// HasRules reports whether any rule exists, so out_dir may be omitted.
+func (s *Store) HasRules(ctx context.Context) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM rules`).Scan(&n)
+	return n > 0, err
+}
+
+func (s *Store) DeleteRule(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RuleView is the API representation of a rule.
+type RuleView struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Site      string   `json:"site,omitempty"`
+	Host      string   `json:"host,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Ext       []string `json:"ext"`
+	MinSize   int64    `json:"min_size,omitempty"`
+	MaxSize   int64    `json:"max_size,omitempty"`
+	Root      string   `json:"root,omitempty"`
+	Template  string   `json:"template"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// RuleRequest describes a rule to create. Sizes are in bytes.
+type RuleRequest struct {
+	Name     string
+	Site     string
+	Host     string
+	Pattern  string
+	Ext      []string
+	MinSize  int64
+	MaxSize  int64
+	Root     string
+	Template string
+}
+
+// RulePreviewRequest describes a job to run through the rules without
+// adding it. Filename defaults to the last segment of the URL path.
+type RulePreviewRequest struct {
+	URL      string
+	Site     string
+	Filename string
+	Size     int64
+	Package  string
+}
+
+// RulePreview is the outcome of a dry run.
+type RulePreview struct {
+	Matched  bool   `json:"matched"`
+	RuleID   int64  `json:"rule_id,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	OutDir   string `json:"out_dir,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func toRuleView(rule Rule) RuleView {
+	exts := rule.Exts
+	if exts == nil {
+		exts = []string{}
+	}
+	return RuleView{
+		ID:        rule.ID,
+		Name:      rule.Name,
+		Site:      rule.Site,
+		Host:      rule.Host,
+		Pattern:   rule.Pattern,
+		Ext:       exts,
+		MinSize:   rule.MinSize,
+		MaxSize:   rule.MaxSize,
+		Root:      rule.Root,
+		Template:  rule.Template,
+		CreatedAt: rule.CreatedAt,
+	}
+}
+
+func (s *Service) CreateRule(ctx context.Context, req RuleRequest) (int64, error) {
+	rule := &Rule{
+		Name:     strings.TrimSpace(req.Name),
+		Site:     strings.ToLower(strings.TrimSpace(req.Site)),
+		Host:     strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Host), "*.")),
+		Pattern:  strings.TrimSpace(req.Pattern),
+		MinSize:  req.MinSize,
+		MaxSize:  req.MaxSize,
+		Template: strings.TrimSpace(req.Template),
+	}
+	if rule.Pattern != "" {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return 0, fmt.Errorf("%w: pattern: %v", ErrInvalidRule, err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, ext := range req.Ext {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" || seen[ext] {
+			continue
+		}
+		if strings.ContainsAny(ext, ",/\\") {
+			return 0, fmt.Errorf("%w: invalid extension %q", ErrInvalidRule, ext)
+		}
+		seen[ext] = true
+		rule.Exts = append(rule.Exts, ext)
+	}
+	if rule.MinSize < 0 || rule.MaxSize < 0 || (rule.MaxSize > 0 && rule.MaxSize < rule.MinSize) {
+		return 0, fmt.Errorf("%w: invalid size bounds", ErrInvalidRule)
+	}
+	if root := strings.TrimSpace(req.Root); root != "" {
+		clean, err := cleanOutDir(root, s.allowedRoots)
+		if err != nil {
+			return 0, fmt.Errorf("%w: root: %v", ErrInvalidRule, err)
+		}
+		rule.Root = clean
+	}
+	if err := validateTemplate(rule.Template); err != nil {
+		return 0, err
+	}
+	return s.store.CreateRule(ctx, rule)
+}
+
+func (s *Service) ListRules(ctx context.Context) ([]RuleView, error) {
+	rules, err := s.store.ListRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]RuleView, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, toRuleView(rule))
+	}
+	return out, nil
+}
+
+func (s *Service) DeleteRule(ctx context.Context, id int64) error {
+	return s.store.DeleteRule(ctx, id)
+}
+
+// PreviewRules shows where a job would be saved, without resolving its URL.
+func (s *Service) PreviewRules(ctx context.Context, req RulePreviewRequest) (RulePreview, error) {
+	filename := sanitizeFilename(req.Filename)
+	if filename == "" {
+		filename = sanitizeFilename(urlFilename(req.URL))
+	}
+	in := ruleInput{
+		Site:     JobSite(req.Site, req.URL),
+		Host:     JobHost(req.URL),
+		Filename: filename,
+		Size:     req.Size,
+		Package:  strings.TrimSpace(req.Package),
+	}
+	rules, err := s.store.ListRules(ctx)
+	if err != nil {
+		return RulePreview{}, err
+	}
+	rule, outDir, name, err := matchRules(rules, in, s.allowedRoots)
+	if rule == nil {
+		return RulePreview{}, nil
+	}
+	preview := RulePreview{Matched: true, RuleID: rule.ID, RuleName: rule.Name, OutDir: outDir, Name: name}
+	if err != nil {
+		preview.Error = err.Error()
+	}
+	return preview, nil
+}
+
+// urlFilename returns the last segment of a URL path, as most resolvers
+// name the file.
+func urlFilename(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+func TestRuleMatchAndRender(t *testing.T) {
+	roots := []string{"/data", "/media"}
+	in := ruleInput{Site: "http", Host: "cdn.example.com", Filename: "Show.S01E01.mkv", Size: 800 << 20, Package: "Show S01"}
+	tests := []struct {
+		name    string
+		rule    Rule
+		match   bool
+		outDir  string
+		outName string
+	}{
+		{name: "package dir", rule: Rule{Site: "http", Template: "{root}/{site}/{package}/{filename}"}, match: true, outDir: "/data/http/Show S01", outName: "Show.S01E01.mkv"},
+		{name: "subdomain and ext", rule: Rule{Host: "example.com", Exts: []string{"mp4", "mkv"}, Root: "/media", Template: "{root}/tv/{stem}.{ext}"}, match: true, outDir: "/media/tv", outName: "Show.S01E01.mkv"},
+		{name: "pattern", rule: Rule{Pattern: `S\d+E\d+`, Template: "/data/tv/"}, match: true, outDir: "/data/tv", outName: "Show.S01E01.mkv"},
+		{name: "literal segment", rule: Rule{Template: "{root}/{package}/x/{filename}"}, match: true, outDir: "/data/Show S01/x", outName: "Show.S01E01.mkv"},
+		{name: "other site", rule: Rule{Site: "mega", Template: "{root}/{filename}"}},
+		{name: "other host", rule: Rule{Host: "ample.com", Template: "{root}/{filename}"}},
+		{name: "too small", rule: Rule{MinSize: 1 << 30, Template: "{root}/{filename}"}},
+		{name: "too big", rule: Rule{MaxSize: 100 << 20, Template: "{root}/{filename}"}},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(in); got != tt.match {
+			t.Fatalf("%s: matches = %v, want %v", tt.name, got, tt.match)
+		}
+		if !tt.match {
+			continue
+		}
+		outDir, name, err := tt.rule.render(in, roots)
+		if err != nil || outDir != tt.outDir || name != tt.outName {
+			t.Fatalf("%s: render = %q, %q, %v", tt.name, outDir, name, err)
+		}
+	}
+
+	noPkg := in
+	noPkg.Package = ""
+	if outDir, _, _ := (Rule{Template: "{root}/{site}/{package}/{filename}"}).render(noPkg, roots); outDir != "/data/http" {
+		t.Fatalf("empty package outDir = %q", outDir)
+	}
+	escape := in
+	escape.Package = ".."
+	if outDir, _, _ := (Rule{Template: "{root}/{package}/{filename}"}).render(escape, roots); outDir != "/data" {
+		t.Fatalf("package .. outDir = %q", outDir)
+	}
+	if _, _, err := (Rule{Template: "/srv/{filename}"}).render(in, roots); err == nil {
+		t.Fatalf("expected error for a directory outside the DATA roots")
+	}
+	if size := (ruleInput{Filename: "a.mkv"}); (Rule{MinSize: 1, Template: "{root}/{filename}"}).matches(size) {
+		t.Fatalf("size bound matched an unknown size")
+	}
+}
+
+func TestServiceRules(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	svc := NewService(store, &fakeDownloader{}, []string{"/data"})
+
+	if _, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a.mkv"}); err == nil || err.Error() != "missing out_dir" {
+		t.Fatalf("create without rules = %v, want missing out_dir", err)
+	}
+	for _, req := range []RuleRequest{
+		{Template: ""},
+		{Template: "tv/{filename}"},
+		{Template: "{root}/{year}/{filename}"},
+		{Template: "/data/{root}/{filename}"},
+		{Template: "{root}"},
+		{Pattern: "(", Template: "{root}/{filename}"},
+		{Root: "/srv", Template: "{root}/{filename}"},
+		{MinSize: 10, MaxSize: 5, Template: "{root}/{filename}"},
+	} {
+		if _, err := svc.CreateRule(ctx, req); !errors.Is(err, ErrInvalidRule) {
+			t.Fatalf("CreateRule(%+v) = %v, want ErrInvalidRule", req, err)
+		}
+	}
+	id, err := svc.CreateRule(ctx, RuleRequest{Name: "video", Host: "*.Example.com", Ext: []string{".MKV", "mkv", "mp4"}, Template: "{root}/video/{package}/{filename}"})
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	rules, err := svc.ListRules(ctx)
+	if err != nil || len(rules) != 1 || rules[0].Host != "example.com" || len(rules[0].Ext) != 2 || rules[0].Ext[0] != "mkv" {
+		t.Fatalf("rules = %+v, %v", rules, err)
+	}
+
+	preview, err := svc.PreviewRules(ctx, RulePreviewRequest{URL: "https://dl.example.com/files/a%20b.mkv?x=1", Package: "Films"})
+	if err != nil || !preview.Matched || preview.RuleID != id || preview.OutDir != "/data/video/Films" || preview.Name != "a b.mkv" {
+		t.Fatalf("preview = %+v, %v", preview, err)
+	}
+	if preview, _ := svc.PreviewRules(ctx, RulePreviewRequest{URL: "https://dl.example.com/a.zip"}); preview.Matched {
+		t.Fatalf("zip matched: %+v", preview)
+	}
+
+	jobID, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a.mkv"})
+	if err != nil {
+		t.Fatalf("create job with rules: %v", err)
+	}
+	events, _ := store.ListEvents(ctx, jobID, 5)
+	if !eventsContain(events, "out=(rules)") {
+		t.Fatalf("events = %v", events)
+	}
+
+	if err := svc.DeleteRule(ctx, id); err != nil {
+		t.Fatalf("delete rule: %v", err)
+	}
+	if err := svc.DeleteRule(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second delete = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestRunnerAppliesRulesAtResolve(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	svc := NewService(store, &fakeDownloader{}, []string{"/data"})
+	if _, err := svc.CreateRule(ctx, RuleRequest{Ext: []string{"mkv"}, Template: "{root}/video/{filename}"}); err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	ruleID, err := svc.CreateRule(ctx, RuleRequest{Site: "http", Template: "{root}/{site}/{package}/{stem}-{host}.{ext}"})
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	pkgID, err := svc.CreatePackage(ctx, "Pack")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	id, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/file", PackageID: pkgID})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	runner := &Runner{
+		Store:        store,
+		Resolvers:    resolver.NewRegistry(&fakeResolver{}),
+		Downloader:   &fakeDownloader{},
+		Concurrency:  1,
+		AllowedRoots: []string{"/data"},
+	}
+	runner.tick(ctx)
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading || job.OutDir != "/data/http/Pack" || job.Name != "file-example.com.bin" {
+		t.Fatalf("status/out/name = %s/%s/%s", job.Status, job.OutDir, job.Name)
+	}
+	events, _ := store.ListEvents(ctx, id, 10)
+	if !eventsContain(events, "rule "+strconv.FormatInt(ruleID, 10)+" matched: out=/data/http/Pack name=file-example.com.bin") {
+		t.Fatalf("events = %v", events)
+	}
+
+	// Without a matching rule the job fails until a rule is added.
+	if err := svc.DeleteRule(ctx, ruleID); err != nil {
+		t.Fatalf("delete rule: %v", err)
+	}
+	id, err = svc.CreateJob(ctx, JobRequest{URL: "https://example.com/other"})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, _ = store.GetJob(ctx, id)
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	job, _ = store.GetJob(ctx, id)
+	if job.Status != StatusFailed || job.ErrorCode.String != "no_rule_matched" || job.NextRetryAt.Valid || job.OutDir != "" {
+		t.Fatalf("status/code/out = %s/%s/%q", job.Status, job.ErrorCode.String, job.OutDir)
+	}
+}
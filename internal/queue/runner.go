@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -17,19 +18,61 @@ import (
 )
 
 type Runner struct {
-	Store              *Store
-	Resolvers          *resolver.Registry
-	Downloader         Downloader
-	MegaDecryptor      MegaDecryptor
-	ArchiveDecryptor   ArchiveDecryptor
-	Concurrency        int        // static fallback
-	GetConcurrency     func() int // dynamic getter (preferred if set)
+	Store            *Store
+	Resolvers        *resolver.Registry
+	Downloader       Downloader            // aria2
+	Engines          map[string]Downloader // additional engines by name
+	GetSiteEngine    func(site string) string
+	GetSpeedLimit    func(now time.Time) int64        // global cap in bytes/s, 0 = unlimited
+	GetQueueState    func(now time.Time) QueueState   // nil means always open
+	FreeSpace        func(path string) (int64, error) // defaults to statfs
+	GetMinFreeSpace  func(outDir string) int64        // bytes to keep free under outDir's DATA root
+	MegaDecryptor    MegaDecryptor
+	ArchiveDecryptor ArchiveDecryptor
+	Par2Repairer     Par2Repairer
+	Concurrency      int        // static fallback
+	GetConcurrency   func() int // dynamic getter (preferred if set)
+	// GetSiteConcurrency returns a per-site cap on active downloads and
+	// GetHostConcurrency the host rule matching a host with its cap; a cap of
+	// 0 means only the global limit applies.
+	GetSiteConcurrency func(site string) int
+	GetHostConcurrency func(host string) (rule string, limit int)
 	GetAutoDecrypt     func() bool
+	// AllowedRoots are the DATA_* volumes rule templates may write to.
+	AllowedRoots []string
+	// GetArchiveCleanup returns what happens to archive volumes after a
+	// successful extraction: keep (default), delete or trash.
+	GetArchiveCleanup func() string
+	// HookScripts run, in order, after a job completed. GetHookPolicy returns
+	// the timeout per script and whether a failing script fails the job.
+	HookScripts   []string
+	GetHookPolicy func() (timeout time.Duration, failJob bool)
+	// GetSeedLimits returns how long finished torrents keep seeding: until
+	// the share ratio or the seed time is reached. Both 0 means no seeding.
+	GetSeedLimits      func() (ratio float64, seedTime time.Duration)
 	DecryptConcurrency int // decrypt worker concurrency (default 1)
 	PollEvery          time.Duration
+	// Notifier pushes engine events; while connected, full status polling only
+	// runs every ReconcileEvery and ticks refresh progress in one call.
+	Notifier       Notifier
+	ReconcileEvery time.Duration
+
+	eventsLive    bool
+	notifierSeen  bool
+	lastReconcile time.Time
+	reconciled    map[string]bool // engines whose restart reconcile ran
+
+	speedLimit  int64                   // last logged global speed limit
+	speedLimits map[string]appliedLimit // per engine, so one failure does not hold back the rest
+
+	queueSeen   bool
+	queueClosed bool
+	queueHeld   bool
 
 	decryptMu      sync.Mutex
 	decryptPending map[int64]struct{}
+	verifyPending  map[int64]struct{}
+	hookPending    map[int64]struct{}
 	decryptSem     chan struct{}
 }
 
@@ -57,16 +100,64 @@ func (r *Runner) concurrency() int {
 	return 2
 }
 
+// engine returns the downloader for an engine name; empty means aria2.
+func (r *Runner) engine(name string) Downloader {
+	if name == "" || name == EngineAria2 {
+		if r.Downloader != nil {
+			return r.Downloader
+		}
+	}
+	if dl, ok := r.Engines[name]; ok {
+		return dl
+	}
+	return nil
+}
+
+// selectEngine picks the engine for a job: the job's own choice, then the
+// per-site setting, then what the resolver asked for.
+func (r *Runner) selectEngine(job *Job, res *resolver.ResolvedTarget) string {
+	if job.RequestedEngine.Valid && job.RequestedEngine.String != "" {
+		return job.RequestedEngine.String
+	}
+	if r.GetSiteEngine != nil {
+		if name := r.GetSiteEngine(JobSite(job.Site, job.URL)); name != "" {
+			return name
+		}
+	}
+	return res.Kind
+}
+
+func (r *Runner) reconcileEvery() time.Duration {
+	if r.ReconcileEvery > 0 {
+		return r.ReconcileEvery
+	}
+	return 30 * time.Second
+}
+
 func (r *Runner) Start(ctx context.Context) {
 	if r.PollEvery <= 0 {
 		r.PollEvery = 2 * time.Second
 	}
+	if err := r.Reconcile(ctx); err != nil {
+		log.Printf("runner reconcile error: %v", err)
+	}
+	var events <-chan downloadclient.Event
+	if r.Notifier != nil {
+		events = r.Notifier.Subscribe(ctx)
+	}
 	ticker := time.NewTicker(r.PollEvery)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				r.eventsLive = false
+				continue
+			}
+			r.handleEvent(ctx, ev)
 		case <-ticker.C:
 			r.tick(ctx)
 		}
@@ -74,20 +165,96 @@ func (r *Runner) Start(ctx context.Context) {
 }
 
 func (r *Runner) tick(ctx context.Context) {
+	start := time.Now()
+	defer func() { tickDuration.Observe(time.Since(start).Seconds()) }()
+	r.applySpeedLimit(ctx)
+	if r.reconcilePending() {
+		if err := r.Reconcile(ctx); err != nil {
+			log.Printf("runner reconcile error: %v", err)
+		}
+	}
 	// Update downloading jobs first.
-	if err := r.updateActive(ctx); err != nil {
-		log.Printf("runner updateActive error: %v", err)
+	if !r.eventsLive || time.Since(r.lastReconcile) >= r.reconcileEvery() {
+		if err := r.updateActive(ctx); err != nil {
+			log.Printf("runner updateActive error: %v", err)
+		}
+		r.lastReconcile = time.Now()
+	} else if err := r.refreshProgress(ctx); err != nil {
+		log.Printf("runner refreshProgress error: %v", err)
 	}
 	if err := r.dispatchCompletedDecrypt(ctx); err != nil {
 		log.Printf("runner dispatchCompletedDecrypt error: %v", err)
 	}
+	if err := r.dispatchVerify(ctx); err != nil {
+		log.Printf("runner dispatchVerify error: %v", err)
+	}
 	if err := r.requeueFailed(ctx); err != nil {
 		log.Printf("runner requeueFailed error: %v", err)
 	}
-	// Start new jobs if capacity.
+	if err := r.recheckWaitingSpace(ctx); err != nil {
+		log.Printf("runner recheckWaitingSpace error: %v", err)
+	}
+	r.fillSlots(ctx)
+}
+
+// appliedLimit is the speed cap last pushed to an engine and when.
+type appliedLimit struct {
+	limit int64
+	at    time.Time
+}
+
+// applySpeedLimit pushes the global speed cap to every engine when it changes,
+// and again every ReconcileEvery in case an engine restarted with its own.
+// An engine that fails is retried on the next tick; the others keep the cap.
+func (r *Runner) applySpeedLimit(ctx context.Context) {
+	if r.GetSpeedLimit == nil {
+		return
+	}
+	limit := r.GetSpeedLimit(time.Now())
+	opts := map[string]string{"max-overall-download-limit": strconv.FormatInt(limit, 10)}
+	var errs []error
+	for _, name := range r.engineNames() {
+		applied, ok := r.speedLimits[name]
+		if ok && applied.limit == limit && time.Since(applied.at) < r.reconcileEvery() {
+			continue
+		}
+		changer, ok := r.engine(name).(OptionChanger)
+		if !ok {
+			continue
+		}
+		if err := changer.ChangeGlobalOption(ctx, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if r.speedLimits == nil {
+			r.speedLimits = map[string]appliedLimit{}
+		}
+		r.speedLimits[name] = appliedLimit{limit: limit, at: time.Now()}
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("runner speed limit error: %v", err)
+	}
+	if limit != r.speedLimit {
+		log.Printf("runner: global speed limit %d B/s", limit)
+		r.speedLimit = limit
+	}
+}
+
+// fillSlots starts queued jobs while there is download capacity. Jobs whose
+// site or host is at its own cap are skipped so they do not block the rest.
+func (r *Runner) fillSlots(ctx context.Context) {
+	if !r.applyQueueState(ctx) {
+		return
+	}
 	active := r.countDownloading(ctx)
+	if active >= r.concurrency() {
+		return
+	}
+	sites, hosts := r.activeBySiteAndHost(ctx)
 	for active < r.concurrency() {
-		job, err := r.Store.ClaimNextQueued(ctx)
+		job, err := r.Store.ClaimNextQueuedFunc(ctx, func(j *Job) bool {
+			return r.hasSiteAndHostCapacity(j, sites, hosts)
+		})
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				break
@@ -99,29 +266,197 @@ func (r *Runner) tick(ctx context.Context) {
 			log.Printf("job %d resolve/start error: %v", job.ID, err)
 		}
 		active = r.countDownloading(ctx)
+		sites, hosts = r.activeBySiteAndHost(ctx)
+	}
+}
+
+// activeBySiteAndHost counts downloading jobs per site and per host rule. It
+// returns nil maps when no per-site or per-host caps are configured.
+func (r *Runner) activeBySiteAndHost(ctx context.Context) (map[string]int, map[string]int) {
+	if r.GetSiteConcurrency == nil && r.GetHostConcurrency == nil {
+		return nil, nil
 	}
+	jobs, err := r.Store.ListJobs(ctx, StatusDownloading, false)
+	if err != nil {
+		log.Printf("runner list downloading error: %v", err)
+		return nil, nil
+	}
+	sites := map[string]int{}
+	hosts := map[string]int{}
+	for _, j := range jobs {
+		sites[JobSite(j.Site, j.URL)]++
+		if r.GetHostConcurrency != nil {
+			if rule, limit := r.GetHostConcurrency(JobHost(j.URL)); limit > 0 {
+				hosts[rule]++
+			}
+		}
+	}
+	return sites, hosts
+}
+
+func (r *Runner) hasSiteAndHostCapacity(job *Job, sites, hosts map[string]int) bool {
+	if r.GetSiteConcurrency != nil {
+		site := JobSite(job.Site, job.URL)
+		if limit := r.GetSiteConcurrency(site); limit > 0 && sites[site] >= limit {
+			return false
+		}
+	}
+	if r.GetHostConcurrency != nil {
+		if rule, limit := r.GetHostConcurrency(JobHost(job.URL)); limit > 0 && hosts[rule] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// handleEvent reacts to a pushed engine notification for a single transfer.
+func (r *Runner) handleEvent(ctx context.Context, ev downloadclient.Event) {
+	switch ev.Type {
+	case downloadclient.EventConnected:
+		log.Printf("runner: aria2 notifications connected")
+		r.eventsLive = true
+		delete(r.speedLimits, EngineAria2)
+		if r.notifierSeen {
+			// aria2 may have restarted while disconnected.
+			delete(r.reconciled, EngineAria2)
+		}
+		if r.reconcilePending() {
+			if err := r.Reconcile(ctx); err != nil {
+				log.Printf("runner reconcile error: %v", err)
+			}
+		} else if err := r.updateActive(ctx); err != nil {
+			// Events may have been missed before subscribing.
+			log.Printf("runner updateActive error: %v", err)
+		}
+		r.notifierSeen = true
+		r.lastReconcile = time.Now()
+		return
+	case downloadclient.EventDisconnected:
+		r.eventsLive = false
+		return
+	case downloadclient.EventPause:
+		// Pauses are recorded by the service that issued them.
+		return
+	}
+	job, err := r.Store.GetJobByEngineGID(ctx, ev.GID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("runner event lookup error for gid %s: %v", ev.GID, err)
+		}
+		return
+	}
+	if job.Status != StatusDownloading || (job.Engine != "" && job.Engine != EngineAria2) {
+		return
+	}
+	r.syncJob(ctx, *job)
+	switch ev.Type {
+	case downloadclient.EventComplete, downloadclient.EventBtComplete, downloadclient.EventError, downloadclient.EventStop:
+		r.fillSlots(ctx)
+	}
+}
+
+// refreshProgress updates progress of running transfers with a single engine
+// call. Completion and errors are left to events and reconciliation.
+func (r *Runner) refreshProgress(ctx context.Context) error {
+	lister, ok := r.Downloader.(ActiveLister)
+	if !ok {
+		return r.updateActive(ctx)
+	}
+	active, err := lister.TellActive(ctx)
+	if err != nil {
+		return err
+	}
+	byGID := make(map[string]downloadclient.Status, len(active))
+	for _, st := range active {
+		byGID[st.GID] = st
+	}
+	jobs, err := r.Store.ListJobs(ctx, StatusDownloading, false)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.Engine != "" && job.Engine != EngineAria2 {
+			// Other engines do not push notifications; poll them directly.
+			r.syncJob(ctx, job)
+			continue
+		}
+		if !job.EngineGID.Valid {
+			continue
+		}
+		st, ok := byGID[job.EngineGID.String]
+		if !ok {
+			continue
+		}
+		bytesDone, _, speed, eta := statusProgress(&st)
+		countDownloaded(job, bytesDone)
+		_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, StatusDownloading, speed, eta)
+	}
+	return nil
 }
 
 func (r *Runner) resolveAndStart(ctx context.Context, job *Job) error {
+	return r.startJob(ctx, job, false)
+}
+
+// startJob resolves a job and hands it to the engine. With resume set the
+// transfer continues from partial data left on disk, unless the resolver
+// requires a fresh start.
+func (r *Runner) startJob(ctx context.Context, job *Job, resume bool) error {
 	latest, err := r.Store.GetJob(ctx, job.ID)
 	if err == nil && latest.DeletedAt.Valid {
 		return r.Store.AddEvent(ctx, job.ID, "info", "skipped deleted job")
 	}
-	res, err := r.Resolvers.ResolveWithSite(ctx, job.Site, job.URL)
-	if err != nil {
-		code, msg, retryAt := mapResolverError(err)
+	fail := func(code, msg string, retryAt time.Time) error {
 		_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
+		if resume {
+			// A restart is not an attempt: queue the job again and let the
+			// next claim count the failure.
+			_ = r.Store.AddEvent(ctx, job.ID, "info", "re-adding after restart failed; requeued")
+			return r.Store.Requeue(ctx, job.ID)
+		}
 		return r.Store.MarkFailed(ctx, job.ID, code, msg, retryAt)
 	}
+	res, err := r.Resolvers.ResolveWithSite(ctx, job.Site, job.URL)
+	if err != nil {
+		return fail(mapResolverError(err))
+	}
+	if job.OutDir == "" {
+		// Added without out_dir: the output rules decide.
+		if ok, err := r.applyRules(ctx, job, res); !ok {
+			return err
+		}
+	}
+	if res.Kind == resolver.KindFolder {
+		return r.expandFolder(ctx, job, res)
+	}
 	filename := sanitizeFilename(res.Filename)
 	if err := r.Store.UpdateResolving(ctx, job.ID, res.URL, filename, res.Size); err != nil {
 		return err
 	}
-	if res.Kind != "aria2" {
-		code := "unsupported_engine"
-		msg := "resolver returned unsupported engine"
-		_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
-		return r.Store.MarkFailed(ctx, job.ID, code, msg, time.Now().UTC().Add(30*time.Minute))
+	// Partial data of a file that changed on the server cannot be continued.
+	changed := remoteFileChanged(*job, res)
+	if changed {
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "remote file changed since the last attempt; starting over")
+	}
+	if res.ETag != "" || res.LastModified != "" {
+		if err := r.Store.SetValidators(ctx, job.ID, res.ETag, res.LastModified); err != nil {
+			return err
+		}
+	}
+	if res.Checksum != "" && nullString(job.Checksum) == "" {
+		// A checksum given when adding the job takes precedence.
+		if err := r.Store.SetChecksum(ctx, job.ID, res.Checksum); err != nil {
+			return err
+		}
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "expected checksum "+res.Checksum)
+	}
+	engineName := r.selectEngine(job, res)
+	dl := r.engine(engineName)
+	if dl == nil {
+		return fail("unsupported_engine", "unsupported engine: "+engineName, time.Now().UTC().Add(30*time.Minute))
+	}
+	if _, ok := dl.(TorrentAdder); res.IsTorrent() && !ok {
+		return fail("unsupported_engine", "engine "+engineName+" cannot download torrents", time.Now().UTC().Add(30*time.Minute))
 	}
 	options := map[string]string{
 		"dir": job.OutDir,
@@ -137,16 +472,40 @@ func (r *Runner) resolveAndStart(ctx context.Context, job *Job) error {
 		}
 		options[k] = v
 	}
+	if job.MaxDownloadLimit > 0 {
+		options["max-download-limit"] = strconv.FormatInt(job.MaxDownloadLimit, 10)
+	}
+	if res.IsTorrent() {
+		r.addSeedOptions(options)
+	}
+	if changed {
+		options["continue"] = "false"
+	}
+	if resume && !needsFreshStart(options) {
+		options["continue"] = "true"
+	}
+	if msg := r.spaceShortfall(ctx, job.ID, job.OutDir, r.downloadSpaceNeeded(*job, sanitizeFilename(options["out"]), res.Size)); msg != "" {
+		_ = r.Store.AddEvent(ctx, job.ID, "info", msg)
+		return r.Store.MarkWaitingSpace(ctx, job.ID, StatusQueued, msg)
+	}
 	if outName := sanitizeFilename(options["out"]); outName != "" {
 		if err := r.prepareOutputForStart(ctx, job, outName, options); err != nil {
-			_ = r.Store.AddEvent(ctx, job.ID, "error", "prepare output failed: "+err.Error())
-			return r.Store.MarkFailed(ctx, job.ID, "prepare_output_failed", err.Error(), time.Now().UTC().Add(10*time.Minute))
+			return fail("prepare_output_failed", "prepare output failed: "+err.Error(), time.Now().UTC().Add(10*time.Minute))
+		}
+	}
+	headers := res.Headers
+	if v := ifRangeValidator(*job, res); v != "" && !needsFreshStart(options) {
+		// Resumed ranges only apply to the same file; a changed one is
+		// sent whole instead.
+		headers = map[string]string{"If-Range": v}
+		for k, hv := range res.Headers {
+			headers[k] = hv
 		}
 	}
-	if len(res.Headers) > 0 {
+	if len(headers) > 0 {
 		var b strings.Builder
 		first := true
-		for k, v := range res.Headers {
+		for k, v := range headers {
 			if !first {
 				b.WriteString("\n")
 			}
@@ -157,13 +516,32 @@ func (r *Runner) resolveAndStart(ctx context.Context, job *Job) error {
 		}
 		options["header"] = b.String()
 	}
-	gid, err := r.Downloader.AddURI(ctx, res.URL, options)
+	var gid string
+	adder, canMirror := dl.(MirrorAdder)
+	useMirrors := canMirror && len(res.Mirrors) > 1
+	switch {
+	case len(res.Torrent) > 0:
+		gid, err = dl.(TorrentAdder).AddTorrent(ctx, res.Torrent, options)
+	case useMirrors:
+		gid, err = adder.AddURIs(ctx, res.Mirrors, options)
+	default:
+		gid, err = dl.AddURI(ctx, res.URL, options)
+	}
 	if err != nil {
-		_ = r.Store.AddEvent(ctx, job.ID, "error", err.Error())
-		return r.Store.MarkFailed(ctx, job.ID, "download_start_failed", err.Error(), time.Now().UTC().Add(10*time.Minute))
+		return fail("download_start_failed", err.Error(), time.Now().UTC().Add(10*time.Minute))
+	}
+	msg := "download started"
+	if resume {
+		msg = "download re-added"
+	}
+	if engineName != EngineAria2 {
+		msg += " engine=" + engineName
+	}
+	if useMirrors {
+		msg += " mirrors=" + strconv.Itoa(len(res.Mirrors))
 	}
-	_ = r.Store.AddEvent(ctx, job.ID, "info", "download started")
-	return r.Store.MarkDownloading(ctx, job.ID, "aria2", gid)
+	_ = r.Store.AddEvent(ctx, job.ID, "info", msg)
+	return r.Store.MarkDownloading(ctx, job.ID, engineName, gid)
 }
 
 func (r *Runner) prepareOutputForStart(ctx context.Context, job *Job, outName string, options map[string]string) error {
@@ -213,7 +591,7 @@ func (r *Runner) hasActiveOutputConflict(ctx context.Context, job *Job, outName
 
 func isOutputConflictActiveStatus(status string) bool {
 	switch status {
-	case StatusQueued, StatusResolving, StatusDownloading, StatusPaused, StatusDecrypting:
+	case StatusQueued, StatusResolving, StatusDownloading, StatusPaused, StatusVerifying, StatusDecrypting:
 		return true
 	default:
 		return false
@@ -251,49 +629,94 @@ func (r *Runner) updateActive(ctx context.Context) error {
 		return err
 	}
 	for _, job := range jobs {
-		if !job.EngineGID.Valid {
-			continue
-		}
-		st, err := r.Downloader.TellStatus(ctx, job.EngineGID.String)
-		if err != nil {
-			if errors.Is(err, downloadclient.ErrGIDNotFound) {
-				_ = r.Store.MarkFailed(ctx, job.ID, "gid_not_found", err.Error(), time.Now().UTC().Add(2*time.Minute))
-				continue
-			}
-			_ = r.Store.AddEvent(ctx, job.ID, "error", err.Error())
-			continue
+		r.syncJob(ctx, job)
+	}
+	return nil
+}
+
+// syncJob fetches the engine status of a downloading job and records progress,
+// completion or failure.
+func (r *Runner) syncJob(ctx context.Context, job Job) {
+	if !job.EngineGID.Valid {
+		return
+	}
+	dl := r.engine(job.Engine)
+	if dl == nil {
+		return
+	}
+	st, err := dl.TellStatus(ctx, job.EngineGID.String)
+	if err != nil {
+		if errors.Is(err, downloadclient.ErrGIDNotFound) {
+			// The engine restarted and forgot the transfer.
+			r.reconcileDownloading(ctx, job, nil)
+			return
 		}
-		bytesDone, _ := strconv.ParseInt(st.CompletedLen, 10, 64)
-		totalLen, _ := strconv.ParseInt(st.TotalLength, 10, 64)
-		speed, _ := strconv.ParseInt(st.DownloadSpeed, 10, 64)
-		eta := int64(0)
-		if speed > 0 && totalLen > 0 && bytesDone < totalLen {
-			eta = (totalLen - bytesDone) / speed
-		}
-		switch st.Status {
-		case "complete":
-			if bytesDone == 0 && totalLen > 0 {
-				bytesDone = totalLen
-			}
-			if r.queueDecryptFromStatus(ctx, job, st, bytesDone) {
-				continue
+		_ = r.Store.AddEvent(ctx, job.ID, "error", err.Error())
+		return
+	}
+	bytesDone, totalLen, speed, eta := statusProgress(st)
+	if st.Status == "complete" && bytesDone == 0 && totalLen > 0 {
+		bytesDone = totalLen
+	}
+	countDownloaded(job, bytesDone)
+	status := st.Status
+	if status == "complete" && len(st.FollowedBy) > 0 {
+		r.followTransfer(ctx, job, st.FollowedBy[0])
+		return
+	}
+	if status == "active" && st.Seeder == "true" {
+		// The torrent is complete; aria2 keeps seeding it on its own until
+		// the seed limits are reached, without holding a queue slot.
+		// Postprocessing waits for the end of seeding, see torrentSeeding.
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "torrent complete; seeding in aria2")
+		status = "complete"
+	}
+	switch status {
+	case "complete":
+		_ = r.Store.AddEvent(ctx, job.ID, "info", "download finished")
+		if r.shouldVerify(ctx, job) {
+			if err := r.Store.MarkVerifying(ctx, job.ID, bytesDone); err != nil {
+				log.Printf("runner mark verifying error for job %d: %v", job.ID, err)
+				return
 			}
-			_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, StatusCompleted, 0, 0)
-			_ = r.Store.AddEvent(ctx, job.ID, "info", "download finished")
-			_ = r.Store.MarkCompleted(ctx, job.ID)
-		case "error":
-			msg := st.ErrorMessage
-			if msg == "" {
-				msg = "download error"
+			if nullString(job.Checksum) == "" && r.sidecarPending(ctx, job) {
+				_ = r.Store.AddEvent(ctx, job.ID, "info", "verify waiting: checksum sidecar still downloading")
 			}
-			code, retryAt := mapDownloadError(msg)
-			_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
-			_ = r.Store.MarkFailed(ctx, job.ID, code, msg, retryAt)
-		default:
-			_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, StatusDownloading, speed, eta)
+			r.scheduleVerify(ctx, job.ID)
+			return
 		}
+		r.finishDownload(ctx, job, st, bytesDone)
+	case "error":
+		msg := st.ErrorMessage
+		if msg == "" {
+			msg = "download error"
+		}
+		code, retryAt := mapDownloadError(msg)
+		_ = r.Store.AddEvent(ctx, job.ID, "error", msg)
+		_ = r.Store.MarkFailed(ctx, job.ID, code, msg, retryAt)
+	default:
+		_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, StatusDownloading, speed, eta)
 	}
-	return nil
+}
+
+// finishDownload queues postprocessing for a finished, verified download or
+// completes it.
+func (r *Runner) finishDownload(ctx context.Context, job Job, st *downloadclient.Status, bytesDone int64) {
+	if r.queueDecryptFromStatus(ctx, job, st, bytesDone) {
+		return
+	}
+	_ = r.Store.UpdateProgress(ctx, job.ID, bytesDone, job.Status, 0, 0)
+	r.completeJob(ctx, job.ID)
+}
+
+func statusProgress(st *downloadclient.Status) (bytesDone, totalLen, speed, eta int64) {
+	bytesDone, _ = strconv.ParseInt(st.CompletedLen, 10, 64)
+	totalLen, _ = strconv.ParseInt(st.TotalLength, 10, 64)
+	speed, _ = strconv.ParseInt(st.DownloadSpeed, 10, 64)
+	if speed > 0 && totalLen > 0 && bytesDone < totalLen {
+		eta = (totalLen - bytesDone) / speed
+	}
+	return bytesDone, totalLen, speed, eta
 }
 
 func (r *Runner) queueDecryptFromStatus(ctx context.Context, job Job, st *downloadclient.Status, bytesDone int64) bool {
@@ -311,7 +734,6 @@ func (r *Runner) queueDecryptFromStatus(ctx context.Context, job Job, st *downlo
 		log.Printf("runner mark decrypting error for job %d: %v", job.ID, err)
 		return false
 	}
-	_ = r.Store.AddEvent(ctx, job.ID, "info", "download finished")
 	if waitMsg != "" {
 		_ = r.Store.AddEvent(ctx, job.ID, "info", waitMsg)
 		return true
@@ -321,7 +743,7 @@ func (r *Runner) queueDecryptFromStatus(ctx context.Context, job Job, st *downlo
 }
 
 func (r *Runner) dispatchCompletedDecrypt(ctx context.Context) error {
-	if r.ArchiveDecryptor == nil && r.MegaDecryptor == nil {
+	if r.ArchiveDecryptor == nil && r.MegaDecryptor == nil && len(r.HookScripts) == 0 {
 		return nil
 	}
 	jobs, err := r.Store.ListPendingPostprocess(ctx, 100)
@@ -329,16 +751,18 @@ func (r *Runner) dispatchCompletedDecrypt(ctx context.Context) error {
 		return err
 	}
 	for _, job := range jobs {
+		if job.HooksPending {
+			r.scheduleHooks(ctx, job.ID)
+			continue
+		}
 		if !r.autoDecryptEnabled() && job.Status != StatusDecrypting && !r.shouldDecryptMega(job, archivePathForJob(job)) {
 			continue
 		}
 		task, shouldProcess, waitMsg, failMsg := r.buildDecryptTask(ctx, job, nil)
 		if !shouldProcess {
 			if job.Status == StatusDecrypting {
-				if markErr := r.Store.MarkCompleted(ctx, job.ID); markErr != nil {
-					log.Printf("runner mark completed error for job %d: %v", job.ID, markErr)
-				}
 				_ = r.Store.ClearArchivePassword(ctx, job.ID)
+				r.completeJob(ctx, job.ID)
 			}
 			continue
 		}
@@ -382,10 +806,23 @@ func (r *Runner) runDecrypt(ctx context.Context, task decryptTask) {
 	defer r.releaseDecryptWorker()
 	defer r.unmarkDecryptPending(task.jobID)
 
+	if msg := r.spaceShortfall(ctx, task.jobID, task.outDir, decryptSpaceNeeded(task)); msg != "" {
+		_ = r.Store.AddEvent(ctx, task.jobID, "info", msg)
+		if err := r.Store.MarkWaitingSpace(ctx, task.jobID, StatusDecrypting, msg); err != nil {
+			log.Printf("runner mark waiting space error for job %d: %v", task.jobID, err)
+		}
+		return
+	}
+
 	if task.decryptMega && r.MegaDecryptor != nil {
 		_ = r.Store.AddEvent(ctx, task.jobID, "info", "mega decrypt started: "+filepath.Base(task.megaPath))
+		start := time.Now()
 		attempted, err := r.MegaDecryptor.MaybeDecrypt(ctx, task.site, task.rawURL, task.megaPath)
+		if attempted || err != nil {
+			decryptDuration.Observe(time.Since(start).Seconds(), "mega")
+		}
 		if err != nil {
+			decryptFailures.Inc("mega")
 			eventMsg := "mega decrypt failed: " + err.Error()
 			_ = r.Store.AddEvent(ctx, task.jobID, "error", eventMsg)
 			if markErr := r.Store.MarkPostprocessFailed(ctx, task.jobID, "mega decrypt failed", "mega_decrypt_failed"); markErr != nil {
@@ -398,10 +835,21 @@ func (r *Runner) runDecrypt(ctx context.Context, task decryptTask) {
 			_ = r.Store.AddEvent(ctx, task.jobID, "info", "mega decrypted: "+filepath.Base(task.megaPath))
 		}
 	}
+	if task.decryptArch && r.Par2Repairer != nil && !r.repairPar2(ctx, task) {
+		return
+	}
+	extracted := false
+	var outputs []string
 	if task.decryptArch && r.ArchiveDecryptor != nil {
 		_ = r.Store.AddEvent(ctx, task.jobID, "info", "archive decrypt started: "+filepath.Base(task.archivePath))
+		before := dirSnapshot(task.outDir)
+		start := time.Now()
 		attempted, err := r.ArchiveDecryptor.MaybeDecrypt(ctx, task.archivePath, task.outDir, task.password)
+		if attempted || err != nil {
+			decryptDuration.Observe(time.Since(start).Seconds(), "archive")
+		}
 		if err != nil {
+			decryptFailures.Inc("archive")
 			eventMsg := "archive decrypt failed: " + err.Error()
 			_ = r.Store.AddEvent(ctx, task.jobID, "error", eventMsg)
 			if markErr := r.Store.MarkPostprocessFailed(ctx, task.jobID, "archive decrypt failed", "archive_decrypt_failed"); markErr != nil {
@@ -411,21 +859,31 @@ func (r *Runner) runDecrypt(ctx context.Context, task decryptTask) {
 			return
 		}
 		if attempted {
+			extracted = true
+			outputs = extractedPaths(task.outDir, before, task.archivePath)
 			_ = r.Store.AddEvent(ctx, task.jobID, "info", "archive decrypted: "+filepath.Base(task.archivePath))
 		} else {
 			_ = r.Store.AddEvent(ctx, task.jobID, "info", "archive decrypt skipped: not an archive")
 		}
 	}
-	if markErr := r.Store.MarkCompleted(ctx, task.jobID); markErr != nil {
-		log.Printf("runner mark completed error for job %d: %v", task.jobID, markErr)
-	}
 	if err := r.Store.ClearArchivePassword(ctx, task.jobID); err != nil {
 		log.Printf("runner clear archive password error for job %d: %v", task.jobID, err)
 	}
+	if extracted {
+		r.cleanupArchive(ctx, task)
+		if err := r.Store.SetOutputPaths(ctx, task.jobID, outputs); err != nil {
+			log.Printf("runner set output paths error for job %d: %v", task.jobID, err)
+		}
+	}
+	r.completeJob(ctx, task.jobID)
 }
 
 func (r *Runner) buildDecryptTask(ctx context.Context, job Job, st *downloadclient.Status) (decryptTask, bool, string, string) {
 	password := strings.TrimSpace(nullString(job.ArchivePassword))
+	var statusErr error
+	if st == nil {
+		st, statusErr = r.torrentStatus(ctx, job)
+	}
 	downloadPath := firstStatusPath(st)
 	if downloadPath == "" {
 		downloadPath = archivePathForJob(job)
@@ -451,10 +909,19 @@ func (r *Runner) buildDecryptTask(ctx context.Context, job Job, st *downloadclie
 	if task.decryptArch && strings.TrimSpace(task.archivePath) == "" {
 		return task, true, "", "postprocess failed: missing file path for archive decrypt"
 	}
+	if statusErr != nil {
+		return task, true, "postprocess waiting: torrent status unavailable: " + statusErr.Error(), ""
+	}
+	if torrentSeeding(st) {
+		return task, true, "postprocess waiting: torrent still seeding", ""
+	}
 	if task.decryptArch {
 		if waitMsg := r.archiveDecryptWaitMessage(ctx, job, task.archivePath); waitMsg != "" {
 			return task, true, waitMsg, ""
 		}
+		if _, pending := r.par2ForArchive(ctx, job.ID, job.OutDir, task.archivePath); pending > 0 {
+			return task, true, "archive decrypt waiting: par2 set still downloading (" + strconv.Itoa(pending) + " job(s))", ""
+		}
 	}
 	return task, true, "", ""
 }
@@ -505,7 +972,7 @@ func (r *Runner) archiveDecryptWaitMessage(ctx context.Context, job Job, filePat
 
 func isMultipartSiblingPending(status string) bool {
 	switch status {
-	case StatusQueued, StatusResolving, StatusDownloading, StatusPaused:
+	case StatusQueued, StatusResolving, StatusDownloading, StatusPaused, StatusVerifying:
 		return true
 	default:
 		return false
@@ -639,11 +1106,36 @@ func nullString(v sql.NullString) string {
 }
 
 func (r *Runner) countDownloading(ctx context.Context) int {
-	jobs, err := r.Store.ListJobs(ctx, StatusDownloading, false)
+	n, err := r.Store.CountJobs(ctx, StatusDownloading)
 	if err != nil {
 		return 0
 	}
-	return len(jobs)
+	return n
+}
+
+// remoteFileChanged reports whether the validators of a fresh resolve differ
+// from those seen by an earlier attempt of the job.
+func remoteFileChanged(job Job, res *resolver.ResolvedTarget) bool {
+	if old := nullString(job.ETag); old != "" && res.ETag != "" {
+		return old != res.ETag
+	}
+	if old := nullString(job.LastModified); old != "" && res.LastModified != "" {
+		return old != res.LastModified
+	}
+	return false
+}
+
+// ifRangeValidator returns the If-Range value for a job that may continue
+// partial data: a strong ETag, or else Last-Modified. Jobs without earlier
+// validators start from nothing and need none.
+func ifRangeValidator(job Job, res *resolver.ResolvedTarget) string {
+	if !job.ETag.Valid && !job.LastModified.Valid {
+		return ""
+	}
+	if res.ETag != "" && !strings.HasPrefix(res.ETag, "W/") {
+		return res.ETag
+	}
+	return res.LastModified
 }
 
 func mapResolverError(err error) (code, msg string, retryAt time.Time) {
@@ -658,6 +1150,9 @@ func mapResolverError(err error) (code, msg string, retryAt time.Time) {
 		return "temporarily_unavailable", "temporarily unavailable; retry later", time.Now().UTC().Add(30 * time.Minute)
 	case errors.Is(err, resolver.ErrUnknownSite):
 		return "unknown_site", "unknown site; cannot resolve", time.Now().UTC().Add(6 * time.Hour)
+	case errors.Is(err, resolver.ErrNotFound):
+		// The server says the file is gone; retrying will not bring it back.
+		return "http_not_found", err.Error(), time.Time{}
 	default:
 		return "resolve_failed", err.Error(), time.Now().UTC().Add(30 * time.Minute)
 	}
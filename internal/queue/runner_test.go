@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -49,6 +50,7 @@ type fakeArchiveDecryptor struct {
 	archivePath string
 	outDir      string
 	password    string
+	extract     []string // files written to outDir, as an extraction would
 }
 
 func (d *fakeArchiveDecryptor) MaybeDecrypt(ctx context.Context, archivePath, outDir, password string) (bool, error) {
@@ -58,6 +60,11 @@ func (d *fakeArchiveDecryptor) MaybeDecrypt(ctx context.Context, archivePath, ou
 	d.archivePath = archivePath
 	d.outDir = outDir
 	d.password = password
+	for _, name := range d.extract {
+		if err := os.WriteFile(filepath.Join(outDir, name), []byte("x"), 0o644); err != nil {
+			return true, err
+		}
+	}
 	return d.attempted, d.err
 }
 
@@ -396,9 +403,7 @@ func TestRunnerDecryptsArchiveOnComplete(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/archive.zip"},
 		},
 	}
@@ -480,9 +485,7 @@ func TestRunnerDecryptsArchiveOnCompleteWithoutPassword(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/archive.zip"},
 		},
 	}
@@ -558,9 +561,7 @@ func TestRunnerDecryptArchiveErrorMarksDecryptFailed(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/archive.zip"},
 		},
 	}
@@ -628,9 +629,7 @@ func TestRunnerMegaDecryptsOnComplete(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/file.bin"},
 		},
 	}
@@ -707,9 +706,7 @@ func TestRunnerMegaDecryptErrorMarksDecryptFailed(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/file.bin"},
 		},
 	}
@@ -775,9 +772,7 @@ func TestRunnerMarksDecryptingWhileArchiveDecryptInProgress(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/archive.zip"},
 		},
 	}
@@ -858,9 +853,7 @@ func TestRunnerWaitsForMultipartSiblingsBeforeArchiveDecrypt(t *testing.T) {
 		t.Fatalf("get part2 job: %v", err)
 	}
 	handled := runner.queueDecryptFromStatus(ctx, *part2Job, &downloader.Status{
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: part2Path},
 		},
 	}, 123)
@@ -1099,9 +1092,7 @@ func TestRunnerSkipsDecryptWhenAutoDecryptDisabled(t *testing.T) {
 		TotalLength:   "10",
 		CompletedLen:  "10",
 		DownloadSpeed: "0",
-		Files: []struct {
-			Path string `json:"path"`
-		}{
+		Files: []downloader.StatusFile{
 			{Path: "/data/archive.zip"},
 		},
 	}
@@ -1216,6 +1207,173 @@ func TestRunnerDispatchSkipsCompletedNoPasswordToAvoidLoop(t *testing.T) {
 	}
 }
 
+type activeListingDownloader struct {
+	fakeDownloader
+	active []downloader.Status
+}
+
+func (d *activeListingDownloader) TellActive(ctx context.Context) ([]downloader.Status, error) {
+	return d.active, nil
+}
+
+func TestRunnerHandleCompleteEventStartsNextJob(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	first, err := store.CreateJob(ctx, &Job{URL: "https://example.com/a", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	fakeDL := &fakeDownloader{}
+	runner := &Runner{
+		Store:       store,
+		Resolvers:   resolver.NewRegistry(&fakeResolver{}),
+		Downloader:  fakeDL,
+		Concurrency: 1,
+	}
+	runner.tick(ctx)
+	second, err := store.CreateJob(ctx, &Job{URL: "https://example.com/b", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	fakeDL.status = &downloader.Status{GID: "gid-1", Status: "complete", TotalLength: "10", CompletedLen: "10"}
+	runner.handleEvent(ctx, downloader.Event{Type: downloader.EventComplete, GID: "gid-1"})
+
+	job, err := store.GetJob(ctx, first)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusCompleted {
+		t.Fatalf("expected first job completed, got %s", job.Status)
+	}
+	job, err = store.GetJob(ctx, second)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading {
+		t.Fatalf("expected second job to start without waiting for a tick, got %s", job.Status)
+	}
+}
+
+func TestRunnerTickRefreshesProgressWhileEventsLive(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/a", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	dl := &activeListingDownloader{}
+	runner := &Runner{
+		Store:       store,
+		Resolvers:   resolver.NewRegistry(&fakeResolver{}),
+		Downloader:  dl,
+		Concurrency: 1,
+	}
+	runner.tick(ctx)
+
+	runner.eventsLive = true
+	runner.lastReconcile = time.Now()
+	// A per-job status would report completion; while events are live only
+	// the batched active list is consulted.
+	dl.status = &downloader.Status{GID: "gid-1", Status: "complete", TotalLength: "10", CompletedLen: "10"}
+	dl.active = []downloader.Status{{GID: "gid-1", Status: "active", TotalLength: "10", CompletedLen: "4", DownloadSpeed: "2"}}
+	runner.tick(ctx)
+
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading {
+		t.Fatalf("expected downloading, got %s", job.Status)
+	}
+	if job.BytesDone != 4 || job.EtaSeconds.Int64 != 3 {
+		t.Fatalf("expected bytes_done 4 eta 3, got %d eta %d", job.BytesDone, job.EtaSeconds.Int64)
+	}
+}
+
+func TestRunnerStartsJobOnSelectedEngine(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	perJob, err := store.CreateJob(ctx, &Job{URL: "https://example.com/a", OutDir: "/data", MaxAttempts: 1, RequestedEngine: sqlNullString(EngineNative)})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	perSite, err := store.CreateJob(ctx, &Job{URL: "https://mega.nz/file/x", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	unknown, err := store.CreateJob(ctx, &Job{URL: "https://example.com/c", OutDir: "/data", MaxAttempts: 1, RequestedEngine: sqlNullString("curl")})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	native := &fakeDownloader{}
+	runner := &Runner{
+		Store:       store,
+		Resolvers:   resolver.NewRegistry(&fakeResolver{}),
+		Downloader:  &fakeDownloader{},
+		Engines:     map[string]Downloader{EngineNative: native},
+		Concurrency: 3,
+		GetSiteEngine: func(site string) string {
+			if site == "mega" {
+				return EngineNative
+			}
+			return ""
+		},
+	}
+	runner.tick(ctx)
+
+	for _, id := range []int64{perJob, perSite} {
+		job, err := store.GetJob(ctx, id)
+		if err != nil {
+			t.Fatalf("get job: %v", err)
+		}
+		if job.Status != StatusDownloading || job.Engine != EngineNative {
+			t.Fatalf("job %d: expected downloading on native, got %s on %s", id, job.Status, job.Engine)
+		}
+	}
+	job, err := store.GetJob(ctx, unknown)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusFailed || job.ErrorCode.String != "unsupported_engine" {
+		t.Fatalf("expected unsupported_engine failure, got %s %s", job.Status, job.ErrorCode.String)
+	}
+}
+
+type optionRecordingDownloader struct {
+	fakeDownloader
+	global []string
+}
+
+func (d *optionRecordingDownloader) ChangeOption(ctx context.Context, gid string, options map[string]string) error {
+	return nil
+}
+
+func (d *optionRecordingDownloader) ChangeGlobalOption(ctx context.Context, options map[string]string) error {
+	d.global = append(d.global, options["max-overall-download-limit"])
+	return nil
+}
+
+func TestRunnerAppliesSpeedLimitWhenItChanges(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	dl := &optionRecordingDownloader{}
+	limit := int64(1 << 20)
+	runner := &Runner{
+		Store:         store,
+		Resolvers:     resolver.NewRegistry(&fakeResolver{}),
+		Downloader:    dl,
+		GetSpeedLimit: func(time.Time) int64 { return limit },
+	}
+	runner.tick(ctx)
+	runner.tick(ctx)
+	limit = 0
+	runner.tick(ctx)
+	if len(dl.global) != 2 || dl.global[0] != "1048576" || dl.global[1] != "0" {
+		t.Fatalf("expected limit applied once per change, got %v", dl.global)
+	}
+}
+
 func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
 	t.Helper()
 	deadline := time.Now().Add(timeout)
@@ -1238,3 +1396,133 @@ func eventsContain(events []string, contains string) bool {
 	}
 	return false
 }
+
+func TestRunnerSkipsJobsForSiteAtConcurrencyCap(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	active, err := store.CreateJob(ctx, &Job{URL: "https://webshare.cz/#/file/a", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, active, EngineAria2, "gid-1"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	blocked, err := store.CreateJob(ctx, &Job{URL: "https://webshare.cz/#/file/b", OutDir: "/data", MaxAttempts: 1, Priority: 10})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	other, err := store.CreateJob(ctx, &Job{URL: "https://example.com/c", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	runner := &Runner{
+		Store:       store,
+		Resolvers:   resolver.NewRegistry(&fakeResolver{}),
+		Downloader:  &fakeDownloader{status: &downloader.Status{GID: "gid-1", Status: "active", TotalLength: "10", CompletedLen: "1"}},
+		Concurrency: 3,
+		GetSiteConcurrency: func(site string) int {
+			if site == "webshare" {
+				return 1
+			}
+			return 0
+		},
+	}
+	runner.tick(ctx)
+
+	job, err := store.GetJob(ctx, blocked)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected webshare job to wait for its site slot, got %s", job.Status)
+	}
+	job, err = store.GetJob(ctx, other)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading {
+		t.Fatalf("expected http job to start past the blocked one, got %s", job.Status)
+	}
+}
+
+// targetResolver answers every URL with a fixed target or error.
+type targetResolver struct {
+	target resolver.ResolvedTarget
+	err    error
+}
+
+func (r *targetResolver) CanHandle(rawURL string) bool { return true }
+func (r *targetResolver) Resolve(ctx context.Context, rawURL string) (*resolver.ResolvedTarget, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	target := r.target
+	return &target, nil
+}
+
+func TestRunnerTracksRemoteValidatorsAcrossAttempts(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/file.bin", OutDir: "/data", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	res := &targetResolver{target: resolver.ResolvedTarget{Kind: "aria2", URL: "https://example.com/file.bin", Filename: "file.bin", ETag: `"v1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}}
+	dl := &reconcileDownloader{}
+	runner := &Runner{Store: store, Resolvers: resolver.NewRegistry(res), Downloader: dl}
+	start := func() map[string]string {
+		t.Helper()
+		job, err := store.GetJob(ctx, id)
+		if err != nil {
+			t.Fatalf("get job: %v", err)
+		}
+		if err := runner.startJob(ctx, job, false); err != nil {
+			t.Fatalf("startJob: %v", err)
+		}
+		return dl.added[len(dl.added)-1]
+	}
+
+	// The first attempt has no partial data to protect.
+	if opts := start(); opts["header"] != "" {
+		t.Fatalf("first start headers = %q", opts["header"])
+	}
+	if job, _ := store.GetJob(ctx, id); job.ETag.String != `"v1"` || job.LastModified.String == "" {
+		t.Fatalf("validators = %q/%q", job.ETag.String, job.LastModified.String)
+	}
+	if opts := start(); opts["header"] != `If-Range: "v1"` || opts["continue"] == "false" {
+		t.Fatalf("same file: header/continue = %q/%q", opts["header"], opts["continue"])
+	}
+	res.target.ETag = `"v2"`
+	if opts := start(); opts["header"] != "" || opts["continue"] != "false" {
+		t.Fatalf("changed file: header/continue = %q/%q", opts["header"], opts["continue"])
+	}
+	events, _ := store.ListEvents(ctx, id, 20)
+	if !eventsContain(events, "remote file changed since the last attempt; starting over") {
+		t.Fatalf("events = %v", events)
+	}
+	if job, _ := store.GetJob(ctx, id); job.ETag.String != `"v2"` {
+		t.Fatalf("etag = %q, want the new one", job.ETag.String)
+	}
+}
+
+func TestRunnerFailsMissingFileWithoutRetry(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/gone.bin", OutDir: "/data", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	runner := &Runner{
+		Store:      store,
+		Resolvers:  resolver.NewRegistry(&targetResolver{err: fmt.Errorf("%w: status=404 Not Found", resolver.ErrNotFound)}),
+		Downloader: &fakeDownloader{},
+	}
+	job, _ := store.GetJob(ctx, id)
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	job, _ = store.GetJob(ctx, id)
+	if job.Status != StatusFailed || job.ErrorCode.String != "http_not_found" || job.NextRetryAt.Valid {
+		t.Fatalf("status/code/retry = %s/%s/%v", job.Status, job.ErrorCode.String, job.NextRetryAt.Valid)
+	}
+}
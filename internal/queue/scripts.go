@@ -0,0 +1,289 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	// maxHookOutputLines caps the script output lines kept as job events and
+	// maxHookLineBytes the length of each.
+	maxHookOutputLines = 20
+	maxHookLineBytes   = 1024
+)
+
+// LoadHookScripts returns the executable files in dir, sorted by name. A
+// missing dir yields no scripts.
+func LoadHookScripts(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var scripts []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		scripts = append(scripts, path)
+	}
+	sort.Strings(scripts)
+	return scripts, nil
+}
+
+func (r *Runner) hookPolicy() (time.Duration, bool) {
+	timeout, failJob := defaultHookTimeout, false
+	if r.GetHookPolicy != nil {
+		var t time.Duration
+		t, failJob = r.GetHookPolicy()
+		if t > 0 {
+			timeout = t
+		}
+	}
+	return timeout, failJob
+}
+
+// completeJob marks a finished job completed. With hook scripts configured
+// the job stays in decrypting with hooks pending until they have run, so a
+// restart runs them again and completion is only reported once; a torrent
+// that still seeds waits there until seeding ends.
+func (r *Runner) completeJob(ctx context.Context, jobID int64) {
+	if len(r.HookScripts) == 0 {
+		if err := r.Store.MarkCompleted(ctx, jobID); err != nil {
+			log.Printf("runner mark completed error for job %d: %v", jobID, err)
+		}
+		return
+	}
+	if err := r.Store.MarkHooksPending(ctx, jobID); err != nil {
+		log.Printf("runner mark hooks pending error for job %d: %v", jobID, err)
+		return
+	}
+	if job, err := r.Store.GetJob(ctx, jobID); err == nil {
+		if st, err := r.torrentStatus(ctx, *job); err == nil && torrentSeeding(st) {
+			_ = r.Store.AddEvent(ctx, jobID, "info", "hooks waiting: torrent still seeding")
+			return
+		}
+	}
+	r.scheduleHooks(ctx, jobID)
+}
+
+// scheduleHooks runs the hook scripts of a job with hooks pending.
+func (r *Runner) scheduleHooks(ctx context.Context, jobID int64) {
+	r.decryptMu.Lock()
+	if r.hookPending == nil {
+		r.hookPending = make(map[int64]struct{})
+	}
+	if _, exists := r.hookPending[jobID]; exists {
+		r.decryptMu.Unlock()
+		return
+	}
+	r.hookPending[jobID] = struct{}{}
+	r.decryptMu.Unlock()
+	go func() {
+		defer func() {
+			r.decryptMu.Lock()
+			delete(r.hookPending, jobID)
+			r.decryptMu.Unlock()
+		}()
+		r.runHooks(ctx, jobID)
+	}()
+}
+
+// runHooks runs the hook scripts of a job with hooks pending and marks it
+// completed. A failing script is logged; when the hook policy says so, the
+// job moves to decrypt_failed with hook_failed instead, and a retry runs the
+// scripts again without downloading or extracting anything.
+func (r *Runner) runHooks(ctx context.Context, jobID int64) {
+	job, err := r.Store.GetJob(ctx, jobID)
+	if err != nil || job.DeletedAt.Valid {
+		return
+	}
+	if job.Status != StatusDecrypting || !job.HooksPending {
+		return
+	}
+	if st, err := r.torrentStatus(ctx, *job); err != nil || torrentSeeding(st) {
+		return
+	}
+	timeout, failJob := r.hookPolicy()
+	env := r.hookEnv(ctx, *job)
+	var failed []string
+	for _, script := range r.HookScripts {
+		if err := r.runHookScript(ctx, job.ID, script, job.OutDir, env, timeout); err != nil {
+			failed = append(failed, filepath.Base(script))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if len(failed) > 0 && failJob {
+		msg := "hook failed: " + strings.Join(failed, ", ")
+		if err := r.Store.MarkPostprocessFailed(ctx, job.ID, msg, "hook_failed"); err != nil {
+			log.Printf("runner mark hook failed error for job %d: %v", job.ID, err)
+		}
+		return
+	}
+	if err := r.Store.MarkCompleted(ctx, job.ID); err != nil {
+		log.Printf("runner mark completed error for job %d: %v", job.ID, err)
+	}
+}
+
+// runHookScript runs one script in the job's out_dir and records its output
+// as job events.
+func (r *Runner) runHookScript(ctx context.Context, jobID int64, script, dir string, env []string, timeout time.Duration) error {
+	name := filepath.Base(script)
+	_ = r.Store.AddEvent(ctx, jobID, "info", "hook "+name+" started")
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out := tailLines{max: maxHookOutputLines}
+	cmd := exec.CommandContext(runCtx, script)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	level := "info"
+	if err != nil {
+		level = "error"
+	}
+	lines := out.Lines()
+	if out.dropped > 0 {
+		_ = r.Store.AddEvent(ctx, jobID, level, fmt.Sprintf("hook %s: (%d earlier lines omitted)", name, out.dropped))
+	}
+	for _, line := range lines {
+		_ = r.Store.AddEvent(ctx, jobID, level, "hook "+name+": "+line)
+	}
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		_ = r.Store.AddEvent(ctx, jobID, "error", "hook "+name+" failed: "+err.Error())
+		return err
+	}
+	_ = r.Store.AddEvent(ctx, jobID, "info", "hook "+name+" finished")
+	return nil
+}
+
+// tailLines keeps the last max non-empty lines written to it, each cut to
+// maxHookLineBytes, so a chatty script cannot grow dlqd's memory.
+type tailLines struct {
+	max     int
+	lines   []string
+	partial []byte
+	dropped int
+}
+
+func (t *tailLines) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.appendPartial(p)
+			return n, nil
+		}
+		t.appendPartial(p[:i])
+		t.endLine()
+		p = p[i+1:]
+	}
+}
+
+func (t *tailLines) appendPartial(p []byte) {
+	if room := maxHookLineBytes - len(t.partial); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		t.partial = append(t.partial, p...)
+	}
+}
+
+func (t *tailLines) endLine() {
+	line := strings.TrimSpace(string(t.partial))
+	t.partial = t.partial[:0]
+	if line == "" {
+		return
+	}
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[1:]
+		t.dropped++
+	}
+}
+
+// Lines ends an unterminated last line and returns the kept lines.
+func (t *tailLines) Lines() []string {
+	t.endLine()
+	return t.lines
+}
+
+// hookEnvAllowlist names the variables of dlqd's environment that hook
+// scripts inherit; secrets such as DLQ_ACCOUNTS_KEY or API tokens stay out.
+var hookEnvAllowlist = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LANG": true, "TZ": true, "TMPDIR": true,
+}
+
+// hookEnv returns the environment of hook scripts: the allowlisted part of
+// dlqd's own, LC_* and HOOK_* variables, plus the job metadata in DLQ_*
+// variables. DLQ_FILE_PATH is empty once cleanup removed the download;
+// DLQ_OUTPUT_PATHS lists what an extraction produced, one path per line, or
+// else the download itself.
+func (r *Runner) hookEnv(ctx context.Context, job Job) []string {
+	filename := job.Name
+	if filename == "" {
+		filename = nullString(job.Filename)
+	}
+	filePath := archivePathForJob(job)
+	if _, err := os.Stat(filePath); err != nil {
+		filePath = ""
+	}
+	outputs := nullString(job.OutputPaths)
+	if outputs == "" {
+		outputs = filePath
+	}
+	vars := map[string]string{
+		"DLQ_JOB_ID":       strconv.FormatInt(job.ID, 10),
+		"DLQ_URL":          job.URL,
+		"DLQ_SITE":         JobSite(job.Site, job.URL),
+		"DLQ_OUT_DIR":      job.OutDir,
+		"DLQ_FILENAME":     filename,
+		"DLQ_FILE_PATH":    filePath,
+		"DLQ_OUTPUT_PATHS": outputs,
+		"DLQ_SIZE":         strconv.FormatInt(job.BytesDone, 10),
+		"DLQ_PACKAGE":      "",
+	}
+	if job.PackageID.Valid {
+		vars["DLQ_PACKAGE_ID"] = strconv.FormatInt(job.PackageID.Int64, 10)
+		if pkg, err := r.Store.GetPackage(ctx, job.PackageID.Int64); err == nil {
+			vars["DLQ_PACKAGE"] = pkg.Name
+		}
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if hookEnvAllowlist[name] || strings.HasPrefix(name, "LC_") || strings.HasPrefix(name, "HOOK_") {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
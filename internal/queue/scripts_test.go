@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, dir, name, body string, mode os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadHookScripts(t *testing.T) {
+	dir := t.TempDir()
+	b := writeScript(t, dir, "20-b.sh", "", 0o755)
+	a := writeScript(t, dir, "10-a.sh", "", 0o755)
+	writeScript(t, dir, "notes.txt", "", 0o644)
+	writeScript(t, dir, ".hidden.sh", "", 0o755)
+	scripts, err := LoadHookScripts(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(scripts) != 2 || scripts[0] != a || scripts[1] != b {
+		t.Fatalf("scripts = %v", scripts)
+	}
+	if scripts, err := LoadHookScripts(filepath.Join(dir, "missing")); err != nil || scripts != nil {
+		t.Fatalf("missing dir = %v, %v", scripts, err)
+	}
+}
+
+func TestTailLinesKeepsLastLines(t *testing.T) {
+	out := tailLines{max: 3}
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&out, "line %d\n\n", i)
+	}
+	out.Write([]byte(strings.Repeat("x", 3*maxHookLineBytes)))
+	out.Write([]byte("y"))
+	lines := out.Lines()
+	if len(lines) != 3 || lines[0] != "line 999" || lines[1] != "line 1000" || len(lines[2]) != maxHookLineBytes {
+		t.Fatalf("lines = %q", lines)
+	}
+	if out.dropped != 998 {
+		t.Fatalf("dropped = %d, want 998", out.dropped)
+	}
+}
+
+func TestRunnerRunsHookScriptsWithJobEnv(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	pkgID, err := store.CreatePackage(ctx, "Show S01")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/ep1.mkv", OutDir: outDir, Name: "ep1.mkv", MaxAttempts: 1, PackageID: sql.NullInt64{Int64: pkgID, Valid: true}})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "ep1.mkv"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DLQ_ACCOUNTS_KEY", "secret")
+	t.Setenv("HOOK_LIBRARY", "tv")
+	dir := t.TempDir()
+	runner := &Runner{
+		Store: store,
+		HookScripts: []string{
+			writeScript(t, dir, "notify.sh", "echo \"job=$DLQ_JOB_ID path=$DLQ_FILE_PATH outputs=$DLQ_OUTPUT_PATHS package=$DLQ_PACKAGE cwd=$(pwd)\"\necho \"key=$DLQ_ACCOUNTS_KEY library=$HOOK_LIBRARY\"\necho warn >&2\n", 0o755),
+			writeScript(t, dir, "slow.sh", "exec sleep 5\n", 0o755),
+		},
+		GetHookPolicy: func() (time.Duration, bool) { return 200 * time.Millisecond, false },
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	runner.finishDownload(ctx, *job, nil, 10)
+	// The job only completes once its scripts have run.
+	if job, _ = store.GetJob(ctx, id); job.Status != StatusDecrypting || !job.HooksPending {
+		t.Fatalf("status/hooks pending = %s/%v", job.Status, job.HooksPending)
+	}
+	waitFor(t, 3*time.Second, func() bool {
+		job, _ := store.GetJob(ctx, id)
+		return job.Status == StatusCompleted
+	})
+	events, _ := store.ListEvents(ctx, id, 20)
+	path := filepath.Join(outDir, "ep1.mkv")
+	want := "hook notify.sh: job=" + strconv.FormatInt(id, 10) + " path=" + path + " outputs=" + path + " package=Show S01 cwd=" + outDir
+	for _, s := range []string{want, "hook notify.sh: key= library=tv", "hook notify.sh: warn", "hook notify.sh finished", "hook slow.sh failed: timed out after 200ms"} {
+		if !eventsContain(events, s) {
+			t.Fatalf("missing %q in %v", s, events)
+		}
+	}
+	// Without hook_fail_job a failing script still completes the job.
+	if job, _ = store.GetJob(ctx, id); job.HooksPending {
+		t.Fatalf("hooks still pending after completion")
+	}
+}
+
+func TestRunnerHooksSeeExtractedOutputs(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	for _, name := range []string{"movie.zip", "old.txt"} {
+		if err := os.WriteFile(filepath.Join(outDir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/movie.zip", OutDir: outDir, Name: "movie.zip", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDecrypting(ctx, id, 1); err != nil {
+		t.Fatalf("mark decrypting: %v", err)
+	}
+	runner := &Runner{
+		Store:             store,
+		ArchiveDecryptor:  &fakeArchiveDecryptor{attempted: true, extract: []string{"movie.mkv", "movie.nfo"}},
+		GetAutoDecrypt:    func() bool { return true },
+		GetArchiveCleanup: func() string { return ArchiveCleanupDelete },
+		HookScripts:       []string{writeScript(t, t.TempDir(), "paths.sh", "echo \"path=[$DLQ_FILE_PATH]\"\necho \"$DLQ_OUTPUT_PATHS\"\n", 0o755)},
+	}
+	runner.runDecrypt(ctx, decryptTask{jobID: id, archivePath: filepath.Join(outDir, "movie.zip"), outDir: outDir, decryptArch: true})
+	waitFor(t, 2*time.Second, func() bool {
+		job, _ := store.GetJob(ctx, id)
+		return job.Status == StatusCompleted
+	})
+	events, _ := store.ListEvents(ctx, id, 20)
+	for _, s := range []string{"hook paths.sh: path=[]", "hook paths.sh: " + filepath.Join(outDir, "movie.mkv"), "hook paths.sh: " + filepath.Join(outDir, "movie.nfo")} {
+		if !eventsContain(events, s) {
+			t.Fatalf("missing %q in %v", s, events)
+		}
+	}
+	if eventsContain(events, "old.txt") {
+		t.Fatalf("untouched file listed as output: %v", events)
+	}
+	// Cleanup runs before the scripts, so the archive is already gone.
+	if _, err := os.Stat(filepath.Join(outDir, "movie.zip")); !os.IsNotExist(err) {
+		t.Fatalf("archive not removed: %v", err)
+	}
+}
+
+func TestRunnerHookFailureRetriesOnlyHooks(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/a.zip", OutDir: outDir, Name: "a.zip", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkHooksPending(ctx, id); err != nil {
+		t.Fatalf("mark hooks pending: %v", err)
+	}
+	marker := filepath.Join(t.TempDir(), "ok")
+	dec := &fakeArchiveDecryptor{attempted: true}
+	runner := &Runner{
+		Store:            store,
+		ArchiveDecryptor: dec,
+		GetAutoDecrypt:   func() bool { return true },
+		HookScripts:      []string{writeScript(t, t.TempDir(), "library.sh", "test -f "+marker+" || { echo 'library offline'; exit 3; }\n", 0o755)},
+		GetHookPolicy:    func() (time.Duration, bool) { return 0, true },
+	}
+	runner.runHooks(ctx, id)
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDecryptFail || job.ErrorCode.String != "hook_failed" {
+		t.Fatalf("status/code = %s/%s", job.Status, job.ErrorCode.String)
+	}
+
+	svc := NewService(store, &fakeDownloader{}, nil)
+	if err := svc.Retry(ctx, id); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.dispatchCompletedDecrypt(ctx); err != nil {
+		t.Fatalf("dispatchCompletedDecrypt: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		job, _ := store.GetJob(ctx, id)
+		return job.Status == StatusCompleted
+	})
+	if called, _, _, _ := dec.snapshot(); called {
+		t.Fatalf("hook retry extracted the archive again")
+	}
+	events, _ := store.ListEvents(ctx, id, 20)
+	if !eventsContain(events, "hook library.sh: library offline") || !eventsContain(events, "retry hooks queued") {
+		t.Fatalf("events = %v", events)
+	}
+}
@@ -10,18 +10,36 @@ import (
 	"strings"
 
 	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
 )
 
 var (
 	ErrDownloaderNotConfigured = errors.New("downloader_not_configured")
 	ErrMissingEngineGID        = errors.New("missing_engine_gid")
 	ErrActionNotAllowed        = errors.New("action_not_allowed")
+	ErrInvalidMove             = errors.New("invalid_move")
+	ErrPackageNotFound         = errors.New("package_not_found")
+	ErrUnknownEngine           = errors.New("unknown_engine")
+	ErrInvalidLimit            = errors.New("invalid_limit")
+	ErrInvalidTorrent          = errors.New("invalid_torrent")
+	ErrInvalidChecksum         = errors.New("invalid_checksum")
+	ErrUnknownSite             = errors.New("unknown_site")
+)
+
+// Queue move targets accepted by Service.Move.
+const (
+	MoveTop    = "top"
+	MoveBottom = "bottom"
+	MoveBefore = "before"
 )
 
 type Service struct {
 	store        *Store
 	downloader   Downloader
+	engines      map[string]Downloader
 	allowedRoots []string
+	torrentDir   string
+	sites        *resolver.Registry
 }
 
 func NewService(store *Store, dl Downloader, allowedRoots []string) *Service {
@@ -30,37 +48,161 @@ func NewService(store *Store, dl Downloader, allowedRoots []string) *Service {
 	return &Service{store: store, downloader: dl, allowedRoots: roots}
 }
 
-func (s *Service) CreateJob(ctx context.Context, url, outDir, name, site, archivePassword string, maxAttempts int) (int64, error) {
+// RegisterEngine makes an additional download engine available for job
+// actions. The downloader passed to NewService serves aria2.
+func (s *Service) RegisterEngine(name string, dl Downloader) {
+	if s.engines == nil {
+		s.engines = map[string]Downloader{}
+	}
+	s.engines[name] = dl
+}
+
+// SetTorrentDir sets where uploaded .torrent files are kept; without it
+// uploads are rejected.
+func (s *Service) SetTorrentDir(dir string) {
+	s.torrentDir = dir
+}
+
+// SetSites sets the registry that --site values are checked against; without
+// it any site is accepted and fails later at resolve time.
+func (s *Service) SetSites(reg *resolver.Registry) {
+	s.sites = reg
+}
+
+// engineFor returns the downloader running a job, or nil if none is configured.
+func (s *Service) engineFor(job *Job) Downloader {
+	if job.Engine == "" || job.Engine == EngineAria2 {
+		return s.downloader
+	}
+	if dl, ok := s.engines[job.Engine]; ok {
+		return dl
+	}
+	return nil
+}
+
+// JobRequest describes a job to add to the queue.
+type JobRequest struct {
+	URL             string
+	OutDir          string
+	Name            string
+	Site            string
+	ArchivePassword string
+	MaxAttempts     int
+	Priority        int
+	PackageID       int64
+	Engine          string
+	// MaxDownloadLimit caps the job's speed in bytes per second (0 = none).
+	MaxDownloadLimit int64
+	// Torrent is an uploaded .torrent file, used instead of URL.
+	Torrent []byte
+	// Checksum is the expected hash of the file as type:hex, verified once
+	// the download completes.
+	Checksum string
+}
+
+func (s *Service) CreateJob(ctx context.Context, req JobRequest) (int64, error) {
+	maxAttempts := req.MaxAttempts
 	if maxAttempts <= 0 {
 		maxAttempts = 5
 	}
-	cleanOut, err := cleanOutDir(outDir, s.allowedRoots)
+	// Without out_dir the output rules pick the location once the URL is
+	// resolved; that needs at least one rule.
+	var cleanOut string
+	var err error
+	useRules := false
+	if req.OutDir == "" {
+		if useRules, err = s.store.HasRules(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if !useRules {
+		if cleanOut, err = cleanOutDir(req.OutDir, s.allowedRoots); err != nil {
+			return 0, err
+		}
+	}
+	cleanName, err := cleanUserFilename(req.Name)
 	if err != nil {
 		return 0, err
 	}
-	cleanName, err := cleanUserFilename(name)
+	engine := strings.ToLower(strings.TrimSpace(req.Engine))
+	if !ValidEngine(engine) {
+		return 0, ErrUnknownEngine
+	}
+	if req.MaxDownloadLimit < 0 {
+		return 0, ErrInvalidLimit
+	}
+	req.Site = strings.ToLower(strings.TrimSpace(req.Site))
+	if req.Site != "" && s.sites != nil && !s.sites.HasSite(req.Site) {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownSite, req.Site)
+	}
+	checksum, err := normalizeChecksum(req.Checksum)
 	if err != nil {
 		return 0, err
 	}
-	archivePassword = strings.TrimSpace(archivePassword)
-	job := &Job{URL: url, OutDir: cleanOut, Name: cleanName, Site: site, MaxAttempts: maxAttempts}
+	if checksum != "" && (IsMegaJob(req.Site, req.URL) || IsTorrentJob(req.Site, req.URL) || len(req.Torrent) > 0) {
+		return 0, fmt.Errorf("%w: not supported for MEGA and torrent jobs", ErrInvalidChecksum)
+	}
+	if req.PackageID > 0 {
+		pkg, err := s.store.GetPackage(ctx, req.PackageID)
+		if err != nil || pkg.DeletedAt.Valid {
+			return 0, ErrPackageNotFound
+		}
+	}
+	if len(req.Torrent) > 0 {
+		if s.torrentDir == "" {
+			return 0, fmt.Errorf("%w: torrent uploads are not enabled", ErrInvalidTorrent)
+		}
+		torrentURL, err := resolver.SaveTorrent(s.torrentDir, req.Torrent)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidTorrent, err)
+		}
+		req.URL = torrentURL
+	}
+	archivePassword := strings.TrimSpace(req.ArchivePassword)
+	job := &Job{URL: req.URL, OutDir: cleanOut, Name: cleanName, Site: req.Site, MaxAttempts: maxAttempts, Priority: req.Priority, MaxDownloadLimit: req.MaxDownloadLimit}
 	if archivePassword != "" {
 		job.ArchivePassword = sqlNullString(archivePassword)
 	}
+	if req.PackageID > 0 {
+		job.PackageID = sql.NullInt64{Int64: req.PackageID, Valid: true}
+	}
+	if engine != "" {
+		job.RequestedEngine = sqlNullString(engine)
+	}
+	job.Checksum = sqlNullString(checksum)
 	id, err := s.store.CreateJob(ctx, job)
 	if err != nil {
 		return 0, err
 	}
-	msg := "added url=" + redactURLForLog(url) + " out=" + cleanOut
+	out := cleanOut
+	if useRules {
+		out = "(rules)"
+	}
+	msg := "added url=" + redactURLForLog(req.URL) + " out=" + out
 	if cleanName != "" {
 		msg += " name=" + cleanName
 	}
-	if site != "" {
-		msg += " site=" + site
+	if req.Site != "" {
+		msg += " site=" + req.Site
 	}
 	if archivePassword != "" {
 		msg += " archive_password=***"
 	}
+	if req.Priority != 0 {
+		msg += " priority=" + strconv.Itoa(req.Priority)
+	}
+	if req.PackageID > 0 {
+		msg += " package=" + strconv.FormatInt(req.PackageID, 10)
+	}
+	if engine != "" {
+		msg += " engine=" + engine
+	}
+	if req.MaxDownloadLimit > 0 {
+		msg += " max_download_limit=" + strconv.FormatInt(req.MaxDownloadLimit, 10)
+	}
+	if checksum != "" {
+		msg += " checksum=" + checksum
+	}
 	msg += " max_attempts=" + strconv.Itoa(maxAttempts)
 	_ = s.store.AddEvent(ctx, id, "info", msg)
 	return id, nil
@@ -112,10 +254,13 @@ func (s *Service) Retry(ctx context.Context, id int64) error {
 		if err := s.store.MarkDecryptingRetry(ctx, id, job.BytesDone); err != nil {
 			return err
 		}
+		if job.HooksPending {
+			return s.store.AddEvent(ctx, id, "info", "retry hooks queued")
+		}
 		return s.store.AddEvent(ctx, id, "info", "retry decrypt queued")
 	}
-	if job.EngineGID.Valid && s.downloader != nil {
-		if err := s.removeEngineTask(ctx, job.EngineGID.String); err != nil {
+	if job.EngineGID.Valid && s.engineFor(job) != nil {
+		if err := s.removeEngineTask(ctx, job); err != nil {
 			return err
 		}
 	}
@@ -130,8 +275,8 @@ func (s *Service) Remove(ctx context.Context, id int64) error {
 	if err != nil {
 		return err
 	}
-	if job.EngineGID.Valid && s.downloader != nil {
-		if err := s.removeEngineTask(ctx, job.EngineGID.String); err != nil {
+	if job.EngineGID.Valid && s.engineFor(job) != nil {
+		if err := s.removeEngineTask(ctx, job); err != nil {
 			return err
 		}
 	}
@@ -155,19 +300,21 @@ func (s *Service) Pause(ctx context.Context, id int64) error {
 		return err
 	}
 	eventMessage := DisplayStatus(StatusPaused, job.Site, job.URL)
-	if job.Status == StatusQueued || job.Status == StatusResolving {
+	waitingToStart := job.Status == StatusWaitingSpace && nullString(job.ResumeStatus) != StatusDecrypting
+	if job.Status == StatusQueued || job.Status == StatusResolving || waitingToStart {
 		if err := s.store.MarkPaused(ctx, id); err != nil {
 			return err
 		}
 		return s.store.AddEvent(ctx, id, "info", eventMessage)
 	}
-	if s.downloader == nil {
+	dl := s.engineFor(job)
+	if dl == nil {
 		return ErrDownloaderNotConfigured
 	}
 	if !job.EngineGID.Valid {
 		return ErrMissingEngineGID
 	}
-	if err := s.downloader.Pause(ctx, job.EngineGID.String); err != nil {
+	if err := dl.Pause(ctx, job.EngineGID.String); err != nil {
 		if errors.Is(err, downloadclient.ErrActionNotAllowed) {
 			return fmt.Errorf("%w: %v", ErrActionNotAllowed, err)
 		}
@@ -185,8 +332,8 @@ func (s *Service) Resume(ctx context.Context, id int64) error {
 		return err
 	}
 	if IsWebshareJob(job.Site, job.URL) {
-		if job.EngineGID.Valid && s.downloader != nil {
-			if err := s.removeEngineTask(ctx, job.EngineGID.String); err != nil {
+		if job.EngineGID.Valid && s.engineFor(job) != nil {
+			if err := s.removeEngineTask(ctx, job); err != nil {
 				return err
 			}
 		}
@@ -195,7 +342,8 @@ func (s *Service) Resume(ctx context.Context, id int64) error {
 		}
 		return s.store.AddEvent(ctx, id, "info", "resume requeued")
 	}
-	if s.downloader == nil {
+	dl := s.engineFor(job)
+	if dl == nil {
 		return ErrDownloaderNotConfigured
 	}
 	if !job.EngineGID.Valid {
@@ -204,7 +352,7 @@ func (s *Service) Resume(ctx context.Context, id int64) error {
 		}
 		return s.store.AddEvent(ctx, id, "info", "resume requeued")
 	}
-	if err := s.downloader.Unpause(ctx, job.EngineGID.String); err != nil {
+	if err := dl.Unpause(ctx, job.EngineGID.String); err != nil {
 		if errors.Is(err, downloadclient.ErrGIDNotFound) {
 			if err := s.store.Requeue(ctx, id); err != nil {
 				return err
@@ -222,11 +370,98 @@ func (s *Service) Resume(ctx context.Context, id int64) error {
 	return s.store.AddEvent(ctx, id, "info", "resumed")
 }
 
-func (s *Service) removeEngineTask(ctx context.Context, gid string) error {
-	if s.downloader == nil || strings.TrimSpace(gid) == "" {
+// Move reorders a queued job. Ordering applies within a priority level; a
+// higher priority job is still claimed before a lower priority one.
+func (s *Service) Move(ctx context.Context, id int64, to string, beforeID int64) error {
+	job, err := s.store.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.DeletedAt.Valid || job.Status != StatusQueued {
+		return ErrActionNotAllowed
+	}
+	var eventMessage string
+	switch to {
+	case MoveTop:
+		err = s.store.MoveToTop(ctx, id)
+		eventMessage = "moved to top of queue"
+	case MoveBottom:
+		err = s.store.MoveToBottom(ctx, id)
+		eventMessage = "moved to bottom of queue"
+	case MoveBefore:
+		if beforeID <= 0 || beforeID == id {
+			return ErrInvalidMove
+		}
+		before, getErr := s.store.GetJob(ctx, beforeID)
+		if getErr != nil {
+			return getErr
+		}
+		if before.DeletedAt.Valid || before.Status != StatusQueued {
+			return ErrActionNotAllowed
+		}
+		err = s.store.MoveBefore(ctx, id, beforeID)
+		eventMessage = "moved before job " + strconv.FormatInt(beforeID, 10)
+	default:
+		return ErrInvalidMove
+	}
+	if err != nil {
+		return err
+	}
+	return s.store.AddEvent(ctx, id, "info", eventMessage)
+}
+
+func (s *Service) SetPriority(ctx context.Context, id int64, priority int) error {
+	job, err := s.store.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.DeletedAt.Valid {
+		return ErrActionNotAllowed
+	}
+	if err := s.store.SetPriority(ctx, id, priority); err != nil {
+		return err
+	}
+	return s.store.AddEvent(ctx, id, "info", "priority set to "+strconv.Itoa(priority))
+}
+
+// SetDownloadLimit changes the speed cap of a job in bytes per second; 0
+// removes it. A running transfer is updated in place.
+func (s *Service) SetDownloadLimit(ctx context.Context, id int64, limit int64) error {
+	if limit < 0 {
+		return ErrInvalidLimit
+	}
+	job, err := s.store.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.DeletedAt.Valid {
+		return ErrActionNotAllowed
+	}
+	if err := s.store.SetDownloadLimit(ctx, id, limit); err != nil {
+		return err
+	}
+	if job.EngineGID.Valid && (job.Status == StatusDownloading || job.Status == StatusPaused) {
+		if changer, ok := s.engineFor(job).(OptionChanger); ok {
+			opts := map[string]string{"max-download-limit": strconv.FormatInt(limit, 10)}
+			if err := changer.ChangeOption(ctx, job.EngineGID.String, opts); err != nil && !errors.Is(err, downloadclient.ErrGIDNotFound) {
+				return err
+			}
+		}
+	}
+	msg := "download limit removed"
+	if limit > 0 {
+		msg = "download limit set to " + strconv.FormatInt(limit, 10) + " B/s"
+	}
+	return s.store.AddEvent(ctx, id, "info", msg)
+}
+
+func (s *Service) removeEngineTask(ctx context.Context, job *Job) error {
+	dl := s.engineFor(job)
+	gid := strings.TrimSpace(job.EngineGID.String)
+	if dl == nil || gid == "" {
 		return nil
 	}
-	if err := s.downloader.Remove(ctx, gid); err != nil {
+	if err := dl.Remove(ctx, gid); err != nil {
 		if errors.Is(err, downloadclient.ErrGIDNotFound) {
 			return nil
 		}
@@ -240,34 +475,43 @@ func (s *Service) removeEngineTask(ctx context.Context, gid string) error {
 
 // JobView is a light view for API/CLI.
 type JobView struct {
-	ID            int64  `json:"id"`
-	URL           string `json:"url"`
-	Site          string `json:"site"`
-	OutDir        string `json:"out_dir"`
-	Name          string `json:"name"`
-	Status        string `json:"status"`
-	Filename      string `json:"filename,omitempty"`
-	SizeBytes     int64  `json:"size_bytes,omitempty"`
-	BytesDone     int64  `json:"bytes_done"`
-	DownloadSpeed int64  `json:"download_speed"`
-	EtaSeconds    int64  `json:"eta_seconds"`
-	Error         string `json:"error,omitempty"`
-	ErrorCode     string `json:"error_code,omitempty"`
-	CreatedAt     string `json:"created_at"`
-	UpdatedAt     string `json:"updated_at"`
+	ID               int64  `json:"id"`
+	URL              string `json:"url"`
+	Site             string `json:"site"`
+	OutDir           string `json:"out_dir"`
+	Name             string `json:"name"`
+	Status           string `json:"status"`
+	Filename         string `json:"filename,omitempty"`
+	SizeBytes        int64  `json:"size_bytes,omitempty"`
+	BytesDone        int64  `json:"bytes_done"`
+	DownloadSpeed    int64  `json:"download_speed"`
+	EtaSeconds       int64  `json:"eta_seconds"`
+	Priority         int    `json:"priority"`
+	Position         int64  `json:"position"`
+	PackageID        int64  `json:"package_id,omitempty"`
+	Engine           string `json:"engine,omitempty"`
+	MaxDownloadLimit int64  `json:"max_download_limit,omitempty"`
+	Checksum         string `json:"checksum,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorCode        string `json:"error_code,omitempty"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
 }
 
 func toView(j Job) JobView {
 	v := JobView{
-		ID:        j.ID,
-		URL:       j.URL,
-		Site:      j.Site,
-		OutDir:    j.OutDir,
-		Name:      j.Name,
-		Status:    j.Status,
-		BytesDone: j.BytesDone,
-		CreatedAt: j.CreatedAt,
-		UpdatedAt: j.UpdatedAt,
+		ID:               j.ID,
+		URL:              j.URL,
+		Site:             j.Site,
+		OutDir:           j.OutDir,
+		Name:             j.Name,
+		Status:           j.Status,
+		BytesDone:        j.BytesDone,
+		Priority:         j.Priority,
+		Position:         j.Position,
+		MaxDownloadLimit: j.MaxDownloadLimit,
+		CreatedAt:        j.CreatedAt,
+		UpdatedAt:        j.UpdatedAt,
 	}
 	if j.Filename.Valid {
 		v.Filename = j.Filename.String
@@ -275,6 +519,9 @@ func toView(j Job) JobView {
 	if j.SizeBytes.Valid {
 		v.SizeBytes = j.SizeBytes.Int64
 	}
+	if j.Checksum.Valid {
+		v.Checksum = j.Checksum.String
+	}
 	if j.Error.Valid {
 		v.Error = j.Error.String
 	}
@@ -284,6 +531,14 @@ func toView(j Job) JobView {
 	if j.DownloadSpeed.Valid {
 		v.DownloadSpeed = j.DownloadSpeed.Int64
 	}
+	if j.PackageID.Valid {
+		v.PackageID = j.PackageID.Int64
+	}
+	if j.EngineGID.Valid {
+		v.Engine = j.Engine
+	} else if j.RequestedEngine.Valid {
+		v.Engine = j.RequestedEngine.String
+	}
 	if j.EtaSeconds.Valid {
 		v.EtaSeconds = j.EtaSeconds.Int64
 	}
@@ -291,7 +546,7 @@ func toView(j Job) JobView {
 }
 
 var _ interface {
-	CreateJob(context.Context, string, string, string, string, string, int) (int64, error)
+	CreateJob(context.Context, JobRequest) (int64, error)
 	ListJobs(context.Context, string, bool) ([]JobView, error)
 	GetJob(context.Context, int64) (*JobView, error)
 	ListEvents(context.Context, int64, int) ([]string, error)
@@ -301,6 +556,15 @@ var _ interface {
 	Purge(context.Context) error
 	Pause(context.Context, int64) error
 	Resume(context.Context, int64) error
+	Move(context.Context, int64, string, int64) error
+	SetPriority(context.Context, int64, int) error
+	CreatePackage(context.Context, string) (int64, error)
+	ListPackages(context.Context) ([]PackageView, error)
+	GetPackage(context.Context, int64) (*PackageView, error)
+	PausePackage(context.Context, int64) error
+	ResumePackage(context.Context, int64) error
+	RetryPackage(context.Context, int64) error
+	RemovePackage(context.Context, int64) error
 } = (*Service)(nil)
 
 func sqlNullString(v string) sql.NullString {
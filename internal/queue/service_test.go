@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
 )
 
 type serviceTestDownloader struct {
@@ -17,6 +18,16 @@ type serviceTestDownloader struct {
 	pauseHits   int
 	unpauseHits int
 	removeHits  int
+	options     map[string]string
+}
+
+func (d *serviceTestDownloader) ChangeOption(ctx context.Context, gid string, options map[string]string) error {
+	d.options = options
+	return nil
+}
+
+func (d *serviceTestDownloader) ChangeGlobalOption(ctx context.Context, options map[string]string) error {
+	return nil
 }
 
 func (d *serviceTestDownloader) AddURI(ctx context.Context, uri string, options map[string]string) (string, error) {
@@ -220,7 +231,14 @@ func TestServiceCreateJobStoresArchivePassword(t *testing.T) {
 	ctx := context.Background()
 	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
 
-	id, err := svc.CreateJob(ctx, "https://example.com/file", "/data", "file.zip", "http", "pw-123", 2)
+	id, err := svc.CreateJob(ctx, JobRequest{
+		URL:             "https://example.com/file",
+		OutDir:          "/data",
+		Name:            "file.zip",
+		Site:            "http",
+		ArchivePassword: "pw-123",
+		MaxAttempts:     2,
+	})
 	if err != nil {
 		t.Fatalf("create job: %v", err)
 	}
@@ -249,12 +267,70 @@ func TestServiceCreateJobStoresArchivePassword(t *testing.T) {
 	}
 }
 
+func TestServiceCreateJobSavesUploadedTorrent(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
+	torrent := []byte("d4:infod6:lengthi7e4:name5:x.isoee")
+
+	if _, err := svc.CreateJob(ctx, JobRequest{OutDir: "/data", Torrent: torrent}); !errors.Is(err, ErrInvalidTorrent) {
+		t.Fatalf("upload without torrent dir: err = %v, want ErrInvalidTorrent", err)
+	}
+	dir := t.TempDir()
+	svc.SetTorrentDir(dir)
+	if _, err := svc.CreateJob(ctx, JobRequest{OutDir: "/data", Torrent: []byte("junk")}); !errors.Is(err, ErrInvalidTorrent) {
+		t.Fatalf("junk upload: err = %v, want ErrInvalidTorrent", err)
+	}
+	id, err := svc.CreateJob(ctx, JobRequest{OutDir: "/data", Torrent: torrent})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if !strings.HasPrefix(job.URL, "file://"+dir+"/") || !strings.HasSuffix(job.URL, ".torrent") {
+		t.Fatalf("url = %q", job.URL)
+	}
+	if got := JobSite(job.Site, job.URL); got != "torrent" {
+		t.Fatalf("site = %q", got)
+	}
+}
+
+func TestServiceCreateJobStoresChecksum(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
+	digest := strings.Repeat("ab", 32)
+
+	if _, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/x.iso", OutDir: "/data", Checksum: "sha256:abc"}); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("short digest: err = %v, want ErrInvalidChecksum", err)
+	}
+	id, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/x.iso", OutDir: "/data", Checksum: "SHA256:" + strings.ToUpper(digest)})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	view, err := svc.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if view.Checksum != "sha256:"+digest {
+		t.Fatalf("checksum = %q", view.Checksum)
+	}
+}
+
 func TestServiceCreateJobRedactsURLFragmentInEvents(t *testing.T) {
 	store := newTestStore(t)
 	ctx := context.Background()
 	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
 
-	id, err := svc.CreateJob(ctx, "https://mega.nz/file/AbCdEf12#super-secret-key", "/data", "file.bin", "mega", "", 2)
+	id, err := svc.CreateJob(ctx, JobRequest{
+		URL:         "https://mega.nz/file/AbCdEf12#super-secret-key",
+		OutDir:      "/data",
+		Name:        "file.bin",
+		Site:        "mega",
+		MaxAttempts: 2,
+	})
 	if err != nil {
 		t.Fatalf("create job: %v", err)
 	}
@@ -379,3 +455,82 @@ func TestServiceRetryDecryptFailedQueuesDecryptOnly(t *testing.T) {
 		t.Fatalf("expected retry decrypt queued event, got %v", events)
 	}
 }
+
+func TestServiceSetDownloadLimitUpdatesRunningTransfer(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/file", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.MarkDownloading(ctx, id, "aria2", "gid-1"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	dl := &serviceTestDownloader{}
+	svc := NewService(store, dl, []string{"/data"})
+	if err := svc.SetDownloadLimit(ctx, id, 2048); err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+	if dl.options["max-download-limit"] != "2048" {
+		t.Fatalf("expected running transfer limit 2048, got %v", dl.options)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.MaxDownloadLimit != 2048 {
+		t.Fatalf("expected stored limit 2048, got %d", job.MaxDownloadLimit)
+	}
+	if err := svc.SetDownloadLimit(ctx, id, -1); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("expected ErrInvalidLimit, got %v", err)
+	}
+}
+
+func TestServiceMoveRequiresQueuedJobs(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/file", OutDir: "/data", MaxAttempts: 1})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := store.MarkDownloading(ctx, ids[0], "aria2", "gid-1"); err != nil {
+		t.Fatalf("mark downloading: %v", err)
+	}
+	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
+	if err := svc.Move(ctx, ids[0], MoveTop, 0); !errors.Is(err, ErrActionNotAllowed) {
+		t.Fatalf("move downloading job = %v, want ErrActionNotAllowed", err)
+	}
+	if err := svc.Move(ctx, ids[2], MoveBefore, ids[0]); !errors.Is(err, ErrActionNotAllowed) {
+		t.Fatalf("move before downloading job = %v, want ErrActionNotAllowed", err)
+	}
+	if err := svc.Move(ctx, ids[2], MoveBefore, ids[1]); err != nil {
+		t.Fatalf("move queued job: %v", err)
+	}
+	jobs, err := store.ListJobs(ctx, StatusQueued, false)
+	if err != nil || len(jobs) != 2 || jobs[0].ID != ids[2] || jobs[1].ID != ids[1] {
+		t.Fatalf("queued jobs = %+v, %v; want %d before %d", jobs, err, ids[2], ids[1])
+	}
+}
+
+func TestServiceCreateJobRejectsUnknownSite(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, &serviceTestDownloader{}, []string{"/data"})
+	reg := resolver.NewRegistry()
+	reg.RegisterSite("webshare", &fakeResolver{})
+	svc.SetSites(reg)
+	if _, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a", OutDir: "/data", Site: "webshrae"}); !errors.Is(err, ErrUnknownSite) {
+		t.Fatalf("CreateJob with a typo site = %v, want ErrUnknownSite", err)
+	}
+	id, err := svc.CreateJob(ctx, JobRequest{URL: "https://example.com/a", OutDir: "/data", Site: " WebShare "})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if job, _ := store.GetJob(ctx, id); job.Site != "webshare" {
+		t.Fatalf("site = %q, want webshare", job.Site)
+	}
+}
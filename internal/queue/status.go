@@ -42,6 +42,33 @@ func IsMegaJob(site, rawURL string) bool {
 	return strings.Contains(lower, "mega.nz") || strings.Contains(lower, "mega.co.nz")
 }
 
+// IsTorrentJob detects BitTorrent either from explicit site or from a magnet
+// link or .torrent URL.
+func IsTorrentJob(site, rawURL string) bool {
+	if strings.EqualFold(strings.TrimSpace(site), "torrent") {
+		return true
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, "magnet") || strings.HasSuffix(strings.ToLower(u.Path), ".torrent")
+}
+
+// IsMetalinkJob detects Metalink either from explicit site or from a .meta4
+// or .metalink URL.
+func IsMetalinkJob(site, rawURL string) bool {
+	if strings.EqualFold(strings.TrimSpace(site), "metalink") {
+		return true
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return false
+	}
+	p := strings.ToLower(u.Path)
+	return strings.HasSuffix(p, ".meta4") || strings.HasSuffix(p, ".metalink")
+}
+
 // DisplayStatus maps internal paused state to user-facing stopped label for webshare jobs.
 func DisplayStatus(status, site, rawURL string) string {
 	if status == StatusPaused && IsWebshareJob(site, rawURL) {
@@ -49,3 +76,32 @@ func DisplayStatus(status, site, rawURL string) string {
 	}
 	return status
 }
+
+// JobSite names the site a job belongs to: the explicit site if set, otherwise
+// webshare, mega, torrent, metalink or http detected from the URL.
+func JobSite(site, rawURL string) string {
+	if site = strings.ToLower(strings.TrimSpace(site)); site != "" {
+		return site
+	}
+	switch {
+	case IsWebshareJob("", rawURL):
+		return "webshare"
+	case IsMegaJob("", rawURL):
+		return "mega"
+	case IsTorrentJob("", rawURL):
+		return "torrent"
+	case IsMetalinkJob("", rawURL):
+		return "metalink"
+	default:
+		return "http"
+	}
+}
+
+// JobHost returns the lowercased host of a job URL, or "" if it has none.
+func JobHost(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
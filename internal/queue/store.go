@@ -3,7 +3,6 @@ package queue
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -11,60 +10,123 @@ import (
 )
 
 const (
-	StatusQueued      = "queued"
-	StatusResolving   = "resolving"
-	StatusDownloading = "downloading"
-	StatusPaused      = "paused"
-	StatusDecrypting  = "decrypting"
-	StatusDecryptFail = "decrypt_failed"
-	StatusCompleted   = "completed"
-	StatusFailed      = "failed"
-	StatusDeleted     = "deleted"
+	StatusQueued       = "queued"
+	StatusResolving    = "resolving"
+	StatusDownloading  = "downloading"
+	StatusPaused       = "paused"
+	StatusWaitingSpace = "waiting_space"
+	StatusVerifying    = "verifying"
+	StatusDecrypting   = "decrypting"
+	StatusDecryptFail  = "decrypt_failed"
+	StatusCompleted    = "completed"
+	StatusFailed       = "failed"
+	StatusDeleted      = "deleted"
 )
 
+// liveStatuses lists every status a job that has not been deleted can have.
+var liveStatuses = []string{
+	StatusQueued, StatusResolving, StatusDownloading, StatusPaused, StatusWaitingSpace,
+	StatusVerifying, StatusDecrypting, StatusCompleted, StatusFailed, StatusDecryptFail,
+}
+
 type Job struct {
-	ID              int64
-	URL             string
-	Site            string
-	OutDir          string
-	Name            string
-	ArchivePassword sql.NullString
-	ResolvedURL     sql.NullString
-	Filename        sql.NullString
-	SizeBytes       sql.NullInt64
-	BytesDone       int64
-	DownloadSpeed   sql.NullInt64
-	EtaSeconds      sql.NullInt64
-	Status          string
-	Error           sql.NullString
-	ErrorCode       sql.NullString
-	Engine          string
-	EngineGID       sql.NullString
-	Attempts        int
-	MaxAttempts     int
-	NextRetryAt     sql.NullString
-	CreatedAt       string
-	UpdatedAt       string
-	StartedAt       sql.NullString
-	CompletedAt     sql.NullString
-	DeletedAt       sql.NullString
+	ID               int64
+	URL              string
+	Site             string
+	OutDir           string
+	Name             string
+	ArchivePassword  sql.NullString
+	ResolvedURL      sql.NullString
+	Filename         sql.NullString
+	SizeBytes        sql.NullInt64
+	BytesDone        int64
+	DownloadSpeed    sql.NullInt64
+	EtaSeconds       sql.NullInt64
+	Status           string
+	Error            sql.NullString
+	ErrorCode        sql.NullString
+	Engine           string
+	RequestedEngine  sql.NullString
+	EngineGID        sql.NullString
+	Attempts         int
+	MaxAttempts      int
+	NextRetryAt      sql.NullString
+	Priority         int
+	Position         int64
+	PackageID        sql.NullInt64
+	MaxDownloadLimit int64          // bytes per second, 0 = unlimited
+	ResumeStatus     sql.NullString // status to return to when leaving waiting_space
+	Checksum         sql.NullString // expected hash as type:hex
+	HooksPending     bool           // hook scripts still have to run before completion
+	OutputPaths      sql.NullString // newline-separated files the job produced
+	ETag             sql.NullString // remote validators seen by the last resolve
+	LastModified     sql.NullString
+	CreatedAt        string
+	UpdatedAt        string
+	StartedAt        sql.NullString
+	CompletedAt      sql.NullString
+	DeletedAt        sql.NullString
+}
+
+const jobColumns = `id, url, site, out_dir, name, archive_password, resolved_url, filename, size_bytes, bytes_done, download_speed, eta_seconds, status, error, error_code,
+       engine, requested_engine, engine_gid, attempts, max_attempts, next_retry_at, priority, position, package_id, max_download_limit, resume_status, checksum, hooks_pending, output_paths, etag, last_modified, created_at, updated_at, started_at, completed_at, deleted_at`
+
+// queueOrder is the order in which queued jobs are claimed: higher priority
+// first, then by queue position.
+const queueOrder = `priority DESC, position ASC, id ASC`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var j Job
+	err := row.Scan(
+		&j.ID, &j.URL, &j.Site, &j.OutDir, &j.Name, &j.ArchivePassword, &j.ResolvedURL, &j.Filename, &j.SizeBytes, &j.BytesDone, &j.DownloadSpeed, &j.EtaSeconds,
+		&j.Status, &j.Error, &j.ErrorCode, &j.Engine, &j.RequestedEngine, &j.EngineGID, &j.Attempts, &j.MaxAttempts,
+		&j.NextRetryAt, &j.Priority, &j.Position, &j.PackageID, &j.MaxDownloadLimit, &j.ResumeStatus, &j.Checksum, &j.HooksPending, &j.OutputPaths, &j.ETag, &j.LastModified, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.CompletedAt, &j.DeletedAt,
+	)
+	return j, err
 }
 
 // Store wraps DB access for jobs and events.
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	changes changeHub
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// execJob runs a write against one job and tells change subscribers.
+func (s *Store) execJob(ctx context.Context, id int64, query string, args ...any) error {
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	s.jobChanged(id)
+	return nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 func (s *Store) CreateJob(ctx context.Context, j *Job) (int64, error) {
+	id, err := insertJob(ctx, s.db, j)
+	if err == nil {
+		s.jobChanged(id)
+	}
+	return id, err
+}
+
+func insertJob(ctx context.Context, ex execer, j *Job) (int64, error) {
 	now := time.Now().UTC().Format(time.RFC3339)
-	res, err := s.db.ExecContext(ctx, `
-INSERT INTO jobs (url, site, out_dir, name, archive_password, status, created_at, updated_at, max_attempts)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-`, j.URL, j.Site, j.OutDir, j.Name, nullStringValue(j.ArchivePassword), StatusQueued, now, now, j.MaxAttempts)
+	res, err := ex.ExecContext(ctx, `
+INSERT INTO jobs (url, site, out_dir, name, archive_password, status, created_at, updated_at, max_attempts, priority, package_id, requested_engine, max_download_limit, checksum, position)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT MAX(position) FROM jobs), 0) + 1)
+`, j.URL, j.Site, j.OutDir, j.Name, nullStringValue(j.ArchivePassword), StatusQueued, now, now, j.MaxAttempts, j.Priority, nullInt64Value(j.PackageID), nullStringValue(j.RequestedEngine), j.MaxDownloadLimit, nullStringValue(j.Checksum))
 	if err != nil {
 		return 0, err
 	}
@@ -76,28 +138,34 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 }
 
 func (s *Store) GetJob(ctx context.Context, id int64) (*Job, error) {
-	row := s.db.QueryRowContext(ctx, `
-SELECT id, url, site, out_dir, name, archive_password, resolved_url, filename, size_bytes, bytes_done, download_speed, eta_seconds, status, error, error_code,
-       engine, engine_gid, attempts, max_attempts, next_retry_at, created_at, updated_at, started_at, completed_at, deleted_at
-FROM jobs WHERE id = ?
-`, id)
-	var j Job
-	err := row.Scan(
-		&j.ID, &j.URL, &j.Site, &j.OutDir, &j.Name, &j.ArchivePassword, &j.ResolvedURL, &j.Filename, &j.SizeBytes, &j.BytesDone, &j.DownloadSpeed, &j.EtaSeconds,
-		&j.Status, &j.Error, &j.ErrorCode, &j.Engine, &j.EngineGID, &j.Attempts, &j.MaxAttempts,
-		&j.NextRetryAt, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.CompletedAt, &j.DeletedAt,
-	)
+	row := s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	j, err := scanJob(row)
 	if err != nil {
 		return nil, err
 	}
 	return &j, nil
 }
 
+func (s *Store) queryJobs(ctx context.Context, query string, args ...any) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// ListJobs returns jobs in queue order, optionally filtered by status.
 func (s *Store) ListJobs(ctx context.Context, status string, includeDeleted bool) ([]Job, error) {
-	query := `
-SELECT id, url, site, out_dir, name, archive_password, resolved_url, filename, size_bytes, bytes_done, download_speed, eta_seconds, status, error, error_code,
-       engine, engine_gid, attempts, max_attempts, next_retry_at, created_at, updated_at, started_at, completed_at, deleted_at
-FROM jobs`
+	query := `SELECT ` + jobColumns + ` FROM jobs`
 	args := []any{}
 	where := []string{}
 	if !includeDeleted {
@@ -110,33 +178,37 @@ FROM jobs`
 	if len(where) > 0 {
 		query += " WHERE " + strings.Join(where, " AND ")
 	}
-	query += " ORDER BY id DESC"
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	query += " ORDER BY " + queueOrder
+	return s.queryJobs(ctx, query, args...)
+}
+
+// GetJobByEngineGID returns the live job that owns an engine transfer.
+func (s *Store) GetJobByEngineGID(ctx context.Context, gid string) (*Job, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+jobColumns+`
+FROM jobs
+WHERE engine_gid = ? AND deleted_at IS NULL
+ORDER BY id DESC
+LIMIT 1`, gid)
+	j, err := scanJob(row)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var out []Job
-	for rows.Next() {
-		var j Job
-		if err := rows.Scan(
-			&j.ID, &j.URL, &j.Site, &j.OutDir, &j.Name, &j.ArchivePassword, &j.ResolvedURL, &j.Filename, &j.SizeBytes, &j.BytesDone, &j.DownloadSpeed, &j.EtaSeconds,
-			&j.Status, &j.Error, &j.ErrorCode, &j.Engine, &j.EngineGID, &j.Attempts, &j.MaxAttempts,
-			&j.NextRetryAt, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.CompletedAt, &j.DeletedAt,
-		); err != nil {
-			return nil, err
-		}
-		out = append(out, j)
-	}
-	return out, rows.Err()
+	return &j, nil
+}
+
+// CountJobs counts non-deleted jobs in the given status.
+func (s *Store) CountJobs(ctx context.Context, status string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM jobs WHERE status = ? AND deleted_at IS NULL
+`, status).Scan(&n)
+	return n, err
 }
 
 // ListPendingPostprocess returns jobs waiting for post-download processing
 // (MEGA content decrypt and/or archive decrypt).
 func (s *Store) ListPendingPostprocess(ctx context.Context, limit int) ([]Job, error) {
-	query := `
-SELECT id, url, site, out_dir, name, archive_password, resolved_url, filename, size_bytes, bytes_done, download_speed, eta_seconds, status, error, error_code,
-       engine, engine_gid, attempts, max_attempts, next_retry_at, created_at, updated_at, started_at, completed_at, deleted_at
+	query := `SELECT ` + jobColumns + `
 FROM jobs
 WHERE deleted_at IS NULL
   AND (
@@ -149,24 +221,7 @@ ORDER BY id ASC`
 		query += " LIMIT ?"
 		args = append(args, limit)
 	}
-	rows, err := s.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []Job
-	for rows.Next() {
-		var j Job
-		if err := rows.Scan(
-			&j.ID, &j.URL, &j.Site, &j.OutDir, &j.Name, &j.ArchivePassword, &j.ResolvedURL, &j.Filename, &j.SizeBytes, &j.BytesDone, &j.DownloadSpeed, &j.EtaSeconds,
-			&j.Status, &j.Error, &j.ErrorCode, &j.Engine, &j.EngineGID, &j.Attempts, &j.MaxAttempts,
-			&j.NextRetryAt, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.CompletedAt, &j.DeletedAt,
-		); err != nil {
-			return nil, err
-		}
-		out = append(out, j)
-	}
-	return out, rows.Err()
+	return s.queryJobs(ctx, query, args...)
 }
 
 // ListPendingArchiveDecrypt is kept as a compatibility alias.
@@ -181,6 +236,7 @@ INSERT INTO job_events (job_id, level, message, created_at) VALUES (?, ?, ?, ?)
 `, jobID, level, msg, now)
 	if err == nil {
 		log.Printf("job_event id=%d level=%s message=%q", jobID, level, msg)
+		s.eventAdded()
 	}
 	return err
 }
@@ -238,6 +294,12 @@ ORDER BY next_retry_at ASC`
 
 // ClaimNextQueued finds a queued job ready to run and marks it as resolving.
 func (s *Store) ClaimNextQueued(ctx context.Context) (*Job, error) {
+	return s.ClaimNextQueuedFunc(ctx, nil)
+}
+
+// ClaimNextQueuedFunc is like ClaimNextQueued but skips jobs that accept
+// rejects, so a blocked job does not hold up the ones behind it.
+func (s *Store) ClaimNextQueuedFunc(ctx context.Context, accept func(*Job) bool) (*Job, error) {
 	now := time.Now().UTC().Format(time.RFC3339)
 	for {
 		// Use a transaction for a simple claim.
@@ -245,29 +307,39 @@ func (s *Store) ClaimNextQueued(ctx context.Context) (*Job, error) {
 		if err != nil {
 			return nil, err
 		}
-		row := tx.QueryRowContext(ctx, `
-SELECT id, url, site, out_dir, name, archive_password, resolved_url, filename, size_bytes, bytes_done, status, error, error_code,
-       download_speed, eta_seconds, engine, engine_gid, attempts, max_attempts, next_retry_at, created_at, updated_at, started_at, completed_at, deleted_at
+		rows, err := tx.QueryContext(ctx, `SELECT `+jobColumns+`
 FROM jobs
 WHERE status = ? AND deleted_at IS NULL AND (next_retry_at IS NULL OR next_retry_at <= ?)
-ORDER BY id ASC
-LIMIT 1
-`, StatusQueued, now)
-		var j Job
-		if err := row.Scan(
-			&j.ID, &j.URL, &j.Site, &j.OutDir, &j.Name, &j.ArchivePassword, &j.ResolvedURL, &j.Filename, &j.SizeBytes, &j.BytesDone,
-			&j.Status, &j.Error, &j.ErrorCode, &j.DownloadSpeed, &j.EtaSeconds, &j.Engine, &j.EngineGID, &j.Attempts, &j.MaxAttempts,
-			&j.NextRetryAt, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.CompletedAt, &j.DeletedAt,
-		); err != nil {
+ORDER BY `+queueOrder, StatusQueued, now)
+		if err != nil {
 			_ = tx.Rollback()
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, sql.ErrNoRows
+			return nil, err
+		}
+		var picked *Job
+		for rows.Next() {
+			j, err := scanJob(rows)
+			if err != nil {
+				_ = rows.Close()
+				_ = tx.Rollback()
+				return nil, err
+			}
+			if accept == nil || accept(&j) {
+				picked = &j
+				break
 			}
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			_ = tx.Rollback()
 			return nil, err
 		}
+		if picked == nil {
+			_ = tx.Rollback()
+			return nil, sql.ErrNoRows
+		}
 		res, err := tx.ExecContext(ctx, `
 UPDATE jobs SET status = ?, updated_at = ?, started_at = ? WHERE id = ? AND status = ?
-`, StatusResolving, now, now, j.ID, StatusQueued)
+`, StatusResolving, now, now, picked.ID, StatusQueued)
 		if err != nil {
 			_ = tx.Rollback()
 			return nil, err
@@ -284,22 +356,117 @@ UPDATE jobs SET status = ?, updated_at = ?, started_at = ? WHERE id = ? AND stat
 		if err := tx.Commit(); err != nil {
 			return nil, err
 		}
-		j.Status = StatusResolving
-		return &j, nil
+		s.jobChanged(picked.ID)
+		picked.Status = StatusResolving
+		return picked, nil
+	}
+}
+
+// SetPriority changes the claim priority of a job. Higher values are claimed first.
+func (s *Store) SetPriority(ctx context.Context, id int64, priority int) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET priority = ?, updated_at = ? WHERE id = ?
+`, priority, now, id)
+	return err
+}
+
+// MoveToTop places a job ahead of every other job in the queue order.
+func (s *Store) MoveToTop(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET position = COALESCE((SELECT MIN(position) FROM jobs WHERE id <> ?), 1) - 1, updated_at = ? WHERE id = ?
+`, id, now, id)
+	return err
+}
+
+// MoveToBottom places a job behind every other job in the queue order.
+func (s *Store) MoveToBottom(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET position = COALESCE((SELECT MAX(position) FROM jobs WHERE id <> ?), 0) + 1, updated_at = ? WHERE id = ?
+`, id, now, id)
+	return err
+}
+
+// MoveBefore places a job directly ahead of beforeID, shifting later jobs back.
+func (s *Store) MoveBefore(ctx context.Context, id, beforeID int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var target int64
+	if err := tx.QueryRowContext(ctx, `SELECT position FROM jobs WHERE id = ?`, beforeID).Scan(&target); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE jobs SET position = position + 1 WHERE position >= ? AND id <> ?
+`, target, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE jobs SET position = ?, updated_at = ? WHERE id = ?
+`, target, now, id); err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Every job behind the target moved.
+	s.jobChanged(0)
+	return nil
+}
+
+// SetDownloadLimit sets the per-job speed cap in bytes per second (0 = none).
+func (s *Store) SetDownloadLimit(ctx context.Context, id int64, limit int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET max_download_limit = ?, updated_at = ? WHERE id = ?
+`, limit, now, id)
+	return err
 }
 
 func (s *Store) UpdateResolving(ctx context.Context, id int64, resolvedURL, filename string, sizeBytes int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET resolved_url = ?, filename = ?, size_bytes = ?, updated_at = ? WHERE id = ?
 `, resolvedURL, filename, sizeBytes, now, id)
 	return err
 }
 
+// SetOutput stores the output directory and filename picked by a rule.
+func (s *Store) SetOutput(ctx context.Context, id int64, outDir, name string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET out_dir = ?, name = ?, updated_at = ? WHERE id = ?
+`, outDir, name, now, id)
+	return err
+}
+
+// SetValidators records the ETag and Last-Modified the server reported for a
+// job's file, so a later resume can tell whether the file changed.
+func (s *Store) SetValidators(ctx context.Context, id int64, etag, lastModified string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET etag = ?, last_modified = ?, updated_at = ? WHERE id = ?
+`, sqlNullString(etag), sqlNullString(lastModified), now, id)
+	return err
+}
+
+// SetChecksum records the expected hash of a job's file as type:hex.
+func (s *Store) SetChecksum(ctx context.Context, id int64, checksum string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET checksum = ?, updated_at = ? WHERE id = ?
+`, checksum, now, id)
+	return err
+}
+
 func (s *Store) MarkDownloading(ctx context.Context, id int64, engine, gid string) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET status = ?, engine = ?, engine_gid = ?, updated_at = ? WHERE id = ?
 `, StatusDownloading, engine, gid, now, id)
 	return err
@@ -307,15 +474,68 @@ UPDATE jobs SET status = ?, engine = ?, engine_gid = ?, updated_at = ? WHERE id
 
 func (s *Store) MarkPaused(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
-UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET status = ?, queue_held = 0, updated_at = ? WHERE id = ?
+`, StatusPaused, now, id)
+	return err
+}
+
+// MarkHeld pauses a job on behalf of the queue schedule, so it is resumed
+// when the queue opens again. User pauses are never resumed automatically.
+func (s *Store) MarkHeld(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET status = ?, queue_held = 1, download_speed = 0, eta_seconds = NULL, updated_at = ? WHERE id = ?
 `, StatusPaused, now, id)
 	return err
 }
 
+// ListHeld returns paused jobs that were held by the queue schedule.
+func (s *Store) ListHeld(ctx context.Context) ([]Job, error) {
+	return s.queryJobs(ctx, `SELECT `+jobColumns+`
+FROM jobs
+WHERE status = ? AND queue_held = 1 AND deleted_at IS NULL
+ORDER BY `+queueOrder, StatusPaused)
+}
+
+// MarkWaitingSpace parks a job until there is enough free disk space. The job
+// returns to resumeStatus (queued or decrypting) once space frees up.
+func (s *Store) MarkWaitingSpace(ctx context.Context, id int64, resumeStatus, msg string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET status = ?, resume_status = ?, error = ?, download_speed = 0, eta_seconds = NULL, updated_at = ? WHERE id = ?
+`, StatusWaitingSpace, resumeStatus, msg, now, id)
+	return err
+}
+
+// ResumeFromWaitingSpace moves a job out of waiting_space to the status it was
+// parked from.
+func (s *Store) ResumeFromWaitingSpace(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET status = COALESCE(resume_status, ?), resume_status = NULL, error = NULL, updated_at = ?
+WHERE id = ? AND status = ?
+`, StatusQueued, now, id, StatusWaitingSpace)
+	return err
+}
+
+// ListWaitingSpace returns jobs waiting for disk space in queue order.
+func (s *Store) ListWaitingSpace(ctx context.Context) ([]Job, error) {
+	return s.queryJobs(ctx, `SELECT `+jobColumns+`
+FROM jobs
+WHERE status = ? AND deleted_at IS NULL
+ORDER BY `+queueOrder, StatusWaitingSpace)
+}
+
+// ClearHeld drops the queue hold marker without changing the job status.
+func (s *Store) ClearHeld(ctx context.Context, id int64) error {
+	err := s.execJob(ctx, id, `UPDATE jobs SET queue_held = 0 WHERE id = ?`, id)
+	return err
+}
+
 func (s *Store) MarkDownloadingStatus(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?
 `, StatusDownloading, now, id)
 	return err
@@ -323,7 +543,7 @@ UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?
 
 func (s *Store) UpdateProgress(ctx context.Context, id int64, bytesDone int64, status string, speed int64, etaSeconds int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET bytes_done = ?, status = ?, download_speed = ?, eta_seconds = ?, updated_at = ? WHERE id = ?
 `, bytesDone, status, speed, etaSeconds, now, id)
 	return err
@@ -331,7 +551,7 @@ UPDATE jobs SET bytes_done = ?, status = ?, download_speed = ?, eta_seconds = ?,
 
 func (s *Store) MarkCompleted(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?,
     bytes_done = CASE
@@ -342,16 +562,41 @@ SET status = ?,
     eta_seconds = NULL,
     error = NULL,
     error_code = NULL,
+    hooks_pending = 0,
     updated_at = ?,
     completed_at = ?
 WHERE id = ?
 `, StatusCompleted, now, now, id)
+	if err != nil {
+		return err
+	}
+	s.notifyJob(ctx, WebhookCompleted, id, nil)
+	return nil
+}
+
+// MarkVerifying moves a finished download to the checksum verification step.
+func (s *Store) MarkVerifying(ctx context.Context, id int64, bytesDone int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs
+SET status = ?,
+    bytes_done = CASE
+      WHEN ? > 0 THEN ?
+      ELSE bytes_done
+    END,
+    download_speed = 0,
+    eta_seconds = NULL,
+    error = NULL,
+    error_code = NULL,
+    updated_at = ?
+WHERE id = ?
+`, StatusVerifying, bytesDone, bytesDone, now, id)
 	return err
 }
 
 func (s *Store) MarkDecrypting(ctx context.Context, id int64, bytesDone int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?,
     bytes_done = CASE
@@ -368,9 +613,37 @@ WHERE id = ?
 	return err
 }
 
+// MarkHooksPending moves a finished job to decrypting until its hook
+// scripts have run; the flag survives restarts.
+func (s *Store) MarkHooksPending(ctx context.Context, id int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs
+SET status = ?,
+    hooks_pending = 1,
+    download_speed = 0,
+    eta_seconds = NULL,
+    error = NULL,
+    error_code = NULL,
+    updated_at = ?
+WHERE id = ?
+`, StatusDecrypting, now, id)
+	return err
+}
+
+// SetOutputPaths records the files a job produced, e.g. the entries an
+// archive extracted to.
+func (s *Store) SetOutputPaths(ctx context.Context, id int64, paths []string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.execJob(ctx, id, `
+UPDATE jobs SET output_paths = ?, updated_at = ? WHERE id = ?
+`, nullStringValue(sql.NullString{String: strings.Join(paths, "\n"), Valid: len(paths) > 0}), now, id)
+	return err
+}
+
 func (s *Store) MarkDecryptingRetry(ctx context.Context, id int64, bytesDone int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?,
     bytes_done = CASE
@@ -394,7 +667,7 @@ func (s *Store) MarkPostprocessFailed(ctx context.Context, id int64, msg, code s
 	if strings.TrimSpace(code) == "" {
 		code = "archive_decrypt_failed"
 	}
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?,
     error = ?,
@@ -405,12 +678,16 @@ SET status = ?,
     completed_at = COALESCE(completed_at, ?)
 WHERE id = ?
 `, StatusDecryptFail, msg, code, now, now, id)
-	return err
+	if err != nil {
+		return err
+	}
+	s.notifyJob(ctx, WebhookDecryptFailed, id, nil)
+	return nil
 }
 
 func (s *Store) ClearArchivePassword(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET archive_password = NULL, updated_at = ? WHERE id = ?
 `, now, id)
 	return err
@@ -422,7 +699,7 @@ func (s *Store) MarkFailed(ctx context.Context, id int64, code, msg string, next
 	if !nextRetry.IsZero() {
 		retryStr = nextRetry.UTC().Format(time.RFC3339)
 	}
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?, error = ?, error_code = ?, download_speed = 0, eta_seconds = NULL, updated_at = ?, next_retry_at = ?, attempts = attempts + 1
 WHERE id = ?
@@ -442,12 +719,14 @@ WHERE id = ?
 		}
 		_ = s.AddEvent(ctx, id, "error", eventMsg)
 	}
+	willRetry := (maxAttempts <= 0 || attempts < maxAttempts) && !nextRetry.IsZero()
+	s.notifyJob(ctx, WebhookFailed, id, &willRetry)
 	return nil
 }
 
 func (s *Store) Requeue(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs
 SET status = ?,
     error = NULL,
@@ -464,6 +743,8 @@ SET status = ?,
     engine_gid = NULL,
     started_at = NULL,
     completed_at = NULL,
+    hooks_pending = 0,
+    output_paths = NULL,
     updated_at = ?
 WHERE id = ?
 `, StatusQueued, now, id)
@@ -472,7 +753,7 @@ WHERE id = ?
 
 func (s *Store) Remove(ctx context.Context, id int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.ExecContext(ctx, `
+	err := s.execJob(ctx, id, `
 UPDATE jobs SET status = ?, deleted_at = ?, updated_at = ?
 WHERE id = ?
 `, StatusDeleted, now, now, id)
@@ -485,6 +766,9 @@ func (s *Store) ClearCompleted(ctx context.Context) error {
 UPDATE jobs SET status = ?, deleted_at = ?, updated_at = ?
 WHERE status = ?
 `, StatusDeleted, now, now, StatusCompleted)
+	if err == nil {
+		s.jobChanged(0)
+	}
 	return err
 }
 
@@ -500,13 +784,21 @@ func (s *Store) ClearAll(ctx context.Context) error {
 	if _, err := tx.ExecContext(ctx, `DELETE FROM jobs`); err != nil {
 		return err
 	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM packages`); err != nil {
+		return err
+	}
 	var seqName string
 	if err := tx.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type='table' AND name='sqlite_sequence'`).Scan(&seqName); err == nil {
-		if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name IN ('jobs', 'job_events')`); err != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name IN ('jobs', 'job_events', 'packages')`); err != nil {
 			return err
 		}
 	}
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.jobChanged(0)
+	s.eventAdded()
+	return nil
 }
 
 func nullStringValue(v sql.NullString) any {
@@ -515,3 +807,10 @@ func nullStringValue(v sql.NullString) any {
 	}
 	return nil
 }
+
+func nullInt64Value(v sql.NullInt64) any {
+	if v.Valid {
+		return v.Int64
+	}
+	return nil
+}
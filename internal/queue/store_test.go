@@ -288,3 +288,81 @@ func TestListPendingArchiveDecryptAndClear(t *testing.T) {
 		t.Fatalf("expected archive password to be cleared")
 	}
 }
+
+func TestClaimNextQueuedOrdersByPriorityThenPosition(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	first, err := store.CreateJob(ctx, &Job{URL: "https://example.com/1", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	second, err := store.CreateJob(ctx, &Job{URL: "https://example.com/2", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	urgent, err := store.CreateJob(ctx, &Job{URL: "https://example.com/3", OutDir: "/data", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	if err := store.SetPriority(ctx, urgent, 10); err != nil {
+		t.Fatalf("set priority: %v", err)
+	}
+	if err := store.MoveBefore(ctx, second, first); err != nil {
+		t.Fatalf("move before: %v", err)
+	}
+
+	var order []int64
+	for {
+		job, err := store.ClaimNextQueued(ctx)
+		if err != nil {
+			break
+		}
+		order = append(order, job.ID)
+	}
+	want := []int64{urgent, second, first}
+	if len(order) != len(want) {
+		t.Fatalf("expected claim order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected claim order %v, got %v", want, order)
+		}
+	}
+}
+
+func TestStoreMoveToTopAndBottom(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := store.CreateJob(ctx, &Job{URL: "https://example.com/file", OutDir: "/data", MaxAttempts: 1})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := store.MoveToTop(ctx, ids[2]); err != nil {
+		t.Fatalf("move to top: %v", err)
+	}
+	if err := store.MoveToBottom(ctx, ids[0]); err != nil {
+		t.Fatalf("move to bottom: %v", err)
+	}
+	job, err := store.ClaimNextQueued(ctx)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if job.ID != ids[2] {
+		t.Fatalf("expected job %d first, got %d", ids[2], job.ID)
+	}
+	last, err := store.GetJob(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	middle, err := store.GetJob(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if last.Position <= middle.Position {
+		t.Fatalf("expected job %d behind job %d, positions %d <= %d", ids[0], ids[1], last.Position, middle.Position)
+	}
+}
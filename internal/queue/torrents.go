@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	downloadclient "github.com/Witriol/dlq-download-queue/internal/downloader"
+)
+
+// addSeedOptions sets aria2's seeding limits for a torrent. aria2 stops
+// seeding at whichever limit is reached first; seed-ratio 0 means any ratio
+// and seed-time 0 stops right after the download.
+func (r *Runner) addSeedOptions(options map[string]string) {
+	var ratio float64
+	var seedTime time.Duration
+	if r.GetSeedLimits != nil {
+		ratio, seedTime = r.GetSeedLimits()
+	}
+	if ratio <= 0 && seedTime <= 0 {
+		options["seed-time"] = "0"
+		return
+	}
+	options["seed-ratio"] = strconv.FormatFloat(max(ratio, 0), 'f', -1, 64)
+	if seedTime > 0 {
+		options["seed-time"] = strconv.FormatFloat(seedTime.Minutes(), 'f', -1, 64)
+	}
+}
+
+// torrentStatus returns aria2's status of a torrent job's transfer, which
+// lists its files and tells whether it still seeds. It is nil for other jobs
+// and once aria2 no longer knows the transfer.
+func (r *Runner) torrentStatus(ctx context.Context, job Job) (*downloadclient.Status, error) {
+	if !IsTorrentJob(job.Site, job.URL) || !job.EngineGID.Valid {
+		return nil, nil
+	}
+	dl := r.engine(job.Engine)
+	if dl == nil {
+		return nil, nil
+	}
+	st, err := dl.TellStatus(ctx, job.EngineGID.String)
+	if errors.Is(err, downloadclient.ErrGIDNotFound) {
+		return nil, nil
+	}
+	return st, err
+}
+
+// torrentSeeding reports whether st is a torrent aria2 still seeds. Its files
+// must stay in place meanwhile, so extraction, cleanup and hook scripts wait
+// until aria2 reports the transfer complete.
+func torrentSeeding(st *downloadclient.Status) bool {
+	if st == nil || st.Seeder != "true" {
+		return false
+	}
+	switch st.Status {
+	case "active", "waiting", "paused":
+		return true
+	}
+	return false
+}
+
+// followTransfer moves a job to the transfer aria2 started from its current
+// one, such as the torrent download behind a magnet link once the metadata
+// arrived, and takes the torrent's name and size from it when unknown.
+func (r *Runner) followTransfer(ctx context.Context, job Job, gid string) {
+	if err := r.Store.MarkDownloading(ctx, job.ID, job.Engine, gid); err != nil {
+		log.Printf("runner follow transfer error for job %d: %v", job.ID, err)
+		return
+	}
+	msg := "metadata received; following transfer " + gid
+	job.EngineGID = sqlNullString(gid)
+	if dl := r.engine(job.Engine); dl != nil {
+		if st, err := dl.TellStatus(ctx, gid); err == nil {
+			_, total, _, _ := statusProgress(st)
+			name := torrentName(job.OutDir, st)
+			if job.Filename.String != "" {
+				name = job.Filename.String
+			}
+			if job.SizeBytes.Int64 > 0 {
+				total = job.SizeBytes.Int64
+			}
+			if name != job.Filename.String || total != job.SizeBytes.Int64 {
+				_ = r.Store.UpdateResolving(ctx, job.ID, job.ResolvedURL.String, name, total)
+			}
+			selected := 0
+			for _, f := range st.Files {
+				if f.Selected != "false" {
+					selected++
+				}
+			}
+			msg += " (" + strconv.Itoa(selected) + " of " + strconv.Itoa(len(st.Files)) + " files)"
+		}
+	}
+	_ = r.Store.AddEvent(ctx, job.ID, "info", msg)
+	r.syncJob(ctx, job)
+}
+
+// torrentName returns the top-level file or directory a transfer writes
+// under outDir, which for a torrent is its name.
+func torrentName(outDir string, st *downloadclient.Status) string {
+	rel, err := filepath.Rel(outDir, firstStatusPath(st))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	name, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return name
+}
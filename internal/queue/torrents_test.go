@@ -0,0 +1,252 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/downloader"
+	"github.com/Witriol/dlq-download-queue/internal/resolver"
+)
+
+type torrentTargetResolver struct{ target resolver.ResolvedTarget }
+
+func (r *torrentTargetResolver) CanHandle(rawURL string) bool { return true }
+func (r *torrentTargetResolver) Resolve(ctx context.Context, rawURL string) (*resolver.ResolvedTarget, error) {
+	target := r.target
+	return &target, nil
+}
+
+// fakeTorrentDownloader records how transfers were added and answers status
+// per gid.
+type fakeTorrentDownloader struct {
+	fakeDownloader
+	torrent  []byte
+	uri      string
+	options  map[string]string
+	statuses map[string]*downloader.Status
+}
+
+func (d *fakeTorrentDownloader) AddURI(ctx context.Context, uri string, options map[string]string) (string, error) {
+	d.uri, d.options = uri, options
+	return "gid-meta", nil
+}
+func (d *fakeTorrentDownloader) AddTorrent(ctx context.Context, torrent []byte, options map[string]string) (string, error) {
+	d.torrent, d.options = torrent, options
+	return "gid-bt", nil
+}
+func (d *fakeTorrentDownloader) TellStatus(ctx context.Context, gid string) (*downloader.Status, error) {
+	return d.statuses[gid], nil
+}
+
+func TestRunnerStartsTorrentWithSeedLimits(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	id, err := store.CreateJob(ctx, &Job{URL: "file:///state/torrents/x.torrent", OutDir: t.TempDir(), MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	dl := &fakeTorrentDownloader{}
+	reg := resolver.NewRegistry(&torrentTargetResolver{target: resolver.ResolvedTarget{
+		Kind: "aria2", URL: job.URL, Torrent: []byte("d4:infodee"), Filename: "Series", Size: 150,
+	}})
+	runner := &Runner{
+		Store:         store,
+		Resolvers:     reg,
+		Downloader:    dl,
+		GetSeedLimits: func() (float64, time.Duration) { return 1.5, 90 * time.Minute },
+	}
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	if string(dl.torrent) != "d4:infodee" || dl.uri != "" {
+		t.Fatalf("torrent not added with AddTorrent (uri %q)", dl.uri)
+	}
+	if dl.options["seed-ratio"] != "1.5" || dl.options["seed-time"] != "90" {
+		t.Fatalf("options = %v", dl.options)
+	}
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading || job.EngineGID.String != "gid-bt" {
+		t.Fatalf("status/gid = %s/%s", job.Status, job.EngineGID.String)
+	}
+
+	runner.Engines = map[string]Downloader{EngineNative: &fakeDownloader{}}
+	job.RequestedEngine = sqlNullString(EngineNative)
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob native: %v", err)
+	}
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusFailed || job.ErrorCode.String != "unsupported_engine" {
+		t.Fatalf("native torrent: status/code = %s/%s", job.Status, job.ErrorCode.String)
+	}
+}
+
+func TestAddSeedOptions(t *testing.T) {
+	cases := []struct {
+		ratio    float64
+		seedTime time.Duration
+		want     map[string]string
+	}{
+		{0, 0, map[string]string{"seed-time": "0"}},
+		{2, 0, map[string]string{"seed-ratio": "2"}},
+		{0, 30 * time.Minute, map[string]string{"seed-ratio": "0", "seed-time": "30"}},
+	}
+	for _, tc := range cases {
+		r := &Runner{GetSeedLimits: func() (float64, time.Duration) { return tc.ratio, tc.seedTime }}
+		got := map[string]string{}
+		r.addSeedOptions(got)
+		if len(got) != len(tc.want) {
+			t.Fatalf("ratio %v time %v: options = %v, want %v", tc.ratio, tc.seedTime, got, tc.want)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Fatalf("ratio %v time %v: options = %v, want %v", tc.ratio, tc.seedTime, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestRunnerFollowsMagnetMetadataAndCompletesWhenSeeding(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	link := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"
+	id, err := store.CreateJob(ctx, &Job{URL: link, OutDir: outDir, MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job, err := store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	dl := &fakeTorrentDownloader{statuses: map[string]*downloader.Status{}}
+	reg := resolver.NewRegistry(&torrentTargetResolver{target: resolver.ResolvedTarget{Kind: "aria2", URL: link}})
+	runner := &Runner{Store: store, Resolvers: reg, Downloader: dl}
+	if err := runner.startJob(ctx, job, false); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	if dl.uri != link || dl.options["seed-time"] != "0" {
+		t.Fatalf("magnet not added with AddURI: uri %q options %v", dl.uri, dl.options)
+	}
+
+	dl.statuses["gid-meta"] = &downloader.Status{GID: "gid-meta", Status: "complete", TotalLength: "20", CompletedLen: "20", FollowedBy: []string{"gid-bt"}}
+	dl.statuses["gid-bt"] = &downloader.Status{
+		GID: "gid-bt", Status: "active", TotalLength: "150", CompletedLen: "30",
+		Files: []downloader.StatusFile{
+			{Path: filepath.Join(outDir, "Series", "a.bin"), Length: "100", Selected: "true"},
+			{Path: filepath.Join(outDir, "Series", "sub", "b.bin"), Length: "50", Selected: "true"},
+		},
+	}
+	if err := runner.updateActive(ctx); err != nil {
+		t.Fatalf("updateActive: %v", err)
+	}
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusDownloading || job.EngineGID.String != "gid-bt" {
+		t.Fatalf("status/gid = %s/%s, want downloading/gid-bt", job.Status, job.EngineGID.String)
+	}
+	if job.Filename.String != "Series" || job.SizeBytes.Int64 != 150 || job.BytesDone != 30 {
+		t.Fatalf("filename/size/done = %q/%d/%d", job.Filename.String, job.SizeBytes.Int64, job.BytesDone)
+	}
+	events, err := store.ListEvents(ctx, id, 20)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if !strings.Contains(strings.Join(events, "\n"), "following transfer gid-bt (2 of 2 files)") {
+		t.Fatalf("events = %v", events)
+	}
+
+	dl.statuses["gid-bt"].CompletedLen = "150"
+	dl.statuses["gid-bt"].Seeder = "true"
+	if err := runner.updateActive(ctx); err != nil {
+		t.Fatalf("updateActive: %v", err)
+	}
+	job, err = store.GetJob(ctx, id)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Status != StatusCompleted || job.BytesDone != 150 {
+		t.Fatalf("status/done = %s/%d, want completed/150", job.Status, job.BytesDone)
+	}
+}
+
+func TestRunnerHoldsPostprocessWhileSeeding(t *testing.T) {
+	store := newRunnerStore(t)
+	ctx := context.Background()
+	outDir := t.TempDir()
+	dl := &fakeTorrentDownloader{statuses: map[string]*downloader.Status{}}
+	dec := &fakeArchiveDecryptor{attempted: true}
+	runner := &Runner{
+		Store:             store,
+		Downloader:        dl,
+		ArchiveDecryptor:  dec,
+		GetAutoDecrypt:    func() bool { return true },
+		GetArchiveCleanup: func() string { return ArchiveCleanupDelete },
+		HookScripts:       []string{writeScript(t, t.TempDir(), "notify.sh", "echo done\n", 0o755)},
+	}
+	var ids []int64
+	for i, name := range []string{"movie.zip", "episode.mkv"} {
+		link := "magnet:?xt=urn:btih:" + strings.Repeat(string(rune('a'+i)), 40)
+		dir := strings.TrimSuffix(name, filepath.Ext(name))
+		id, err := store.CreateJob(ctx, &Job{URL: link, Site: "torrent", OutDir: outDir, Name: dir, MaxAttempts: 1})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		gid := "gid-" + name
+		if err := store.MarkDownloading(ctx, id, EngineAria2, gid); err != nil {
+			t.Fatalf("mark downloading: %v", err)
+		}
+		dl.statuses[gid] = &downloader.Status{
+			GID: gid, Status: "active", Seeder: "true", TotalLength: "10", CompletedLen: "10",
+			Files: []downloader.StatusFile{{Path: filepath.Join(outDir, dir, name), Length: "10", Selected: "true"}},
+		}
+		ids = append(ids, id)
+	}
+	if err := runner.updateActive(ctx); err != nil {
+		t.Fatalf("updateActive: %v", err)
+	}
+	if err := runner.dispatchCompletedDecrypt(ctx); err != nil {
+		t.Fatalf("dispatchCompletedDecrypt: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i, want := range []string{"postprocess waiting: torrent still seeding", "hooks waiting: torrent still seeding"} {
+		job, _ := store.GetJob(ctx, ids[i])
+		events, _ := store.ListEvents(ctx, ids[i], 20)
+		if job.Status != StatusDecrypting || !eventsContain(events, want) || eventsContain(events, "hook notify.sh started") {
+			t.Fatalf("job %d while seeding: status %s, events %v", ids[i], job.Status, events)
+		}
+	}
+	if called, _, _, _ := dec.snapshot(); called {
+		t.Fatalf("archive extracted while seeding")
+	}
+
+	for _, st := range dl.statuses {
+		st.Status = "complete"
+	}
+	if err := runner.dispatchCompletedDecrypt(ctx); err != nil {
+		t.Fatalf("dispatchCompletedDecrypt: %v", err)
+	}
+	for _, id := range ids {
+		waitFor(t, 2*time.Second, func() bool {
+			job, _ := store.GetJob(ctx, id)
+			return job.Status == StatusCompleted
+		})
+	}
+	if _, archivePath, _, _ := dec.snapshot(); archivePath != filepath.Join(outDir, "movie", "movie.zip") {
+		t.Fatalf("extracted %q", archivePath)
+	}
+}
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WebhookSender posts pending webhook deliveries, retrying failures with
+// exponential backoff.
+type WebhookSender struct {
+	Store       *Store
+	Client      *http.Client
+	PollEvery   time.Duration
+	MaxAttempts int           // attempts before a delivery is given up (default 8)
+	Backoff     time.Duration // delay after the first failure, doubled per attempt (default 30s)
+}
+
+func (w *WebhookSender) Start(ctx context.Context) {
+	if w.PollEvery <= 0 {
+		w.PollEvery = 2 * time.Second
+	}
+	ticker := time.NewTicker(w.PollEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.deliverDue(ctx)
+		}
+	}
+}
+
+func (w *WebhookSender) deliverDue(ctx context.Context) {
+	deliveries, err := w.Store.ListDueDeliveries(ctx, 50)
+	if err != nil {
+		log.Printf("webhook list error: %v", err)
+		return
+	}
+	for _, d := range deliveries {
+		hook, err := w.Store.GetWebhook(ctx, d.WebhookID)
+		if err != nil {
+			continue
+		}
+		code, sendErr := w.send(ctx, hook, d)
+		var next time.Time
+		if sendErr != nil {
+			if d.Attempts+1 < w.maxAttempts() {
+				next = time.Now().Add(w.backoff(d.Attempts + 1))
+			}
+			log.Printf("webhook delivery id=%d webhook=%d event=%s error: %v", d.ID, hook.ID, d.Event, sendErr)
+		}
+		if err := w.Store.RecordDeliveryAttempt(ctx, d.ID, code, sendErr, next); err != nil {
+			log.Printf("webhook record error: %v", err)
+		}
+	}
+}
+
+func (w *WebhookSender) send(ctx context.Context, hook *Webhook, d WebhookDelivery) (int, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dlq-webhook")
+	req.Header.Set("X-DLQ-Event", d.Event)
+	req.Header.Set("X-DLQ-Delivery", strconv.FormatInt(d.ID, 10))
+	if hook.Secret.Valid && hook.Secret.String != "" {
+		req.Header.Set("X-DLQ-Signature", "sha256="+WebhookSignature(hook.Secret.String, body))
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (w *WebhookSender) maxAttempts() int {
+	if w.MaxAttempts > 0 {
+		return w.MaxAttempts
+	}
+	return 8
+}
+
+func (w *WebhookSender) backoff(attempt int) time.Duration {
+	base := w.Backoff
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	d := base << (attempt - 1)
+	if d > time.Hour || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// WebhookSignature returns the hex HMAC-SHA256 of body, sent as
+// "X-DLQ-Signature: sha256=<hex>" so receivers can verify the sender.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,429 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Webhook events.
+const (
+	WebhookCompleted        = "completed"
+	WebhookFailed           = "failed"
+	WebhookDecryptFailed    = "decrypt_failed"
+	WebhookPackageCompleted = "package_completed"
+	WebhookTest             = "test"
+)
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// ValidWebhookEvent reports whether name can be used in a webhook filter.
+func ValidWebhookEvent(name string) bool {
+	switch name {
+	case WebhookCompleted, WebhookFailed, WebhookDecryptFailed, WebhookPackageCompleted:
+		return true
+	}
+	return false
+}
+
+// Webhook is a URL notified about job lifecycle events. An empty Events list
+// subscribes to every event.
+type Webhook struct {
+	ID        int64
+	URL       string
+	Secret    sql.NullString
+	Events    []string
+	CreatedAt string
+	UpdatedAt string
+}
+
+func (w Webhook) wants(event string) bool {
+	if event == WebhookTest || len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one attempt series to send an event to a webhook.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	JobID         sql.NullInt64
+	PackageID     sql.NullInt64
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt sql.NullString
+	ResponseCode  sql.NullInt64
+	Error         sql.NullString
+	CreatedAt     string
+	UpdatedAt     string
+	DeliveredAt   sql.NullString
+}
+
+const webhookColumns = `id, url, secret, events, created_at, updated_at`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.CreatedAt, &w.UpdatedAt)
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return w, err
+}
+
+const deliveryColumns = `id, webhook_id, event, job_id, package_id, payload, status, attempts, next_attempt_at, response_code, error, created_at, updated_at, delivered_at`
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &d.JobID, &d.PackageID, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseCode, &d.Error, &d.CreatedAt, &d.UpdatedAt, &d.DeliveredAt)
+	return d, err
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *Webhook) (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO webhooks (url, secret, events, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+`, w.URL, nullStringValue(w.Secret), strings.Join(w.Events, ","), now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	w, err := scanWebhook(row)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// DeleteWebhook removes a webhook together with its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC`
+	args := []any{webhookID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return s.queryDeliveries(ctx, query, args...)
+}
+
+// ListDueDeliveries returns pending deliveries whose next attempt is due.
+func (s *Store) ListDueDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return s.queryDeliveries(ctx, `SELECT `+deliveryColumns+`
+FROM webhook_deliveries
+WHERE status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+ORDER BY id ASC
+LIMIT ?`, DeliveryPending, now, limit)
+}
+
+func (s *Store) queryDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RecordDeliveryAttempt stores the outcome of one send. A zero nextAttempt
+// with a non-nil err marks the delivery as given up.
+func (s *Store) RecordDeliveryAttempt(ctx context.Context, id int64, code int, sendErr error, nextAttempt time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var codeVal any
+	if code > 0 {
+		codeVal = code
+	}
+	if sendErr == nil {
+		_, err := s.db.ExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status = ?, attempts = attempts + 1, response_code = ?, error = NULL, next_attempt_at = NULL, updated_at = ?, delivered_at = ?
+WHERE id = ?
+`, DeliveryDelivered, codeVal, now, now, id)
+		return err
+	}
+	status := DeliveryPending
+	var next any
+	if nextAttempt.IsZero() {
+		status = DeliveryFailed
+	} else {
+		next = nextAttempt.UTC().Format(time.RFC3339)
+	}
+	_, err := s.db.ExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status = ?, attempts = attempts + 1, response_code = ?, error = ?, next_attempt_at = ?, updated_at = ?
+WHERE id = ?
+`, status, codeVal, sendErr.Error(), next, now, id)
+	return err
+}
+
+// webhookPayload is the JSON body posted to webhooks.
+type webhookPayload struct {
+	Event     string       `json:"event"`
+	Timestamp string       `json:"timestamp"`
+	Job       *JobView     `json:"job,omitempty"`
+	Package   *PackageView `json:"package,omitempty"`
+	WillRetry *bool        `json:"will_retry,omitempty"`
+}
+
+// enqueueWebhooks records a pending delivery for every webhook subscribed to
+// the event. Failures are logged: notifications must never block a job
+// state change.
+func (s *Store) enqueueWebhooks(ctx context.Context, only int64, payload webhookPayload, jobID, packageID int64) {
+	hooks, err := s.ListWebhooks(ctx)
+	if err != nil {
+		log.Printf("webhook enqueue error: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("webhook payload error: %v", err)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, h := range hooks {
+		if (only > 0 && h.ID != only) || !h.wants(payload.Event) {
+			continue
+		}
+		_, err := s.db.ExecContext(ctx, `
+INSERT INTO webhook_deliveries (webhook_id, event, job_id, package_id, payload, status, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`, h.ID, payload.Event, nullIDValue(jobID), nullIDValue(packageID), string(body), DeliveryPending, now, now)
+		if err != nil {
+			log.Printf("webhook enqueue error: %v", err)
+		}
+	}
+}
+
+func nullIDValue(id int64) any {
+	if id <= 0 {
+		return nil
+	}
+	return id
+}
+
+// notifyJob queues webhooks for a job lifecycle event. Completing the last
+// job of a package also sends package_completed once.
+func (s *Store) notifyJob(ctx context.Context, event string, jobID int64, willRetry *bool) {
+	job, err := s.GetJob(ctx, jobID)
+	if err != nil {
+		return
+	}
+	view := toView(*job)
+	s.enqueueWebhooks(ctx, 0, webhookPayload{Event: event, Job: &view, WillRetry: willRetry}, jobID, 0)
+	if event != WebhookCompleted || !job.PackageID.Valid {
+		return
+	}
+	pkgID := job.PackageID.Int64
+	var sent int
+	if err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM webhook_deliveries WHERE event = ? AND package_id = ?
+`, WebhookPackageCompleted, pkgID).Scan(&sent); err != nil || sent > 0 {
+		return
+	}
+	pkg, err := s.GetPackage(ctx, pkgID)
+	if err != nil {
+		return
+	}
+	jobs, err := s.ListPackageJobs(ctx, pkgID)
+	if err != nil {
+		return
+	}
+	pv := toPackageView(*pkg, jobs)
+	if pv.Status != StatusCompleted {
+		return
+	}
+	pv.Jobs = nil
+	s.enqueueWebhooks(ctx, 0, webhookPayload{Event: WebhookPackageCompleted, Package: &pv}, 0, pkgID)
+}
+
+// WebhookView is the API shape of a webhook. The secret is never returned.
+type WebhookView struct {
+	ID        int64    `json:"id"`
+	URL       string   `json:"url"`
+	HasSecret bool     `json:"has_secret"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookDeliveryView is the API shape of a delivery log entry.
+type WebhookDeliveryView struct {
+	ID            int64  `json:"id"`
+	Event         string `json:"event"`
+	JobID         int64  `json:"job_id,omitempty"`
+	PackageID     int64  `json:"package_id,omitempty"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"`
+	ResponseCode  int64  `json:"response_code,omitempty"`
+	Error         string `json:"error,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	DeliveredAt   string `json:"delivered_at,omitempty"`
+}
+
+// WebhookRequest describes a webhook to create.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+func toWebhookView(w Webhook) WebhookView {
+	events := w.Events
+	if events == nil {
+		events = []string{}
+	}
+	return WebhookView{
+		ID:        w.ID,
+		URL:       w.URL,
+		HasSecret: w.Secret.Valid && w.Secret.String != "",
+		Events:    events,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+func toDeliveryView(d WebhookDelivery) WebhookDeliveryView {
+	return WebhookDeliveryView{
+		ID:            d.ID,
+		Event:         d.Event,
+		JobID:         d.JobID.Int64,
+		PackageID:     d.PackageID.Int64,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		NextAttemptAt: nullString(d.NextAttemptAt),
+		ResponseCode:  d.ResponseCode.Int64,
+		Error:         nullString(d.Error),
+		CreatedAt:     d.CreatedAt,
+		DeliveredAt:   nullString(d.DeliveredAt),
+	}
+}
+
+func (s *Service) CreateWebhook(ctx context.Context, req WebhookRequest) (int64, error) {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return 0, fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	var events []string
+	seen := map[string]bool{}
+	for _, e := range req.Events {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" || seen[e] {
+			continue
+		}
+		if !ValidWebhookEvent(e) {
+			return 0, fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, e)
+		}
+		seen[e] = true
+		events = append(events, e)
+	}
+	w := &Webhook{URL: u.String(), Events: events}
+	if secret := strings.TrimSpace(req.Secret); secret != "" {
+		w.Secret = sql.NullString{String: secret, Valid: true}
+	}
+	return s.store.CreateWebhook(ctx, w)
+}
+
+func (s *Service) ListWebhooks(ctx context.Context) ([]WebhookView, error) {
+	hooks, err := s.store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WebhookView, 0, len(hooks))
+	for _, h := range hooks {
+		out = append(out, toWebhookView(h))
+	}
+	return out, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, id int64) error {
+	return s.store.DeleteWebhook(ctx, id)
+}
+
+func (s *Service) ListWebhookDeliveries(ctx context.Context, id int64, limit int) ([]WebhookDeliveryView, error) {
+	if _, err := s.store.GetWebhook(ctx, id); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(ctx, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WebhookDeliveryView, 0, len(deliveries))
+	for _, d := range deliveries {
+		out = append(out, toDeliveryView(d))
+	}
+	return out, nil
+}
+
+// TestWebhook queues a test event for one webhook.
+func (s *Service) TestWebhook(ctx context.Context, id int64) error {
+	if _, err := s.store.GetWebhook(ctx, id); err != nil {
+		return err
+	}
+	s.store.enqueueWebhooks(ctx, id, webhookPayload{Event: WebhookTest}, 0, 0)
+	return nil
+}
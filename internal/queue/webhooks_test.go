@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooksDeliverSignedJobAndPackageEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-DLQ-Signature") != "sha256="+WebhookSignature("s3cret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload struct {
+			Event string `json:"event"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		mu.Lock()
+		events = append(events, payload.Event)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, nil, []string{"/data"})
+	hookID, err := svc.CreateWebhook(ctx, WebhookRequest{URL: srv.URL, Secret: "s3cret", Events: []string{"completed", "package_completed"}})
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	if _, err := svc.CreateWebhook(ctx, WebhookRequest{URL: srv.URL, Events: []string{"finished"}}); !errors.Is(err, ErrInvalidWebhook) {
+		t.Fatalf("expected ErrInvalidWebhook for unknown event, got %v", err)
+	}
+	pkgID, err := store.CreatePackage(ctx, "release")
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	var ids []int64
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		id, err := store.CreateJob(ctx, &Job{URL: u, OutDir: "/data", MaxAttempts: 1, PackageID: sql.NullInt64{Int64: pkgID, Valid: true}})
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	// Not subscribed: no delivery.
+	if err := store.MarkFailed(ctx, ids[0], "http_error", "boom", time.Time{}); err != nil {
+		t.Fatalf("mark failed: %v", err)
+	}
+	for _, id := range ids {
+		if err := store.MarkCompleted(ctx, id); err != nil {
+			t.Fatalf("mark completed: %v", err)
+		}
+	}
+
+	sender := &WebhookSender{Store: store}
+	sender.deliverDue(ctx)
+
+	mu.Lock()
+	got := append([]string{}, events...)
+	mu.Unlock()
+	want := []string{"completed", "completed", "package_completed"}
+	if len(got) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, got)
+		}
+	}
+	deliveries, err := svc.ListWebhookDeliveries(ctx, hookID, 0)
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	for _, d := range deliveries {
+		if d.Status != DeliveryDelivered || d.ResponseCode != http.StatusOK {
+			t.Fatalf("expected delivered with 200, got %+v", d)
+		}
+	}
+}
+
+func TestWebhookSenderRetriesWithBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	store := newTestStore(t)
+	ctx := context.Background()
+	svc := NewService(store, nil, []string{"/data"})
+	hookID, err := svc.CreateWebhook(ctx, WebhookRequest{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	if err := svc.TestWebhook(ctx, hookID); err != nil {
+		t.Fatalf("test webhook: %v", err)
+	}
+	sender := &WebhookSender{Store: store}
+	sender.deliverDue(ctx)
+	deliveries, err := svc.ListWebhookDeliveries(ctx, hookID, 0)
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryPending || deliveries[0].Attempts != 1 || deliveries[0].ResponseCode != http.StatusBadGateway {
+		t.Fatalf("expected pending retry after first failure, got %+v", deliveries)
+	}
+	if deliveries[0].NextAttemptAt == "" {
+		t.Fatalf("expected next attempt to be scheduled")
+	}
+}
@@ -0,0 +1,39 @@
+package resolver
+
+import "errors"
+
+// Account is a hoster login handed to a resolver.
+type Account struct {
+	ID       int64
+	Username string
+	Password string
+}
+
+// AccountSource hands out hoster accounts per site. Next returns the first
+// usable account whose ID is not in skip; Report records what happened when
+// the account was used, so exhausted or rejected accounts are rotated out.
+type AccountSource interface {
+	Next(site string, skip []int64) (Account, bool)
+	Report(id int64, err error)
+}
+
+// withAccounts calls resolve with each usable account of site in turn until
+// one succeeds or fails for a reason other than quota or login. Once the
+// accounts run out (or with none configured) resolve is called with the zero
+// Account, which resolvers treat as their fallback or anonymous mode.
+func withAccounts(src AccountSource, site string, resolve func(Account) (*ResolvedTarget, error)) (*ResolvedTarget, error) {
+	var skip []int64
+	for src != nil {
+		acct, ok := src.Next(site, skip)
+		if !ok {
+			break
+		}
+		target, err := resolve(acct)
+		src.Report(acct.ID, err)
+		if !errors.Is(err, ErrQuotaExceeded) && !errors.Is(err, ErrLoginRequired) {
+			return target, err
+		}
+		skip = append(skip, acct.ID)
+	}
+	return resolve(Account{})
+}
@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"errors"
+	"strconv"
+)
+
+// bencodeMaxDepth bounds nesting so a hostile file cannot exhaust the stack.
+const bencodeMaxDepth = 64
+
+var errBencode = errors.New("torrent_invalid")
+
+// bdecoder decodes bencoded data into int64, string, []any and
+// map[string]any values. It remembers where the top-level "info" value
+// starts and ends, as the info hash is taken over those exact bytes.
+type bdecoder struct {
+	data      []byte
+	pos       int
+	infoStart int
+	infoEnd   int
+}
+
+func bdecode(data []byte) (any, *bdecoder, error) {
+	d := &bdecoder{data: data, infoStart: -1}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, nil, errBencode
+	}
+	return v, d, nil
+}
+
+func (d *bdecoder) value(depth int) (any, error) {
+	if d.pos >= len(d.data) || depth > bencodeMaxDepth {
+		return nil, errBencode
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		end := d.index('e')
+		if end < 0 {
+			return nil, errBencode
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos:end]), 10, 64)
+		if err != nil {
+			return nil, errBencode
+		}
+		d.pos = end + 1
+		return n, nil
+	case c == 'l':
+		d.pos++
+		list := []any{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode
+		}
+		d.pos++
+		return list, nil
+	case c == 'd':
+		d.pos++
+		dict := map[string]any{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if depth == 0 && key == "info" {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[key] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode
+		}
+		d.pos++
+		return dict, nil
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, errBencode
+	}
+}
+
+func (d *bdecoder) str() (string, error) {
+	colon := d.index(':')
+	if colon < 0 {
+		return "", errBencode
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos:colon]))
+	if err != nil || n < 0 || n > len(d.data)-colon-1 {
+		return "", errBencode
+	}
+	d.pos = colon + 1 + n
+	return string(d.data[colon+1 : d.pos]), nil
+}
+
+func (d *bdecoder) index(b byte) int {
+	for i := d.pos; i < len(d.data); i++ {
+		if d.data[i] == b {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewHTTPResolver returns the resolver for direct HTTP/HTTPS URLs. It probes
+// the URL before download for the file name, size and range support.
+func NewHTTPResolver() Resolver {
+	return &httpResolver{client: &http.Client{Timeout: 20 * time.Second}}
+}
+
+type httpResolver struct {
+	client *http.Client
+}
+
+func (r *httpResolver) CanHandle(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+}
+
+// Resolve sends a HEAD, or a one-byte ranged GET when HEAD is refused, and
+// follows redirects. Statuses that say the file is not downloadable now fail
+// with the matching error; when the probe itself fails the URL is handed to
+// the engine unchanged, as the engine may still get through.
+func (r *httpResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
+	target := &ResolvedTarget{Kind: "aria2", URL: rawURL}
+	resp, err := r.probe(ctx, http.MethodHead, rawURL)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Some servers reject HEAD, or sign URLs for GET only.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		resp, err = r.probe(ctx, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("http resolver: probe %s: %v", rawURL, err)
+		return target, nil
+	}
+	defer resp.Body.Close()
+	if err := httpProbeError(resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		log.Printf("http resolver: probe %s: status %d", rawURL, resp.StatusCode)
+		return target, nil
+	}
+
+	target.Filename = httpFilename(resp)
+	target.ETag = resp.Header.Get("ETag")
+	target.LastModified = resp.Header.Get("Last-Modified")
+	resumable := strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes")
+	if !resumable && resp.Request.Method == http.MethodHead && resp.Header.Get("Accept-Ranges") == "" {
+		// Not every server advertises range support on HEAD; ask for a byte.
+		if ranged, err := r.probe(ctx, http.MethodGet, rawURL); err == nil {
+			resumable = ranged.StatusCode == http.StatusPartialContent
+			ranged.Body.Close()
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		resumable = true
+		target.Size = max(httpContentRangeTotal(resp.Header.Get("Content-Range")), 0)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// An empty file cannot satisfy bytes=0-0.
+		target.Size = 0
+	case resp.ContentLength > 0:
+		target.Size = resp.ContentLength
+	}
+	if !resumable {
+		// Partial data cannot be continued; start over instead of failing.
+		target.Options = map[string]string{"always-resume": "false"}
+	}
+	return target, nil
+}
+
+func (r *httpResolver) probe(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	// The body is never read: closing it drops whatever a server without
+	// range support started to send.
+	return r.client.Do(req)
+}
+
+// httpProbeError maps statuses that make a download pointless right now to
+// the resolver errors, so the runner retries with the usual delays. A
+// missing file fails for good.
+func httpProbeError(code int) error {
+	status := fmt.Sprintf("status=%d %s", code, http.StatusText(code))
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrLoginRequired, status)
+	case http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: %s", ErrNotFound, status)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrTemporarilyOff, status)
+	case 509: // Bandwidth Limit Exceeded
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, status)
+	}
+	return nil
+}
+
+// httpFilename returns the Content-Disposition file name, or else the last
+// path element of the URL after redirects.
+func httpFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := httpBaseName(params["filename"]); name != "" {
+			return name
+		}
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		return httpBaseName(resp.Request.URL.Path)
+	}
+	return ""
+}
+
+func httpBaseName(p string) string {
+	name := path.Base(strings.ReplaceAll(strings.TrimSpace(p), "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+func httpContentRangeTotal(v string) int64 {
+	idx := strings.LastIndex(v, "/")
+	if idx < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(v[idx+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
@@ -0,0 +1,148 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPResolverProbesHead(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		methods = append(methods, req.Method)
+		if req.URL.Path == "/dl" {
+			http.Redirect(w, req, "/files/Some%20File.iso?sig=1", http.StatusFound)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer srv.Close()
+
+	r := &httpResolver{client: srv.Client()}
+	res, err := r.Resolve(context.Background(), srv.URL+"/dl")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.URL != srv.URL+"/dl" {
+		t.Fatalf("url = %q, want the original URL", res.URL)
+	}
+	if res.Filename != "Some File.iso" || res.Size != 1234 {
+		t.Fatalf("filename/size = %q/%d", res.Filename, res.Size)
+	}
+	if res.ETag != `"abc"` || res.LastModified == "" {
+		t.Fatalf("validators = %q/%q", res.ETag, res.LastModified)
+	}
+	if res.Options["always-resume"] != "" {
+		t.Fatalf("resumable target got options %v", res.Options)
+	}
+	if strings.Join(methods, ",") != "HEAD,HEAD" {
+		t.Fatalf("methods = %v", methods)
+	}
+}
+
+func TestHTTPResolverFallsBackToRangedGet(t *testing.T) {
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ranges = append(ranges, req.Header.Get("Range"))
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+		w.Header().Set("Content-Range", "bytes 0-0/5000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("x"))
+	}))
+	defer srv.Close()
+
+	r := &httpResolver{client: srv.Client()}
+	res, err := r.Resolve(context.Background(), srv.URL+"/get?id=7")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.Filename != "résumé.pdf" || res.Size != 5000 {
+		t.Fatalf("filename/size = %q/%d", res.Filename, res.Size)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=0-0" {
+		t.Fatalf("ranges = %v", ranges)
+	}
+	if res.Options["always-resume"] != "" {
+		t.Fatalf("206 target got options %v", res.Options)
+	}
+}
+
+func TestHTTPResolverMarksNonResumable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "42")
+		if req.Method == http.MethodGet {
+			w.Write(make([]byte, 42))
+		}
+	}))
+	defer srv.Close()
+
+	r := &httpResolver{client: srv.Client()}
+	res, err := r.Resolve(context.Background(), srv.URL+"/a/b.zip")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.Filename != "b.zip" || res.Size != 42 {
+		t.Fatalf("filename/size = %q/%d", res.Filename, res.Size)
+	}
+	if res.Options["always-resume"] != "false" {
+		t.Fatalf("options = %v, want always-resume=false", res.Options)
+	}
+}
+
+func TestHTTPResolverClassifiesStatuses(t *testing.T) {
+	cases := []struct {
+		status int
+		want   error
+		code   string
+	}{
+		{http.StatusUnauthorized, ErrLoginRequired, "login_required"},
+		{http.StatusForbidden, ErrLoginRequired, "login_required"},
+		{http.StatusNotFound, ErrNotFound, "http_not_found"},
+		{http.StatusGone, ErrNotFound, "http_not_found"},
+		{http.StatusTooManyRequests, ErrTemporarilyOff, "temporarily_unavailable"},
+		{509, ErrQuotaExceeded, "quota_exceeded"},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(tc.status)
+		}))
+		r := &httpResolver{client: srv.Client()}
+		_, err := r.Resolve(context.Background(), srv.URL+"/f")
+		srv.Close()
+		if err == nil {
+			t.Fatalf("status %d: expected error", tc.status)
+		}
+		if tc.want != nil && !errors.Is(err, tc.want) {
+			t.Fatalf("status %d: err = %v, want %v", tc.status, err, tc.want)
+		}
+		if got := ResultCode(err); got != tc.code {
+			t.Fatalf("status %d: code = %q, want %q", tc.status, got, tc.code)
+		}
+	}
+}
+
+func TestHTTPResolverPassesThroughOnProbeFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	r := &httpResolver{client: srv.Client()}
+	res, err := r.Resolve(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.URL != srv.URL+"/f.bin" || res.Filename != "" || res.Options != nil {
+		t.Fatalf("target = %+v, want a plain pass-through", res)
+	}
+}
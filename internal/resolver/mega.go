@@ -43,13 +43,32 @@ func (r *megaResolver) CanHandle(rawURL string) bool {
 }
 
 func (r *megaResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
-	fileID, fileKey, err := parseMegaFileLink(rawURL)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := r.fileInfo(ctx, fileID)
-	if err != nil {
-		return nil, err
+	if isMegaFolderLink(rawURL) {
+		link, err := parseMegaFolderLink(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolveFolder(ctx, link)
+	}
+	var (
+		resp    *megaFileInfoResponse
+		fileKey string
+	)
+	if handle, key, folderID, ok := parseMegaNodeLink(rawURL); ok {
+		var err error
+		if resp, err = r.fileInfo(ctx, folderID, map[string]any{"a": "g", "g": 1, "n": handle}); err != nil {
+			return nil, err
+		}
+		fileKey = key
+	} else {
+		fileID, key, err := parseMegaFileLink(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = r.fileInfo(ctx, "", map[string]any{"a": "g", "g": 1, "p": fileID}); err != nil {
+			return nil, err
+		}
+		fileKey = key
 	}
 	filename, err := decryptMegaFilename(resp.Attributes, fileKey)
 	if err != nil {
@@ -70,7 +89,30 @@ type megaFileInfoResponse struct {
 	ErrorCode   int    `json:"e"`
 }
 
-func (r *megaResolver) fileInfo(ctx context.Context, fileID string) (*megaFileInfoResponse, error) {
+func (r *megaResolver) fileInfo(ctx context.Context, folderID string, cmd map[string]any) (*megaFileInfoResponse, error) {
+	body, err := r.call(ctx, folderID, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var out megaFileInfoResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	if out.ErrorCode != 0 {
+		return nil, mapMegaAPIError(out.ErrorCode)
+	}
+	if out.DownloadURL == "" {
+		return nil, errors.New("mega_download_url_missing")
+	}
+	if out.Attributes == "" {
+		return nil, errors.New("mega_attributes_missing")
+	}
+	return &out, nil
+}
+
+// call sends one API command and returns its result. Commands on nodes of a
+// public folder pass the folder handle as the n query parameter.
+func (r *megaResolver) call(ctx context.Context, folderID string, cmd map[string]any) (json.RawMessage, error) {
 	apiURL := strings.TrimSpace(r.apiURL)
 	if apiURL == "" {
 		apiURL = megaAPI
@@ -82,14 +124,11 @@ func (r *megaResolver) fileInfo(ctx context.Context, fileID string) (*megaFileIn
 	} else {
 		requestURL += "?id=" + strconv.FormatUint(requestID, 10)
 	}
+	if folderID != "" {
+		requestURL += "&n=" + url.QueryEscape(folderID)
+	}
 
-	payload, err := json.Marshal([]map[string]any{
-		{
-			"a": "g",
-			"g": 1,
-			"p": fileID,
-		},
-	})
+	payload, err := json.Marshal([]map[string]any{cmd})
 	if err != nil {
 		return nil, err
 	}
@@ -120,21 +159,7 @@ func (r *megaResolver) fileInfo(ctx context.Context, fileID string) (*megaFileIn
 	if err := json.Unmarshal(body[0], &apiErrorCode); err == nil {
 		return nil, mapMegaAPIError(apiErrorCode)
 	}
-
-	var out megaFileInfoResponse
-	if err := json.Unmarshal(body[0], &out); err != nil {
-		return nil, err
-	}
-	if out.ErrorCode != 0 {
-		return nil, mapMegaAPIError(out.ErrorCode)
-	}
-	if out.DownloadURL == "" {
-		return nil, errors.New("mega_download_url_missing")
-	}
-	if out.Attributes == "" {
-		return nil, errors.New("mega_attributes_missing")
-	}
-	return &out, nil
+	return body[0], nil
 }
 
 func parseMegaFileLink(rawURL string) (string, string, error) {
@@ -0,0 +1,258 @@
+package resolver
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// megaFolderLink is a parsed public folder link. SubfolderID limits the
+// listing to one subfolder and FileID to a single file of the folder.
+type megaFolderLink struct {
+	FolderID    string
+	FolderKey   string
+	SubfolderID string
+	FileID      string
+}
+
+type megaNode struct {
+	Handle     string `json:"h"`
+	Parent     string `json:"p"`
+	Type       int    `json:"t"`
+	Attributes string `json:"a"`
+	Key        string `json:"k"`
+	Size       int64  `json:"s"`
+}
+
+// MegaNodeURL is the link dlq stores for a file inside a public folder:
+// "#N!<handle>!<key>###n=<folder>" carries the node handle, its decrypted key
+// and the folder handle, so the file can be resolved and decrypted on its own.
+func MegaNodeURL(handle, key, folderID string) string {
+	return "https://mega.nz/#N!" + handle + "!" + key + "###n=" + folderID
+}
+
+func isMegaFolderLink(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.Trim(u.Path, "/"), "folder/") || strings.HasPrefix(u.Fragment, "F!")
+}
+
+// parseMegaFolderLink accepts /folder/<id>#<key>, optionally followed by
+// /folder/<subid> or /file/<id> in the fragment, and the legacy
+// #F!<id>!<key>[!<subid>] form.
+func parseMegaFolderLink(rawURL string) (*megaFolderLink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	p := strings.Trim(u.Path, "/")
+	fragment := strings.TrimSpace(u.Fragment)
+
+	link := &megaFolderLink{}
+	if strings.HasPrefix(p, "folder/") {
+		link.FolderID = strings.TrimSpace(strings.Split(strings.TrimPrefix(p, "folder/"), "/")[0])
+		parts := strings.Split(fragment, "/")
+		link.FolderKey = strings.TrimSpace(parts[0])
+		if len(parts) >= 3 {
+			switch parts[1] {
+			case "folder":
+				link.SubfolderID = strings.TrimSpace(parts[2])
+			case "file":
+				link.FileID = strings.TrimSpace(parts[2])
+			default:
+				return nil, errors.New("mega_folder_link_invalid")
+			}
+		} else if len(parts) == 2 {
+			return nil, errors.New("mega_folder_link_invalid")
+		}
+	} else if strings.HasPrefix(fragment, "F!") {
+		parts := strings.Split(strings.TrimPrefix(fragment, "F!"), "!")
+		if len(parts) >= 2 {
+			link.FolderID = strings.TrimSpace(parts[0])
+			link.FolderKey = strings.TrimSpace(parts[1])
+		}
+		if len(parts) >= 3 {
+			link.SubfolderID = strings.TrimSpace(parts[2])
+		}
+	}
+
+	if link.FolderID == "" || link.FolderKey == "" {
+		return nil, errors.New("mega_folder_link_required")
+	}
+	for _, token := range []string{link.FolderID, link.FolderKey, link.SubfolderID, link.FileID} {
+		if token != "" && !isValidMegaToken(token) {
+			return nil, errors.New("mega_link_invalid_tokens")
+		}
+	}
+	return link, nil
+}
+
+// parseMegaNodeLink parses a link built by MegaNodeURL.
+func parseMegaNodeLink(rawURL string) (handle, key, folderID string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.HasPrefix(u.Fragment, "N!") {
+		return "", "", "", false
+	}
+	node, folder, found := strings.Cut(strings.TrimPrefix(u.Fragment, "N!"), "###n=")
+	if !found {
+		return "", "", "", false
+	}
+	handle, key, found = strings.Cut(node, "!")
+	if !found || !isValidMegaToken(handle) || !isValidMegaToken(key) || !isValidMegaToken(folder) {
+		return "", "", "", false
+	}
+	return handle, key, folder, true
+}
+
+// resolveFolder lists a public folder and returns one entry per file below
+// the link's root, with the directory path relative to that root.
+func (r *megaResolver) resolveFolder(ctx context.Context, link *megaFolderLink) (*ResolvedTarget, error) {
+	folderKey, err := decodeMegaBase64(link.FolderKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(folderKey) != 16 {
+		return nil, errors.New("mega_folder_key_invalid")
+	}
+	body, err := r.call(ctx, link.FolderID, map[string]any{"a": "f", "c": 1, "ca": 1, "r": 1})
+	if err != nil {
+		return nil, err
+	}
+	var listing struct {
+		Nodes []megaNode `json:"f"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*megaNode, len(listing.Nodes))
+	keys := make(map[string]string, len(listing.Nodes))
+	names := make(map[string]string, len(listing.Nodes))
+	for i := range listing.Nodes {
+		n := &listing.Nodes[i]
+		key, err := decryptMegaNodeKey(n.Key, folderKey)
+		if err != nil {
+			continue
+		}
+		name, err := decryptMegaFilename(n.Attributes, key)
+		if err != nil {
+			continue
+		}
+		nodes[n.Handle] = n
+		keys[n.Handle] = key
+		names[n.Handle] = megaSafeName(name)
+	}
+	rootID := link.SubfolderID
+	if rootID == "" {
+		// The shared folder is the one folder whose parent is not listed.
+		for _, n := range listing.Nodes {
+			if _, ok := nodes[n.Handle]; ok && n.Type == 1 && nodes[n.Parent] == nil {
+				rootID = n.Handle
+				break
+			}
+		}
+	}
+	root, ok := nodes[rootID]
+	if !ok || root.Type != 1 {
+		return nil, errors.New("mega_folder_not_found")
+	}
+
+	target := &ResolvedTarget{Kind: KindFolder, Filename: names[rootID]}
+	if link.FileID != "" {
+		n, ok := nodes[link.FileID]
+		if !ok || n.Type != 0 {
+			return nil, errors.New("mega_file_not_found")
+		}
+		target.Entries = append(target.Entries, FolderEntry{
+			URL:      MegaNodeURL(n.Handle, keys[n.Handle], link.FolderID),
+			Filename: names[n.Handle],
+			Size:     n.Size,
+		})
+		target.Size = n.Size
+		return target, nil
+	}
+	for _, n := range listing.Nodes {
+		if n.Type != 0 {
+			continue
+		}
+		if _, ok := nodes[n.Handle]; !ok {
+			continue
+		}
+		dir, ok := megaNodeDir(nodes, names, n.Parent, rootID)
+		if !ok {
+			continue
+		}
+		target.Entries = append(target.Entries, FolderEntry{
+			URL:      MegaNodeURL(n.Handle, keys[n.Handle], link.FolderID),
+			Path:     dir,
+			Filename: names[n.Handle],
+			Size:     n.Size,
+		})
+		target.Size += n.Size
+	}
+	sort.SliceStable(target.Entries, func(i, j int) bool {
+		a, b := target.Entries[i], target.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Filename < b.Filename
+	})
+	return target, nil
+}
+
+// megaNodeDir returns the path of folder handle relative to rootID, or false
+// when the folder is not below rootID.
+func megaNodeDir(nodes map[string]*megaNode, names map[string]string, handle, rootID string) (string, bool) {
+	var parts []string
+	for depth := 0; handle != rootID; depth++ {
+		n, ok := nodes[handle]
+		if !ok || n.Type != 1 || depth > len(nodes) {
+			return "", false
+		}
+		parts = append([]string{names[handle]}, parts...)
+		handle = n.Parent
+	}
+	return path.Join(parts...), true
+}
+
+// decryptMegaNodeKey decrypts a node key from a folder listing. The k field
+// holds "<owner>:<key>" pairs; in a public folder the key is AES-ECB
+// encrypted with the folder key.
+func decryptMegaNodeKey(k string, folderKey []byte) (string, error) {
+	enc, _, _ := strings.Cut(k, "/")
+	if _, after, found := strings.Cut(enc, ":"); found {
+		enc = after
+	}
+	raw, err := decodeMegaBase64(enc)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) != 16 && len(raw) != 32 {
+		return "", errors.New("mega_node_key_invalid")
+	}
+	block, err := aes.NewCipher(folderKey)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(raw); i += aes.BlockSize {
+		block.Decrypt(raw[i:i+aes.BlockSize], raw[i:i+aes.BlockSize])
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// megaSafeName turns a node name into a single path element.
+func megaSafeName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
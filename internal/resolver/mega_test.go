@@ -6,6 +6,7 @@ import (
 	"crypto/cipher"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -193,3 +194,179 @@ func encryptMegaAttributes(t *testing.T, rawKey []byte, filename string) string
 	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, plain)
 	return base64.RawURLEncoding.EncodeToString(out)
 }
+
+func TestParseMegaFolderLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    megaFolderLink
+		wantErr bool
+	}{
+		{
+			name: "folder",
+			url:  "https://mega.nz/folder/AbCdEf12#QwErTy123_-",
+			want: megaFolderLink{FolderID: "AbCdEf12", FolderKey: "QwErTy123_-"},
+		},
+		{
+			name: "file in folder",
+			url:  "https://mega.nz/folder/AbCdEf12#QwErTy123_-/file/XyZ98765",
+			want: megaFolderLink{FolderID: "AbCdEf12", FolderKey: "QwErTy123_-", FileID: "XyZ98765"},
+		},
+		{
+			name: "subfolder",
+			url:  "https://mega.nz/folder/AbCdEf12#QwErTy123_-/folder/SuB12345",
+			want: megaFolderLink{FolderID: "AbCdEf12", FolderKey: "QwErTy123_-", SubfolderID: "SuB12345"},
+		},
+		{
+			name: "legacy",
+			url:  "https://mega.co.nz/#F!AbCdEf12!QwErTy123_-",
+			want: megaFolderLink{FolderID: "AbCdEf12", FolderKey: "QwErTy123_-"},
+		},
+		{
+			name:    "missing key",
+			url:     "https://mega.nz/folder/AbCdEf12",
+			wantErr: true,
+		},
+		{
+			name:    "unknown suffix",
+			url:     "https://mega.nz/folder/AbCdEf12#QwErTy123_-/photo/XyZ98765",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMegaFolderLink(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != tt.want {
+				t.Fatalf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMegaResolverResolveFolder(t *testing.T) {
+	folderKey := []byte("0123456789abcdef")
+	fileKey := func(b byte) []byte {
+		k := make([]byte, 32)
+		for i := range k {
+			k[i] = b + byte(i)
+		}
+		return k
+	}
+	rootKey := []byte("root-folder-key!")
+	subKey := []byte("sub--folder-key!")
+	aKey, bKey := fileKey(1), fileKey(100)
+	nodes := []string{
+		megaTestNode(t, folderKey, "ROOT", "", 1, rootKey, "Release", 0),
+		megaTestNode(t, folderKey, "SUB", "ROOT", 1, subKey, "Extras", 0),
+		megaTestNode(t, folderKey, "FILEA", "ROOT", 0, aKey, "a.rar", 100),
+		megaTestNode(t, folderKey, "FILEB", "SUB", 0, bKey, "b.nfo", 5),
+	}
+
+	var queries []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			queries = append(queries, r.URL.Query().Get("n"))
+			body, _ := io.ReadAll(r.Body)
+			out := `[{"f":[` + strings.Join(nodes, ",") + `]}]`
+			if strings.Contains(string(body), `"a":"g"`) {
+				out = `[{"g":"https://dl.example.com/b","s":5,"at":"` + encryptMegaAttributes(t, bKey, "b.nfo") + `"}]`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(out))}, nil
+		}),
+	}
+	r := &megaResolver{client: client, apiURL: "https://api.test/cs"}
+	link := "https://mega.nz/folder/PuBlIc12#" + base64.RawURLEncoding.EncodeToString(folderKey)
+
+	got, err := r.Resolve(context.Background(), link)
+	if err != nil {
+		t.Fatalf("resolve folder: %v", err)
+	}
+	if got.Kind != KindFolder || got.Filename != "Release" || got.Size != 105 {
+		t.Fatalf("got kind=%q name=%q size=%d", got.Kind, got.Filename, got.Size)
+	}
+	if len(got.Entries) != 2 {
+		t.Fatalf("entries = %+v", got.Entries)
+	}
+	if e := got.Entries[0]; e.Path != "" || e.Filename != "a.rar" || e.Size != 100 {
+		t.Fatalf("entry 0 = %+v", e)
+	}
+	if e := got.Entries[1]; e.Path != "Extras" || e.Filename != "b.nfo" {
+		t.Fatalf("entry 1 = %+v", e)
+	}
+	wantURL := "https://mega.nz/#N!FILEB!" + base64.RawURLEncoding.EncodeToString(bKey) + "###n=PuBlIc12"
+	if got.Entries[1].URL != wantURL {
+		t.Fatalf("entry url = %q, want %q", got.Entries[1].URL, wantURL)
+	}
+
+	sub, err := r.Resolve(context.Background(), link+"/folder/SUB")
+	if err != nil {
+		t.Fatalf("resolve subfolder: %v", err)
+	}
+	if sub.Filename != "Extras" || len(sub.Entries) != 1 || sub.Entries[0].Path != "" || sub.Entries[0].Filename != "b.nfo" {
+		t.Fatalf("subfolder = %+v", sub)
+	}
+
+	single, err := r.Resolve(context.Background(), link+"/file/FILEA")
+	if err != nil {
+		t.Fatalf("resolve file in folder: %v", err)
+	}
+	if len(single.Entries) != 1 || single.Entries[0].Filename != "a.rar" {
+		t.Fatalf("file in folder = %+v", single)
+	}
+
+	file, err := r.Resolve(context.Background(), wantURL)
+	if err != nil {
+		t.Fatalf("resolve node link: %v", err)
+	}
+	if file.Kind != "aria2" || file.URL != "https://dl.example.com/b" || file.Filename != "b.nfo" {
+		t.Fatalf("node link = %+v", file)
+	}
+	for _, n := range queries {
+		if n != "PuBlIc12" {
+			t.Fatalf("request n = %q, want folder handle", n)
+		}
+	}
+}
+
+// megaTestNode renders a folder listing node whose key is encrypted with the
+// folder key and whose attributes are encrypted with the node key.
+func megaTestNode(t *testing.T, folderKey []byte, handle, parent string, typ int, key []byte, name string, size int64) string {
+	t.Helper()
+	block, err := aes.NewCipher(folderKey)
+	if err != nil {
+		t.Fatalf("aes cipher: %v", err)
+	}
+	encKey := make([]byte, len(key))
+	for i := 0; i < len(key); i += aes.BlockSize {
+		block.Encrypt(encKey[i:i+aes.BlockSize], key[i:i+aes.BlockSize])
+	}
+	var attrs string
+	if len(key) == 32 {
+		attrs = encryptMegaAttributes(t, key, name)
+	} else {
+		attrBlock, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatalf("aes cipher: %v", err)
+		}
+		plain := []byte(`MEGA{"n":"` + name + `"}`)
+		if rem := len(plain) % aes.BlockSize; rem != 0 {
+			plain = append(plain, make([]byte, aes.BlockSize-rem)...)
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(attrBlock, make([]byte, aes.BlockSize)).CryptBlocks(out, plain)
+		attrs = base64.RawURLEncoding.EncodeToString(out)
+	}
+	return fmt.Sprintf(`{"h":%q,"p":%q,"t":%d,"a":%q,"k":"owner:%s","s":%d}`,
+		handle, parent, typ, attrs, base64.RawURLEncoding.EncodeToString(encKey), size)
+}
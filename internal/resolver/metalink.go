@@ -0,0 +1,241 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxMetalinkSize caps Metalink documents fetched over HTTP.
+const maxMetalinkSize = 10 << 20
+
+var errMetalink = errors.New("metalink_invalid")
+
+// MetalinkFile is one file described by a Metalink document. URLs are the
+// mirrors, best first, and Checksum the strongest hash as type:hex.
+type MetalinkFile struct {
+	Name     string
+	Size     int64
+	URLs     []string
+	Checksum string
+}
+
+// metalinkDoc covers both Metalink 4 (RFC 5854, .meta4) and Metalink 3
+// (.metalink). Element names are matched without namespaces.
+type metalinkDoc struct {
+	XMLName xml.Name       `xml:"metalink"`
+	Files   []metalinkFile `xml:"file"`
+	FilesV3 []metalinkFile `xml:"files>file"`
+}
+
+type metalinkFile struct {
+	Name     string         `xml:"name,attr"`
+	Size     int64          `xml:"size"`
+	Hashes   []metalinkHash `xml:"hash"`
+	HashesV3 []metalinkHash `xml:"verification>hash"`
+	URLs     []metalinkURL  `xml:"url"`
+	URLsV3   []metalinkURL  `xml:"resources>url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority   int    `xml:"priority,attr"`   // Metalink 4: lower is better
+	Preference int    `xml:"preference,attr"` // Metalink 3: higher is better
+	Type       string `xml:"type,attr"`       // Metalink 3: http, ftp, bittorrent...
+	Value      string `xml:",chardata"`
+}
+
+// metalinkHashTypes lists the hash types dlq records, strongest first.
+var metalinkHashTypes = []string{"sha512", "sha384", "sha256", "sha1", "md5"}
+
+// ParseMetalink returns the files of a Metalink 3 or 4 document. Files
+// without a name or without any http(s) or ftp mirror are rejected.
+func ParseMetalink(data []byte) ([]MetalinkFile, error) {
+	var doc metalinkDoc
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMetalink, err)
+	}
+	files := append(doc.Files, doc.FilesV3...)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files", errMetalink)
+	}
+	out := make([]MetalinkFile, 0, len(files))
+	for _, f := range files {
+		name := strings.TrimSpace(f.Name)
+		if name == "" || f.Size < 0 {
+			return nil, fmt.Errorf("%w: file without name", errMetalink)
+		}
+		mf := MetalinkFile{Name: name, Size: f.Size, URLs: metalinkMirrors(append(f.URLs, f.URLsV3...))}
+		if len(mf.URLs) == 0 {
+			return nil, fmt.Errorf("%w: no mirrors for %s", errMetalink, name)
+		}
+		mf.Checksum = metalinkChecksum(append(f.Hashes, f.HashesV3...))
+		out = append(out, mf)
+	}
+	return out, nil
+}
+
+// metalinkMirrors orders mirror URLs best first and drops schemes the
+// engines cannot download.
+func metalinkMirrors(urls []metalinkURL) []string {
+	rank := func(u metalinkURL) int {
+		if u.Priority > 0 {
+			return u.Priority
+		}
+		if u.Preference > 0 {
+			return 1000000 - u.Preference
+		}
+		return 1000000
+	}
+	sort.SliceStable(urls, func(i, j int) bool { return rank(urls[i]) < rank(urls[j]) })
+	var out []string
+	for _, u := range urls {
+		if typ := strings.ToLower(u.Type); typ != "" && typ != "http" && typ != "https" && typ != "ftp" {
+			continue
+		}
+		raw := strings.TrimSpace(u.Value)
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(parsed.Scheme) {
+		case "http", "https", "ftp":
+			out = append(out, raw)
+		}
+	}
+	return out
+}
+
+func metalinkChecksum(hashes []metalinkHash) string {
+	found := map[string]string{}
+	for _, h := range hashes {
+		typ := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(h.Type)), "-", "")
+		found[typ] = strings.ToLower(strings.TrimSpace(h.Value))
+	}
+	for _, typ := range metalinkHashTypes {
+		if v := found[typ]; v != "" {
+			return typ + ":" + v
+		}
+	}
+	return ""
+}
+
+// NewMetalinkResolver returns the resolver for http(s) URLs of .meta4 and
+// .metalink files. A document with one file resolves to that file with all
+// its mirrors; one with several resolves to a folder whose entries select a
+// file by URL fragment.
+func NewMetalinkResolver() Resolver {
+	return &metalinkResolver{client: &http.Client{Timeout: 20 * time.Second}}
+}
+
+type metalinkResolver struct {
+	client *http.Client
+}
+
+func (r *metalinkResolver) CanHandle(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		p := strings.ToLower(u.Path)
+		return strings.HasSuffix(p, ".meta4") || strings.HasSuffix(p, ".metalink")
+	}
+	return false
+}
+
+func (r *metalinkResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	selected := u.Fragment
+	u.Fragment = ""
+	data, err := r.fetch(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	files, err := ParseMetalink(data)
+	if err != nil {
+		return nil, err
+	}
+	if selected != "" {
+		for _, f := range files {
+			if f.Name == selected {
+				return metalinkTarget(f), nil
+			}
+		}
+		return nil, fmt.Errorf("metalink_file_not_found: %s", selected)
+	}
+	if len(files) == 1 {
+		return metalinkTarget(files[0]), nil
+	}
+	target := &ResolvedTarget{Kind: KindFolder, Filename: strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))}
+	for _, f := range files {
+		entry := *u
+		entry.Fragment = f.Name
+		dir := path.Dir(f.Name)
+		if dir == "." {
+			dir = ""
+		}
+		target.Entries = append(target.Entries, FolderEntry{
+			URL:      entry.String(),
+			Path:     dir,
+			Filename: path.Base(f.Name),
+			Size:     f.Size,
+		})
+		target.Size += f.Size
+	}
+	return target, nil
+}
+
+func metalinkTarget(f MetalinkFile) *ResolvedTarget {
+	return &ResolvedTarget{
+		Kind:     "aria2",
+		URL:      f.URLs[0],
+		Mirrors:  f.URLs,
+		Filename: path.Base(f.Name),
+		Size:     f.Size,
+		Checksum: f.Checksum,
+	}
+}
+
+func (r *metalinkResolver) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := httpProbeError(resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("metalink_http_status:%d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMetalinkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMetalinkSize {
+		return nil, errors.New("metalink_too_large")
+	}
+	return data, nil
+}
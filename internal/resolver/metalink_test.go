@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testMeta4 = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="distro.iso">
+    <size>1024</size>
+    <hash type="md5">0123456789abcdef0123456789abcdef</hash>
+    <hash type="sha-256">9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08</hash>
+    <pieces length="512" type="sha-1"><hash>aa</hash><hash>bb</hash></pieces>
+    <url location="de" priority="2">https://mirror-b.example/distro.iso</url>
+    <url location="us" priority="1">https://mirror-a.example/distro.iso</url>
+    <url>ftp://mirror-c.example/distro.iso</url>
+    <metaurl mediatype="torrent">https://example.com/distro.torrent</metaurl>
+  </file>
+</metalink>`
+
+const testMetalink3 = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="data/a.csv">
+      <size>10</size>
+      <verification><hash type="sha1">da39a3ee5e6b4b0d3255bfef95601890afd80709</hash></verification>
+      <resources>
+        <url type="http" preference="10">http://slow.example/a.csv</url>
+        <url type="bittorrent" preference="100">http://fast.example/a.csv.torrent</url>
+        <url type="http" preference="90">http://fast.example/a.csv</url>
+      </resources>
+    </file>
+    <file name="b.csv">
+      <size>20</size>
+      <resources><url type="http">http://slow.example/b.csv</url></resources>
+    </file>
+  </files>
+</metalink>`
+
+func TestParseMetalink(t *testing.T) {
+	files, err := ParseMetalink([]byte(testMeta4))
+	if err != nil {
+		t.Fatalf("parse meta4: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "distro.iso" || files[0].Size != 1024 {
+		t.Fatalf("files = %+v", files)
+	}
+	wantURLs := "https://mirror-a.example/distro.iso https://mirror-b.example/distro.iso ftp://mirror-c.example/distro.iso"
+	if got := strings.Join(files[0].URLs, " "); got != wantURLs {
+		t.Fatalf("urls = %s", got)
+	}
+	if files[0].Checksum != "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Fatalf("checksum = %s", files[0].Checksum)
+	}
+
+	files, err = ParseMetalink([]byte(testMetalink3))
+	if err != nil {
+		t.Fatalf("parse metalink 3: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %+v", files)
+	}
+	// The bittorrent resource is not a URL the engines download.
+	if got := strings.Join(files[0].URLs, " "); got != "http://fast.example/a.csv http://slow.example/a.csv" {
+		t.Fatalf("urls = %s", got)
+	}
+	if files[0].Checksum != "sha1:da39a3ee5e6b4b0d3255bfef95601890afd80709" || files[1].Checksum != "" {
+		t.Fatalf("checksums = %q %q", files[0].Checksum, files[1].Checksum)
+	}
+
+	for _, bad := range []string{"", "<metalink/>", "<metalink><file name=\"x\"><size>1</size></file></metalink>", "<html></html>"} {
+		if _, err := ParseMetalink([]byte(bad)); err == nil {
+			t.Fatalf("ParseMetalink(%q): expected error", bad)
+		}
+	}
+}
+
+func TestMetalinkResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/distro.meta4":
+			w.Write([]byte(testMeta4))
+		case "/set.metalink":
+			w.Write([]byte(testMetalink3))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := &metalinkResolver{client: srv.Client()}
+	if !r.CanHandle(srv.URL+"/distro.meta4") || !r.CanHandle(srv.URL+"/set.metalink") || r.CanHandle(srv.URL+"/distro.iso") {
+		t.Fatalf("CanHandle should only accept .meta4 and .metalink URLs")
+	}
+	res, err := r.Resolve(context.Background(), srv.URL+"/distro.meta4")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.Kind != "aria2" || res.URL != "https://mirror-a.example/distro.iso" || len(res.Mirrors) != 3 {
+		t.Fatalf("target = %+v", res)
+	}
+	if res.Filename != "distro.iso" || res.Size != 1024 || !strings.HasPrefix(res.Checksum, "sha256:") {
+		t.Fatalf("filename/size/checksum = %q/%d/%q", res.Filename, res.Size, res.Checksum)
+	}
+
+	folder, err := r.Resolve(context.Background(), srv.URL+"/set.metalink")
+	if err != nil {
+		t.Fatalf("resolve folder: %v", err)
+	}
+	if folder.Kind != KindFolder || folder.Filename != "set" || folder.Size != 30 || len(folder.Entries) != 2 {
+		t.Fatalf("folder = %+v", folder)
+	}
+	entry := folder.Entries[0]
+	if entry.Path != "data" || entry.Filename != "a.csv" || !r.CanHandle(entry.URL) {
+		t.Fatalf("entry = %+v", entry)
+	}
+	file, err := r.Resolve(context.Background(), entry.URL)
+	if err != nil {
+		t.Fatalf("resolve entry: %v", err)
+	}
+	if file.URL != "http://fast.example/a.csv" || file.Filename != "a.csv" || file.Checksum == "" {
+		t.Fatalf("entry target = %+v", file)
+	}
+	if _, err := r.Resolve(context.Background(), srv.URL+"/set.metalink#missing.csv"); err == nil {
+		t.Fatalf("expected error for a file not in the metalink")
+	}
+	if _, err := r.Resolve(context.Background(), srv.URL+"/gone.meta4"); err == nil || !strings.Contains(err.Error(), "http_not_found") {
+		t.Fatalf("404 err = %v", err)
+	}
+}
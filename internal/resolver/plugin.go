@@ -0,0 +1,274 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPluginCheckTimeout   = 5 * time.Second
+	defaultPluginResolveTimeout = 60 * time.Second
+	maxPluginOutput             = 1 << 20
+)
+
+// PluginResolver runs an external executable that speaks a JSON protocol.
+// Each call starts the executable, writes one request object to its stdin and
+// reads one response object from its stdout:
+//
+//	{"action":"info"}                    -> {"name":"site","hosts":["example.com"],"timeout_seconds":30}
+//	{"action":"can_handle","url":"..."}  -> {"can_handle":true}
+//	{"action":"resolve","url":"..."}     -> {"url":"...","kind":"aria2","headers":{},"options":{},"filename":"...","size":123}
+//
+// When accounts are stored for the plugin's site, resolve requests carry one
+// as "account":{"username":"...","password":"..."}; a login_required or
+// quota_exceeded error rotates to the next account.
+//
+// A failed resolve returns {"error":"<code>","message":"..."}, where the
+// codes login_required, quota_exceeded, captcha_needed and
+// temporarily_unavailable map to the built-in resolver errors. "info" is
+// optional: with hosts listed, URLs on those hosts (and subdomains) are
+// matched without running the plugin.
+type PluginResolver struct {
+	Name           string
+	Path           string
+	Hosts          []string
+	CheckTimeout   time.Duration
+	ResolveTimeout time.Duration
+	Accounts       AccountSource
+}
+
+type pluginRequest struct {
+	Action  string         `json:"action"`
+	URL     string         `json:"url,omitempty"`
+	Account *pluginAccount `json:"account,omitempty"`
+}
+
+type pluginAccount struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type pluginResponse struct {
+	Name           string            `json:"name"`
+	Hosts          []string          `json:"hosts"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	CanHandle      bool              `json:"can_handle"`
+	Kind           string            `json:"kind"`
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	Options        map[string]string `json:"options"`
+	Filename       string            `json:"filename"`
+	Size           int64             `json:"size"`
+	Error          string            `json:"error"`
+	Message        string            `json:"message"`
+}
+
+// LoadPlugins returns a resolver for every executable file in dir, sorted by
+// name. A missing dir yields no plugins. The site name is the file name
+// without extension unless the plugin's info response names one.
+func LoadPlugins(dir string) ([]*PluginResolver, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var plugins []*PluginResolver
+	seen := map[string]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		p := &PluginResolver{
+			Name: strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))),
+			Path: path,
+		}
+		if err := p.loadInfo(); err != nil {
+			log.Printf("resolver plugin %s: info: %v", path, err)
+		}
+		if first, ok := seen[p.Name]; ok {
+			log.Printf("resolver plugin %s: site %q already provided by %s; skipped", path, p.Name, first)
+			continue
+		}
+		seen[p.Name] = path
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, nil
+}
+
+func (p *PluginResolver) loadInfo() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.checkTimeout())
+	defer cancel()
+	resp, err := p.call(ctx, pluginRequest{Action: "info"})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return pluginError(resp)
+	}
+	if name := strings.ToLower(strings.TrimSpace(resp.Name)); name != "" {
+		p.Name = name
+	}
+	for _, h := range resp.Hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			p.Hosts = append(p.Hosts, h)
+		}
+	}
+	if resp.TimeoutSeconds > 0 {
+		p.ResolveTimeout = time.Duration(resp.TimeoutSeconds) * time.Second
+	}
+	return nil
+}
+
+func (p *PluginResolver) CanHandle(rawURL string) bool {
+	if len(p.Hosts) > 0 {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(u.Hostname())
+		for _, h := range p.Hosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return true
+			}
+		}
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.checkTimeout())
+	defer cancel()
+	resp, err := p.call(ctx, pluginRequest{Action: "can_handle", URL: rawURL})
+	if err != nil {
+		log.Printf("resolver plugin %s: can_handle: %v", p.Name, err)
+		return false
+	}
+	return resp.CanHandle
+}
+
+func (p *PluginResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
+	return withAccounts(p.Accounts, p.Name, func(acct Account) (*ResolvedTarget, error) {
+		req := pluginRequest{Action: "resolve", URL: rawURL}
+		if acct.Username != "" {
+			req.Account = &pluginAccount{Username: acct.Username, Password: acct.Password}
+		}
+		return p.resolve(ctx, req)
+	})
+}
+
+func (p *PluginResolver) resolve(ctx context.Context, req pluginRequest) (*ResolvedTarget, error) {
+	timeout := p.ResolveTimeout
+	if timeout <= 0 {
+		timeout = defaultPluginResolveTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := p.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, pluginError(resp)
+	}
+	if resp.URL == "" {
+		return nil, fmt.Errorf("resolver plugin %s: response has no url", p.Name)
+	}
+	kind := resp.Kind
+	if kind == "" {
+		kind = "aria2"
+	}
+	return &ResolvedTarget{
+		Kind:     kind,
+		URL:      resp.URL,
+		Headers:  resp.Headers,
+		Options:  resp.Options,
+		Filename: resp.Filename,
+		Size:     resp.Size,
+	}, nil
+}
+
+func (p *PluginResolver) checkTimeout() time.Duration {
+	if p.CheckTimeout > 0 {
+		return p.CheckTimeout
+	}
+	return defaultPluginCheckTimeout
+}
+
+func (p *PluginResolver) call(ctx context.Context, req pluginRequest) (*pluginResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Env = append(os.Environ(), "DLQ_RESOLVER_ACTION="+req.Action)
+	cmd.WaitDelay = time.Second
+	var stdout, stderr limitedBuffer
+	stdout.limit, stderr.limit = maxPluginOutput, 4096
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("resolver plugin %s: %s timed out", p.Name, req.Action)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("resolver plugin %s: %v: %s", p.Name, err, msg)
+		}
+		return nil, fmt.Errorf("resolver plugin %s: %v", p.Name, err)
+	}
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("resolver plugin %s: invalid response: %v", p.Name, err)
+	}
+	return &resp, nil
+}
+
+func pluginError(resp *pluginResponse) error {
+	code := strings.TrimSpace(resp.Error)
+	for _, sentinel := range []error{ErrLoginRequired, ErrQuotaExceeded, ErrCaptchaNeeded, ErrTemporarilyOff} {
+		if code == sentinel.Error() {
+			if resp.Message == "" {
+				return sentinel
+			}
+			return fmt.Errorf("%w: %s", sentinel, resp.Message)
+		}
+	}
+	if resp.Message == "" {
+		return errors.New(code)
+	}
+	return fmt.Errorf("%s: %s", code, resp.Message)
+}
+
+// limitedBuffer keeps at most limit bytes and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
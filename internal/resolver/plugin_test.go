@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("write plugin: %v", err)
+	}
+	return path
+}
+
+func TestLoadPluginsAndResolve(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "filehost.sh", `read req
+case "$req" in
+  *'"info"'*) echo '{"hosts":["filehost.example"]}' ;;
+  *'/gone'*) echo '{"error":"quota_exceeded","message":"daily limit"}' ;;
+  *'"resolve"'*) echo '{"url":"https://cdn.filehost.example/f.bin","filename":"f.bin","size":42,"headers":{"Cookie":"a=b"}}' ;;
+esac
+`)
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatalf("write readme: %v", err)
+	}
+
+	plugins, err := LoadPlugins(dir)
+	if err != nil {
+		t.Fatalf("load plugins: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "filehost" {
+		t.Fatalf("expected one plugin named filehost, got %+v", plugins)
+	}
+	p := plugins[0]
+	if !p.CanHandle("https://www.filehost.example/abc") || p.CanHandle("https://other.example/abc") {
+		t.Fatalf("host matching failed: hosts=%v", p.Hosts)
+	}
+
+	reg := NewRegistry(p)
+	reg.RegisterSite(p.Name, p)
+	res, err := reg.ResolveWithSite(context.Background(), "filehost", "https://filehost.example/abc")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.URL != "https://cdn.filehost.example/f.bin" || res.Filename != "f.bin" || res.Size != 42 || res.Kind != "aria2" || res.Headers["Cookie"] != "a=b" {
+		t.Fatalf("unexpected target: %+v", res)
+	}
+	_, err = p.Resolve(context.Background(), "https://filehost.example/gone")
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "daily limit") {
+		t.Fatalf("expected quota_exceeded, got %v", err)
+	}
+	if sites := reg.Sites(); len(sites) != 1 || sites[0].Type != "plugin" || sites[0].Path == "" {
+		t.Fatalf("unexpected sites: %+v", sites)
+	}
+}
+
+func TestPluginCanHandleAndTimeout(t *testing.T) {
+	dir := t.TempDir()
+	path := writePlugin(t, dir, "slow", `read req
+case "$req" in
+  *'"can_handle"'*) case "$req" in *slow.example*) echo '{"can_handle":true}' ;; *) echo '{"can_handle":false}' ;; esac ;;
+  *'"resolve"'*) sleep 5 ;;
+  *) exit 1 ;;
+esac
+`)
+	p := &PluginResolver{Name: "slow", Path: path, ResolveTimeout: 200 * time.Millisecond}
+	if !p.CanHandle("https://slow.example/x") || p.CanHandle("https://fast.example/x") {
+		t.Fatalf("can_handle did not follow the plugin")
+	}
+	start := time.Now()
+	_, err := p.Resolve(context.Background(), "https://slow.example/x")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("timeout took %v", time.Since(start))
+	}
+}
+
+func TestLoadPluginsSkipsDuplicateNames(t *testing.T) {
+	dir := t.TempDir()
+	info := `read req
+echo '{"name":"filehost","hosts":["filehost.example"]}'
+`
+	first := writePlugin(t, dir, "a-filehost.sh", info)
+	writePlugin(t, dir, "b-filehost.sh", info)
+	plugins, err := LoadPlugins(dir)
+	if err != nil {
+		t.Fatalf("load plugins: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "filehost" || plugins[0].Path != first {
+		t.Fatalf("expected only %s for site filehost, got %+v", first, plugins)
+	}
+}
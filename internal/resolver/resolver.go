@@ -3,7 +3,12 @@ package resolver
 import (
 	"context"
 	"errors"
+	"log"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/Witriol/dlq-download-queue/internal/metrics"
 )
 
 var (
@@ -12,8 +17,14 @@ var (
 	ErrCaptchaNeeded  = errors.New("captcha_needed")
 	ErrTemporarilyOff = errors.New("temporarily_unavailable")
 	ErrUnknownSite    = errors.New("unknown_site")
+	ErrNotFound       = errors.New("http_not_found")
 )
 
+// KindFolder marks a target that is a folder of files rather than one
+// download: Entries lists the files, Filename names the folder and Size is
+// the total.
+const KindFolder = "folder"
+
 type ResolvedTarget struct {
 	Kind     string
 	URL      string
@@ -21,6 +32,35 @@ type ResolvedTarget struct {
 	Options  map[string]string
 	Filename string
 	Size     int64
+	Entries  []FolderEntry
+	// ETag and LastModified are the validators the server reported for the
+	// file, when the resolver probed it.
+	ETag         string
+	LastModified string
+	// Torrent holds the .torrent file of a BitTorrent target; URL is then
+	// only the link it came from.
+	Torrent []byte
+	// Mirrors lists every URL serving the file, URL first; engines that
+	// support it download from all of them.
+	Mirrors []string
+	// Checksum is the expected hash of the file as type:hex, e.g.
+	// sha256:9f86...
+	Checksum string
+}
+
+// IsTorrent reports whether the target is a BitTorrent download: a .torrent
+// file or a magnet link.
+func (t *ResolvedTarget) IsTorrent() bool {
+	return len(t.Torrent) > 0 || strings.HasPrefix(strings.ToLower(t.URL), "magnet:")
+}
+
+// FolderEntry is one file of a folder target. Path is its directory relative
+// to the folder ("" for top-level files) and URL a link that resolves to it.
+type FolderEntry struct {
+	URL      string
+	Path     string
+	Filename string
+	Size     int64
 }
 
 type Resolver interface {
@@ -49,6 +89,8 @@ func (r *Registry) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget,
 	return nil, errors.New("no_resolver")
 }
 
+// RegisterSite makes res selectable as --site name. Registering a name again
+// replaces the earlier resolver and is logged.
 func (r *Registry) RegisterSite(name string, res Resolver) {
 	if res == nil {
 		return
@@ -60,34 +102,103 @@ func (r *Registry) RegisterSite(name string, res Resolver) {
 	if key == "" {
 		return
 	}
+	if prev, ok := r.siteResolvers[key]; ok && prev != res {
+		log.Printf("resolver: site %q registered twice; the later resolver replaces the earlier one", key)
+	}
 	r.siteResolvers[key] = res
 }
 
+var (
+	resolveResults = metrics.NewCounterVec("dlq_resolver_results_total",
+		"Resolver calls by site and outcome (ok or the error code).", "site", "code")
+	resolveDuration = metrics.NewHistogramVec("dlq_resolver_duration_seconds",
+		"Time spent resolving URLs.", metrics.DurationBuckets, "site")
+)
+
 func (r *Registry) ResolveWithSite(ctx context.Context, site, rawURL string) (*ResolvedTarget, error) {
+	var res Resolver
 	if site = strings.TrimSpace(site); site != "" {
-		key := strings.ToLower(site)
-		if res, ok := r.siteResolvers[key]; ok {
-			return res.Resolve(ctx, rawURL)
+		var ok bool
+		site = strings.ToLower(site)
+		if res, ok = r.siteResolvers[site]; !ok {
+			resolveResults.Inc(site, ErrUnknownSite.Error())
+			return nil, ErrUnknownSite
 		}
-		return nil, ErrUnknownSite
+	} else {
+		for _, candidate := range r.resolvers {
+			if candidate.CanHandle(rawURL) {
+				res = candidate
+				break
+			}
+		}
+		if res == nil {
+			resolveResults.Inc("", "no_resolver")
+			return nil, errors.New("no_resolver")
+		}
+		site = r.siteName(res)
 	}
-	return r.Resolve(ctx, rawURL)
+	start := time.Now()
+	target, err := res.Resolve(ctx, rawURL)
+	resolveDuration.Observe(time.Since(start).Seconds(), site)
+	resolveResults.Inc(site, ResultCode(err))
+	return target, err
 }
 
-// NewHTTPResolver returns a pass-through resolver for direct HTTP/HTTPS URLs.
-func NewHTTPResolver() Resolver {
-	return &httpResolver{}
+// SiteInfo describes a registered site name for listing.
+type SiteInfo struct {
+	Site  string   `json:"site"`
+	Type  string   `json:"type"` // builtin or plugin
+	Path  string   `json:"path,omitempty"`
+	Hosts []string `json:"hosts,omitempty"`
 }
 
-type httpResolver struct{}
+// Sites lists the registered site names, sorted.
+func (r *Registry) Sites() []SiteInfo {
+	out := make([]SiteInfo, 0, len(r.siteResolvers))
+	for name, res := range r.siteResolvers {
+		info := SiteInfo{Site: name, Type: "builtin"}
+		if p, ok := res.(*PluginResolver); ok {
+			info.Type = "plugin"
+			info.Path = p.Path
+			info.Hosts = append([]string{}, p.Hosts...)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Site < out[j].Site })
+	return out
+}
 
-func (r *httpResolver) CanHandle(rawURL string) bool {
-	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+// HasSite reports whether name is registered for --site.
+func (r *Registry) HasSite(name string) bool {
+	_, ok := r.siteResolvers[strings.ToLower(strings.TrimSpace(name))]
+	return ok
 }
 
-func (r *httpResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
-	return &ResolvedTarget{
-		Kind: "aria2",
-		URL:  rawURL,
-	}, nil
+// siteName returns the first registered site name of res, for labelling.
+func (r *Registry) siteName(res Resolver) string {
+	names := make([]string, 0, len(r.siteResolvers))
+	for name, candidate := range r.siteResolvers {
+		if candidate == res {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// ResultCode maps a resolver error to its error code: "ok" for nil, the
+// sentinel's code when err wraps one, and "error" otherwise.
+func ResultCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	for _, sentinel := range []error{ErrLoginRequired, ErrQuotaExceeded, ErrCaptchaNeeded, ErrTemporarilyOff, ErrUnknownSite, ErrNotFound} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return "error"
 }
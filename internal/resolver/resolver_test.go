@@ -2,6 +2,8 @@ package resolver
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -31,3 +33,28 @@ func TestResolveWithSite(t *testing.T) {
 		t.Fatalf("expected unknown site error")
 	}
 }
+
+type failingResolver struct{ err error }
+
+func (f *failingResolver) CanHandle(rawURL string) bool { return true }
+func (f *failingResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
+	return nil, f.err
+}
+
+func TestResolveWithSiteCountsOutcomes(t *testing.T) {
+	ctx := context.Background()
+	res := &failingResolver{err: fmt.Errorf("%w: daily limit", ErrQuotaExceeded)}
+	reg := NewRegistry(res)
+	reg.RegisterSite("metricsite", res)
+
+	before := resolveResults.Value("metricsite", "quota_exceeded")
+	if _, err := reg.ResolveWithSite(ctx, "", "https://example.com/a"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if got := resolveResults.Value("metricsite", "quota_exceeded"); got != before+1 {
+		t.Fatalf("quota_exceeded count = %v, want %v", got, before+1)
+	}
+	if got := ResultCode(errors.New("boom")); got != "error" {
+		t.Fatalf("ResultCode(other) = %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package resolver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxTorrentSize caps .torrent files read from disk, fetched over HTTP or
+// uploaded.
+const MaxTorrentSize = 10 << 20
+
+// TorrentInfo is what dlq reads from a .torrent file.
+type TorrentInfo struct {
+	InfoHash string
+	Name     string
+	Size     int64
+	Files    int
+}
+
+// ParseTorrent validates a .torrent file and returns its name, total size,
+// file count and info hash.
+func ParseTorrent(data []byte) (*TorrentInfo, error) {
+	v, d, err := bdecode(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(map[string]any)
+	if !ok || d.infoStart < 0 {
+		return nil, errBencode
+	}
+	info, ok := root["info"].(map[string]any)
+	if !ok {
+		return nil, errBencode
+	}
+	sum := sha1.Sum(data[d.infoStart:d.infoEnd])
+	t := &TorrentInfo{InfoHash: hex.EncodeToString(sum[:])}
+	if name, ok := info["name.utf-8"].(string); ok {
+		t.Name = name
+	} else {
+		t.Name, _ = info["name"].(string)
+	}
+	t.Name = httpBaseName(t.Name)
+	if files, ok := info["files"].([]any); ok {
+		for _, f := range files {
+			entry, ok := f.(map[string]any)
+			if !ok {
+				return nil, errBencode
+			}
+			length, ok := entry["length"].(int64)
+			if !ok || length < 0 {
+				return nil, errBencode
+			}
+			t.Size += length
+			t.Files++
+		}
+	} else {
+		length, ok := info["length"].(int64)
+		if !ok || length < 0 {
+			return nil, errBencode
+		}
+		t.Size, t.Files = length, 1
+	}
+	return t, nil
+}
+
+// SaveTorrent stores an uploaded .torrent file in dir under its info hash
+// and returns the file:// URL the torrent resolver accepts for it.
+func SaveTorrent(dir string, data []byte) (string, error) {
+	if len(data) > MaxTorrentSize {
+		return "", errors.New("torrent_too_large")
+	}
+	info, err := ParseTorrent(data)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, info.InfoHash+".torrent")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(path), nil
+}
+
+// NewTorrentResolver returns the BitTorrent resolver. It accepts magnet
+// links, http(s) URLs of .torrent files and file:// URLs of torrents saved
+// in dir by SaveTorrent.
+func NewTorrentResolver(dir string) Resolver {
+	return &torrentResolver{client: &http.Client{Timeout: 20 * time.Second}, dir: dir}
+}
+
+type torrentResolver struct {
+	client *http.Client
+	dir    string
+}
+
+func (r *torrentResolver) CanHandle(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "magnet":
+		return true
+	case "file":
+		return r.savedPath(u) != ""
+	case "http", "https":
+		return strings.HasSuffix(strings.ToLower(u.Path), ".torrent")
+	}
+	return false
+}
+
+func (r *torrentResolver) Resolve(ctx context.Context, rawURL string) (*ResolvedTarget, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch strings.ToLower(u.Scheme) {
+	case "magnet":
+		return resolveMagnet(rawURL, u)
+	case "file":
+		path := r.savedPath(u)
+		if path == "" {
+			return nil, errors.New("torrent_file_outside_torrent_dir")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = readTorrent(f); err != nil {
+			return nil, err
+		}
+	case "http", "https":
+		if data, err = r.fetch(ctx, rawURL); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("torrent_url_unsupported")
+	}
+	info, err := ParseTorrent(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ResolvedTarget{
+		Kind:     "aria2",
+		URL:      rawURL,
+		Torrent:  data,
+		Filename: info.Name,
+		Size:     info.Size,
+	}, nil
+}
+
+// resolveMagnet checks that a magnet link names a BitTorrent info hash. The
+// name and size come from the optional dn and xl parameters; aria2 fetches
+// the metadata itself.
+func resolveMagnet(rawURL string, u *url.URL) (*ResolvedTarget, error) {
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, errors.New("magnet_link_invalid")
+	}
+	hasHash := false
+	for _, xt := range q["xt"] {
+		xt = strings.ToLower(xt)
+		if strings.HasPrefix(xt, "urn:btih:") || strings.HasPrefix(xt, "urn:btmh:") {
+			hasHash = true
+		}
+	}
+	if !hasHash {
+		return nil, errors.New("magnet_link_missing_info_hash")
+	}
+	target := &ResolvedTarget{Kind: "aria2", URL: rawURL, Filename: httpBaseName(q.Get("dn"))}
+	if xl, err := strconv.ParseInt(q.Get("xl"), 10, 64); err == nil && xl > 0 {
+		target.Size = xl
+	}
+	return target, nil
+}
+
+// savedPath returns the local path of a file:// URL inside the torrent dir,
+// or "" for anything else; other local files are never read.
+func (r *torrentResolver) savedPath(u *url.URL) string {
+	if r.dir == "" || (u.Host != "" && u.Host != "localhost") {
+		return ""
+	}
+	path := filepath.Clean(filepath.FromSlash(u.Path))
+	if filepath.Dir(path) != filepath.Clean(r.dir) || !strings.HasSuffix(path, ".torrent") {
+		return ""
+	}
+	return path
+}
+
+func (r *torrentResolver) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := httpProbeError(resp.StatusCode); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("torrent_http_status:%d", resp.StatusCode)
+	}
+	return readTorrent(resp.Body)
+}
+
+func readTorrent(rc io.ReadCloser) ([]byte, error) {
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, MaxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxTorrentSize {
+		return nil, errors.New("torrent_too_large")
+	}
+	return data, nil
+}
@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTorrentInfo = "d5:filesld6:lengthi100e4:pathl5:a.bineed6:lengthi50e4:pathl3:sub5:b.bineee4:name6:Series12:piece lengthi16384e6:pieces0:e"
+
+func testTorrent() []byte {
+	return []byte("d8:announce14:http://tracker4:info" + testTorrentInfo + "e")
+}
+
+func TestParseTorrent(t *testing.T) {
+	info, err := ParseTorrent(testTorrent())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sum := sha1.Sum([]byte(testTorrentInfo))
+	if info.InfoHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("info hash = %s", info.InfoHash)
+	}
+	if info.Name != "Series" || info.Size != 150 || info.Files != 2 {
+		t.Fatalf("info = %+v", info)
+	}
+
+	single, err := ParseTorrent([]byte("d4:infod6:lengthi7e4:name5:x.isoee"))
+	if err != nil {
+		t.Fatalf("parse single: %v", err)
+	}
+	if single.Name != "x.iso" || single.Size != 7 || single.Files != 1 {
+		t.Fatalf("single = %+v", single)
+	}
+
+	for _, bad := range []string{"", "d4:infoi1ee", "d4:infod4:name1:xee", "l4:spam", "d4:infod6:lengthi7e4:name5:x.isoeextra"} {
+		if _, err := ParseTorrent([]byte(bad)); err == nil {
+			t.Fatalf("ParseTorrent(%q): expected error", bad)
+		}
+	}
+}
+
+func TestTorrentResolverSavedUpload(t *testing.T) {
+	dir := t.TempDir()
+	torrentURL, err := SaveTorrent(dir, testTorrent())
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if !strings.HasPrefix(torrentURL, "file://") || !strings.HasSuffix(torrentURL, ".torrent") {
+		t.Fatalf("url = %q", torrentURL)
+	}
+
+	r := NewTorrentResolver(dir)
+	if !r.CanHandle(torrentURL) {
+		t.Fatalf("resolver does not handle %q", torrentURL)
+	}
+	res, err := r.Resolve(context.Background(), torrentURL)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if !res.IsTorrent() || string(res.Torrent) != string(testTorrent()) {
+		t.Fatalf("torrent not returned: %+v", res)
+	}
+	if res.Filename != "Series" || res.Size != 150 {
+		t.Fatalf("filename/size = %q/%d", res.Filename, res.Size)
+	}
+
+	other := filepath.Join(t.TempDir(), "other.torrent")
+	if err := os.WriteFile(other, testTorrent(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r.CanHandle("file://" + other) {
+		t.Fatalf("resolver accepted a torrent outside its dir")
+	}
+	if _, err := r.Resolve(context.Background(), "file://"+other); err == nil {
+		t.Fatalf("expected error for a torrent outside the dir")
+	}
+	if _, err := SaveTorrent(dir, []byte("not a torrent")); err == nil {
+		t.Fatalf("expected invalid torrent to be rejected")
+	}
+}
+
+func TestTorrentResolverMagnet(t *testing.T) {
+	r := NewTorrentResolver("")
+	link := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=Some+Show&xl=4096&tr=udp%3A%2F%2Ftracker"
+	if !r.CanHandle(link) {
+		t.Fatalf("magnet not handled")
+	}
+	res, err := r.Resolve(context.Background(), link)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if res.URL != link || !res.IsTorrent() || len(res.Torrent) != 0 {
+		t.Fatalf("target = %+v", res)
+	}
+	if res.Filename != "Some Show" || res.Size != 4096 {
+		t.Fatalf("filename/size = %q/%d", res.Filename, res.Size)
+	}
+	if _, err := r.Resolve(context.Background(), "magnet:?dn=nothing"); err == nil {
+		t.Fatalf("expected error for magnet without info hash")
+	}
+}
+
+func TestTorrentResolverFetchesTorrentURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/gone.torrent" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write(testTorrent())
+	}))
+	defer srv.Close()
+
+	r := &torrentResolver{client: srv.Client()}
+	if !r.CanHandle(srv.URL+"/x.torrent") || r.CanHandle(srv.URL+"/x.iso") {
+		t.Fatalf("CanHandle should only accept .torrent URLs")
+	}
+	res, err := r.Resolve(context.Background(), srv.URL+"/x.torrent")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(res.Torrent) == 0 || res.Filename != "Series" {
+		t.Fatalf("target = %+v", res)
+	}
+	if _, err := r.Resolve(context.Background(), srv.URL+"/gone.torrent"); ResultCode(err) != "login_required" {
+		t.Fatalf("403 err = %v", err)
+	}
+}
@@ -2,28 +2,69 @@ package resolver
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	webshareAPI = "https://webshare.cz/api"
+	// webshareSessionCheck is how long a login token and its VIP status are
+	// trusted before user_data is asked again.
+	webshareSessionCheck = time.Hour
 )
 
 type webshareResolver struct {
 	client *http.Client
+	apiURL string
+	// fallback is used when no stored account is usable; an empty username
+	// means anonymous mode.
+	fallback Account
+	accounts AccountSource
+
+	// mu guards the maps only; API calls run without it.
+	mu       sync.Mutex
+	sessions map[string]*wsSession // by username
+	logins   map[string]*wsLogin   // logins in progress, by username
+}
+
+// wsSession is a cached login token with the account's VIP status.
+type wsSession struct {
+	token     string
+	vip       bool
+	checkedAt time.Time
 }
 
-func NewWebshareResolver() Resolver {
+// wsLogin is a login in progress. Concurrent resolves of the same account
+// wait for it instead of logging in again.
+type wsLogin struct {
+	done chan struct{}
+	sess wsSession
+	err  error
+}
+
+// NewWebshareResolver returns the Webshare resolver. It uses the "webshare"
+// accounts of accounts in turn, then the given credentials, then anonymous
+// mode. A logged-in account gets a WST token for file_link.
+func NewWebshareResolver(username, password string, accounts AccountSource) Resolver {
 	return &webshareResolver{
-		client: &http.Client{Timeout: 20 * time.Second},
+		client:   &http.Client{Timeout: 20 * time.Second},
+		apiURL:   webshareAPI,
+		fallback: Account{Username: strings.TrimSpace(username), Password: password},
+		accounts: accounts,
+		sessions: map[string]*wsSession{},
+		logins:   map[string]*wsLogin{},
 	}
 }
 
@@ -49,21 +90,45 @@ func (r *webshareResolver) Resolve(ctx context.Context, rawURL string) (*Resolve
 	if err != nil {
 		return nil, err
 	}
-	link, err := r.fileLink(ctx, ident)
+	return withAccounts(r.accounts, "webshare", func(acct Account) (*ResolvedTarget, error) {
+		if acct.Username == "" {
+			acct = r.fallback
+		}
+		return r.resolveWith(ctx, ident, info, acct)
+	})
+}
+
+func (r *webshareResolver) resolveWith(ctx context.Context, ident string, info *ResolvedTarget, acct Account) (*ResolvedTarget, error) {
+	wst, vip, err := r.session(ctx, acct, "")
+	if err != nil {
+		return nil, err
+	}
+	link, err := r.fileLink(ctx, ident, wst)
+	if errors.Is(err, ErrLoginRequired) && wst != "" {
+		// The token was rejected, most likely expired: log in again once.
+		if wst, vip, err = r.session(ctx, acct, wst); err != nil {
+			return nil, err
+		}
+		link, err = r.fileLink(ctx, ident, wst)
+	}
 	if err != nil {
 		return nil, err
 	}
+	options := map[string]string{
+		"allow-overwrite":    "true",
+		"auto-file-renaming": "false",
+	}
+	if !vip {
+		// Free downloads allow one connection and their links cannot resume.
+		options["max-connection-per-server"] = "1"
+		options["split"] = "1"
+		options["continue"] = "false"
+		options["always-resume"] = "false"
+	}
 	return &ResolvedTarget{
-		Kind: "aria2",
-		URL:  link,
-		Options: map[string]string{
-			"max-connection-per-server": "1",
-			"split":                     "1",
-			"continue":                  "false",
-			"always-resume":             "false",
-			"allow-overwrite":           "true",
-			"auto-file-renaming":        "false",
-		},
+		Kind:    "aria2",
+		URL:     link,
+		Options: options,
 		Headers: map[string]string{
 			"Referer":    "https://webshare.cz/",
 			"User-Agent": "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
@@ -73,6 +138,88 @@ func (r *webshareResolver) Resolve(ctx context.Context, rawURL string) (*Resolve
 	}, nil
 }
 
+// session returns the cached WST token and VIP flag of acct, logging in when
+// there is no token, when the cached one is the rejected token or when
+// user_data rejects it. Without a username it returns an empty token
+// (anonymous mode).
+func (r *webshareResolver) session(ctx context.Context, acct Account, rejected string) (string, bool, error) {
+	if acct.Username == "" {
+		return "", false, nil
+	}
+	r.mu.Lock()
+	var cached wsSession
+	s := r.sessions[acct.Username]
+	if s != nil {
+		cached = *s
+	}
+	r.mu.Unlock()
+	if s != nil && cached.token != rejected {
+		if time.Since(cached.checkedAt) < webshareSessionCheck {
+			return cached.token, cached.vip, nil
+		}
+		vip, err := r.userData(ctx, cached.token)
+		if err == nil {
+			r.mu.Lock()
+			if s := r.sessions[acct.Username]; s != nil && s.token == cached.token {
+				s.vip, s.checkedAt = vip, time.Now()
+			}
+			r.mu.Unlock()
+			return cached.token, vip, nil
+		}
+		if !errors.Is(err, ErrLoginRequired) {
+			return "", false, err
+		}
+	}
+	sess, err := r.sharedLogin(ctx, acct)
+	if err != nil {
+		return "", false, err
+	}
+	return sess.token, sess.vip, nil
+}
+
+// sharedLogin logs acct in and caches the session. A login of the same
+// account already in progress is joined rather than repeated.
+func (r *webshareResolver) sharedLogin(ctx context.Context, acct Account) (wsSession, error) {
+	r.mu.Lock()
+	if l := r.logins[acct.Username]; l != nil {
+		r.mu.Unlock()
+		select {
+		case <-l.done:
+			return l.sess, l.err
+		case <-ctx.Done():
+			return wsSession{}, ctx.Err()
+		}
+	}
+	l := &wsLogin{done: make(chan struct{})}
+	r.logins[acct.Username] = l
+	delete(r.sessions, acct.Username)
+	r.mu.Unlock()
+
+	l.sess, l.err = r.newSession(ctx, acct)
+	r.mu.Lock()
+	delete(r.logins, acct.Username)
+	if l.err == nil {
+		sess := l.sess
+		r.sessions[acct.Username] = &sess
+	}
+	r.mu.Unlock()
+	close(l.done)
+	return l.sess, l.err
+}
+
+func (r *webshareResolver) newSession(ctx context.Context, acct Account) (wsSession, error) {
+	token, err := r.login(ctx, acct)
+	if err != nil {
+		return wsSession{}, err
+	}
+	vip, err := r.userData(ctx, token)
+	if err != nil {
+		return wsSession{}, err
+	}
+	log.Printf("webshare: logged in as %s (vip=%t)", acct.Username, vip)
+	return wsSession{token: token, vip: vip, checkedAt: time.Now()}, nil
+}
+
 type wsInfoResponse struct {
 	Status    string `xml:"status"`
 	Name      string `xml:"name"`
@@ -91,21 +238,39 @@ type wsLinkResponse struct {
 	Code    string `xml:"code"`
 }
 
-func (r *webshareResolver) fileInfo(ctx context.Context, ident string) (*ResolvedTarget, error) {
-	form := url.Values{}
-	form.Set("ident", ident)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webshareAPI+"/file_info/", strings.NewReader(form.Encode()))
+type wsLoginResponse struct {
+	Status  string `xml:"status"`
+	Salt    string `xml:"salt"`
+	Token   string `xml:"token"`
+	VIP     string `xml:"vip"`
+	Message string `xml:"message"`
+	Code    string `xml:"code"`
+}
+
+// post sends a form to an API endpoint and decodes the XML response.
+func (r *webshareResolver) post(ctx context.Context, endpoint string, form url.Values, out any) error {
+	apiURL := strings.TrimSpace(r.apiURL)
+	if apiURL == "" {
+		apiURL = webshareAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/"+endpoint+"/", strings.NewReader(form.Encode()))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp, err := r.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+func (r *webshareResolver) fileInfo(ctx context.Context, ident string) (*ResolvedTarget, error) {
+	form := url.Values{}
+	form.Set("ident", ident)
 	var out wsInfoResponse
-	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := r.post(ctx, "file_info", form, &out); err != nil {
 		return nil, err
 	}
 	if strings.ToUpper(out.Status) != "OK" {
@@ -115,21 +280,14 @@ func (r *webshareResolver) fileInfo(ctx context.Context, ident string) (*Resolve
 	return &ResolvedTarget{Filename: out.Name, Size: size}, nil
 }
 
-func (r *webshareResolver) fileLink(ctx context.Context, ident string) (string, error) {
+func (r *webshareResolver) fileLink(ctx context.Context, ident, wst string) (string, error) {
 	form := url.Values{}
 	form.Set("ident", ident)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webshareAPI+"/file_link/", strings.NewReader(form.Encode()))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return "", err
+	if wst != "" {
+		form.Set("wst", wst)
 	}
-	defer resp.Body.Close()
 	var out wsLinkResponse
-	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := r.post(ctx, "file_link", form, &out); err != nil {
 		return "", err
 	}
 	if strings.ToUpper(out.Status) != "OK" {
@@ -149,6 +307,113 @@ func (r *webshareResolver) fileLink(ctx context.Context, ident string) (string,
 	return out.Link, nil
 }
 
+// login exchanges the credentials for a WST token. The API wants the password
+// as sha1(md5crypt(password, salt)) plus an md5 digest of user and password.
+func (r *webshareResolver) login(ctx context.Context, acct Account) (string, error) {
+	form := url.Values{}
+	form.Set("username_or_email", acct.Username)
+	var salt wsLoginResponse
+	if err := r.post(ctx, "salt", form, &salt); err != nil {
+		return "", err
+	}
+	if strings.ToUpper(salt.Status) != "OK" || salt.Salt == "" {
+		return "", fmt.Errorf("%w: webshare salt: %s %s", ErrLoginRequired, salt.Code, salt.Message)
+	}
+	passwordHash := sha1.Sum([]byte(md5Crypt(acct.Password, salt.Salt)))
+	digest := md5.Sum([]byte(acct.Username + ":Webshare:" + acct.Password))
+	form.Set("password", hex.EncodeToString(passwordHash[:]))
+	form.Set("digest", hex.EncodeToString(digest[:]))
+	form.Set("keep_logged_in", "1")
+	var out wsLoginResponse
+	if err := r.post(ctx, "login", form, &out); err != nil {
+		return "", err
+	}
+	if strings.ToUpper(out.Status) != "OK" || out.Token == "" {
+		return "", fmt.Errorf("%w: webshare login: %s %s", ErrLoginRequired, out.Code, out.Message)
+	}
+	return out.Token, nil
+}
+
+// userData reports whether the token's account is VIP. A rejected token
+// returns ErrLoginRequired.
+func (r *webshareResolver) userData(ctx context.Context, wst string) (bool, error) {
+	form := url.Values{}
+	form.Set("wst", wst)
+	var out wsLoginResponse
+	if err := r.post(ctx, "user_data", form, &out); err != nil {
+		return false, err
+	}
+	if strings.ToUpper(out.Status) != "OK" {
+		return false, fmt.Errorf("%w: webshare user_data: %s %s", ErrLoginRequired, out.Code, out.Message)
+	}
+	return strings.TrimSpace(out.VIP) == "1", nil
+}
+
+// md5Crypt is the FreeBSD MD5-based crypt(3) ("$1$salt$hash").
+func md5Crypt(password, salt string) string {
+	const magic = "$1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	salt = strings.TrimPrefix(salt, magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	d := md5.New()
+	d.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		d.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(final)
+		} else {
+			d.Write(pw)
+		}
+		final = d.Sum(nil)
+	}
+
+	out := []byte(magic + salt + "$")
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(final[0], final[6], final[12], 4)
+	encode(final[1], final[7], final[13], 4)
+	encode(final[2], final[8], final[14], 4)
+	encode(final[3], final[9], final[15], 4)
+	encode(final[4], final[10], final[5], 4)
+	encode(0, 0, final[11], 2)
+	return string(out)
+}
+
 var wsIdentRe = regexp.MustCompile(`^[A-Za-z0-9]{5,}$`)
 
 func extractWebshareIdent(u *url.URL) string {
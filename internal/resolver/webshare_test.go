@@ -0,0 +1,263 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMD5Crypt(t *testing.T) {
+	tests := []struct{ password, salt, want string }{
+		{"password", "saltsalt", "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"", "ab", "$1$ab$rn6aQS/o7141mj179E/zA."},
+	}
+	for _, tt := range tests {
+		if got := md5Crypt(tt.password, tt.salt); got != tt.want {
+			t.Fatalf("md5Crypt(%q, %q) = %q, want %q", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+// fakeWebshare serves the salt/login/user_data/file_info/file_link endpoints.
+// Tokens listed in expired are rejected by file_link. When loginGate is set,
+// logins wait until it is closed.
+type fakeWebshare struct {
+	mu        sync.Mutex
+	loginGate chan struct{}
+	vip       bool
+	logins    int
+	tokens    int
+	expired   map[string]bool
+	quota     map[string]bool // usernames whose downloads are used up
+	users     map[string]string
+	wsts      []string
+}
+
+func (f *fakeWebshare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.mu.Lock()
+	gate := f.loginGate
+	f.mu.Unlock()
+	if r.URL.Path == "/api/login/" && gate != nil {
+		<-gate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(body string) { _, _ = w.Write([]byte("<response>" + body + "</response>")) }
+	switch r.URL.Path {
+	case "/api/salt/":
+		reply("<status>OK</status><salt>abcdefgh</salt>")
+	case "/api/login/":
+		user := r.Form.Get("username_or_email")
+		if (user != "user" && user != "spare") || r.Form.Get("password") == "" || r.Form.Get("digest") == "" {
+			reply("<status>FATAL</status><code>LOGIN_FATAL_1</code>")
+			return
+		}
+		f.logins++
+		f.tokens++
+		token := "wst" + string(rune('0'+f.tokens))
+		if f.users == nil {
+			f.users = map[string]string{}
+		}
+		f.users[token] = user
+		reply("<status>OK</status><token>" + token + "</token>")
+	case "/api/user_data/":
+		vip := "0"
+		if f.vip {
+			vip = "1"
+		}
+		reply("<status>OK</status><vip>" + vip + "</vip>")
+	case "/api/file_info/":
+		reply("<status>OK</status><name>movie.mkv</name><size>42</size>")
+	case "/api/file_link/":
+		wst := r.Form.Get("wst")
+		f.wsts = append(f.wsts, wst)
+		if f.expired[wst] {
+			reply("<status>FATAL</status><code>FILE_LINK_FATAL_1</code>")
+			return
+		}
+		if f.quota[f.users[wst]] {
+			reply("<status>FATAL</status><code>FILE_LINK_FATAL_3</code>")
+			return
+		}
+		reply("<status>OK</status><link>https://dl.webshare.cz/" + wst + "</link>")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestWebshare(fake *fakeWebshare, username string) (*webshareResolver, func()) {
+	srv := httptest.NewServer(fake)
+	r := NewWebshareResolver(username, "secret", nil).(*webshareResolver)
+	r.apiURL = srv.URL + "/api"
+	return r, srv.Close
+}
+
+func TestWebshareAnonymousForcesSingleConnection(t *testing.T) {
+	fake := &fakeWebshare{}
+	r, done := newTestWebshare(fake, "")
+	defer done()
+	got, err := r.Resolve(context.Background(), "https://webshare.cz/#/file/AbCdE123/movie")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if fake.logins != 0 || fake.wsts[0] != "" {
+		t.Fatalf("anonymous resolve logged in: logins=%d wst=%q", fake.logins, fake.wsts[0])
+	}
+	if got.Options["max-connection-per-server"] != "1" || got.Options["continue"] != "false" {
+		t.Fatalf("options = %v", got.Options)
+	}
+}
+
+func TestWebshareVIPLoginCachesTokenAndRelaxesOptions(t *testing.T) {
+	fake := &fakeWebshare{vip: true, expired: map[string]bool{}}
+	r, done := newTestWebshare(fake, "user")
+	defer done()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		got, err := r.Resolve(ctx, "https://webshare.cz/#/file/AbCdE123/movie")
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		if got.URL != "https://dl.webshare.cz/wst1" || got.Filename != "movie.mkv" || got.Size != 42 {
+			t.Fatalf("target = %+v", got)
+		}
+		for _, k := range []string{"max-connection-per-server", "split", "continue", "always-resume"} {
+			if _, ok := got.Options[k]; ok {
+				t.Fatalf("VIP options still force %s: %v", k, got.Options)
+			}
+		}
+	}
+	if fake.logins != 1 {
+		t.Fatalf("logins = %d, want 1 (token cached)", fake.logins)
+	}
+
+	fake.expired["wst1"] = true
+	got, err := r.Resolve(ctx, "https://webshare.cz/#/file/AbCdE123/movie")
+	if err != nil {
+		t.Fatalf("resolve after expiry: %v", err)
+	}
+	if fake.logins != 2 || got.URL != "https://dl.webshare.cz/wst2" {
+		t.Fatalf("expected relogin: logins=%d url=%s", fake.logins, got.URL)
+	}
+	if strings.Join(fake.wsts, ",") != "wst1,wst1,wst1,wst2" {
+		t.Fatalf("file_link wst = %v", fake.wsts)
+	}
+}
+
+func TestWebshareBadCredentialsNeedLogin(t *testing.T) {
+	r, done := newTestWebshare(&fakeWebshare{}, "someone-else")
+	defer done()
+	_, err := r.Resolve(context.Background(), "https://webshare.cz/#/file/AbCdE123/movie")
+	if ResultCode(err) != "login_required" {
+		t.Fatalf("err = %v, want login_required", err)
+	}
+}
+
+type stubAccounts struct {
+	list     []Account
+	reported map[int64]error
+}
+
+func (s *stubAccounts) Next(site string, skip []int64) (Account, bool) {
+	for _, a := range s.list {
+		used := false
+		for _, id := range skip {
+			used = used || id == a.ID
+		}
+		if site == "webshare" && !used {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+func (s *stubAccounts) Report(id int64, err error) { s.reported[id] = err }
+
+func TestWebshareRotatesExhaustedAccounts(t *testing.T) {
+	fake := &fakeWebshare{vip: true, quota: map[string]bool{"user": true}}
+	accts := &stubAccounts{
+		list:     []Account{{ID: 1, Username: "user", Password: "a"}, {ID: 2, Username: "spare", Password: "b"}},
+		reported: map[int64]error{},
+	}
+	r, done := newTestWebshare(fake, "")
+	defer done()
+	r.accounts = accts
+	got, err := r.Resolve(context.Background(), "https://webshare.cz/#/file/AbCdE123/movie")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if got.URL != "https://dl.webshare.cz/wst2" {
+		t.Fatalf("url = %s, want the spare account's link", got.URL)
+	}
+	if ResultCode(accts.reported[1]) != "quota_exceeded" || accts.reported[2] != nil {
+		t.Fatalf("reported = %v", accts.reported)
+	}
+
+	fake.quota["spare"] = true
+	got, err = r.Resolve(context.Background(), "https://webshare.cz/#/file/AbCdE123/movie")
+	if err != nil {
+		t.Fatalf("resolve with accounts exhausted: %v", err)
+	}
+	if got.URL != "https://dl.webshare.cz/" || got.Options["max-connection-per-server"] != "1" {
+		t.Fatalf("expected anonymous fallback, got %+v", got)
+	}
+}
+
+func TestWebshareSharesConcurrentLogins(t *testing.T) {
+	fake := &fakeWebshare{vip: true}
+	r, done := newTestWebshare(fake, "")
+	defer done()
+	ctx := context.Background()
+	spare := Account{Username: "spare", Password: "secret"}
+	spareToken, _, err := r.session(ctx, spare, "")
+	if err != nil {
+		t.Fatalf("spare login: %v", err)
+	}
+
+	gate := make(chan struct{})
+	fake.mu.Lock()
+	fake.loginGate = gate
+	fake.mu.Unlock()
+	user := Account{Username: "user", Password: "secret"}
+	tokens := make(chan string, 5)
+	for i := 0; i < cap(tokens); i++ {
+		go func() {
+			token, _, err := r.session(ctx, user, "")
+			if err != nil {
+				t.Errorf("user login: %v", err)
+			}
+			tokens <- token
+		}()
+	}
+	// A cached session is served while another account logs in.
+	cached := make(chan string)
+	go func() {
+		token, _, _ := r.session(ctx, spare, "")
+		cached <- token
+	}()
+	select {
+	case token := <-cached:
+		if token != spareToken {
+			t.Fatalf("spare token = %q, want %q", token, spareToken)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("cached session blocked behind a login")
+	}
+	close(gate)
+	first := <-tokens
+	for i := 1; i < cap(tokens); i++ {
+		if token := <-tokens; token != first {
+			t.Fatalf("tokens differ: %q and %q", first, token)
+		}
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.logins != 2 {
+		t.Fatalf("logins = %d, want 2 (one per account)", fake.logins)
+	}
+}
@@ -79,6 +79,12 @@ else
   DLQ_HTTP_ADDR="${DLQ_HTTP_HOST}:${DLQ_HTTP_PORT}"
 fi
 DLQ_WEBUI_PORT="${DLQ_WEBUI_PORT:-8098}"
+# Keep in sync with scripts/run-dev.sh: API requests need a token, and the
+# web UI gets a control-scope token (never admin). Without DLQ_WEBUI_TOKEN
+# one is created on the server once and kept in the state dir.
+DLQ_ANONYMOUS_SCOPE="${DLQ_ANONYMOUS_SCOPE:-}"
+DLQ_WEBUI_TOKEN="${DLQ_WEBUI_TOKEN:-}"
+WEBUI_TOKEN_FILE="${STATE_MOUNT%%:*}/webui.token"
 
 # Constants
 IMAGE_REPO="dlq"
@@ -164,6 +170,7 @@ docker run -d --name ${CONTAINER_NAME} --restart unless-stopped \
   -v '${STATE_MOUNT}' \
   -e DLQ_HTTP_ADDR=${DLQ_HTTP_ADDR} \
   -e DLQ_HTTP_PORT=${DLQ_HTTP_PORT} \
+  -e DLQ_ANONYMOUS_SCOPE=${DLQ_ANONYMOUS_SCOPE} \
   ${ENV_FLAGS[*]} \
   -e PUID=${PUID} \
   -e PGID=${PGID} \
@@ -174,6 +181,17 @@ fi
 
 if [[ "${DEPLOY_WEBUI}" == "true" ]]; then
   REMOTE_CMD="${REMOTE_CMD} && \
+if [ -n '${DLQ_WEBUI_TOKEN}' ]; then webui_token='${DLQ_WEBUI_TOKEN}'; \
+elif [ -s '${WEBUI_TOKEN_FILE}' ]; then webui_token=\$(cat '${WEBUI_TOKEN_FILE}'); \
+else \
+  for i in \$(seq 1 30); do \
+    webui_token=\$(docker exec -e DLQ_API=http://127.0.0.1:${DLQ_HTTP_PORT} ${CONTAINER_NAME} dlq token create --name webui --scope control 2>/dev/null | tail -n 1); \
+    case \"\${webui_token}\" in dlq_*) break ;; esac; \
+    sleep 1; \
+  done; \
+  case \"\${webui_token}\" in dlq_*) ;; *) echo 'could not create the web UI token; set DLQ_WEBUI_TOKEN' >&2; exit 1 ;; esac; \
+  (umask 077 && printf '%s\\n' \"\${webui_token}\" > '${WEBUI_TOKEN_FILE}'); \
+fi && \
 docker stop ${WEBUI_CONTAINER_NAME} 2>/dev/null || true && \
 docker rm ${WEBUI_CONTAINER_NAME} 2>/dev/null || true && \
 docker run -d --name ${WEBUI_CONTAINER_NAME} --restart unless-stopped \
@@ -182,6 +200,7 @@ docker run -d --name ${WEBUI_CONTAINER_NAME} --restart unless-stopped \
   -e HOST=0.0.0.0 \
   -e PORT=${DLQ_WEBUI_PORT} \
   -e DLQ_API=http://dlq:${DLQ_HTTP_PORT} \
+  -e DLQ_TOKEN=\${webui_token} \
   ${WEBUI_IMAGE_TAG_LATEST} && \
 for img in \$(docker images --format '{{.Repository}}:{{.Tag}}' ${WEBUI_IMAGE_REPO} | awk '\$0 !~ /:latest$/'); do docker rmi \"\${img}\" >/dev/null 2>&1 || true; done"
 fi
@@ -37,6 +37,10 @@ if [ "${#DATA_VOLUMES[@]}" -eq 0 ]; then
 fi
 
 STATE_MOUNT="${STATE_MOUNT:-/tmp/dlq-state:/state}"
+# Keep in sync with scripts/deploy-unraid.sh: API requests need a token, and
+# the UI gets a control-scope token (never admin), created once and kept in
+# the state dir unless DLQ_WEBUI_TOKEN is set.
+WEBUI_TOKEN_FILE="${STATE_MOUNT%%:*}/webui.token"
 # Keep in sync with scripts/deploy-unraid.sh
 DLQ_HTTP_HOST="${DLQ_HTTP_HOST:-0.0.0.0}"
 DLQ_HTTP_PORT="${DLQ_HTTP_PORT:-}"
@@ -82,6 +86,7 @@ docker run -d --name dlq \
   "${DATA_ENVS[@]}" \
   -e DLQ_HTTP_ADDR="${DLQ_HTTP_ADDR}" \
   -e DLQ_HTTP_PORT="${DLQ_HTTP_PORT}" \
+  -e DLQ_ANONYMOUS_SCOPE="${DLQ_ANONYMOUS_SCOPE:-}" \
   -e PUID="${PUID}" \
   -e PGID="${PGID}" \
   -p "${DLQ_HTTP_PORT}:${DLQ_HTTP_PORT}" \
@@ -103,11 +108,26 @@ if [ -d "ui" ]; then
   if [ ! -d "ui/node_modules" ]; then
     (cd ui && npm install)
   fi
+  DLQ_WEBUI_TOKEN="${DLQ_WEBUI_TOKEN:-}"
+  if [ -z "${DLQ_WEBUI_TOKEN}" ] && [ -s "${WEBUI_TOKEN_FILE}" ]; then
+    DLQ_WEBUI_TOKEN="$(cat "${WEBUI_TOKEN_FILE}")"
+  fi
+  if [ -z "${DLQ_WEBUI_TOKEN}" ]; then
+    for _ in $(seq 1 30); do
+      DLQ_WEBUI_TOKEN="$(docker exec -e DLQ_API="http://127.0.0.1:${DLQ_HTTP_PORT}" dlq dlq token create --name webui --scope control 2>/dev/null | tail -n 1)"
+      case "${DLQ_WEBUI_TOKEN}" in dlq_*) break ;; esac
+      sleep 1
+    done
+    case "${DLQ_WEBUI_TOKEN}" in
+      dlq_*) (umask 077 && printf '%s\n' "${DLQ_WEBUI_TOKEN}" > "${WEBUI_TOKEN_FILE}") ;;
+      *) echo "ERROR: could not create the UI token; set DLQ_WEBUI_TOKEN" >&2; exit 1 ;;
+    esac
+  fi
   echo "DLQ API running at http://127.0.0.1:${DLQ_HTTP_PORT}"
   echo "Starting UI dev server at http://127.0.0.1:5173"
   docker logs -f dlq 2>&1 | awk '{print "[dlq] " $0; fflush();}' &
   LOG_DOCKER_PID=$!
-  (cd ui && DLQ_API=http://127.0.0.1:${DLQ_HTTP_PORT} npm run dev 2>&1 | awk '{print "[ui] " $0; fflush();}') &
+  (cd ui && DLQ_API=http://127.0.0.1:${DLQ_HTTP_PORT} DLQ_TOKEN="${DLQ_WEBUI_TOKEN}" npm run dev 2>&1 | awk '{print "[ui] " $0; fflush();}') &
   LOG_UI_PID=$!
   wait "${LOG_UI_PID}"
 else
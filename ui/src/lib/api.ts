@@ -1,4 +1,4 @@
-import type { BatchResult, JobView, Meta } from './types';
+import type { BatchResult, JobView, Meta, PackageView } from './types';
 
 async function extractError(res: Response): Promise<string> {
   const text = await res.text();
@@ -44,6 +44,7 @@ export async function addJob(payload: {
   site?: string;
   archive_password?: string;
   max_attempts?: number;
+  package_id?: number;
 }): Promise<{ id: number }> {
   return requestJson<{ id: number }>('/api/jobs', {
     method: 'POST',
@@ -60,10 +61,21 @@ export async function addJobsBatch(
   site?: string;
   archive_password?: string;
   max_attempts?: number;
+  package_name?: string;
 },
   siteResolver?: (url: string) => string | undefined
 ): Promise<BatchResult[]> {
   const results: BatchResult[] = [];
+  let packageId: number | undefined;
+  const packageName = payload.package_name?.trim() || (payload.urls.length > 1 ? defaultPackageName(payload.urls[0]) : '');
+  if (packageName) {
+    try {
+      packageId = (await createPackage(packageName)).id;
+    } catch (err) {
+      const error = err instanceof Error ? err.message : String(err);
+      return payload.urls.map((url) => ({ url, ok: false, error }));
+    }
+  }
   for (const url of payload.urls) {
     const resolvedSite = payload.site ?? (siteResolver ? siteResolver(url) : undefined);
     try {
@@ -73,7 +85,8 @@ export async function addJobsBatch(
         name: payload.name,
         site: resolvedSite,
         archive_password: payload.archive_password,
-        max_attempts: payload.max_attempts
+        max_attempts: payload.max_attempts,
+        package_id: packageId
       });
       results.push({ url, ok: true, id: resp.id });
     } catch (err) {
@@ -83,6 +96,31 @@ export async function addJobsBatch(
   return results;
 }
 
+function defaultPackageName(url: string): string {
+  try {
+    const parsed = new URL(url);
+    const last = parsed.pathname.split('/').filter(Boolean).pop();
+    if (last) {
+      return decodeURIComponent(last);
+    }
+    return parsed.hostname;
+  } catch {
+    return url;
+  }
+}
+
+export async function createPackage(name: string): Promise<{ id: number }> {
+  return requestJson<{ id: number }>('/api/packages', {
+    method: 'POST',
+    headers: { 'content-type': 'application/json' },
+    body: JSON.stringify({ name })
+  });
+}
+
+export async function listPackages(): Promise<PackageView[]> {
+  return requestJson<PackageView[]>('/api/packages');
+}
+
 export async function postAction(id: string | number, action: 'retry' | 'remove' | 'pause' | 'resume'):
   Promise<{ status: string }> {
   return requestJson<{ status: string }>(`/api/jobs/${id}/${action}`, {
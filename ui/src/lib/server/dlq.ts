@@ -23,6 +23,9 @@ export async function forward(fetchFn: typeof fetch, path: string, init?: Reques
   if (init?.body && !headers.has('content-type')) {
     headers.set('content-type', 'application/json');
   }
+  if (env.DLQ_TOKEN && !headers.has('authorization')) {
+    headers.set('authorization', `Bearer ${env.DLQ_TOKEN}`);
+  }
   const resp = await fetchFn(url, { ...init, headers });
   const body = await resp.text();
   const outHeaders = new Headers();
@@ -3,6 +3,8 @@ export type JobStatus =
   | 'resolving'
   | 'downloading'
   | 'paused'
+  | 'waiting_space'
+  | 'verifying'
   | 'decrypting'
   | 'decrypt_failed'
   | 'completed'
@@ -21,12 +23,31 @@ export type JobView = {
   bytes_done: number;
   download_speed: number;
   eta_seconds: number;
+  priority: number;
+  position: number;
+  package_id?: number;
+  engine?: string;
   error?: string;
   error_code?: string;
   created_at: string;
   updated_at: string;
 };
 
+export type PackageView = {
+  id: number;
+  name: string;
+  status: string;
+  job_count: number;
+  status_counts: Record<string, number>;
+  size_bytes: number;
+  bytes_done: number;
+  download_speed: number;
+  eta_seconds: number;
+  created_at: string;
+  updated_at: string;
+  jobs?: JobView[];
+};
+
 export type BatchResult = {
   url: string;
   ok: boolean;
@@ -0,0 +1,23 @@
+import { json } from '@sveltejs/kit';
+import { forward } from '$lib/server/dlq';
+
+export async function GET({ fetch }: { fetch: typeof globalThis.fetch }) {
+  try {
+    return await forward(fetch, '/packages');
+  } catch (err) {
+    return json({ error: err instanceof Error ? err.message : 'dlq_unreachable' }, { status: 502 });
+  }
+}
+
+export async function POST({ request, fetch }: { request: Request; fetch: typeof globalThis.fetch }) {
+  const body = await request.text();
+  try {
+    return await forward(fetch, '/packages', {
+      method: 'POST',
+      headers: { 'content-type': request.headers.get('content-type') || 'application/json' },
+      body
+    });
+  } catch (err) {
+    return json({ error: err instanceof Error ? err.message : 'dlq_unreachable' }, { status: 502 });
+  }
+}